@@ -74,7 +74,7 @@ func main() {
 		ctx = output.WithStructure(ctx, cmd)
 		if err := cmd.ExecuteContext(ctx); err != nil {
 			fmt.Fprintf(cmd.ErrOrStderr(), "%s: error: %v\n", cmd.CommandPath(), err)
-			if errcat.GetCategory(err) > errcat.NoDaemonLogs {
+			if cat := errcat.GetCategory(err); cat > errcat.NoDaemonLogs && cat != errcat.VersionMismatch {
 				summarizeLogs(ctx, cmd)
 				// If the user gets here, it might be an actual bug that they found, so
 				// point them to the `gather-logs` command in case they want to open an
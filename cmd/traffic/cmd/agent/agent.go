@@ -186,6 +186,7 @@ func Main(ctx context.Context, args ...string) error {
 					return err
 				}
 				fwd := forwarder.NewInterceptor(lisAddr, "127.0.0.1", cp)
+				fwd.SetSocketRoot(cn.MountPoint)
 				g.Go(fmt.Sprintf("forward-%s:%d", cn.Name, cp), func(ctx context.Context) error {
 					return fwd.Serve(tunnel.WithPool(ctx, tunnel.NewPool()), nil)
 				})
@@ -66,7 +66,7 @@ func (fs *fwdState) HandleIntercepts(ctx context.Context, cepts []*manager.Inter
 			}
 		}
 
-		if myChoice != nil && myChoice.Disposition == manager.InterceptDispositionType_ACTIVE {
+		if myChoice != nil && myChoice.Disposition == manager.InterceptDispositionType_ACTIVE && !myChoice.Paused {
 			// The chosen intercept still exists and is active
 			activeIntercept = myChoice
 		}
@@ -76,7 +76,9 @@ func (fs *fwdState) HandleIntercepts(ctx context.Context, cepts []*manager.Inter
 			if cept.Disposition == manager.InterceptDispositionType_ACTIVE {
 				myChoice = cept
 				fs.chosenIntercept = cept
-				activeIntercept = cept
+				if !cept.Paused {
+					activeIntercept = cept
+				}
 				break
 			}
 		}
@@ -144,6 +144,19 @@ func TestState_HandleIntercepts(t *testing.T) {
 	a.Equal(rpc.InterceptDispositionType_AGENT_ERROR, reviews[0].Disposition)
 	a.Equal("Conflicts with the currently-served intercept \"intercept-01\"", reviews[0].Message)
 
+	// Handle pause and resume of the active intercept
+
+	a.Equal(cepts[0].Id, f.InterceptId())
+	cepts[0].Paused = true
+	reviews = s.HandleIntercepts(ctx, cepts[:1])
+	a.Len(reviews, 0)
+	a.Equal("", f.InterceptId())
+
+	cepts[0].Paused = false
+	reviews = s.HandleIntercepts(ctx, cepts[:1])
+	a.Len(reviews, 0)
+	a.Equal(cepts[0].Id, f.InterceptId())
+
 	// Handle resets state on an empty intercept list again
 
 	reviews = s.HandleIntercepts(ctx, nil)
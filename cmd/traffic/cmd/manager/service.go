@@ -513,6 +513,10 @@ func (m *Manager) UpdateIntercept(ctx context.Context, req *rpc.UpdateInterceptR
 
 	dlog.Debugf(ctx, "UpdateIntercept called: %s", interceptID)
 
+	if action, ok := req.PauseAction.(*rpc.UpdateInterceptRequest_SetPaused); ok {
+		return m.setInterceptPaused(interceptID, action.SetPaused)
+	}
+
 	switch action := req.PreviewDomainAction.(type) {
 	case *rpc.UpdateInterceptRequest_AddPreviewDomain:
 		// Check if this is already done.
@@ -538,6 +542,19 @@ func (m *Manager) UpdateIntercept(ctx context.Context, req *rpc.UpdateInterceptR
 	}
 }
 
+// setInterceptPaused pauses or resumes the intercept. The agent routes the traffic of a paused intercept to
+// the intercepted container, but the intercept remains ACTIVE so that the client retains its port forwards
+// and mounts.
+func (m *Manager) setInterceptPaused(interceptID string, paused bool) (*rpc.InterceptInfo, error) {
+	intercept := m.state.UpdateIntercept(interceptID, func(intercept *rpc.InterceptInfo) {
+		intercept.Paused = paused
+	})
+	if intercept == nil {
+		return nil, status.Errorf(codes.NotFound, "Intercept with ID %q not found for this session", interceptID)
+	}
+	return intercept, nil
+}
+
 func (m *Manager) removeInterceptDomain(ctx context.Context, interceptID string) (*rpc.InterceptInfo, error) {
 	var domain string
 	systemaPool := a8rcloud.GetSystemAPool[managerutil.SystemaCRUDClient](ctx, a8rcloud.TrafficManagerConnName)
@@ -14,9 +14,11 @@ import (
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 	"google.golang.org/grpc"
+	empty "google.golang.org/protobuf/types/known/emptypb"
 
 	"github.com/telepresenceio/telepresence/rpc/v2/connector"
 	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/cli/cliutil"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/scout"
 	"github.com/telepresenceio/telepresence/v2/pkg/filelocation"
@@ -158,6 +160,11 @@ func (gl *gatherLogsArgs) gatherLogs(ctx context.Context, cmd *cobra.Command) er
 		gl.gatherClusterLogs(ctx, cmd, exportDir, az)
 	}
 
+	// The state of the connector can't be anonymized, so it's only included when the logs aren't
+	if !gl.anon && (gl.daemons == "all" || gl.daemons == "user") {
+		gatherConnectorState(ctx, cmd, exportDir)
+	}
+
 	// Get all logs from the logDir that match the daemons the user cares about.
 	logFiles, err := os.ReadDir(logDir)
 	if err != nil {
@@ -257,6 +264,24 @@ func (gl *gatherLogsArgs) gatherClusterLogs(ctx context.Context, cmd *cobra.Comm
 	}
 }
 
+// gatherConnectorState writes a dump of the connector's internal state to the export directory, unless
+// the connector isn't running.
+func gatherConnectorState(ctx context.Context, cmd *cobra.Command, exportDir string) {
+	if running, err := client.SocketExists(client.ConnectorSocketName); err != nil || !running {
+		return
+	}
+	err := cliutil.WithConnector(ctx, func(ctx context.Context, userD connector.ConnectorClient) error {
+		sd, err := userD.DumpState(ctx, &empty.Empty{})
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(filepath.Join(exportDir, "connector_state.json"), sd.Json, 0o644)
+	})
+	if err != nil && !errors.Is(err, cliutil.ErrNoUserDaemon) {
+		fmt.Fprintf(cmd.ErrOrStderr(), "error getting the state of the connector: %s\n", err)
+	}
+}
+
 func isEmpty(file string) (bool, error) {
 	s, err := os.Stat(file)
 	if err != nil {
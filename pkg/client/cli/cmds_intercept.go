@@ -8,7 +8,11 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math"
+	"net"
 	"os"
+	"path"
+	"path/filepath"
 	"reflect"
 	"regexp"
 	"runtime"
@@ -54,6 +58,12 @@ type interceptArgs struct {
 	mountSet bool     // whether --mount was passed
 	toPod    []string // --to-pod
 
+	localSocket  string // --local-socket // only valid if !localOnly
+	remoteSocket string // --remote-socket // only valid if !localOnly
+
+	filterSources []string // --filter-source
+	filterPorts   []int    // --filter-port
+
 	dockerRun   bool   // --docker-run
 	dockerMount string // --docker-mount // where to mount in a docker container. Defaults to mount unless mount is "true" or "false".
 
@@ -153,6 +163,22 @@ func interceptCommand(ctx context.Context) *cobra.Command {
 		`Use this to, for example, access proxy/helper sidecars in the intercepted pod. The default protocol is TCP. `+
 		`Use <port>/UDP for UDP ports`)
 
+	flags.StringVar(&args.localSocket, "local-socket", "", ``+
+		`Path of a Unix socket on the workstation that intercepted connections are forwarded to instead of `+
+		`the local port. The local port of --port is still used to identify the connections.`)
+
+	flags.StringVar(&args.remoteSocket, "remote-socket", "", ``+
+		`Absolute path of a Unix socket in the intercepted container. Connections to it are intercepted too. `+
+		`The socket must be in a volume of the container. An existing socket is restored when the intercept ends.`)
+
+	flags.StringSliceVar(&args.filterSources, "filter-source", nil, ``+
+		`Only intercept connections that originate from the given subnet, in CIDR notation. Can be repeated. `+
+		`Other connections are passed through to the intercepted container.`)
+
+	flags.IntSliceVar(&args.filterPorts, "filter-port", nil, ``+
+		`Only intercept connections to the given container port. Can be repeated. `+
+		`Other connections are passed through to the intercepted container.`)
+
 	flags.BoolVarP(&args.dockerRun, "docker-run", "", false, ``+
 		`Run a Docker container with intercepted environment, volume mount, by passing arguments after -- to 'docker run', `+
 		`e.g. '--docker-run -- -it --rm ubuntu:20.04 /bin/bash'`)
@@ -204,6 +230,12 @@ func interceptCommand(ctx context.Context) *cobra.Command {
 			if cmd.Flag("preview-url").Changed && args.previewEnabled {
 				return errcat.User.New("a local-only intercept cannot be previewed")
 			}
+			if args.localSocket != "" {
+				return errcat.User.New("a local-only intercept cannot have a local socket")
+			}
+			if args.remoteSocket != "" {
+				return errcat.User.New("a local-only intercept cannot have a remote socket")
+			}
 		case false:
 			// Actually intercepting something
 			if args.agentName == "" {
@@ -215,6 +247,9 @@ func interceptCommand(ctx context.Context) *cobra.Command {
 		}
 		args.mountSet = cmd.Flag("mount").Changed
 		if args.dockerRun {
+			if args.localSocket != "" {
+				return errcat.User.New("--local-socket cannot be used with --docker-run")
+			}
 			if err := validateDockerArgs(args.cmdline); err != nil {
 				return err
 			}
@@ -376,6 +411,8 @@ func interceptMessage(r *connector.InterceptResult) error {
 		msg = r.ErrorText
 	case common.InterceptError_UNKNOWN_FLAG:
 		msg = fmt.Sprintf("Unknown flag: %s", r.ErrorText)
+	case common.InterceptError_TOO_MANY_INTERCEPTS:
+		msg = fmt.Sprintf("The maximum number of concurrent intercepts (%s) has been reached", r.ErrorText)
 	default:
 		msg = fmt.Sprintf("Unknown error code %d", r.Error)
 	}
@@ -490,6 +527,17 @@ func (is *interceptState) createRequest(ctx context.Context) (*connector.CreateI
 		return nil, err
 	}
 	spec.TargetPort = int32(is.localPort)
+	if is.args.localSocket != "" {
+		if spec.LocalSocketPath, err = filepath.Abs(is.args.localSocket); err != nil {
+			return nil, errcat.User.Newf("invalid --local-socket %q: %w", is.args.localSocket, err)
+		}
+	}
+	if is.args.remoteSocket != "" {
+		if !path.IsAbs(is.args.remoteSocket) {
+			return nil, errcat.User.Newf("--remote-socket %q is not an absolute path", is.args.remoteSocket)
+		}
+		spec.RemoteSocketPath = is.args.remoteSocket
+	}
 
 	doMount := false
 	if err = checkMountCapability(ctx); err == nil {
@@ -517,6 +565,23 @@ func (is *interceptState) createRequest(ctx context.Context) (*connector.CreateI
 		}
 	}
 
+	if len(is.args.filterSources) > 0 || len(is.args.filterPorts) > 0 {
+		filter := &manager.InterceptFilter{}
+		for _, src := range is.args.filterSources {
+			if _, _, err := net.ParseCIDR(src); err != nil {
+				return nil, errcat.User.Newf("invalid --filter-source %q: %w", src, err)
+			}
+			filter.SourceCidrs = append(filter.SourceCidrs, src)
+		}
+		for _, port := range is.args.filterPorts {
+			if port <= 0 || port > math.MaxUint16 {
+				return nil, errcat.User.Newf("invalid --filter-port %d", port)
+			}
+			filter.Ports = append(filter.Ports, int32(port))
+		}
+		spec.Filter = filter
+	}
+
 	if is.args.dockerMount != "" {
 		if !is.args.dockerRun {
 			return nil, errcat.User.New("--docker-mount must be used together with --docker-run")
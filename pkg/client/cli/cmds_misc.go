@@ -4,10 +4,12 @@ import (
 	"context"
 	"fmt"
 	"runtime"
+	"time"
 
 	"github.com/pkg/browser"
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
+	"google.golang.org/protobuf/types/known/durationpb"
 	"k8s.io/cli-runtime/pkg/genericclioptions"
 	"k8s.io/client-go/kubernetes"
 
@@ -55,6 +57,15 @@ func ClusterIdCommand() *cobra.Command {
 func connectCommand() *cobra.Command {
 	var dnsIP string
 	var mappedNamespaces []string
+	var inCluster bool
+	var disableDNS bool
+	var allowedNamespaces []string
+	var assumeManagerInstalled bool
+	var managerNamespace string
+	var managerName string
+	var additionalDNS map[string]string
+	var noTelemetry bool
+	var telemetryInterval time.Duration
 
 	kubeFlags := pflag.NewFlagSet("Kubernetes flags", 0)
 	cmd := &cobra.Command{
@@ -63,8 +74,21 @@ func connectCommand() *cobra.Command {
 		Short: "Connect to a cluster",
 		RunE: func(cmd *cobra.Command, args []string) error {
 			request := &connector.ConnectRequest{
-				KubeFlags:        kubeFlagMap(kubeFlags),
-				MappedNamespaces: mappedNamespaces,
+				KubeFlags:              kubeFlagMap(kubeFlags),
+				MappedNamespaces:       mappedNamespaces,
+				InCluster:              inCluster,
+				DisableDns:             disableDNS,
+				AllowedNamespaces:      allowedNamespaces,
+				AssumeManagerInstalled: assumeManagerInstalled,
+				ManagerNamespace:       managerNamespace,
+				ManagerName:            managerName,
+				AdditionalDns:          additionalDNS,
+			}
+			if noTelemetry || telemetryInterval > 0 {
+				request.Telemetry = &connector.TelemetrySettings{
+					Disabled:    noTelemetry,
+					MinInterval: durationpb.New(telemetryInterval),
+				}
 			}
 
 			if len(args) == 0 {
@@ -94,8 +118,43 @@ func connectCommand() *cobra.Command {
 		"mapped-namespaces", nil, ``+
 			`Comma separated list of namespaces considered by DNS resolver and NAT for outbound connections. `+
 			`Defaults to all namespaces`)
+	nwFlags.BoolVar(&disableDNS,
+		"disable-dns", false, ``+
+			`Only set up routing to the cluster and leave the DNS search path untouched. Useful when running `+
+			`your own DNS`)
+	nwFlags.StringSliceVar(&allowedNamespaces,
+		"allowed-namespaces", nil, ``+
+			`Comma separated list of namespaces that outbound connections are allowed to reach. Connections `+
+			`to other destinations are reset. Defaults to all namespaces`)
+	nwFlags.StringToStringVar(&additionalDNS,
+		"additional-dns", nil, ``+
+			`Comma separated list of <host name>=<IP address> pairs. Each host name resolves to the given `+
+			`IP address for the duration of the session, regardless of the cluster DNS`)
 	flags.AddFlagSet(nwFlags)
 
+	flags.BoolVar(&inCluster, "in-cluster", false, ``+
+		`Connect using the service account of the pod that telepresence runs in instead of a kubeconfig. `+
+		`Kubernetes flags are ignored when this is set`)
+
+	flags.BoolVar(&assumeManagerInstalled, "assume-manager-installed", false, ``+
+		`Skip the checks that ensure that the traffic-manager is installed. Useful when the installation `+
+		`is managed externally. Connect fails if the traffic-manager can't be reached`)
+
+	flags.StringVar(&managerNamespace, "manager-namespace", "", ``+
+		`The namespace of the traffic-manager to connect to. Defaults to the namespace configured in the `+
+		`kubeconfig extension, or "ambassador"`)
+
+	flags.StringVar(&managerName, "manager-name", "", ``+
+		`The name of the service of the traffic-manager to connect to, for clusters with several `+
+		`traffic-managers. A traffic-manager with a custom name must already be installed. Defaults to "traffic-manager"`)
+
+	flags.BoolVar(&noTelemetry, "no-telemetry", false, ``+
+		`Don't send any telemetry reports during the session`)
+
+	flags.DurationVar(&telemetryInterval, "telemetry-interval", 0, ``+
+		`The minimum time between two telemetry reports during the session. Reports that are made sooner `+
+		`are discarded. Defaults to sending all reports`)
+
 	kubeConfig := genericclioptions.NewConfigFlags(false)
 	kubeConfig.Namespace = nil // "connect", don't take --namespace
 	kubeConfig.AddFlags(kubeFlags)
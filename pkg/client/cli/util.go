@@ -105,6 +105,9 @@ func connect(ctx context.Context, connectorClient connector.ConnectorClient, std
 		return connect(ctx, connectorClient, stdout, &connector.ConnectRequest{})
 	case connector.ConnectInfo_MUST_RESTART:
 		msg = "Cluster configuration changed, please quit telepresence and reconnect"
+		if ci.ErrorText != "" {
+			msg = fmt.Sprintf("Cluster configuration changed (%s), please quit telepresence and reconnect", ci.ErrorText)
+		}
 	case connector.ConnectInfo_TRAFFIC_MANAGER_FAILED, connector.ConnectInfo_CLUSTER_FAILED, connector.ConnectInfo_DAEMON_FAILED:
 		msg = ci.ErrorText
 		if ci.ErrorCategory != 0 {
@@ -155,6 +155,8 @@ type Timeouts struct {
 	PrivateClusterConnect time.Duration `json:"clusterConnect,omitempty" yaml:"clusterConnect,omitempty"`
 	// PrivateEndpointDial is how long to wait for a Dial to a service for which the IP is known.
 	PrivateEndpointDial time.Duration `json:"endpointDial,omitempty" yaml:"endpointDial,omitempty"`
+	// PrivateGracefulShutdown is how long the daemons wait for their goroutines to finish when shutting down
+	PrivateGracefulShutdown time.Duration `json:"gracefulShutdown,omitempty" yaml:"gracefulShutdown,omitempty"`
 	// PrivateHelm is how long to wait for any helm operation.
 	PrivateHelm time.Duration `json:"helm,omitempty" yaml:"helm,omitempty"`
 	// PrivateIntercept is the time to wait for an intercept after the agents has been installed
@@ -176,6 +178,7 @@ const (
 	TimeoutApply
 	TimeoutClusterConnect
 	TimeoutEndpointDial
+	TimeoutGracefulShutdown
 	TimeoutHelm
 	TimeoutIntercept
 	TimeoutProxyDial
@@ -214,6 +217,8 @@ func (t *Timeouts) Get(timeoutID TimeoutID) time.Duration {
 		timeoutVal = t.PrivateClusterConnect
 	case TimeoutEndpointDial:
 		timeoutVal = t.PrivateEndpointDial
+	case TimeoutGracefulShutdown:
+		timeoutVal = t.PrivateGracefulShutdown
 	case TimeoutHelm:
 		timeoutVal = t.PrivateHelm
 	case TimeoutIntercept:
@@ -265,6 +270,9 @@ func (e timeoutErr) Error() string {
 	case TimeoutEndpointDial:
 		yamlName = "endpointDial"
 		humanName = "tunnel endpoint dial with known IP"
+	case TimeoutGracefulShutdown:
+		yamlName = "gracefulShutdown"
+		humanName = "graceful shutdown"
 	case TimeoutHelm:
 		yamlName = "helm"
 		humanName = "helm operation"
@@ -323,6 +331,8 @@ func (t *Timeouts) UnmarshalYAML(node *yaml.Node) (err error) {
 			dp = &t.PrivateClusterConnect
 		case "endpointDial":
 			dp = &t.PrivateEndpointDial
+		case "gracefulShutdown":
+			dp = &t.PrivateGracefulShutdown
 		case "helm":
 			dp = &t.PrivateHelm
 		case "intercept":
@@ -365,6 +375,7 @@ const defaultTimeoutsAgentInstall = 120 * time.Second
 const defaultTimeoutsApply = 1 * time.Minute
 const defaultTimeoutsClusterConnect = 20 * time.Second
 const defaultTimeoutsEndpointDial = 3 * time.Second
+const defaultTimeoutsGracefulShutdown = 2 * time.Second
 const defaultTimeoutsHelm = 30 * time.Second
 const defaultTimeoutsIntercept = 5 * time.Second
 const defaultTimeoutsProxyDial = 5 * time.Second
@@ -377,6 +388,7 @@ var defaultTimeouts = Timeouts{
 	PrivateApply:                 defaultTimeoutsApply,
 	PrivateClusterConnect:        defaultTimeoutsClusterConnect,
 	PrivateEndpointDial:          defaultTimeoutsEndpointDial,
+	PrivateGracefulShutdown:      defaultTimeoutsGracefulShutdown,
 	PrivateHelm:                  defaultTimeoutsHelm,
 	PrivateIntercept:             defaultTimeoutsIntercept,
 	PrivateProxyDial:             defaultTimeoutsProxyDial,
@@ -405,6 +417,9 @@ func (t Timeouts) MarshalYAML() (any, error) {
 	if t.PrivateEndpointDial != 0 && t.PrivateEndpointDial != defaultTimeoutsEndpointDial {
 		tm["endpointDial"] = t.PrivateEndpointDial.String()
 	}
+	if t.PrivateGracefulShutdown != 0 && t.PrivateGracefulShutdown != defaultTimeoutsGracefulShutdown {
+		tm["gracefulShutdown"] = t.PrivateGracefulShutdown.String()
+	}
 	if t.PrivateHelm != 0 && t.PrivateHelm != defaultTimeoutsHelm {
 		tm["helm"] = t.PrivateHelm.String()
 	}
@@ -440,6 +455,9 @@ func (t *Timeouts) merge(o *Timeouts) {
 	if o.PrivateEndpointDial != 0 {
 		t.PrivateEndpointDial = o.PrivateEndpointDial
 	}
+	if o.PrivateGracefulShutdown != 0 {
+		t.PrivateGracefulShutdown = o.PrivateGracefulShutdown
+	}
 	if o.PrivateHelm != 0 {
 		t.PrivateHelm = o.PrivateHelm
 	}
@@ -693,12 +711,32 @@ type Grpc struct {
 	// MaxReceiveSize is the maximum message size in bytes the client can receive in a gRPC call or stream message.
 	// Overrides the gRPC default of 4MB.
 	MaxReceiveSize resource.Quantity `json:"maxReceiveSize,omitempty" yaml:"maxReceiveSize,omitempty"`
+
+	// TLS enables mutual TLS on the connector's gRPC socket when set.
+	TLS GrpcTLS `json:"tls,omitempty" yaml:"tls,omitempty"`
+
+	// Reflection registers the gRPC server reflection service on the connector's gRPC socket, so
+	// that tools like grpcurl can list and invoke its RPCs. Intended for debugging.
+	Reflection bool `json:"reflection,omitempty" yaml:"reflection,omitempty"`
+
+	// ListenAddress is a TCP address, e.g. "0.0.0.0:8765", that the connector's gRPC server
+	// listens on in addition to its local socket, so that it can be reached from other hosts.
+	// It requires TLS, because anyone that can reach the address could otherwise control the
+	// connector.
+	ListenAddress string `json:"listenAddress,omitempty" yaml:"listenAddress,omitempty"`
 }
 
 func (g *Grpc) merge(o *Grpc) {
 	if !o.MaxReceiveSize.IsZero() {
 		g.MaxReceiveSize = o.MaxReceiveSize
 	}
+	g.TLS.merge(&o.TLS)
+	if o.Reflection {
+		g.Reflection = true
+	}
+	if o.ListenAddress != "" {
+		g.ListenAddress = o.ListenAddress
+	}
 }
 
 // UnmarshalYAML parses the images YAML
@@ -723,6 +761,19 @@ func (g *Grpc) UnmarshalYAML(node *yaml.Node) (err error) {
 			} else {
 				g.MaxReceiveSize = val
 			}
+		case "tls":
+			if err := v.Decode(&g.TLS); err != nil {
+				return err
+			}
+		case "reflection":
+			val, err := strconv.ParseBool(v.Value)
+			if err != nil {
+				dlog.Warn(parseContext, withLoc(fmt.Sprintf("bool expected for key %q", kv), ms[i]))
+			} else {
+				g.Reflection = val
+			}
+		case "listenAddress":
+			g.ListenAddress = v.Value
 		default:
 			if parseContext != nil {
 				dlog.Warn(parseContext, withLoc(fmt.Sprintf("unknown key %q", kv), ms[i]))
@@ -738,6 +789,15 @@ func (g Grpc) MarshalYAML() (any, error) {
 	if !g.MaxReceiveSize.IsZero() {
 		cm["maxReceiveSize"] = g.MaxReceiveSize.String()
 	}
+	if g.TLS.Enabled() {
+		cm["tls"] = g.TLS
+	}
+	if g.Reflection {
+		cm["reflection"] = true
+	}
+	if g.ListenAddress != "" {
+		cm["listenAddress"] = g.ListenAddress
+	}
 	return cm, nil
 }
 
@@ -753,12 +813,19 @@ func (g *TelepresenceAPI) merge(o *TelepresenceAPI) {
 
 type Daemons struct {
 	UserDaemonBinary string `json:"userDaemonBinary,omitempty" yaml:"userDaemonBinary,omitempty"`
+
+	// ConnMetricsFile is the name of a file that the root daemon appends a line of JSON to for each
+	// TCP connection that it closes, describing the connection's lifetime and counters.
+	ConnMetricsFile string `json:"connMetricsFile,omitempty" yaml:"connMetricsFile,omitempty"`
 }
 
 func (d *Daemons) merge(o *Daemons) {
 	if o.UserDaemonBinary != "" {
 		d.UserDaemonBinary = o.UserDaemonBinary
 	}
+	if o.ConnMetricsFile != "" {
+		d.ConnMetricsFile = o.ConnMetricsFile
+	}
 }
 
 const defaultInterceptDefaultPort = 8080
@@ -770,6 +837,15 @@ var defaultIntercept = Intercept{
 type Intercept struct {
 	AppProtocolStrategy k8sapi.AppProtocolStrategy `json:"appProtocolStrategy,omitempty" yaml:"appProtocolStrategy,omitempty"`
 	DefaultPort         int                        `json:"defaultPort,omitempty" yaml:"defaultPort,omitempty"`
+
+	// MaxConcurrent is the maximum number of intercepts that one connector session can hold at
+	// the same time. Zero means unlimited.
+	MaxConcurrent int `json:"maxConcurrent,omitempty" yaml:"maxConcurrent,omitempty"`
+
+	// MaxSessionDuration is the maximum time that a connector session may last, regardless of
+	// activity. The intercepts are removed and the session is ended when it has passed. Zero
+	// means unlimited.
+	MaxSessionDuration time.Duration `json:"maxSessionDuration,omitempty" yaml:"maxSessionDuration,omitempty"`
 }
 
 func (ic *Intercept) merge(o *Intercept) {
@@ -779,6 +855,12 @@ func (ic *Intercept) merge(o *Intercept) {
 	if o.DefaultPort != 0 {
 		ic.DefaultPort = o.DefaultPort
 	}
+	if o.MaxConcurrent != 0 {
+		ic.MaxConcurrent = o.MaxConcurrent
+	}
+	if o.MaxSessionDuration != 0 {
+		ic.MaxSessionDuration = o.MaxSessionDuration
+	}
 }
 
 // IsZero controls whether this element will be included in marshalled output
@@ -795,6 +877,12 @@ func (ic Intercept) MarshalYAML() (any, error) {
 	if ic.AppProtocolStrategy != k8sapi.Http2Probe {
 		im["appProtocolStrategy"] = ic.AppProtocolStrategy.String()
 	}
+	if ic.MaxConcurrent != 0 {
+		im["maxConcurrent"] = ic.MaxConcurrent
+	}
+	if ic.MaxSessionDuration != 0 {
+		im["maxSessionDuration"] = ic.MaxSessionDuration.String()
+	}
 	return im, nil
 }
 
@@ -846,6 +934,7 @@ func GetDefaultConfig() Config {
 			PrivateApply:                 defaultTimeoutsApply,
 			PrivateClusterConnect:        defaultTimeoutsClusterConnect,
 			PrivateEndpointDial:          defaultTimeoutsEndpointDial,
+			PrivateGracefulShutdown:      defaultTimeoutsGracefulShutdown,
 			PrivateHelm:                  defaultTimeoutsHelm,
 			PrivateIntercept:             defaultTimeoutsIntercept,
 			PrivateProxyDial:             defaultTimeoutsProxyDial,
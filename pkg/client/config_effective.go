@@ -0,0 +1,75 @@
+package client
+
+import (
+	"context"
+
+	"gopkg.in/yaml.v3"
+)
+
+// grpcDefaultMaxReceiveSize is the maximum receive size that gRPC uses when none is configured.
+const grpcDefaultMaxReceiveSize = "4Mi"
+
+// EffectiveYAML returns the configuration in the same format as the config file, but unlike
+// the MarshalYAML methods, which omit values that are equal to their defaults, it includes every
+// setting with the value that is in effect. Image settings that aren't configured are resolved
+// using the environment of the given context.
+func (c *Config) EffectiveYAML(ctx context.Context) ([]byte, error) {
+	t := &c.Timeouts
+	grpcMap := map[string]any{
+		"maxReceiveSize": grpcDefaultMaxReceiveSize,
+		"tls": map[string]string{
+			"certFile": c.Grpc.TLS.CertFile,
+			"keyFile":  c.Grpc.TLS.KeyFile,
+			"caFile":   c.Grpc.TLS.CAFile,
+		},
+		"reflection":    c.Grpc.Reflection,
+		"listenAddress": c.Grpc.ListenAddress,
+	}
+	if !c.Grpc.MaxReceiveSize.IsZero() {
+		grpcMap["maxReceiveSize"] = c.Grpc.MaxReceiveSize.String()
+	}
+	return yaml.Marshal(map[string]any{
+		"timeouts": map[string]string{
+			"agentInstall":          t.PrivateAgentInstall.String(),
+			"apply":                 t.PrivateApply.String(),
+			"clusterConnect":        t.PrivateClusterConnect.String(),
+			"endpointDial":          t.PrivateEndpointDial.String(),
+			"gracefulShutdown":      t.PrivateGracefulShutdown.String(),
+			"helm":                  t.PrivateHelm.String(),
+			"intercept":             t.PrivateIntercept.String(),
+			"proxyDial":             t.PrivateProxyDial.String(),
+			"roundtripLatency":      t.PrivateRoundtripLatency.String(),
+			"trafficManagerAPI":     t.PrivateTrafficManagerAPI.String(),
+			"trafficManagerConnect": t.PrivateTrafficManagerConnect.String(),
+		},
+		"logLevels": map[string]string{
+			"userDaemon": c.LogLevels.UserDaemon.String(),
+			"rootDaemon": c.LogLevels.RootDaemon.String(),
+		},
+		"images": map[string]string{
+			"registry":        c.Images.Registry(ctx),
+			"agentImage":      c.Images.AgentImage(ctx),
+			"webhookRegistry": c.Images.WebhookRegistry(ctx),
+		},
+		"cloud": map[string]any{
+			"skipLogin":       c.Cloud.SkipLogin,
+			"refreshMessages": c.Cloud.RefreshMessages.String(),
+			"systemaHost":     c.Cloud.SystemaHost,
+			"systemaPort":     c.Cloud.SystemaPort,
+		},
+		"grpc": grpcMap,
+		"telepresenceAPI": map[string]int{
+			"port": c.TelepresenceAPI.Port,
+		},
+		"daemons": map[string]string{
+			"userDaemonBinary": c.Daemons.UserDaemonBinary,
+			"connMetricsFile":  c.Daemons.ConnMetricsFile,
+		},
+		"intercept": map[string]any{
+			"appProtocolStrategy": c.Intercept.AppProtocolStrategy.String(),
+			"defaultPort":         c.Intercept.DefaultPort,
+			"maxConcurrent":       c.Intercept.MaxConcurrent,
+			"maxSessionDuration":  c.Intercept.MaxSessionDuration.String(),
+		},
+	})
+}
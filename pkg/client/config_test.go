@@ -35,6 +35,7 @@ logLevels:
 		/* user */ `
 timeouts:
   clusterConnect: 25
+  gracefulShutdown: 10s
   proxyDial: 17.0
 logLevels:
   rootDaemon: trace
@@ -46,6 +47,8 @@ telepresenceAPI:
 intercept:
   appProtocolStrategy: portName
   defaultPort: 9080
+  maxConcurrent: 3
+  maxSessionDuration: 8h
 `,
 	}
 
@@ -74,6 +77,7 @@ intercept:
 	assert.Equal(t, 2*time.Minute+10*time.Second, to.PrivateAgentInstall) // from sys1
 	assert.Equal(t, 33*time.Second, to.PrivateApply)                      // from sys2
 	assert.Equal(t, 25*time.Second, to.PrivateClusterConnect)             // from user
+	assert.Equal(t, 10*time.Second, to.PrivateGracefulShutdown)           // from user
 	assert.Equal(t, 17*time.Second, to.PrivateProxyDial)                  // from user
 
 	assert.Equal(t, logrus.DebugLevel, cfg.LogLevels.UserDaemon) // from sys2
@@ -84,6 +88,8 @@ intercept:
 	assert.Equal(t, 1234, cfg.TelepresenceAPI.Port)                                            // from user
 	assert.Equal(t, k8sapi.PortName, cfg.Intercept.AppProtocolStrategy)                        // from user
 	assert.Equal(t, 9080, cfg.Intercept.DefaultPort)                                           // from user
+	assert.Equal(t, 3, cfg.Intercept.MaxConcurrent)                                            // from user
+	assert.Equal(t, 8*time.Hour, cfg.Intercept.MaxSessionDuration)                             // from user
 }
 
 func Test_ConfigMarshalYAML(t *testing.T) {
@@ -94,12 +100,18 @@ func Test_ConfigMarshalYAML(t *testing.T) {
 	cfg := GetDefaultConfig()
 	cfg.Images.PrivateAgentImage = "something:else"
 	cfg.Timeouts.PrivateTrafficManagerAPI = defaultTimeoutsTrafficManagerAPI + 20*time.Second
+	cfg.Timeouts.PrivateGracefulShutdown = defaultTimeoutsGracefulShutdown + 8*time.Second
 	cfg.Cloud.RefreshMessages += 10 * time.Minute
 	cfg.LogLevels.UserDaemon = logrus.TraceLevel
 	cfg.Grpc.MaxReceiveSize, _ = resource.ParseQuantity("20Mi")
+	cfg.Grpc.TLS = GrpcTLS{CertFile: "/etc/tp/cert.pem", KeyFile: "/etc/tp/key.pem", CAFile: "/etc/tp/ca.pem"}
+	cfg.Grpc.Reflection = true
+	cfg.Grpc.ListenAddress = "0.0.0.0:8765"
 	cfg.TelepresenceAPI.Port = 4567
 	cfg.Intercept.AppProtocolStrategy = k8sapi.PortName
 	cfg.Intercept.DefaultPort = 9080
+	cfg.Intercept.MaxConcurrent = 5
+	cfg.Intercept.MaxSessionDuration = 90 * time.Minute
 	cfgBytes, err := yaml.Marshal(cfg)
 	require.NoError(t, err)
 
@@ -119,3 +131,66 @@ func Test_ConfigMarshalYAMLDefaults(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, "{}\n", string(cfgBytes))
 }
+
+func Test_ConfigEffectiveYAML(t *testing.T) {
+	ctx := dlog.NewTestContext(t, true)
+	env, err := LoadEnv(ctx)
+	require.NoError(t, err)
+	ctx = WithEnv(ctx, env)
+
+	const cfgYAML = `
+timeouts:
+  apply: 2m
+  helm: 45s
+logLevels:
+  userDaemon: debug
+images:
+  registry: example.com/tp
+  agentImage: tel2:2.7.0
+  webhookRegistry: example.com/hooks
+grpc:
+  maxReceiveSize: 10Mi
+intercept:
+  defaultPort: 9080
+`
+	tmp := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(tmp, configFile), []byte(cfgYAML), 0600))
+	ctx = filelocation.WithAppUserConfigDir(ctx, tmp)
+	cfg, err := LoadConfig(ctx)
+	require.NoError(t, err)
+
+	data, err := cfg.EffectiveYAML(ctx)
+	require.NoError(t, err)
+
+	// Values that are equal to their defaults are included.
+	var m map[string]map[string]any
+	require.NoError(t, yaml.Unmarshal(data, &m))
+	assert.Equal(t, "2m0s", m["timeouts"]["apply"])
+	assert.Equal(t, "3s", m["timeouts"]["endpointDial"])
+	assert.Equal(t, "info", m["logLevels"]["rootDaemon"])
+	assert.Equal(t, "app.getambassador.io", m["cloud"]["systemaHost"])
+	assert.Equal(t, "10Mi", m["grpc"]["maxReceiveSize"])
+	assert.Equal(t, 9080, m["intercept"]["defaultPort"])
+
+	// The exported config is read back as the config it was exported from.
+	var cfg2 Config
+	require.NoError(t, yaml.Unmarshal(data, &cfg2))
+	require.Equal(t, cfg, &cfg2)
+}
+
+func Test_ConfigEffectiveYAMLDefaults(t *testing.T) {
+	ctx := dlog.NewTestContext(t, true)
+	env, err := LoadEnv(ctx)
+	require.NoError(t, err)
+	ctx = WithEnv(ctx, env)
+
+	cfg := GetDefaultConfig()
+	data, err := cfg.EffectiveYAML(ctx)
+	require.NoError(t, err)
+	var m map[string]map[string]any
+	require.NoError(t, yaml.Unmarshal(data, &m))
+	assert.Equal(t, defaultTimeoutsTrafficManagerConnect.String(), m["timeouts"]["trafficManagerConnect"])
+	assert.Equal(t, grpcDefaultMaxReceiveSize, m["grpc"]["maxReceiveSize"])
+	assert.Equal(t, env.Registry, m["images"]["registry"])
+	assert.Equal(t, env.Registry, m["images"]["webhookRegistry"])
+}
@@ -20,6 +20,10 @@ const (
 	Config       // Errors in config.yml, extensions, or kubeconfig
 	NoDaemonLogs // Other error generated in the CLI process, so no use pointing the user to logs
 	Unknown      // Something else. Consult the logs
+
+	// VersionMismatch is used when the client and the traffic-manager versions are incompatible. It's
+	// declared last so that the values of the other categories remain stable when passed over gRPC.
+	VersionMismatch
 )
 
 // New creates a new categorized error based in its argument. The argument
@@ -0,0 +1,92 @@
+package client
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"os"
+
+	"google.golang.org/grpc/credentials"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+)
+
+// GrpcTLS configures mutual TLS on the connector's gRPC socket. The connector uses the
+// certificate as its server certificate and requires that clients present a certificate
+// signed by the CA. Clients, such as the CLI and the root daemon, use the same certificate
+// as their client certificate and verify the connector using the CA. The certificate must
+// therefore be valid for both server and client authentication, for the name "localhost".
+type GrpcTLS struct {
+	// CertFile is the path to a PEM encoded certificate
+	CertFile string `json:"certFile,omitempty" yaml:"certFile,omitempty"`
+
+	// KeyFile is the path to the PEM encoded private key of the certificate
+	KeyFile string `json:"keyFile,omitempty" yaml:"keyFile,omitempty"`
+
+	// CAFile is the path to the PEM encoded certificate of the CA that is used when verifying peers
+	CAFile string `json:"caFile,omitempty" yaml:"caFile,omitempty"`
+}
+
+// Enabled returns true when any of the TLS settings are present.
+func (t *GrpcTLS) Enabled() bool {
+	return t.CertFile != "" || t.KeyFile != "" || t.CAFile != ""
+}
+
+func (t *GrpcTLS) merge(o *GrpcTLS) {
+	if o.CertFile != "" {
+		t.CertFile = o.CertFile
+	}
+	if o.KeyFile != "" {
+		t.KeyFile = o.KeyFile
+	}
+	if o.CAFile != "" {
+		t.CAFile = o.CAFile
+	}
+}
+
+// ServerConfig returns a tls.Config that requires and verifies client certificates.
+func (t *GrpcTLS) ServerConfig() (*tls.Config, error) {
+	cert, pool, err := t.load()
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// ClientCredentials returns transport credentials that present the certificate to the server
+// and verify the server using the CA.
+func (t *GrpcTLS) ClientCredentials() (credentials.TransportCredentials, error) {
+	cert, pool, err := t.load()
+	if err != nil {
+		return nil, err
+	}
+	return credentials.NewTLS(&tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      pool,
+		ServerName:   "localhost",
+		MinVersion:   tls.VersionTLS12,
+	}), nil
+}
+
+func (t *GrpcTLS) load() (tls.Certificate, *x509.CertPool, error) {
+	if t.CertFile == "" || t.KeyFile == "" || t.CAFile == "" {
+		return tls.Certificate{}, nil, errcat.Config.New("grpc.tls requires certFile, keyFile, and caFile")
+	}
+	cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+	if err != nil {
+		return tls.Certificate{}, nil, errcat.Config.Newf("unable to load grpc.tls certificate: %v", err)
+	}
+	caPEM, err := os.ReadFile(t.CAFile)
+	if err != nil {
+		return tls.Certificate{}, nil, errcat.Config.Newf("unable to read grpc.tls CA: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return tls.Certificate{}, nil, errcat.Config.Newf("no certificates found in %s", t.CAFile)
+	}
+	return cert, pool, nil
+}
@@ -0,0 +1,121 @@
+package client
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+
+	"github.com/datawire/dlib/dhttp"
+	"github.com/datawire/dlib/dlog"
+)
+
+// writeTestCerts creates a CA and a certificate signed by that CA that is valid for both server and
+// client authentication, and returns a GrpcTLS that refers to them.
+func writeTestCerts(t *testing.T) *GrpcTLS {
+	dir := t.TempDir()
+	writePEM := func(name, tp string, der []byte) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: tp, Bytes: der}), 0o600))
+		return path
+	}
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, &caKey.PublicKey, caKey)
+	require.NoError(t, err)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, caTmpl, &key.PublicKey, caKey)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	return &GrpcTLS{
+		CertFile: writePEM("cert.pem", "CERTIFICATE", der),
+		KeyFile:  writePEM("key.pem", "EC PRIVATE KEY", keyDER),
+		CAFile:   writePEM("ca.pem", "CERTIFICATE", caDER),
+	}
+}
+
+func TestGrpcTLS(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+	gt := writeTestCerts(t)
+
+	srv := grpc.NewServer()
+	grpc_health_v1.RegisterHealthServer(srv, health.NewServer())
+	sc := &dhttp.ServerConfig{Handler: srv}
+	var err error
+	sc.TLSConfig, err = gt.ServerConfig()
+	require.NoError(t, err)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() {
+		_ = sc.ServeTLS(ctx, ln, "", "")
+	}()
+
+	check := func(creds credentials.TransportCredentials) error {
+		cc, err := grpc.DialContext(ctx, ln.Addr().String(), grpc.WithTransportCredentials(creds))
+		require.NoError(t, err)
+		defer cc.Close()
+		tc, tCancel := context.WithTimeout(ctx, 5*time.Second)
+		defer tCancel()
+		_, err = grpc_health_v1.NewHealthClient(cc).Check(tc, &grpc_health_v1.HealthCheckRequest{})
+		return err
+	}
+
+	// A client that doesn't present a certificate is rejected.
+	caPEM, err := os.ReadFile(gt.CAFile)
+	require.NoError(t, err)
+	pool := x509.NewCertPool()
+	require.True(t, pool.AppendCertsFromPEM(caPEM))
+	require.Error(t, check(credentials.NewTLS(&tls.Config{RootCAs: pool, ServerName: "localhost", MinVersion: tls.VersionTLS12})))
+
+	// A client that presents a certificate signed by the CA is accepted.
+	creds, err := gt.ClientCredentials()
+	require.NoError(t, err)
+	require.NoError(t, check(creds))
+}
+
+func TestGrpcTLS_Incomplete(t *testing.T) {
+	gt := GrpcTLS{CertFile: "cert.pem"}
+	require.True(t, gt.Enabled())
+	_, err := gt.ServerConfig()
+	require.Error(t, err)
+}
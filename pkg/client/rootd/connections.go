@@ -0,0 +1,69 @@
+package rootd
+
+import (
+	"context"
+	"net"
+	"sort"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/daemon"
+	"github.com/telepresenceio/telepresence/v2/pkg/tunnel"
+	"github.com/telepresenceio/telepresence/v2/pkg/vif/tcp"
+)
+
+// listConnections returns the connections of the given pool that match the given filter, sorted
+// by connection ID.
+func listConnections(pool *tunnel.Pool, filter *rpc.ConnectionFilter) (*rpc.ConnectionList, error) {
+	var ip net.IP
+	if dip := filter.DestinationIp; len(dip) > 0 {
+		if len(dip) != net.IPv4len && len(dip) != net.IPv6len {
+			return nil, status.Errorf(codes.InvalidArgument, "destination IP must be 4 or 16 bytes, got %d", len(dip))
+		}
+		ip = dip
+	}
+	port := filter.DestinationPort
+	if port < 0 || port > 0xffff {
+		return nil, status.Errorf(codes.InvalidArgument, "destination port %d is out of range", port)
+	}
+
+	handlers := pool.HandlersTo(ip, uint16(port))
+	ids := make([]tunnel.ConnID, 0, len(handlers))
+	for id := range handlers {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	cl := &rpc.ConnectionList{Connections: make([]*rpc.ConnectionInfo, len(ids))}
+	for i, id := range ids {
+		ci := &rpc.ConnectionInfo{
+			Id:              id.String(),
+			Protocol:        id.ProtocolString(),
+			SourceIp:        id.Source(),
+			SourcePort:      int32(id.SourcePort()),
+			DestinationIp:   id.Destination(),
+			DestinationPort: int32(id.DestinationPort()),
+		}
+		if h, ok := handlers[id].(tcp.PacketHandler); ok {
+			stats := h.Stats()
+			ci.State = h.State()
+			ci.BytesToManager = stats.BytesToManager
+			ci.BytesToTun = stats.BytesToTun
+			ci.RetransmittedBytes = stats.RetransmittedBytes
+			ci.Age = durationpb.New(stats.Age)
+			ci.Idle = durationpb.New(stats.Idle)
+		}
+		cl.Connections[i] = ci
+	}
+	return cl, nil
+}
+
+func (d *service) ListConnections(ctx context.Context, filter *rpc.ConnectionFilter) (result *rpc.ConnectionList, err error) {
+	err = d.withSession(ctx, func(_ context.Context, session *session) error {
+		result, err = listConnections(session.handlers, filter)
+		return err
+	})
+	return result, err
+}
@@ -114,6 +114,9 @@ func (s *Server) updateLinkDomains(c context.Context, paths []string, dev *vif.D
 	for _, sfx := range s.config.IncludeSuffixes {
 		paths = append(paths, "~"+strings.TrimPrefix(sfx, "."))
 	}
+	for _, domain := range s.additionalDomains() {
+		paths = append(paths, "~"+domain)
+	}
 	paths = append(paths, "~"+s.clusterDomain)
 	namespaces[tel2SubDomain] = struct{}{}
 
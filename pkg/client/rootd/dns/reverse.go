@@ -0,0 +1,90 @@
+package dns
+
+import (
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/miekg/dns"
+)
+
+// reverseIndex maps the IPs of the resolved entries of the cache to their names. It's kept in step
+// with the cache, so that a name can be found without a scan of the cache. When several names
+// resolve to the same IP, the one that was resolved last is used.
+type reverseIndex struct {
+	sync.Mutex
+	names map[string][]string // IP to names, in the order that they were resolved
+	ips   map[string][]string // name to IPs
+}
+
+// set replaces the IPs of the given name with the ones in the given answer.
+func (r *reverseIndex) set(name string, answer []dns.RR) {
+	var ips []string
+	for _, rr := range answer {
+		switch rr := rr.(type) {
+		case *dns.A:
+			ips = append(ips, rr.A.String())
+		case *dns.AAAA:
+			ips = append(ips, rr.AAAA.String())
+		}
+	}
+	r.Lock()
+	defer r.Unlock()
+	r.removeLocked(name)
+	if len(ips) == 0 {
+		return
+	}
+	if r.names == nil {
+		r.names = make(map[string][]string)
+		r.ips = make(map[string][]string)
+	}
+	name = strings.TrimSuffix(name, ".")
+	for _, ip := range ips {
+		r.names[ip] = append(r.names[ip], name)
+	}
+	r.ips[name] = ips
+}
+
+// remove removes the IPs of the given name.
+func (r *reverseIndex) remove(name string) {
+	r.Lock()
+	r.removeLocked(name)
+	r.Unlock()
+}
+
+func (r *reverseIndex) removeLocked(name string) {
+	name = strings.TrimSuffix(name, ".")
+	for _, ip := range r.ips[name] {
+		names := r.names[ip]
+		for i, n := range names {
+			if n == name {
+				names = append(names[:i], names[i+1:]...)
+				break
+			}
+		}
+		if len(names) == 0 {
+			delete(r.names, ip)
+		} else {
+			r.names[ip] = names
+		}
+	}
+	delete(r.ips, name)
+}
+
+// clear removes all entries.
+func (r *reverseIndex) clear() {
+	r.Lock()
+	r.names = nil
+	r.ips = nil
+	r.Unlock()
+}
+
+// nameOf returns the name that the given IP was resolved from, or an empty string.
+func (r *reverseIndex) nameOf(ip net.IP) string {
+	r.Lock()
+	defer r.Unlock()
+	if names := r.names[ip.String()]; len(names) > 0 {
+		return names[len(names)-1]
+	}
+	return ""
+}
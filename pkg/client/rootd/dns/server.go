@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"sort"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -53,7 +54,8 @@ type Server struct {
 	resolve      Resolver
 	requestCount int64
 	cache        sync.Map
-	recursive    int32 // one of the recursionXXX constants declared above (unique type avoided because it just gets messy with the atomic calls)
+	reverse      reverseIndex // IPs of the resolved cache entries
+	recursive    int32        // one of the recursionXXX constants declared above (unique type avoided because it just gets messy with the atomic calls)
 	cacheResolve func(*dns.Question) ([]dns.RR, error)
 
 	// Namespaces, accessible using <service-name>.<namespace-name>
@@ -61,7 +63,7 @@ type Server struct {
 	domains    map[string]struct{}
 	search     []string
 
-	// The domainsLock locks usage of namespaces, domains, and search
+	// The domainsLock locks usage of namespaces, domains, search, and the config's include and exclude suffixes
 	domainsLock sync.RWMutex
 
 	// searchPathCh receives requests to change the search path.
@@ -74,6 +76,10 @@ type Server struct {
 
 	// Function that sends a lookup requrest to the traffic-manager
 	clusterLookup func(context.Context, string) ([][]byte, error)
+
+	// additionalRecords are fully qualified, lower case, names that resolve to the given IPs
+	// regardless of the cluster DNS. They're immutable once the server has been created.
+	additionalRecords map[string]net.IP
 }
 
 type cacheEntry struct {
@@ -90,20 +96,24 @@ func (dv *cacheEntry) expired() bool {
 	return time.Since(dv.created) > cacheTTL
 }
 
+// defaultExcludeSuffixes are the suffixes that are never resolved using the cluster unless
+// other excludes are configured.
+var defaultExcludeSuffixes = []string{
+	".arpa",
+	".com",
+	".io",
+	".net",
+	".org",
+	".ru",
+}
+
 // NewServer returns a new dns.Server
 func NewServer(config *rpc.DNSConfig, clusterLookup func(context.Context, string) ([][]byte, error)) *Server {
 	if config == nil {
 		config = &rpc.DNSConfig{}
 	}
 	if len(config.ExcludeSuffixes) == 0 {
-		config.ExcludeSuffixes = []string{
-			".arpa",
-			".com",
-			".io",
-			".net",
-			".org",
-			".ru",
-		}
+		config.ExcludeSuffixes = defaultExcludeSuffixes
 	}
 	if config.LookupTimeout.AsDuration() <= 0 {
 		config.LookupTimeout = durationpb.New(8 * time.Second)
@@ -117,10 +127,36 @@ func NewServer(config *rpc.DNSConfig, clusterLookup func(context.Context, string
 		clusterDomain: defaultClusterDomain,
 		clusterLookup: clusterLookup,
 	}
+	if len(config.AdditionalRecords) > 0 {
+		s.additionalRecords = make(map[string]net.IP, len(config.AdditionalRecords))
+		for name, ip := range config.AdditionalRecords {
+			s.additionalRecords[dns.Fqdn(strings.ToLower(name))] = ip
+		}
+	}
 	s.cacheResolve = s.resolveWithRecursionCheck
 	return s
 }
 
+// additionalDomains returns the names of the additional records, without the trailing dot. They're
+// routed to this resolver along with the include suffixes.
+func (s *Server) additionalDomains() []string {
+	domains := make([]string, 0, len(s.additionalRecords))
+	for name := range s.additionalRecords {
+		domains = append(domains, strings.TrimSuffix(name, "."))
+	}
+	sort.Strings(domains)
+	return domains
+}
+
+// resolveName returns the IP of the additional record with the given name if one exists, and
+// otherwise resolves the name using the resolver that was passed to Run.
+func (s *Server) resolveName(name string) ([]net.IP, error) {
+	if ip, ok := s.additionalRecords[strings.ToLower(name)]; ok {
+		return []net.IP{ip}, nil
+	}
+	return s.resolve(s.ctx, name)
+}
+
 // tel2SubDomain aims to fix a search-path problem when using Docker on non-linux systems where
 // Docker uses its own search-path for single label names. This means that the search path that
 // is declared in the macOS resolver is ignored although the rest of the DNS-resolution works OK.
@@ -160,6 +196,9 @@ func (s *Server) shouldDoClusterLookup(query string) bool {
 
 	query = query[:len(query)-1] // skip last dot
 
+	s.domainsLock.RLock()
+	defer s.domainsLock.RUnlock()
+
 	// Always include configured includeSuffixes
 	for _, sfx := range s.config.IncludeSuffixes {
 		if strings.HasSuffix(query, sfx) {
@@ -216,10 +255,13 @@ func (s *Server) resolveInCluster(c context.Context, query string) (results []ne
 func (s *Server) GetConfig() *rpc.DNSConfig {
 	dnsConfig := &rpc.DNSConfig{}
 	if s.config != nil {
+		s.domainsLock.RLock()
+		defer s.domainsLock.RUnlock()
 		dnsConfig.LocalIp = s.config.LocalIp
 		dnsConfig.ExcludeSuffixes = s.config.ExcludeSuffixes
 		dnsConfig.IncludeSuffixes = s.config.IncludeSuffixes
 		dnsConfig.LookupTimeout = s.config.LookupTimeout
+		dnsConfig.AdditionalRecords = s.config.AdditionalRecords
 	}
 	return dnsConfig
 }
@@ -234,6 +276,21 @@ func (s *Server) SetClusterDomainAndDNS(domain string, dnsIP net.IP) {
 	}
 }
 
+// SetSuffixes replaces the suffixes that are always (includes) or never (excludes) resolved
+// using the cluster. An empty excludes restores the default excludes. Cached answers are
+// flushed since they may have been resolved using the old suffixes.
+func (s *Server) SetSuffixes(ctx context.Context, excludes, includes []string) {
+	if len(excludes) == 0 {
+		excludes = defaultExcludeSuffixes
+	}
+	dlog.Debugf(ctx, "Setting DNS exclude suffixes %v and include suffixes %v", excludes, includes)
+	s.domainsLock.Lock()
+	s.config.ExcludeSuffixes = excludes
+	s.config.IncludeSuffixes = includes
+	s.domainsLock.Unlock()
+	s.flushDNS()
+}
+
 // SetSearchPath updates the DNS search path used by the resolver
 func (s *Server) SetSearchPath(ctx context.Context, paths, namespaces []string) {
 	// Provide direct access to intercepted namespaces
@@ -303,6 +360,7 @@ func (s *Server) flushDNS() {
 		s.cache.Delete(key)
 		return true
 	})
+	s.reverse.clear()
 }
 
 // splitToUDPAddr splits the given address into an UDPAddr. It's
@@ -333,6 +391,34 @@ func copyRRs(rrs []dns.RR, qType uint16) []dns.RR {
 	return cp
 }
 
+// NameOf returns the name of a cached DNS entry that resolved to the given IP, or an empty string
+// if no such entry exists. The trailing dot of the name is removed.
+func (s *Server) NameOf(ip net.IP) string {
+	return s.reverse.nameOf(ip)
+}
+
+// ServiceOf returns the service and the namespace that the given name, as returned by NameOf, refers
+// to, or empty strings when the name isn't known to refer to a service in the cluster. A name like
+// "svc.ns.svc.cluster.local" always does. A name like "svc.ns" only does when "ns" is a namespace of
+// the search path, so that an external name like "google.com" isn't taken for a service "google" in
+// a namespace "com".
+func (s *Server) ServiceOf(name string) (service, namespace string) {
+	labels := strings.Split(name, ".")
+	switch {
+	case len(labels) == 2:
+		s.domainsLock.RLock()
+		_, ok := s.namespaces[labels[1]]
+		s.domainsLock.RUnlock()
+		if !ok {
+			return "", ""
+		}
+	case len(labels) > 2 && dns.Fqdn(strings.Join(labels[2:], ".")) == "svc."+s.clusterDomain:
+	default:
+		return "", ""
+	}
+	return labels[0], labels[1]
+}
+
 // resolveThruCache resolves the given query by first performing a cache lookup. If a cached
 // entry is found that hasn't expired, it's returned. If not, this function will call
 // resolveQuery() to resolve and store in the case.
@@ -544,7 +630,7 @@ func (s *Server) resolveQuery(q *dns.Question, dv *cacheEntry) ([]dns.RR, error)
 	switch q.Qtype {
 	case dns.TypeA, dns.TypeAAAA:
 		var ips []net.IP
-		if ips, err = s.resolve(s.ctx, q.Name); err != nil || len(ips) == 0 {
+		if ips, err = s.resolveName(q.Name); err != nil || len(ips) == 0 {
 			break
 		}
 		answer := make([]dns.RR, 0, len(ips))
@@ -566,7 +652,7 @@ func (s *Server) resolveQuery(q *dns.Question, dv *cacheEntry) ([]dns.RR, error)
 		dv.answer = answer
 	default:
 		var ips []net.IP
-		if ips, err = s.resolve(s.ctx, q.Name); err != nil {
+		if ips, err = s.resolveName(q.Name); err != nil {
 			break
 		}
 		if len(ips) > 0 {
@@ -576,6 +662,9 @@ func (s *Server) resolveQuery(q *dns.Question, dv *cacheEntry) ([]dns.RR, error)
 	}
 	if err != nil || len(dv.answer) == 0 {
 		s.cache.Delete(q.Name) // Don't cache unless the entry is found.
+		s.reverse.remove(q.Name)
+	} else {
+		s.reverse.set(q.Name, dv.answer)
 	}
 
 	// Return a result for the correct query type. The result will be nil (nxdomain) if nothing was found. It might
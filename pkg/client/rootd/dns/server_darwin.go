@@ -203,14 +203,17 @@ func (s *Server) updateResolverFiles(c context.Context, resolverDirName, resolve
 	}
 	namespaces[tel2SubDomain] = struct{}{}
 
-	// All namespaces and include suffixes become domains
-	domains := make(map[string]struct{}, len(namespaces)+len(s.config.IncludeSuffixes))
+	// All namespaces, include suffixes, and names of additional records become domains
+	domains := make(map[string]struct{}, len(namespaces)+len(s.config.IncludeSuffixes)+len(s.additionalRecords))
 	for ns, v := range namespaces {
 		domains[ns] = v
 	}
 	for _, sfx := range s.config.IncludeSuffixes {
 		domains[strings.TrimPrefix(sfx, ".")] = struct{}{}
 	}
+	for _, domain := range s.additionalDomains() {
+		domains[domain] = struct{}{}
+	}
 
 	s.domainsLock.Lock()
 	defer s.domainsLock.Unlock()
@@ -0,0 +1,161 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/daemon"
+)
+
+func TestServer_SetSuffixes(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	s := NewServer(nil, nil)
+	assert.False(t, s.shouldDoClusterLookup("foo.example.com."))
+	assert.True(t, s.shouldDoClusterLookup("foo.example.svc.cluster.local."))
+
+	// Includes take precedence over the default excludes
+	s.SetSuffixes(ctx, nil, []string{".example.com"})
+	assert.True(t, s.shouldDoClusterLookup("foo.example.com."))
+	assert.False(t, s.shouldDoClusterLookup("foo.other.com."))
+	assert.Equal(t, defaultExcludeSuffixes, s.GetConfig().ExcludeSuffixes)
+
+	// Explicit excludes replace the defaults
+	s.SetSuffixes(ctx, []string{".local"}, nil)
+	assert.True(t, s.shouldDoClusterLookup("foo.example.com."))
+	assert.False(t, s.shouldDoClusterLookup("foo.example.svc.cluster.local."))
+	assert.Empty(t, s.GetConfig().IncludeSuffixes)
+}
+
+func TestServer_NameOf(t *testing.T) {
+	s := NewServer(nil, nil)
+	s.ctx = dlog.NewTestContext(t, false)
+	records := map[string][]net.IP{
+		"foo.default.": {{10, 0, 0, 2}, net.ParseIP("fd00::2")},
+		"bar.default.": {{10, 0, 0, 3}},
+	}
+	s.resolve = func(_ context.Context, name string) ([]net.IP, error) {
+		return records[name], nil
+	}
+	resolve := func(name string) {
+		_, err := s.resolveThruCache(&dns.Question{Name: name, Qtype: dns.TypeA})
+		require.NoError(t, err)
+	}
+
+	// Entries that are still being resolved are ignored
+	s.cache.Store("baz.default.", &cacheEntry{wait: make(chan struct{}), answer: []dns.RR{
+		&dns.A{Hdr: dns.RR_Header{Name: "baz.default.", Rrtype: dns.TypeA}, A: net.IP{10, 0, 0, 4}},
+	}})
+	assert.Empty(t, s.NameOf(net.IP{10, 0, 0, 4}))
+
+	resolve("foo.default.")
+	resolve("bar.default.")
+	assert.Equal(t, "foo.default", s.NameOf(net.IP{10, 0, 0, 2}))
+	assert.Equal(t, "foo.default", s.NameOf(net.ParseIP("fd00::2")))
+	assert.Equal(t, "bar.default", s.NameOf(net.IP{10, 0, 0, 3}))
+	assert.Empty(t, s.NameOf(net.IP{10, 0, 0, 5}))
+
+	// When several names resolve to the same IP, the one that was resolved last is used.
+	records["alias.default."] = []net.IP{{10, 0, 0, 3}}
+	resolve("alias.default.")
+	assert.Equal(t, "alias.default", s.NameOf(net.IP{10, 0, 0, 3}))
+
+	// A name that no longer resolves is removed, so its IPs map to the names that remain.
+	records["alias.default."] = nil
+	s.cache.Delete("alias.default.")
+	resolve("alias.default.")
+	assert.Equal(t, "bar.default", s.NameOf(net.IP{10, 0, 0, 3}))
+	assert.Equal(t, "foo.default", s.NameOf(net.IP{10, 0, 0, 2}))
+
+	// A name that resolves to new IPs no longer maps the old ones.
+	records["foo.default."] = []net.IP{{10, 0, 0, 6}}
+	s.cache.Delete("foo.default.")
+	resolve("foo.default.")
+	assert.Empty(t, s.NameOf(net.IP{10, 0, 0, 2}))
+	assert.Equal(t, "foo.default", s.NameOf(net.IP{10, 0, 0, 6}))
+
+	s.flushDNS()
+	assert.Empty(t, s.NameOf(net.IP{10, 0, 0, 6}))
+}
+
+func TestServer_ServiceOf(t *testing.T) {
+	s := NewServer(nil, nil)
+	s.namespaces = map[string]struct{}{"default": {}}
+	tests := []struct {
+		name      string
+		service   string
+		namespace string
+	}{
+		{"echo.default", "echo", "default"},
+		{"echo.default.svc.cluster.local", "echo", "default"},
+		{"echo.other.svc.cluster.local", "echo", "other"},
+		{"google.com", "", ""},
+		{"echo.default.svc.example.com", "", ""},
+		{"www.example.com", "", ""},
+		{"echo", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, namespace := s.ServiceOf(tt.name)
+			assert.Equal(t, tt.service, service)
+			assert.Equal(t, tt.namespace, namespace)
+		})
+	}
+}
+
+func TestServer_NameOfExpired(t *testing.T) {
+	s := NewServer(nil, nil)
+	s.ctx = dlog.NewTestContext(t, false)
+	s.resolve = func(_ context.Context, name string) ([]net.IP, error) {
+		return []net.IP{{10, 0, 0, 2}}, nil
+	}
+	_, err := s.resolveThruCache(&dns.Question{Name: "echo.default.", Qtype: dns.TypeA})
+	require.NoError(t, err)
+
+	// An expired entry still names its IPs, so that a client that reconnects long after it
+	// resolved the name still gets the name of the destination.
+	v, ok := s.cache.Load("echo.default.")
+	require.True(t, ok)
+	v.(*cacheEntry).created = time.Now().Add(-2 * cacheTTL)
+	assert.Equal(t, "echo.default", s.NameOf(net.IP{10, 0, 0, 2}))
+}
+
+func TestServer_AdditionalRecords(t *testing.T) {
+	s := NewServer(&rpc.DNSConfig{AdditionalRecords: map[string][]byte{
+		"Fake.Example.com": net.IP{10, 1, 2, 3},
+		"laptop.internal.": net.ParseIP("::1"),
+	}}, nil)
+	s.ctx = dlog.NewTestContext(t, false)
+	var resolved []string
+	s.resolve = func(_ context.Context, name string) ([]net.IP, error) {
+		resolved = append(resolved, name)
+		return nil, nil
+	}
+	query := func(name string, qType uint16) []dns.RR {
+		rrs, err := s.resolveQuery(&dns.Question{Name: name, Qtype: qType}, &cacheEntry{wait: make(chan struct{})})
+		assert.NoError(t, err)
+		return rrs
+	}
+
+	rrs := query("fake.example.com.", dns.TypeA)
+	if assert.Len(t, rrs, 1) {
+		assert.Equal(t, net.IP{10, 1, 2, 3}, rrs[0].(*dns.A).A.To4())
+	}
+	assert.Empty(t, query("fake.example.com.", dns.TypeAAAA), "an IPv4 record must not answer an AAAA query")
+	rrs = query("laptop.internal.", dns.TypeAAAA)
+	if assert.Len(t, rrs, 1) {
+		assert.Equal(t, net.ParseIP("::1"), rrs[0].(*dns.AAAA).AAAA)
+	}
+	assert.Empty(t, resolved, "additional records must not be resolved by the cluster")
+
+	assert.Nil(t, query("other.example.com.", dns.TypeA))
+	assert.Equal(t, []string{"other.example.com."}, resolved)
+
+	assert.Equal(t, []string{"fake.example.com", "laptop.internal"}, s.additionalDomains())
+}
@@ -0,0 +1,68 @@
+package rootd
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/tunnel"
+	"github.com/telepresenceio/telepresence/v2/pkg/vif/tcp"
+)
+
+// mtuCheckInterval is the interval at which the MTU of the TUN device is checked for changes,
+// e.g. because a VPN has reconfigured it.
+const mtuCheckInterval = 5 * time.Second
+
+// watchMTU checks the MTU of the TUN device periodically and notifies the TCP handlers when it
+// changes.
+func (s *session) watchMTU(c context.Context) error {
+	ticker := time.NewTicker(mtuCheckInterval)
+	defer ticker.Stop()
+	for {
+		if iface, err := net.InterfaceByName(s.dev.Name()); err == nil {
+			s.setTunMTU(c, iface.MTU)
+		}
+		select {
+		case <-c.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// setTunMTU records the MTU of the TUN device and, when it has changed, makes the active TCP
+// handlers recompute their maximum segment size. New handlers are created with the new MTU.
+func (s *session) setTunMTU(c context.Context, mtu int) {
+	old := atomic.SwapInt32(&s.tunMTU, int32(mtu))
+	if old == 0 || int(old) == mtu {
+		// The first MTU that is found is the one that the handlers were created with.
+		return
+	}
+	effective := s.effectiveMTU()
+	dlog.Infof(c, "MTU of the TUN device changed from %d to %d", old, mtu)
+	s.handlers.Range(func(_ tunnel.ConnID, h tunnel.Handler) bool {
+		if ph, ok := h.(tcp.PacketHandler); ok {
+			ph.HandleMTUChange(c, effective)
+		}
+		return true
+	})
+}
+
+// effectiveMTU returns the smallest of the MTU of the TUN device and the MTU of the network used
+// by the cluster connection, or zero when neither is known.
+func (s *session) effectiveMTU() int {
+	mtu := int(atomic.LoadInt32(&s.tunMTU))
+	if nm := s.tcpConfig.MTU; nm > 0 && (mtu <= 0 || nm < mtu) {
+		mtu = nm
+	}
+	return mtu
+}
+
+// handlerConfig returns the configuration used when creating a TCP handler.
+func (s *session) handlerConfig() tcp.HandlerConfig {
+	cfg := s.tcpConfig
+	cfg.MTU = s.effectiveMTU()
+	return cfg
+}
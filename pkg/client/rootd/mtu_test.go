@@ -0,0 +1,50 @@
+package rootd
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/ipproto"
+	"github.com/telepresenceio/telepresence/v2/pkg/tunnel"
+	"github.com/telepresenceio/telepresence/v2/pkg/vif/tcp"
+)
+
+// mtuRecorder is a tcp.PacketHandler that records the MTU changes that it's notified of.
+type mtuRecorder struct {
+	tcp.PacketHandler
+	mtus []int
+}
+
+func (h *mtuRecorder) Start(context.Context) {}
+
+func (h *mtuRecorder) HandleMTUChange(_ context.Context, mtu int) {
+	h.mtus = append(h.mtus, mtu)
+}
+
+func TestSession_setTunMTU(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	s := &session{handlers: tunnel.NewPool()}
+	s.tcpConfig.MTU = 1400
+	h := &mtuRecorder{}
+	id := tunnel.NewConnID(ipproto.TCP, net.IP{10, 0, 0, 1}, net.IP{10, 96, 0, 10}, 4711, 80)
+	_, _, err := s.handlers.GetOrCreate(ctx, id, func(context.Context, func()) (tunnel.Handler, error) { return h, nil })
+	require.NoError(t, err)
+
+	// The first MTU that is seen is the one that the handlers were created with.
+	s.setTunMTU(ctx, 1500)
+	require.Empty(t, h.mtus)
+	require.Equal(t, 1400, s.handlerConfig().MTU)
+
+	// A change is propagated using the smallest of the TUN and the network MTU.
+	s.setTunMTU(ctx, 1300)
+	s.setTunMTU(ctx, 1300)
+	s.setTunMTU(ctx, 1500)
+	require.Equal(t, []int{1300, 1400}, h.mtus)
+
+	s.setTunMTU(ctx, 1200)
+	require.Equal(t, 1200, s.handlerConfig().MTU)
+}
@@ -2,11 +2,13 @@ package rootd
 
 import (
 	"context"
+	"encoding/binary"
 	"fmt"
 	"net"
 	"sync/atomic"
 
 	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
 
 	"github.com/datawire/dlib/dlog"
 	"github.com/telepresenceio/telepresence/v2/pkg/client"
@@ -111,10 +113,10 @@ func (s *session) handlePacket(c context.Context, data *buffer.Data) {
 		}
 		data = nil
 		s.udp(c, dg)
-	case ipproto.ICMP:
-	case ipproto.ICMPV6:
+	case ipproto.ICMP, ipproto.ICMPV6:
 		pkt := icmp.PacketFromData(ipHdr, data)
 		dlog.Tracef(c, "<- TUN %s", pkt)
+		s.icmp(c, pkt)
 	default:
 		// An L4 protocol that we don't handle.
 		dlog.Tracef(c, "Unhandled protocol %d", ipHdr.L4Protocol())
@@ -171,8 +173,14 @@ func (s *session) tcp(c context.Context, pkt tcp.Packet) {
 		return
 	}
 
-	wf, _, err := s.handlers.GetOrCreate(c, connID, func(c context.Context, remove func()) (tunnel.Handler, error) {
-		return tcp.NewHandler(s.streamCreator(connID), &s.closing, vifWriter{s.dev}, connID, remove, s.rndSource), nil
+	name := s.dnsServer.NameOf(connID.Destination())
+	md := s.connMetadata(name)
+	if s.rejectDisallowed(c, vifWriter{s.dev}, pkt, md) {
+		return
+	}
+
+	wf, _, err := s.handlers.GetOrCreate(tcp.WithConnMetadata(c, md), connID, func(c context.Context, remove func()) (tunnel.Handler, error) {
+		return tcp.NewHandler(s.streamCreator(connID), &s.closing, vifWriter{s.dev}, connID, connLabel(name, connID), remove, s.rndSource, s.handlerConfig()), nil
 	})
 	if err != nil {
 		dlog.Error(c, err)
@@ -185,6 +193,81 @@ func (s *session) tcp(c context.Context, pkt tcp.Packet) {
 	}
 }
 
+// connLabel returns a label that describes the destination of the given connection using the
+// name that it was resolved from, or an empty string when that name is unknown.
+func connLabel(name string, connID tunnel.ConnID) string {
+	if name == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s:%d", name, connID.DestinationPort())
+}
+
+// connMetadata returns what's known about the destination of a connection based on the name that
+// it was resolved from. The namespace and the service, which is used as the workload, are only
+// known when the name is known to refer to a service in the cluster.
+func (s *session) connMetadata(name string) tcp.ConnMetadata {
+	var md tcp.ConnMetadata
+	if name != "" {
+		md.Workload, md.Namespace = s.dnsServer.ServiceOf(name)
+	}
+	return md
+}
+
+// rejectDisallowed resets the connection initiated by the given SYN and returns true when the
+// session has an allow-list of namespaces and the destination is known to be in a namespace that
+// isn't in it. A destination that isn't known to be in a namespace, because it was dialed using an
+// IP or a name that the cluster DNS didn't resolve, is let through, because the namespace of an IP
+// can't be told reliably. The SYN is released when it's rejected.
+func (s *session) rejectDisallowed(c context.Context, w ip.Writer, syn tcp.Packet, md tcp.ConnMetadata) bool {
+	if len(s.allowedNamespaces) == 0 || md.Namespace == "" {
+		return false
+	}
+	if _, ok := s.allowedNamespaces[md.Namespace]; ok {
+		return false
+	}
+	defer syn.Release()
+	atomic.AddUint64(&s.rejectedConns, 1)
+	ipHdr := syn.IPHeader()
+	dlog.Infof(c, "rejecting connection from %s to %s:%d, namespace %s is not allowed",
+		ipHdr.Source(), ipHdr.Destination(), syn.Header().DestinationPort(), md.Namespace)
+	if err := w.Write(c, syn.Reset()); err != nil {
+		dlog.Errorf(c, "failed to reset rejected connection: %v", err)
+	}
+	return true
+}
+
+// icmp dispatches ICMP "fragmentation needed" (IPv4) and "packet too big" (IPv6) messages to
+// the TCP handler that sent the offending segment. All other ICMP messages are ignored.
+func (s *session) icmp(c context.Context, pkt icmp.Packet) {
+	ipHdr := pkt.IPHeader()
+	icmpHdr := pkt.Header()
+	var mtu int
+	switch {
+	case ipHdr.Version() == ipv4.Version &&
+		icmpHdr.MessageType() == int(ipv4.ICMPTypeDestinationUnreachable) && icmpHdr.Code() == int(icmp.MustFragment):
+		mtu = int(binary.BigEndian.Uint16(icmpHdr.RestOfHeader()[2:]))
+	case ipHdr.Version() == ipv6.Version && icmpHdr.MessageType() == int(ipv6.ICMPTypePacketTooBig):
+		mtu = int(binary.BigEndian.Uint32(icmpHdr.RestOfHeader()))
+	default:
+		return
+	}
+
+	// The ICMP payload contains the IP header of the offending packet followed by at least
+	// the first 8 bytes of its TCP header, which is enough to get the ports and the sequence.
+	orig := icmpHdr.Payload()
+	origHdr, err := ip.ParseHeader(orig)
+	if err != nil || origHdr.L4Protocol() != ipproto.TCP || len(orig) < origHdr.HeaderLen()+8 {
+		return
+	}
+	tcpHdr := tcp.Header(orig[origHdr.HeaderLen():])
+
+	// The offending packet was sent by the handler, so source and destination are reversed.
+	connID := tunnel.NewConnID(ipproto.TCP, origHdr.Destination(), origHdr.Source(), tcpHdr.DestinationPort(), tcpHdr.SourcePort())
+	if wf := s.handlers.Get(connID); wf != nil {
+		wf.(tcp.PacketHandler).HandlePacketTooBig(c, mtu, tcpHdr.Sequence())
+	}
+}
+
 func (s *session) udp(c context.Context, dg udp.Datagram) {
 	ipHdr := dg.IPHeader()
 	udpHdr := dg.Header()
@@ -0,0 +1,177 @@
+package rootd
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/rootd/dns"
+	"github.com/telepresenceio/telepresence/v2/pkg/ipproto"
+	"github.com/telepresenceio/telepresence/v2/pkg/tunnel"
+	"github.com/telepresenceio/telepresence/v2/pkg/vif/icmp"
+	"github.com/telepresenceio/telepresence/v2/pkg/vif/ip"
+	"github.com/telepresenceio/telepresence/v2/pkg/vif/tcp"
+)
+
+type captureWriter []ip.Packet
+
+func (w *captureWriter) Write(_ context.Context, pkt ip.Packet) error {
+	*w = append(*w, pkt)
+	return nil
+}
+
+func newSyn() tcp.Packet {
+	pkt := tcp.NewPacket(tcp.HeaderLen, net.IP{10, 0, 0, 1}, net.IP{10, 96, 0, 10}, false)
+	ipHdr := pkt.IPHeader()
+	ipHdr.SetL4Protocol(ipproto.TCP)
+	ipHdr.SetChecksum()
+	tcpHdr := pkt.Header()
+	tcpHdr.SetDataOffset(tcp.HeaderLen / 4)
+	tcpHdr.SetSourcePort(4711)
+	tcpHdr.SetDestinationPort(80)
+	tcpHdr.SetSequence(1000)
+	tcpHdr.SetSYN(true)
+	tcpHdr.SetChecksum(ipHdr)
+	return pkt
+}
+
+func TestRejectDisallowed(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	s := &session{allowedNamespaces: map[string]struct{}{"default": {}}}
+
+	// A SYN to an allowed namespace passes.
+	var w captureWriter
+	syn := newSyn()
+	require.False(t, s.rejectDisallowed(ctx, &w, syn, tcp.ConnMetadata{Namespace: "default", Workload: "echo"}))
+	syn.Release()
+	require.Empty(t, w)
+
+	// A SYN to a disallowed namespace is reset.
+	w = nil
+	require.True(t, s.rejectDisallowed(ctx, &w, newSyn(), tcp.ConnMetadata{Namespace: "kube-system", Workload: "kube-dns"}))
+	require.Len(t, w, 1)
+	rst := w[0].(tcp.Packet).Header()
+	require.True(t, rst.RST())
+	require.Equal(t, uint16(4711), rst.DestinationPort())
+	require.Equal(t, uint32(1001), rst.AckNumber())
+	require.Equal(t, uint64(1), s.rejectedConns)
+
+	// A SYN to a destination that was dialed using its IP, or whose name is no longer known, passes
+	// because its namespace can't be told.
+	s.dnsServer = dns.NewServer(nil, nil)
+	w = nil
+	syn = newSyn()
+	md := s.connMetadata(s.dnsServer.NameOf(syn.IPHeader().Destination()))
+	require.Empty(t, md.Namespace)
+	require.False(t, s.rejectDisallowed(ctx, &w, syn, md))
+	syn.Release()
+	require.Empty(t, w)
+	require.Equal(t, uint64(1), s.rejectedConns)
+
+	// All namespaces are allowed when there's no allow-list.
+	s = &session{}
+	w = nil
+	syn = newSyn()
+	require.False(t, s.rejectDisallowed(ctx, &w, syn, tcp.ConnMetadata{}))
+	syn.Release()
+	require.Empty(t, w)
+}
+
+// tooBigHandler is a tcp.PacketHandler that records the calls to HandlePacketTooBig. It must not
+// be given anything else.
+type tooBigHandler struct {
+	tcp.PacketHandler
+	mtus []int
+	seqs []uint32
+}
+
+func (h *tooBigHandler) Start(context.Context) {}
+
+func (h *tooBigHandler) HandlePacketTooBig(_ context.Context, mtu int, sequence uint32) {
+	h.mtus = append(h.mtus, mtu)
+	h.seqs = append(h.seqs, sequence)
+}
+
+// newICMPForSegment returns an ICMP message of the given type and code that quotes a segment
+// sent from dst:80 to src:4711 with the given sequence. The restOfHeader is copied into the
+// four bytes that follow the checksum.
+func newICMPForSegment(src, dst net.IP, msgType, code int, restOfHeader []byte, sequence uint32) icmp.Packet {
+	seg := tcp.NewPacket(tcp.HeaderLen+100, dst, src, false)
+	segIP := seg.IPHeader()
+	segIP.SetL4Protocol(ipproto.TCP)
+	segIP.SetChecksum()
+	segHdr := seg.Header()
+	segHdr.SetDataOffset(tcp.HeaderLen / 4)
+	segHdr.SetSourcePort(80)
+	segHdr.SetDestinationPort(4711)
+	segHdr.SetSequence(sequence)
+	segHdr.SetACK(true)
+	defer seg.Release()
+
+	quoted := segIP.Packet()[:segIP.HeaderLen()+8]
+	pkt := icmp.NewPacket(icmp.HeaderLen+len(quoted), dst, dst)
+	ipHdr := pkt.IPHeader()
+	icmpHdr := icmp.Header(ipHdr.Payload())
+	icmpHdr.SetMessageType(msgType)
+	icmpHdr.SetCode(code)
+	copy(icmpHdr.RestOfHeader(), restOfHeader)
+	copy(icmpHdr.Payload(), quoted)
+	icmpHdr.SetChecksum(ipHdr)
+	return pkt
+}
+
+func TestSession_ICMP(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	v4Src, v4Dst := net.IP{10, 0, 0, 1}, net.IP{10, 96, 0, 10}
+	v6Src, v6Dst := net.ParseIP("fd00::1"), net.ParseIP("fd00:96::10")
+
+	fragNeeded := make([]byte, 4)
+	binary.BigEndian.PutUint16(fragNeeded[2:], 1200)
+	tooBig := make([]byte, 4)
+	binary.BigEndian.PutUint32(tooBig, 1300)
+
+	tests := []struct {
+		name     string
+		src, dst net.IP
+		msgType  int
+		code     int
+		rest     []byte
+		wantMTU  int
+	}{
+		{"IPv4 fragmentation needed", v4Src, v4Dst, int(ipv4.ICMPTypeDestinationUnreachable), int(icmp.MustFragment), fragNeeded, 1200},
+		{"IPv6 packet too big", v6Src, v6Dst, int(ipv6.ICMPTypePacketTooBig), 0, tooBig, 1300},
+		{"IPv4 port unreachable", v4Src, v4Dst, int(ipv4.ICMPTypeDestinationUnreachable), int(icmp.PortUnreachable), fragNeeded, 0},
+		{"IPv6 destination unreachable", v6Src, v6Dst, int(ipv6.ICMPTypeDestinationUnreachable), 0, tooBig, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &session{handlers: tunnel.NewPool()}
+			h := &tooBigHandler{}
+			connID := tunnel.NewConnID(ipproto.TCP, tt.src, tt.dst, 4711, 80)
+			_, _, err := s.handlers.GetOrCreate(ctx, connID, func(context.Context, func()) (tunnel.Handler, error) { return h, nil })
+			require.NoError(t, err)
+
+			pkt := newICMPForSegment(tt.src, tt.dst, tt.msgType, tt.code, tt.rest, 5000)
+			defer pkt.Release()
+			s.icmp(ctx, pkt)
+			if tt.wantMTU == 0 {
+				require.Empty(t, h.mtus)
+				return
+			}
+			require.Equal(t, []int{tt.wantMTU}, h.mtus)
+			require.Equal(t, []uint32{5000}, h.seqs)
+		})
+	}
+
+	// A message that quotes a segment of an unknown connection is ignored.
+	s := &session{handlers: tunnel.NewPool()}
+	pkt := newICMPForSegment(v4Src, v4Dst, int(ipv4.ICMPTypeDestinationUnreachable), int(icmp.MustFragment), fragNeeded, 5000)
+	defer pkt.Release()
+	s.icmp(ctx, pkt)
+}
@@ -31,6 +31,7 @@ import (
 	"github.com/telepresenceio/telepresence/v2/pkg/filelocation"
 	"github.com/telepresenceio/telepresence/v2/pkg/log"
 	"github.com/telepresenceio/telepresence/v2/pkg/proc"
+	"github.com/telepresenceio/telepresence/v2/pkg/vif/tcp"
 )
 
 const ProcessName = "daemon"
@@ -115,6 +116,14 @@ func (d *service) SetDnsSearchPath(ctx context.Context, paths *rpc.Paths) (*empt
 	return &empty.Empty{}, err
 }
 
+func (d *service) SetDnsSuffixes(ctx context.Context, suffixes *rpc.Suffixes) (*empty.Empty, error) {
+	err := d.withSession(ctx, func(ctx context.Context, session *session) error {
+		session.dnsServer.SetSuffixes(ctx, suffixes.ExcludeSuffixes, suffixes.IncludeSuffixes)
+		return nil
+	})
+	return &empty.Empty{}, err
+}
+
 func (d *service) Connect(ctx context.Context, info *rpc.OutboundInfo) (*rpc.DaemonStatus, error) {
 	dlog.Debug(ctx, "Received gRPC Connect")
 	select {
@@ -199,6 +208,38 @@ func (d *service) SetLogLevel(ctx context.Context, request *manager.LogLevelRequ
 	return &empty.Empty{}, logging.SetAndStoreTimedLevel(ctx, d.timedLogLevel, request.LogLevel, duration, ProcessName)
 }
 
+func (d *service) StreamTraces(_ *empty.Empty, stream rpc.Daemon_StreamTracesServer) error {
+	var traces *tcp.StateRecorder
+	err := d.withSession(stream.Context(), func(_ context.Context, session *session) error {
+		traces = session.traces
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	// The session lock isn't held while sending, so a slow client never delays a disconnect.
+	return sendTraces(traces, traceChunkSize, stream.Send)
+}
+
+func (d *service) StartTraceCapture(ctx context.Context, req *rpc.TraceCaptureRequest) (*empty.Empty, error) {
+	err := d.withSession(ctx, func(_ context.Context, session *session) error {
+		return session.traceCaptures.start(req.CorrelationId, req.Duration.AsDuration())
+	})
+	return &empty.Empty{}, err
+}
+
+func (d *service) FetchTraceCapture(req *rpc.TraceCaptureId, stream rpc.Daemon_FetchTraceCaptureServer) error {
+	var traces *tcp.StateRecorder
+	err := d.withSession(stream.Context(), func(_ context.Context, session *session) (err error) {
+		traces, err = session.traceCaptures.get(req.CorrelationId)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return sendTraces(traces, traceChunkSize, stream.Send)
+}
+
 func (d *service) configReload(c context.Context) error {
 	return client.Watch(c, func(c context.Context) error {
 		return logging.ReloadDaemonConfig(c, true)
@@ -380,7 +421,7 @@ func run(c context.Context, loggingDir, configDir string) error {
 	}
 
 	g := dgroup.NewGroup(c, dgroup.GroupConfig{
-		SoftShutdownTimeout:  2 * time.Second,
+		SoftShutdownTimeout:  cfg.Timeouts.Get(client.TimeoutGracefulShutdown),
 		EnableSignalHandling: true,
 		ShutdownOnNonError:   true,
 	})
@@ -7,12 +7,14 @@ import (
 	"math/rand"
 	"net"
 	"os"
+	"sort"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/blang/semver"
+	"github.com/sirupsen/logrus"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
@@ -36,6 +38,7 @@ import (
 	"github.com/telepresenceio/telepresence/v2/pkg/vif"
 	"github.com/telepresenceio/telepresence/v2/pkg/vif/buffer"
 	"github.com/telepresenceio/telepresence/v2/pkg/vif/routing"
+	"github.com/telepresenceio/telepresence/v2/pkg/vif/tcp"
 )
 
 // session resolves DNS names and routes outbound traffic that is centered around a TUN device. The router is
@@ -124,12 +127,34 @@ type session struct {
 	// rndSource is the source for the random number generator in the TCP handlers
 	rndSource rand.Source
 
+	// tcpConfig is the configuration used when creating TCP handlers. Its MTU is the one of the
+	// network used by the cluster connection.
+	tcpConfig tcp.HandlerConfig
+
+	// tunMTU is the MTU of the TUN device as last seen by watchMTU, or zero if it's not known yet.
+	// Accessed atomically.
+	tunMTU int32
+
 	// Telemetry counters for DNS lookups
 	dnsLookups  int
 	dnsFailures int
 
+	// allowedNamespaces are the namespaces that outbound connections may reach. All namespaces
+	// are allowed when it's empty.
+	allowedNamespaces map[string]struct{}
+
+	// rejectedConns is the number of connections that were reset because their destination
+	// wasn't in an allowed namespace
+	rejectedConns uint64
+
 	// Whether pods and services should be proxied by the TUN-device
 	proxyCluster bool
+
+	// traces records the state transitions of the most recent TCP connections
+	traces *tcp.StateRecorder
+
+	// traceCaptures records the state transitions into the trace captures that are started on demand
+	traceCaptures *traceCaptures
 }
 
 // connectToManager connects to the traffic-manager and asserts that its version is compatible
@@ -168,7 +193,7 @@ func connectToManager(c context.Context) (*grpc.ClientConn, manager.ManagerClien
 
 	if mgrVer.LE(semver.MustParse("2.4.4")) {
 		conn.Close()
-		return nil, nil, errcat.User.Newf("unsupported traffic-manager version %s. Minimum supported version is 2.4.5", mgrVer)
+		return nil, nil, errcat.VersionMismatch.Newf("unsupported traffic-manager version %s. Minimum supported version is 2.4.5", mgrVer)
 	}
 	return conn, mc, nil
 }
@@ -227,6 +252,19 @@ func newSession(c context.Context, scout *scout.Reporter, mi *rpc.OutboundInfo)
 		alsoProxySubnets:  convertAlsoProxySubnets(c, mi.AlsoProxySubnets),
 		neverProxySubnets: convertNeverProxySubnets(c, mi.NeverProxySubnets),
 		proxyCluster:      true,
+		traces:            tcp.NewStateRecorder(maxTracedConns),
+	}
+	s.traceCaptures = newTraceCaptures(s.traces)
+	if mi.Mtu > 0 {
+		dlog.Infof(c, "MTU of the network used by the cluster connection is %d", mi.Mtu)
+		s.tcpConfig.MTU = int(mi.Mtu)
+	}
+	if len(mi.AllowedNamespaces) > 0 {
+		dlog.Infof(c, "Outbound connections are limited to namespaces %v", mi.AllowedNamespaces)
+		s.allowedNamespaces = make(map[string]struct{}, len(mi.AllowedNamespaces))
+		for _, ns := range mi.AllowedNamespaces {
+			s.allowedNamespaces[ns] = struct{}{}
+		}
 	}
 	s.dnsServer = dns.NewServer(mi.Dns, s.clusterLookup)
 	return s, nil
@@ -253,7 +291,12 @@ func (s *session) getInfo() *rpc.OutboundInfo {
 	info := rpc.OutboundInfo{
 		Session: s.session,
 		Dns:     s.dnsServer.GetConfig(),
+		Mtu:     int32(s.tcpConfig.MTU),
+	}
+	for ns := range s.allowedNamespaces {
+		info.AllowedNamespaces = append(info.AllowedNamespaces, ns)
 	}
+	sort.Strings(info.AllowedNamespaces)
 	if s.dnsLocalAddr != nil {
 		info.Dns.RemoteIp = s.dnsLocalAddr.IP
 	}
@@ -462,7 +505,48 @@ func (s *session) checkConnectivity(ctx context.Context, info *manager.ClusterIn
 func (s *session) run(c context.Context) error {
 	defer dlog.Info(c, "-- Session ended")
 
+	c = tcp.WithStateTracer(c, s.traceCaptures)
+
+	var leakWatchdog *tcp.LeakWatchdog
+	if client.GetConfig(c).LogLevels.RootDaemon >= logrus.DebugLevel {
+		// Keep an eye on TCP handlers that fail to terminate their goroutines
+		leakWatchdog = tcp.NewLeakWatchdog(leakGracePeriod, s.reportLeak)
+		c = tcp.WithLeakWatchdog(c, leakWatchdog)
+	}
+
+	// Tear down TCP handlers that stop processing their packets, e.g. because of a deadlock
+	stallWatchdog := tcp.NewStallWatchdog(stallTimeout, s.reportStall)
+	c = tcp.WithStallWatchdog(c, stallWatchdog)
+
+	var connMetrics *os.File
+	if cmf := client.GetConfig(c).Daemons.ConnMetricsFile; cmf != "" {
+		var err error
+		if connMetrics, err = os.OpenFile(cmf, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644); err != nil {
+			dlog.Errorf(c, "unable to open connection metrics file: %v", err)
+		}
+	}
+	var connMetricsWriter *tcp.ConnMetricsWriter
+	if connMetrics != nil {
+		connMetricsWriter = tcp.NewConnMetricsWriter(connMetrics, connMetricsBufferSize)
+		c = tcp.WithConnRecorder(c, connMetricsWriter)
+	}
+
 	g := dgroup.NewGroup(c, dgroup.GroupConfig{})
+	if leakWatchdog != nil {
+		g.Go("leak-watchdog", leakWatchdog.Run)
+	}
+	g.Go("stall-watchdog", stallWatchdog.Run)
+	if connMetricsWriter != nil {
+		g.Go("conn-metrics", func(c context.Context) error {
+			defer connMetrics.Close()
+			// A failure to write the metrics must not end the session, and it's already logged.
+			_ = connMetricsWriter.Run(c)
+			if dropped := connMetricsWriter.Dropped(); dropped > 0 {
+				dlog.Warnf(c, "%d connection metrics records were dropped", dropped)
+			}
+			return nil
+		})
+	}
 
 	cancelDNSLock := sync.Mutex{}
 	cancelDNS := func() {}
@@ -497,9 +581,31 @@ func (s *session) run(c context.Context) error {
 		return s.dnsServer.Worker(ctx, s.dev, s.configureDNS)
 	})
 	g.Go("router", s.routerWorker)
+	g.Go("watch-mtu", s.watchMTU)
 	return g.Wait()
 }
 
+// connMetricsBufferSize is the number of connection metrics records that are buffered while
+// waiting to be written to the connection metrics file.
+const connMetricsBufferSize = 1024
+
+// leakGracePeriod is the time that a TCP handler's goroutines are given to terminate after
+// the handler has been closed before the LeakWatchdog reports them.
+const leakGracePeriod = 10 * time.Second
+
+func (s *session) reportLeak(c context.Context, id tunnel.ConnID, goroutines []string) {
+	s.scout.Report(c, "tcp_handler_goroutine_leak",
+		scout.Entry{Key: "goroutines", Value: strings.Join(goroutines, ",")})
+}
+
+// stallTimeout is the time that a TCP handler may have packets waiting without processing any
+// of them before the StallWatchdog tears it down.
+const stallTimeout = 30 * time.Second
+
+func (s *session) reportStall(c context.Context, id tunnel.ConnID) {
+	s.scout.Report(c, "tcp_handler_stalled")
+}
+
 func (s *session) stop(c context.Context) {
 	if !atomic.CompareAndSwapInt32(&s.closing, 0, 1) {
 		// Session already stopped (or is stopping)
@@ -510,6 +616,16 @@ func (s *session) stop(c context.Context) {
 	s.scout.Report(c, "incluster_dns_queries",
 		scout.Entry{Key: "total", Value: s.dnsLookups},
 		scout.Entry{Key: "failures", Value: s.dnsFailures})
+	if len(s.allowedNamespaces) > 0 {
+		s.scout.Report(c, "namespace_allow_list",
+			scout.Entry{Key: "allowed", Value: len(s.allowedNamespaces)},
+			scout.Entry{Key: "rejected", Value: atomic.LoadUint64(&s.rejectedConns)})
+	}
+	if m := s.handlers.Metrics(); m.ZeroWindowStalls > 0 {
+		s.scout.Report(c, "tcp_zero_window",
+			scout.Entry{Key: "stalls", Value: m.ZeroWindowStalls},
+			scout.Entry{Key: "seconds", Value: m.ZeroWindowTime.Seconds()})
+	}
 
 	cc, cancel := context.WithTimeout(c, time.Second)
 	defer cancel()
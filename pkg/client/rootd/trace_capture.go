@@ -0,0 +1,107 @@
+package rootd
+
+import (
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/tunnel"
+	"github.com/telepresenceio/telepresence/v2/pkg/vif/tcp"
+)
+
+const (
+	// maxTraceCaptureDuration is the longest time that a trace capture can record.
+	maxTraceCaptureDuration = 10 * time.Minute
+
+	// traceCaptureRetention is the time that a trace capture can be fetched after it has stopped
+	// recording.
+	traceCaptureRetention = 10 * time.Minute
+
+	// maxTraceCaptures is the maximum number of trace captures that a session keeps.
+	maxTraceCaptures = 10
+)
+
+// traceCapture is the state transitions recorded during a bounded period.
+type traceCapture struct {
+	rec   *tcp.StateRecorder
+	until time.Time
+}
+
+// traceCaptures is a tcp.StateTracer that passes the state transitions on to the session's
+// StateRecorder, and to the trace captures that are recording.
+type traceCaptures struct {
+	tcp.StateTracer
+	lock     sync.Mutex
+	now      func() time.Time
+	captures map[string]*traceCapture
+}
+
+func newTraceCaptures(st tcp.StateTracer) *traceCaptures {
+	return &traceCaptures{
+		StateTracer: st,
+		now:         time.Now,
+		captures:    make(map[string]*traceCapture),
+	}
+}
+
+// StateTransition implements tcp.StateTracer.
+func (tc *traceCaptures) StateTransition(id tunnel.ConnID, ev tcp.StateEvent) {
+	tc.StateTracer.StateTransition(id, ev)
+	tc.lock.Lock()
+	defer tc.lock.Unlock()
+	if len(tc.captures) == 0 {
+		return
+	}
+	now := tc.now()
+	for _, c := range tc.captures {
+		if now.Before(c.until) {
+			c.rec.StateTransition(id, ev)
+		}
+	}
+}
+
+// start starts a capture with the given correlation ID that records for the duration d.
+func (tc *traceCaptures) start(correlationID string, d time.Duration) error {
+	if correlationID == "" {
+		return status.Error(codes.InvalidArgument, "a correlation ID is required")
+	}
+	if d <= 0 || d > maxTraceCaptureDuration {
+		return status.Errorf(codes.InvalidArgument, "capture duration must be greater than zero and at most %s", maxTraceCaptureDuration)
+	}
+	tc.lock.Lock()
+	defer tc.lock.Unlock()
+	now := tc.now()
+	tc.expire(now)
+	if _, ok := tc.captures[correlationID]; ok {
+		return status.Errorf(codes.AlreadyExists, "a trace capture with correlation ID %q already exists", correlationID)
+	}
+	if len(tc.captures) >= maxTraceCaptures {
+		return status.Errorf(codes.ResourceExhausted, "no more than %d trace captures can exist at the same time", maxTraceCaptures)
+	}
+	tc.captures[correlationID] = &traceCapture{rec: tcp.NewStateRecorder(maxTracedConns), until: now.Add(d)}
+	return nil
+}
+
+// get returns the recorder of the capture with the given correlation ID.
+func (tc *traceCaptures) get(correlationID string) (*tcp.StateRecorder, error) {
+	tc.lock.Lock()
+	defer tc.lock.Unlock()
+	tc.expire(tc.now())
+	c, ok := tc.captures[correlationID]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "no trace capture with correlation ID %q exists", correlationID)
+	}
+	return c.rec, nil
+}
+
+// expire removes the captures that have been retained for traceCaptureRetention after they
+// stopped recording. The lock must be held.
+func (tc *traceCaptures) expire(now time.Time) {
+	for id, c := range tc.captures {
+		if now.After(c.until.Add(traceCaptureRetention)) {
+			delete(tc.captures, id)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package rootd
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/ipproto"
+	"github.com/telepresenceio/telepresence/v2/pkg/tunnel"
+	"github.com/telepresenceio/telepresence/v2/pkg/vif/tcp"
+)
+
+func TestTraceCaptures(t *testing.T) {
+	now := time.Now()
+	session := tcp.NewStateRecorder(100)
+	tc := newTraceCaptures(session)
+	tc.now = func() time.Time { return now }
+
+	connID := func(i int) tunnel.ConnID {
+		return tunnel.NewConnID(ipproto.TCP, net.IP{10, 0, 0, 1}, net.IP{10, 96, 0, 10}, uint16(4000+i), 80)
+	}
+	transition := func(i int) {
+		tc.StateTransition(connID(i), tcp.StateEvent{Time: now, From: "IDLE", To: "SYN RECEIVED", Flags: "SYN"})
+	}
+	code := func(err error) codes.Code {
+		return status.Code(err)
+	}
+
+	assert.Equal(t, codes.InvalidArgument, code(tc.start("", time.Second)))
+	assert.Equal(t, codes.InvalidArgument, code(tc.start("bug-1", 0)))
+	assert.Equal(t, codes.InvalidArgument, code(tc.start("bug-1", maxTraceCaptureDuration+time.Second)))
+	_, err := tc.get("bug-1")
+	assert.Equal(t, codes.NotFound, code(err))
+
+	// Only the transitions made while the capture records end up in it.
+	transition(0)
+	require.NoError(t, tc.start("bug-1", 10*time.Second))
+	assert.Equal(t, codes.AlreadyExists, code(tc.start("bug-1", 10*time.Second)))
+	transition(1)
+	now = now.Add(5 * time.Second)
+	require.NoError(t, tc.start("bug-2", 10*time.Second))
+	transition(2)
+	now = now.Add(5 * time.Second)
+	transition(3)
+
+	rec, err := tc.get("bug-1")
+	require.NoError(t, err)
+	assert.Equal(t, []tunnel.ConnID{connID(1), connID(2)}, rec.Connections())
+	rec, err = tc.get("bug-2")
+	require.NoError(t, err)
+	assert.Equal(t, []tunnel.ConnID{connID(2), connID(3)}, rec.Connections())
+
+	// The session's recorder sees everything.
+	assert.Len(t, session.Connections(), 4)
+
+	// A capture expires once it has been retained after its recording stopped.
+	now = now.Add(traceCaptureRetention + time.Second)
+	_, err = tc.get("bug-1")
+	assert.Equal(t, codes.NotFound, code(err))
+	_, err = tc.get("bug-2")
+	assert.NoError(t, err)
+
+	// Expired captures don't count towards the limit, but others do.
+	for i := 0; i < maxTraceCaptures-1; i++ {
+		require.NoError(t, tc.start(connID(i).String(), time.Second))
+	}
+	assert.Equal(t, codes.ResourceExhausted, code(tc.start("bug-3", time.Second)))
+}
@@ -0,0 +1,54 @@
+package rootd
+
+import (
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/daemon"
+	"github.com/telepresenceio/telepresence/v2/pkg/vif/tcp"
+)
+
+// maxTracedConns is the number of connections that a session keeps the state transitions of.
+const maxTracedConns = 1000
+
+// traceChunkSize is the size in bytes that a TraceChunk is limited to, unless it contains a single
+// trace that is larger than that. It's well below the default maximum size of a gRPC message.
+const traceChunkSize = 256 << 10
+
+// sendTraces sends the traces that the given recorder has recorded in chunks of at most chunkSize
+// bytes.
+func sendTraces(rec *tcp.StateRecorder, chunkSize int, send func(*rpc.TraceChunk) error) error {
+	chunk := &rpc.TraceChunk{}
+	size := 0
+	for _, id := range rec.Connections() {
+		evs := rec.Events(id)
+		if len(evs) == 0 {
+			// Forgotten since Connections was called
+			continue
+		}
+		ct := &rpc.ConnTrace{Connection: id.String(), Events: make([]*rpc.TraceEvent, len(evs))}
+		for i, ev := range evs {
+			ct.Events[i] = &rpc.TraceEvent{
+				Time:       timestamppb.New(ev.Time),
+				From:       ev.From,
+				To:         ev.To,
+				Flags:      ev.Flags,
+				Attributes: ev.Attributes,
+			}
+		}
+		ctSize := proto.Size(ct)
+		if size > 0 && size+ctSize > chunkSize {
+			if err := send(chunk); err != nil {
+				return err
+			}
+			chunk = &rpc.TraceChunk{}
+			size = 0
+		}
+		chunk.Traces = append(chunk.Traces, ct)
+		size += ctSize
+	}
+	if size > 0 {
+		return send(chunk)
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package rootd
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/daemon"
+	"github.com/telepresenceio/telepresence/v2/pkg/ipproto"
+	"github.com/telepresenceio/telepresence/v2/pkg/tunnel"
+	"github.com/telepresenceio/telepresence/v2/pkg/vif/tcp"
+)
+
+func TestSendTraces(t *testing.T) {
+	rec := tcp.NewStateRecorder(100)
+	var sent []*rpc.TraceChunk
+	send := func(c *rpc.TraceChunk) error {
+		sent = append(sent, c)
+		return nil
+	}
+
+	// Nothing is sent when nothing is recorded.
+	require.NoError(t, sendTraces(rec, 1024, send))
+	require.Empty(t, sent)
+
+	now := time.Now()
+	connID := func(i int) tunnel.ConnID {
+		return tunnel.NewConnID(ipproto.TCP, net.IP{10, 0, 0, 1}, net.IP{10, 96, 0, 10}, uint16(4000+i), 80)
+	}
+	for i := 0; i < 50; i++ {
+		id := connID(i)
+		rec.StateTransition(id, tcp.StateEvent{Time: now, From: "IDLE", To: "SYN RECEIVED", Flags: "SYN", Attributes: map[string]string{"namespace": "default"}})
+		rec.StateTransition(id, tcp.StateEvent{Time: now, From: "SYN RECEIVED", To: "ESTABLISHED", Flags: "ACK"})
+	}
+	require.NoError(t, sendTraces(rec, 1024, send))
+	require.Greater(t, len(sent), 1)
+
+	// All traces are sent in order, and no chunk exceeds the chunk size.
+	var traces []*rpc.ConnTrace
+	for _, c := range sent {
+		require.LessOrEqual(t, proto.Size(c), 1024+100, "chunk overhead is a few bytes per trace")
+		traces = append(traces, c.Traces...)
+	}
+	require.Len(t, traces, 50)
+	for i, ct := range traces {
+		require.Equal(t, connID(i).String(), ct.Connection)
+		require.Len(t, ct.Events, 2)
+		require.Equal(t, "ESTABLISHED", ct.Events[1].To)
+		require.True(t, now.Equal(ct.Events[0].Time.AsTime()))
+		require.Equal(t, map[string]string{"namespace": "default"}, ct.Events[0].Attributes)
+	}
+}
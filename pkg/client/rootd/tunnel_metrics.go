@@ -0,0 +1,72 @@
+package rootd
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/daemon"
+	"github.com/telepresenceio/telepresence/v2/pkg/tunnel"
+)
+
+const (
+	// defaultMetricsSample is the sample window used when the request doesn't specify one.
+	defaultMetricsSample = time.Second
+
+	// maxMetricsSample is the longest sample window that a request may specify.
+	maxMetricsSample = time.Minute
+)
+
+// sampleTunnelMetrics samples the metrics of the given pool at the start and end of the duration d
+// and computes the rates from the difference.
+func sampleTunnelMetrics(ctx context.Context, pool *tunnel.Pool, d time.Duration) (*rpc.TunnelMetricsResponse, error) {
+	start := time.Now()
+	m0 := pool.Metrics()
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return nil, status.FromContextError(ctx.Err()).Err()
+	case <-t.C:
+	}
+	m1 := pool.Metrics()
+	secs := time.Since(start).Seconds()
+	var states map[string]int32
+	if len(m1.HandlerStates) > 0 {
+		states = make(map[string]int32, len(m1.HandlerStates))
+		for s, n := range m1.HandlerStates {
+			states[s] = int32(n)
+		}
+	}
+	return &rpc.TunnelMetricsResponse{
+		BytesInPerSec:     float64(m1.BytesIn-m0.BytesIn) / secs,
+		BytesOutPerSec:    float64(m1.BytesOut-m0.BytesOut) / secs,
+		ActiveConnections: int32(m1.ActiveHandlers),
+		TotalBytesIn:      m1.BytesIn,
+		TotalBytesOut:     m1.BytesOut,
+		TotalRetransmits:  m1.Retransmits,
+		ConnectionStates:  states,
+	}, nil
+}
+
+func (d *service) TunnelMetrics(ctx context.Context, req *rpc.TunnelMetricsRequest) (*rpc.TunnelMetricsResponse, error) {
+	sd := defaultMetricsSample
+	if req.SampleDuration != nil {
+		sd = req.SampleDuration.AsDuration()
+		if sd <= 0 || sd > maxMetricsSample {
+			return nil, status.Errorf(codes.InvalidArgument, "sample duration must be greater than zero and at most %s", maxMetricsSample)
+		}
+	}
+	var pool *tunnel.Pool
+	err := d.withSession(ctx, func(_ context.Context, session *session) error {
+		pool = session.handlers
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	// The session lock isn't held while sampling, so a disconnect isn't delayed by it.
+	return sampleTunnelMetrics(ctx, pool, sd)
+}
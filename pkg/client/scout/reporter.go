@@ -6,6 +6,7 @@ import (
 	"path/filepath"
 	"runtime"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
@@ -32,6 +33,32 @@ type Reporter struct {
 	buffer   chan bufEntry
 	done     chan struct{}
 	reporter *metriton.Reporter
+
+	// filter restricts the reports of a Reporter that was returned by ForSession
+	filter *reportFilter
+}
+
+// reportFilter restricts the reports that are sent during a session.
+type reportFilter struct {
+	disabled    bool
+	minInterval time.Duration
+
+	lock sync.Mutex
+	last time.Time
+}
+
+// allow returns true if a report made at the given time may be sent.
+func (f *reportFilter) allow(now time.Time) bool {
+	if f.disabled {
+		return false
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if !f.last.IsZero() && now.Sub(f.last) < f.minInterval {
+		return false
+	}
+	f.last = now
+	return true
 }
 
 // Entry is a key/value association used when reporting
@@ -229,6 +256,22 @@ func (r *Reporter) SetMetadatum(ctx context.Context, key string, value any) {
 	r.Report(ctx, setMetadatumAction, Entry{Key: key, Value: value})
 }
 
+// ForSession returns a Reporter that shares the send queue of this instance, but that discards
+// all its reports when disabled is true, and reports that are made less than minInterval after
+// the previous one. Metadata is set as usual. This instance is returned when there's nothing to
+// restrict. The returned Reporter must not be started or closed.
+func (r *Reporter) ForSession(disabled bool, minInterval time.Duration) *Reporter {
+	if !disabled && minInterval <= 0 {
+		return r
+	}
+	return &Reporter{
+		buffer:   r.buffer,
+		done:     r.done,
+		reporter: r.reporter,
+		filter:   &reportFilter{disabled: disabled, minInterval: minInterval},
+	}
+}
+
 // Start starts the instance in a goroutine
 func (r *Reporter) Start(ctx context.Context) {
 	go func() {
@@ -296,6 +339,10 @@ func (r *Reporter) Run(ctx context.Context) error {
 // determine the correct order of reported events for this installation
 // attempt (correlated by the trace_id set at the start).
 func (r *Reporter) Report(ctx context.Context, action string, entries ...Entry) {
+	if r.filter != nil && action != setMetadatumAction && !r.filter.allow(time.Now()) {
+		dlog.Debugf(ctx, "scout report %q discarded by the session's telemetry settings", action)
+		return
+	}
 	select {
 	case r.buffer <- bufEntry{action, entries}:
 	default:
@@ -13,6 +13,7 @@ import (
 	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -495,3 +496,57 @@ func TestReport(t *testing.T) {
 		})
 	}
 }
+
+func TestReporter_ForSession(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	var lock sync.Mutex
+	var actions []string
+	testServer := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		var body map[string]any
+		bodyBytes, err := io.ReadAll(request.Body)
+		require.NoError(t, err)
+		require.NoError(t, json.Unmarshal(bodyBytes, &body))
+		lock.Lock()
+		actions = append(actions, body["metadata"].(map[string]any)["action"].(string))
+		lock.Unlock()
+	}))
+	defer testServer.Close()
+
+	scout := &Reporter{
+		buffer: make(chan bufEntry, 40),
+		reporter: &metriton.Reporter{
+			Application:  "telepresence2",
+			Version:      "v2.4.5-test",
+			GetInstallID: func(r *metriton.Reporter) (string, error) { return "00000000-1111-2222-3333-444444444444", nil },
+			Endpoint:     testServer.URL,
+		},
+	}
+	scout.initialize(ctx, "test-mode", "linux", "amd64")
+	require.Same(t, scout, scout.ForSession(false, 0))
+
+	sc, cancel := context.WithCancel(dcontext.WithSoftness(ctx))
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		assert.NoError(t, scout.Run(sc))
+	}()
+
+	// A session with telemetry disabled emits no reports, but its metadata is still set
+	disabled := scout.ForSession(true, 0)
+	disabled.Report(ctx, "connect")
+	disabled.SetMetadatum(ctx, "cluster_id", "test-cluster")
+	disabled.Report(ctx, "connecting_traffic_manager")
+
+	// A throttled session emits the first report, and then discards reports until the interval has passed
+	throttled := scout.ForSession(false, time.Hour)
+	throttled.Report(ctx, "throttled_first")
+	throttled.Report(ctx, "throttled_second")
+
+	scout.Report(ctx, "unrestricted")
+	cancel()
+	wg.Wait()
+
+	assert.Equal(t, []string{"throttled_first", "unrestricted"}, actions)
+	assert.Equal(t, "test-cluster", scout.reporter.BaseMetadata["cluster_id"])
+}
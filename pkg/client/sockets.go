@@ -11,6 +11,16 @@ import (
 
 // DialSocket dials the given socket and returns the resulting connection
 func DialSocket(ctx context.Context, socketName string, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
+	if socketName == ConnectorSocketName {
+		if cfg := GetConfig(ctx); cfg != nil && cfg.Grpc.TLS.Enabled() {
+			creds, err := cfg.Grpc.TLS.ClientCredentials()
+			if err != nil {
+				return nil, err
+			}
+			// Dial options that are given later take precedence, so this replaces the insecure default
+			opts = append([]grpc.DialOption{grpc.WithTransportCredentials(creds)}, opts...)
+		}
+	}
 	return dialSocket(ctx, socketName, opts...)
 }
 
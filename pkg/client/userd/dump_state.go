@@ -0,0 +1,104 @@
+package userd
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
+	empty "google.golang.org/protobuf/types/known/emptypb"
+	"gopkg.in/yaml.v3"
+
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/rpc/v2/daemon"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+)
+
+// stateDump is the JSON document that DumpState returns. Parts that can't be obtained are left out
+// and the reason is added to Errors, so that a dump is produced even when things are broken.
+type stateDump struct {
+	Time             time.Time         `json:"time"`
+	Version          string            `json:"version"`
+	Config           string            `json:"config,omitempty"`
+	Connection       json.RawMessage   `json:"connection,omitempty"`
+	KubeFlags        map[string]string `json:"kubeFlags,omitempty"`
+	MappedNamespaces []string          `json:"mappedNamespaces,omitempty"`
+	Intercepts       []json.RawMessage `json:"intercepts,omitempty"`
+	RootDaemon       json.RawMessage   `json:"rootDaemon,omitempty"`
+	TunnelMetrics    json.RawMessage   `json:"tunnelMetrics,omitempty"`
+	Errors           []string          `json:"errors,omitempty"`
+}
+
+// dumpStateSampleDuration is the duration that the tunnel metrics in a state dump are sampled over.
+const dumpStateSampleDuration = 200 * time.Millisecond
+
+func (s *service) DumpState(ctx context.Context, _ *empty.Empty) (result *rpc.StateDump, err error) {
+	s.logCall(ctx, "DumpState", func(c context.Context) {
+		var data []byte
+		if data, err = json.MarshalIndent(s.dumpState(c), "", "  "); err == nil {
+			result = &rpc.StateDump{Json: data}
+		}
+	})
+	return
+}
+
+func (s *service) dumpState(c context.Context) *stateDump {
+	sd := &stateDump{
+		Time:    time.Now(),
+		Version: client.DisplayVersion(),
+	}
+	if cfg, err := yaml.Marshal(client.GetConfig(c)); err == nil {
+		sd.Config = string(cfg)
+	} else {
+		sd.addError("config", err)
+	}
+
+	s.sessionLock.RLock()
+	session, sessionCtx := s.session, s.sessionContext
+	s.sessionLock.RUnlock()
+	if session == nil {
+		sd.Connection = marshalProto(&rpc.ConnectInfo{Error: rpc.ConnectInfo_DISCONNECTED})
+	} else {
+		sd.Connection = marshalProto(session.Status(sessionCtx))
+		sd.KubeFlags = session.RedactedFlagMap()
+		sd.MappedNamespaces = session.GetCurrentNamespaces(false)
+		for _, id := range session.InterceptDetails() {
+			sd.Intercepts = append(sd.Intercepts, marshalProto(id))
+		}
+	}
+
+	rd, err := s.RootDaemonClient(c)
+	if err != nil {
+		sd.addError("root daemon", err)
+		return sd
+	}
+	if ds, err := rd.Status(c, &empty.Empty{}); err == nil {
+		sd.RootDaemon = marshalProto(ds)
+	} else {
+		sd.addError("root daemon status", err)
+	}
+	if session != nil {
+		tm, err := rd.TunnelMetrics(c, &daemon.TunnelMetricsRequest{SampleDuration: durationpb.New(dumpStateSampleDuration)})
+		if err == nil {
+			sd.TunnelMetrics = marshalProto(tm)
+		} else {
+			sd.addError("tunnel metrics", err)
+		}
+	}
+	return sd
+}
+
+func (sd *stateDump) addError(what string, err error) {
+	sd.Errors = append(sd.Errors, what+": "+err.Error())
+}
+
+func marshalProto(m proto.Message) json.RawMessage {
+	data, err := protojson.Marshal(m)
+	if err != nil {
+		// Not expected to happen, but the dump shouldn't fail because of it.
+		data, _ = json.Marshal(err.Error())
+	}
+	return data
+}
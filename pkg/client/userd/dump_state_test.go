@@ -0,0 +1,74 @@
+package userd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	empty "google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/datawire/dlib/dlog"
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/rpc/v2/daemon"
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/userd/trafficmgr"
+)
+
+type dumpSession struct {
+	trafficmgr.Session
+}
+
+func (dumpSession) Status(context.Context) *rpc.ConnectInfo {
+	return &rpc.ConnectInfo{ClusterContext: "test-context", ManagerVersion: "2.7.0"}
+}
+
+func (dumpSession) RedactedFlagMap() map[string]string {
+	return map[string]string{"context": "test-context", "token": "<redacted>"}
+}
+
+func (dumpSession) GetCurrentNamespaces(bool) []string {
+	return []string{"default", "team-a"}
+}
+
+func (dumpSession) InterceptDetails() []*rpc.InterceptDetails {
+	return []*rpc.InterceptDetails{{Name: "echo", Namespace: "team-a"}}
+}
+
+type dumpDaemon struct {
+	daemon.DaemonClient
+}
+
+func (dumpDaemon) Status(context.Context, *empty.Empty, ...grpc.CallOption) (*daemon.DaemonStatus, error) {
+	return &daemon.DaemonStatus{OutboundConfig: &daemon.OutboundInfo{Session: &manager.SessionInfo{SessionId: "abc"}}}, nil
+}
+
+func (dumpDaemon) TunnelMetrics(context.Context, *daemon.TunnelMetricsRequest, ...grpc.CallOption) (*daemon.TunnelMetricsResponse, error) {
+	return nil, errors.New("no session")
+}
+
+func TestService_DumpState(t *testing.T) {
+	cfg := client.GetDefaultConfig()
+	ctx := client.WithConfig(dlog.NewTestContext(t, false), &cfg)
+	s := &service{session: dumpSession{}, sessionContext: ctx, daemonClient: dumpDaemon{}}
+
+	sd, err := s.DumpState(ctx, &empty.Empty{})
+	require.NoError(t, err)
+
+	var dump map[string]any
+	require.NoError(t, json.Unmarshal(sd.Json, &dump))
+	assert.NotEmpty(t, dump["config"])
+	assert.Equal(t, "test-context", dump["connection"].(map[string]any)["clusterContext"])
+	assert.Equal(t, "<redacted>", dump["kubeFlags"].(map[string]any)["token"])
+	assert.Equal(t, []any{"default", "team-a"}, dump["mappedNamespaces"])
+	require.Len(t, dump["intercepts"], 1)
+	assert.NotNil(t, dump["rootDaemon"])
+
+	// Parts that can't be obtained are reported, but don't fail the dump.
+	assert.Nil(t, dump["tunnelMetrics"])
+	assert.Equal(t, []any{"tunnel metrics: no session"}, dump["errors"])
+}
@@ -5,10 +5,13 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"os"
+	"strings"
 	"sync/atomic"
 	"time"
 
+	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"google.golang.org/grpc/codes"
 	grpcCodes "google.golang.org/grpc/codes"
@@ -21,6 +24,7 @@ import (
 	"github.com/datawire/dlib/dlog"
 	"github.com/telepresenceio/telepresence/rpc/v2/common"
 	rpc "github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/rpc/v2/daemon"
 	"github.com/telepresenceio/telepresence/rpc/v2/manager"
 	"github.com/telepresenceio/telepresence/rpc/v2/userdaemon"
 	"github.com/telepresenceio/telepresence/v2/pkg/a8rcloud"
@@ -104,12 +108,13 @@ func (s *service) Connect(ctx context.Context, cr *rpc.ConnectRequest) (result *
 		case <-ctx.Done():
 			err = status.Error(codes.Unavailable, ctx.Err().Error())
 			return
-		case s.connectRequest <- cr:
+		case s.connectRequest <- pendingConnect{ctx: ctx, cr: cr}:
 		}
 
 		select {
 		case <-ctx.Done():
-			err = status.Error(codes.Unavailable, ctx.Err().Error())
+			// The connect is aborted and its partial state is rolled back.
+			err = status.FromContextError(ctx.Err()).Err()
 		case result = <-s.connectResponse:
 		}
 	})
@@ -201,6 +206,9 @@ func (s *service) scoutInterceptEntries(spec *manager.InterceptSpec, result *rpc
 		if result.Error != common.InterceptError_UNSPECIFIED {
 			msg = result.Error.String()
 		}
+		if len(result.PodProblemReasons) > 0 {
+			entries = append(entries, scout.Entry{Key: "pod_problem_reasons", Value: strings.Join(result.PodProblemReasons, ",")})
+		}
 	}
 	if err != nil && msg == "" {
 		msg = err.Error()
@@ -246,6 +254,9 @@ func (s *service) CreateIntercept(c context.Context, ir *rpc.CreateInterceptRequ
 	}()
 	err = s.withSession(c, "CreateIntercept", func(c context.Context, session trafficmgr.Session) error {
 		result, err = session.AddIntercept(c, ir)
+		if err == nil && result != nil && result.Error == common.InterceptError_UNSPECIFIED {
+			s.emitStateChange(c, rpc.StateChange_INTERCEPT_ADDED, session.Status(c).ClusterContext, ir.GetSpec().GetName())
+		}
 		return err
 	})
 	return
@@ -280,6 +291,78 @@ func (s *service) RemoveIntercept(c context.Context, rr *manager.RemoveIntercept
 				result.ErrorText = err.Error()
 				result.ErrorCategory = int32(errcat.Unknown)
 			}
+		} else {
+			s.emitStateChange(c, rpc.StateChange_INTERCEPT_REMOVED, session.Status(c).ClusterContext, rr.Name)
+		}
+		return nil
+	})
+	return result, err
+}
+
+func (s *service) PauseIntercept(c context.Context, rr *manager.RemoveInterceptRequest2) (*rpc.InterceptResult, error) {
+	return s.setInterceptPaused(c, "PauseIntercept", rr.Name, true)
+}
+
+func (s *service) ResumeIntercept(c context.Context, rr *manager.RemoveInterceptRequest2) (*rpc.InterceptResult, error) {
+	return s.setInterceptPaused(c, "ResumeIntercept", rr.Name, false)
+}
+
+func (s *service) setInterceptPaused(c context.Context, callName, name string, paused bool) (result *rpc.InterceptResult, err error) {
+	err = s.withSession(c, callName, func(c context.Context, session trafficmgr.Session) error {
+		result = &rpc.InterceptResult{}
+		if spec := session.GetInterceptSpec(name); spec != nil {
+			result.ServiceUid = spec.ServiceUid
+			result.WorkloadKind = spec.WorkloadKind
+		}
+		if err := session.SetInterceptPaused(c, name, paused); err != nil {
+			switch {
+			case grpcStatus.Code(err) == grpcCodes.NotFound:
+				result.Error = common.InterceptError_NOT_FOUND
+				result.ErrorText = name
+				result.ErrorCategory = int32(errcat.User)
+			default:
+				result.Error = common.InterceptError_TRAFFIC_MANAGER_ERROR
+				result.ErrorText = err.Error()
+				result.ErrorCategory = int32(errcat.GetCategory(err))
+			}
+		}
+		return nil
+	})
+	return result, err
+}
+
+func (s *service) ListInterceptsDetailed(c context.Context, _ *empty.Empty) (result *rpc.InterceptDetailsList, err error) {
+	err = s.withSession(c, "ListInterceptsDetailed", func(c context.Context, session trafficmgr.Session) error {
+		result = &rpc.InterceptDetailsList{Intercepts: session.InterceptDetails()}
+		return nil
+	})
+	return result, err
+}
+
+func (s *service) TerminateIntercept(c context.Context, tr *rpc.TerminateInterceptRequest) (result *rpc.InterceptResult, err error) {
+	err = s.withSession(c, "TerminateIntercept", func(c context.Context, session trafficmgr.Session) error {
+		result = &rpc.InterceptResult{}
+		if spec := session.GetInterceptSpec(tr.Name); spec != nil {
+			result.ServiceUid = spec.ServiceUid
+			result.WorkloadKind = spec.WorkloadKind
+		}
+		if err := session.TerminateIntercept(c, tr.Name, tr.Reason); err != nil {
+			if grpcStatus.Code(err) == grpcCodes.NotFound {
+				result.Error = common.InterceptError_NOT_FOUND
+				result.ErrorText = tr.Name
+				result.ErrorCategory = int32(errcat.User)
+			} else {
+				result.Error = common.InterceptError_TRAFFIC_MANAGER_ERROR
+				result.ErrorText = err.Error()
+				result.ErrorCategory = int32(errcat.Unknown)
+			}
+		} else {
+			s.pushStateChange(c, &rpc.StateChange{
+				Event:          rpc.StateChange_INTERCEPT_REMOVED,
+				ClusterContext: session.Status(c).ClusterContext,
+				InterceptName:  tr.Name,
+				Reason:         tr.Reason,
+			})
 		}
 		return nil
 	})
@@ -433,6 +516,17 @@ func (s *service) GetIngressInfos(c context.Context, _ *empty.Empty) (result *rp
 	return
 }
 
+func (s *service) DetectIngressInfos(c context.Context, req *rpc.DetectIngressRequest) (result *rpc.IngressInfos, err error) {
+	err = s.withSession(c, "DetectIngressInfos", func(c context.Context, session trafficmgr.Session) error {
+		iis, diags, err := session.DetectIngressInfos(c, req.Namespaces)
+		if err == nil {
+			result = &rpc.IngressInfos{IngressInfos: iis, Diagnostics: diags}
+		}
+		return err
+	})
+	return
+}
+
 func (s *service) GatherLogs(ctx context.Context, request *rpc.LogsRequest) (result *rpc.LogsResponse, err error) {
 	err = s.withSession(ctx, "GatherLogs", func(c context.Context, session trafficmgr.Session) error {
 		result, err = session.GatherLogs(c, request)
@@ -456,6 +550,24 @@ func (s *service) SetLogLevel(ctx context.Context, request *manager.LogLevelRequ
 	return
 }
 
+// GetEffectiveConfig returns the configuration in effect. A log-level that has been set using
+// SetLogLevel takes precedence over the configured one until it expires.
+func (s *service) GetEffectiveConfig(ctx context.Context, _ *empty.Empty) (result *rpc.EffectiveConfig, err error) {
+	s.logCall(ctx, "GetEffectiveConfig", func(c context.Context) {
+		cfg := *client.GetConfig(c)
+		if level, _ := s.timedLogLevel.Get(); level != "" {
+			if ll, err := logrus.ParseLevel(level); err == nil {
+				cfg.LogLevels.UserDaemon = ll
+			}
+		}
+		var data []byte
+		if data, err = cfg.EffectiveYAML(c); err == nil {
+			result = &rpc.EffectiveConfig{Yaml: data}
+		}
+	})
+	return
+}
+
 func (s *service) Quit(ctx context.Context, _ *empty.Empty) (*empty.Empty, error) {
 	s.logCall(ctx, "Quit", func(c context.Context) {
 		s.sessionLock.RLock()
@@ -524,3 +636,68 @@ func (s *service) ResolveIngressInfo(ctx context.Context, req *userdaemon.Ingres
 	})
 	return
 }
+
+func (s *service) TunnelMetrics(ctx context.Context, req *daemon.TunnelMetricsRequest) (result *daemon.TunnelMetricsResponse, err error) {
+	s.logCall(ctx, "TunnelMetrics", func(c context.Context) {
+		var rd daemon.DaemonClient
+		if rd, err = s.RootDaemonClient(c); err == nil {
+			// The root daemon samples the metrics, and reports codes.Unavailable when it has no session.
+			result, err = rd.TunnelMetrics(c, req)
+		}
+	})
+	return
+}
+
+func (s *service) ListConnections(ctx context.Context, filter *daemon.ConnectionFilter) (result *daemon.ConnectionList, err error) {
+	s.logCall(ctx, "ListConnections", func(c context.Context) {
+		var rd daemon.DaemonClient
+		if rd, err = s.RootDaemonClient(c); err == nil {
+			result, err = rd.ListConnections(c, filter)
+		}
+	})
+	return
+}
+
+func (s *service) StartTraceCapture(ctx context.Context, req *daemon.TraceCaptureRequest) (result *empty.Empty, err error) {
+	s.logCall(ctx, "StartTraceCapture", func(c context.Context) {
+		var rd daemon.DaemonClient
+		if rd, err = s.RootDaemonClient(c); err == nil {
+			result, err = rd.StartTraceCapture(c, req)
+		}
+	})
+	return
+}
+
+func (s *service) FetchTraceCapture(req *daemon.TraceCaptureId, stream rpc.Connector_FetchTraceCaptureServer) (err error) {
+	s.logCall(stream.Context(), "FetchTraceCapture", func(c context.Context) {
+		var rd daemon.DaemonClient
+		if rd, err = s.RootDaemonClient(c); err != nil {
+			return
+		}
+		var rs daemon.Daemon_FetchTraceCaptureClient
+		if rs, err = rd.FetchTraceCapture(c, req); err != nil {
+			return
+		}
+		for {
+			var chunk *daemon.TraceChunk
+			if chunk, err = rs.Recv(); err != nil {
+				if errors.Is(err, io.EOF) {
+					err = nil
+				}
+				return
+			}
+			if err = stream.Send(chunk); err != nil {
+				return
+			}
+		}
+	})
+	return
+}
+
+func (s *service) Ping(c context.Context, _ *empty.Empty) (result *rpc.PingResult, err error) {
+	err = s.withSession(c, "Ping", func(c context.Context, session trafficmgr.Session) error {
+		result, err = session.Ping(c)
+		return err
+	})
+	return
+}
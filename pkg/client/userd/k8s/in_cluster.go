@@ -0,0 +1,74 @@
+package k8s
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/rest"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+)
+
+// InClusterContext is the name of the context of a Config created by NewInClusterConfig.
+const InClusterContext = "in-cluster"
+
+// serviceAccountDir is where Kubernetes mounts the token, CA certificate, and namespace of the
+// pod's service account. Tests use a fake directory.
+var serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// NewInClusterConfig returns a Config for a connector that runs in a pod in the cluster. No kubeconfig
+// is parsed. Instead, the config is created from the pod's service account and the
+// KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT environment variables.
+func NewInClusterConfig(c context.Context) (*Config, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, errcat.Config.New(
+			"unable to load in-cluster configuration, KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT must be defined")
+	}
+	tokenFile := filepath.Join(serviceAccountDir, "token")
+	token, err := os.ReadFile(tokenFile)
+	if err != nil {
+		return nil, errcat.Config.Newf("unable to load in-cluster configuration: %w", err)
+	}
+	server := "https://" + net.JoinHostPort(host, port)
+	restConfig := &rest.Config{
+		Host:            server,
+		BearerToken:     string(token),
+		BearerTokenFile: tokenFile,
+	}
+	caFile := filepath.Join(serviceAccountDir, "ca.crt")
+	if _, err = os.Stat(caFile); err == nil {
+		restConfig.TLSClientConfig.CAFile = caFile
+	}
+
+	namespace := "default"
+	if ns, err := os.ReadFile(filepath.Join(serviceAccountDir, "namespace")); err == nil {
+		if ns := strings.TrimSpace(string(ns)); ns != "" {
+			namespace = ns
+		}
+	}
+
+	// The ConfigFlags are used by the helm installer, so they must reflect the in-cluster config.
+	configFlags := genericclioptions.NewConfigFlags(false)
+	configFlags.APIServer = &server
+	configFlags.BearerToken = &restConfig.BearerToken
+	configFlags.CAFile = &restConfig.TLSClientConfig.CAFile
+	configFlags.Namespace = &namespace
+
+	return &Config{
+		kubeconfigExtension: kubeconfigExtension{
+			Manager: &managerConfig{Namespace: client.GetEnv(c).ManagerNamespace},
+		},
+		Context:     InClusterContext,
+		Server:      server,
+		Namespace:   namespace,
+		flagMap:     map[string]string{},
+		ConfigFlags: configFlags,
+		RestConfig:  restConfig,
+	}, nil
+}
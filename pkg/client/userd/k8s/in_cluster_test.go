@@ -0,0 +1,57 @@
+package k8s
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+)
+
+func TestNewInClusterConfig(t *testing.T) {
+	ctx := client.WithEnv(dlog.NewTestContext(t, false), &client.Env{ManagerNamespace: "tel-system"})
+
+	saDir := t.TempDir()
+	defer func(dir string) { serviceAccountDir = dir }(serviceAccountDir)
+	serviceAccountDir = saDir
+
+	// Not in a cluster
+	t.Setenv("KUBERNETES_SERVICE_HOST", "")
+	t.Setenv("KUBERNETES_SERVICE_PORT", "")
+	_, err := NewInClusterConfig(ctx)
+	require.Error(t, err)
+
+	// In a cluster, but no service account is mounted
+	t.Setenv("KUBERNETES_SERVICE_HOST", "10.96.0.1")
+	t.Setenv("KUBERNETES_SERVICE_PORT", "443")
+	_, err = NewInClusterConfig(ctx)
+	require.Error(t, err)
+
+	require.NoError(t, os.WriteFile(filepath.Join(saDir, "token"), []byte("the-token"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(saDir, "ca.crt"), []byte("the-ca"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(saDir, "namespace"), []byte("ci\n"), 0o600))
+	cfg, err := NewInClusterConfig(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, InClusterContext, cfg.Context)
+	assert.Equal(t, "https://10.96.0.1:443", cfg.Server)
+	assert.Equal(t, "ci", cfg.Namespace)
+	assert.Equal(t, "tel-system", cfg.GetManagerNamespace())
+	assert.Equal(t, cfg.Server, cfg.RestConfig.Host)
+	assert.Equal(t, "the-token", cfg.RestConfig.BearerToken)
+	assert.Equal(t, filepath.Join(saDir, "ca.crt"), cfg.RestConfig.CAFile)
+
+	// The ConfigFlags that the helm installer uses must produce the same rest config
+	rc, err := cfg.ConfigFlags.ToRESTConfig()
+	require.NoError(t, err)
+	assert.Equal(t, cfg.Server, rc.Host)
+	assert.Equal(t, "the-token", rc.BearerToken)
+
+	// Configs created from the same environment are equal, so UpdateStatus doesn't ask for a restart
+	cfg2, err := NewInClusterConfig(ctx)
+	require.NoError(t, err)
+	assert.True(t, cfg.ContextServiceAndFlagsEqual(cfg2))
+}
@@ -118,11 +118,7 @@ func (kc *Cluster) namespaceAccessible(namespace string) (exists bool) {
 }
 
 func NewCluster(c context.Context, kubeFlags *Config, namespaces []string) (*Cluster, error) {
-	rs, err := kubeFlags.ConfigFlags.ToRESTConfig()
-	if err != nil {
-		return nil, err
-	}
-	cs, err := kubernetes.NewForConfig(rs)
+	cs, err := kubernetes.NewForConfig(kubeFlags.RestConfig)
 	if err != nil {
 		return nil, err
 	}
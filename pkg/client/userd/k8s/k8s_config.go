@@ -2,8 +2,13 @@ package k8s
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
 	"os"
+	"sort"
+	"strings"
 
 	"github.com/spf13/pflag"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -14,6 +19,7 @@ import (
 
 	"github.com/telepresenceio/telepresence/v2/pkg/client"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+	"github.com/telepresenceio/telepresence/v2/pkg/install"
 	"github.com/telepresenceio/telepresence/v2/pkg/iputil"
 )
 
@@ -45,6 +51,9 @@ type dnsConfig struct {
 type managerConfig struct {
 	// Namespace is the name of the namespace where the traffic manager is to be found
 	Namespace string `json:"namespace,omitempty"`
+
+	// Name is the name of the traffic manager's service. Defaults to "traffic-manager"
+	Name string `json:"name,omitempty"`
 }
 
 // kubeconfigExtension is an extension read from the selected kubeconfig Cluster.
@@ -53,6 +62,10 @@ type kubeconfigExtension struct {
 	AlsoProxy  []*iputil.Subnet `json:"also-proxy,omitempty"`
 	NeverProxy []*iputil.Subnet `json:"never-proxy,omitempty"`
 	Manager    *managerConfig   `json:"manager,omitempty"`
+
+	// AllowedNamespaces are the namespaces that outbound connections are allowed to reach. All
+	// namespaces are allowed when it's empty.
+	AllowedNamespaces []string `json:"allowed-namespaces,omitempty"`
 }
 
 type Config struct {
@@ -160,24 +173,150 @@ func NewConfig(c context.Context, flagMap map[string]string) (*Config, error) {
 // ContextServiceAndFlagsEqual determines if this instance is equal to the given instance with respect to context,
 // server, and flag arguments.
 func (kf *Config) ContextServiceAndFlagsEqual(okf *Config) bool {
-	return kf != nil && okf != nil &&
-		kf.Context == okf.Context &&
-		kf.Server == okf.Server &&
-		mapEqual(kf.flagMap, okf.flagMap)
+	return kf != nil && okf != nil && len(kf.ContextServiceAndFlagsDiff(okf)) == 0
 }
 
-func (kf *Config) GetManagerNamespace() string {
-	return kf.kubeconfigExtension.Manager.Namespace
+// ConfigChange is a difference between two Config instances with respect to context, server, or a flag argument.
+type ConfigChange struct {
+	// Field is "context", "server", "manager namespace", "manager name", or the name of a kubectl
+	// flag prefixed with "--"
+	Field string
+
+	// Old and New are the values of the field. An empty value means that a flag isn't set.
+	Old string
+	New string
+}
+
+// sensitiveFlags are the flags whose values are never included in a ConfigChange description.
+var sensitiveFlags = map[string]struct{}{
+	"password": {},
+	"token":    {},
 }
 
-func mapEqual(a, b map[string]string) bool {
-	if len(a) != len(b) {
-		return false
+func (c ConfigChange) String() string {
+	if _, ok := sensitiveFlags[strings.TrimPrefix(c.Field, "--")]; ok {
+		return c.Field + " changed"
+	}
+	switch {
+	case c.Old == "":
+		return fmt.Sprintf("%s %q was added", c.Field, c.New)
+	case c.New == "":
+		return fmt.Sprintf("%s %q was removed", c.Field, c.Old)
+	default:
+		return fmt.Sprintf("%s changed from %q to %q", c.Field, c.Old, c.New)
+	}
+}
+
+// ContextServiceAndFlagsDiff returns the differences between this instance and the given instance with respect to
+// context, server, traffic-manager, and flag arguments. The flags are sorted by name. An empty result means that the
+// instances are equal.
+func (kf *Config) ContextServiceAndFlagsDiff(okf *Config) []ConfigChange {
+	var changes []ConfigChange
+	if kf.Context != okf.Context {
+		changes = append(changes, ConfigChange{Field: "context", Old: kf.Context, New: okf.Context})
+	}
+	if kf.Server != okf.Server {
+		changes = append(changes, ConfigChange{Field: "server", Old: kf.Server, New: okf.Server})
+	}
+	if ns, ons := kf.GetManagerNamespace(), okf.GetManagerNamespace(); ns != ons {
+		changes = append(changes, ConfigChange{Field: "manager namespace", Old: ns, New: ons})
 	}
-	for k, v := range a {
-		if v != b[k] {
-			return false
+	if n, on := kf.GetManagerName(), okf.GetManagerName(); n != on {
+		changes = append(changes, ConfigChange{Field: "manager name", Old: n, New: on})
+	}
+	var flags []string
+	for k, v := range kf.flagMap {
+		if ov, ok := okf.flagMap[k]; !ok || v != ov {
+			flags = append(flags, k)
+		}
+	}
+	for k := range okf.flagMap {
+		if _, ok := kf.flagMap[k]; !ok {
+			flags = append(flags, k)
 		}
 	}
-	return true
+	sort.Strings(flags)
+	for _, k := range flags {
+		changes = append(changes, ConfigChange{Field: "--" + k, Old: kf.flagMap[k], New: okf.flagMap[k]})
+	}
+	return changes
+}
+
+// RedactedFlagMap returns a copy of the kubectl flags with the values of sensitive flags, such as
+// tokens and passwords, redacted.
+func (kf *Config) RedactedFlagMap() map[string]string {
+	flags := make(map[string]string, len(kf.flagMap))
+	for k, v := range kf.flagMap {
+		if _, ok := sensitiveFlags[k]; ok {
+			v = "<redacted>"
+		}
+		flags[k] = v
+	}
+	return flags
+}
+
+func (kf *Config) GetManagerNamespace() string {
+	if m := kf.kubeconfigExtension.Manager; m != nil {
+		return m.Namespace
+	}
+	return ""
+}
+
+// GetManagerName returns the name of the traffic-manager's service.
+func (kf *Config) GetManagerName() string {
+	if m := kf.kubeconfigExtension.Manager; m != nil && m.Name != "" {
+		return m.Name
+	}
+	return install.ManagerAppName
+}
+
+// IsDefaultManager returns true unless the name of the traffic-manager's service has been
+// changed. Only the default traffic-manager can be installed by the client.
+func (kf *Config) IsDefaultManager() bool {
+	return kf.GetManagerName() == install.ManagerAppName
+}
+
+// SetManager overrides the namespace and name of the traffic-manager. Empty values leave the
+// current ones unchanged.
+func (kf *Config) SetManager(namespace, name string) {
+	if kf.kubeconfigExtension.Manager == nil {
+		kf.kubeconfigExtension.Manager = &managerConfig{}
+	}
+	if namespace != "" {
+		kf.kubeconfigExtension.Manager.Namespace = namespace
+	}
+	if name != "" {
+		kf.kubeconfigExtension.Manager.Name = name
+	}
+}
+
+// KubeconfigFiles returns the kubeconfig files that the configuration is loaded from.
+func (kf *Config) KubeconfigFiles() []string {
+	if kf.Context == InClusterContext {
+		return nil
+	}
+	return kf.ConfigFlags.ToRawKubeConfigLoader().ConfigAccess().GetLoadingPrecedence()
+}
+
+// ActiveContextFingerprint re-reads the kubeconfig files and returns a fingerprint of the cluster
+// and the credentials of the context that this configuration uses. The fingerprint changes when
+// a credential is rotated, but not when other contexts are modified.
+func (kf *Config) ActiveContextFingerprint() (string, error) {
+	config, err := kf.ConfigFlags.ToRawKubeConfigLoader().ConfigAccess().GetStartingConfig()
+	if err != nil {
+		return "", err
+	}
+	ctx, ok := config.Contexts[kf.Context]
+	if !ok {
+		return "", errcat.Config.Newf("context %q does not exist in the kubeconfig", kf.Context)
+	}
+	data, err := json.Marshal(struct {
+		Cluster  any `json:"cluster"`
+		AuthInfo any `json:"authInfo"`
+	}{config.Clusters[ctx.Cluster], config.AuthInfos[ctx.AuthInfo]})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
 }
@@ -0,0 +1,79 @@
+package k8s
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfig_ContextServiceAndFlagsDiff(t *testing.T) {
+	a := &Config{
+		Context: "ctx-a",
+		Server:  "https://a.example.com",
+		flagMap: map[string]string{"as": "admin", "token": "secret", "user": "alice"},
+	}
+	b := &Config{
+		Context: "ctx-a",
+		Server:  "https://a.example.com",
+		flagMap: map[string]string{"as": "admin", "token": "secret", "user": "alice"},
+	}
+	assert.Empty(t, a.ContextServiceAndFlagsDiff(b))
+	assert.True(t, a.ContextServiceAndFlagsEqual(b))
+
+	b.Context = "ctx-b"
+	b.flagMap = map[string]string{"as": "admin", "token": "other-secret", "user": "bob", "cluster": "c1"}
+	changes := a.ContextServiceAndFlagsDiff(b)
+	assert.False(t, a.ContextServiceAndFlagsEqual(b))
+	assert.Equal(t, []ConfigChange{
+		{Field: "context", Old: "ctx-a", New: "ctx-b"},
+		{Field: "--cluster", New: "c1"},
+		{Field: "--token", Old: "secret", New: "other-secret"},
+		{Field: "--user", Old: "alice", New: "bob"},
+	}, changes)
+
+	descs := make([]string, len(changes))
+	for i, c := range changes {
+		descs[i] = c.String()
+	}
+	assert.Equal(t, []string{
+		`context changed from "ctx-a" to "ctx-b"`,
+		`--cluster "c1" was added`,
+		`--token changed`,
+		`--user changed from "alice" to "bob"`,
+	}, descs)
+
+	delete(b.flagMap, "as")
+	assert.Equal(t, `--as "admin" was removed`, a.ContextServiceAndFlagsDiff(b)[1].String())
+}
+
+func TestConfig_SetManager(t *testing.T) {
+	a := &Config{
+		Context:             "ctx-a",
+		kubeconfigExtension: kubeconfigExtension{Manager: &managerConfig{Namespace: "ambassador"}},
+	}
+	assert.Equal(t, "ambassador", a.GetManagerNamespace())
+	assert.Equal(t, "traffic-manager", a.GetManagerName())
+	assert.True(t, a.IsDefaultManager())
+
+	b := &Config{
+		Context:             "ctx-a",
+		kubeconfigExtension: kubeconfigExtension{Manager: &managerConfig{Namespace: "ambassador"}},
+	}
+	b.SetManager("", "")
+	assert.Empty(t, a.ContextServiceAndFlagsDiff(b))
+
+	b.SetManager("team-a", "team-a-manager")
+	assert.Equal(t, "team-a", b.GetManagerNamespace())
+	assert.Equal(t, "team-a-manager", b.GetManagerName())
+	assert.False(t, b.IsDefaultManager())
+	assert.Equal(t, []ConfigChange{
+		{Field: "manager namespace", Old: "ambassador", New: "team-a"},
+		{Field: "manager name", Old: "traffic-manager", New: "team-a-manager"},
+	}, a.ContextServiceAndFlagsDiff(b))
+}
+
+func TestConfig_RedactedFlagMap(t *testing.T) {
+	c := &Config{flagMap: map[string]string{"context": "ctx-a", "token": "secret", "password": "secret"}}
+	assert.Equal(t, map[string]string{"context": "ctx-a", "token": "<redacted>", "password": "<redacted>"}, c.RedactedFlagMap())
+	assert.Equal(t, "secret", c.flagMap["token"])
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net"
 	"os"
 	"path/filepath"
 	"sync"
@@ -11,6 +12,8 @@ import (
 
 	"github.com/spf13/cobra"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/reflection"
+	empty "google.golang.org/protobuf/types/known/emptypb"
 
 	"github.com/datawire/dlib/dgroup"
 	"github.com/datawire/dlib/dhttp"
@@ -21,6 +24,7 @@ import (
 	"github.com/telepresenceio/telepresence/v2/pkg/a8rcloud"
 	"github.com/telepresenceio/telepresence/v2/pkg/client"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/cli/cliutil"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/logging"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/scout"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/userd/auth"
@@ -68,6 +72,8 @@ type service struct {
 	daemonClient      daemon.DaemonClient
 	loginExecutor     auth.LoginExecutor
 	userNotifications func(context.Context) <-chan string
+	notifyUser        func(string)
+	stateChanges      broadcastqueue.BroadcastQueue
 	ucn               int64
 
 	scout *scout.Reporter
@@ -80,13 +86,24 @@ type service struct {
 	sessionLock    sync.RWMutex
 
 	// These are used to communicate between the various goroutines.
-	connectRequest  chan *rpc.ConnectRequest // server-grpc.connect() -> connectWorker
-	connectResponse chan *rpc.ConnectInfo    // connectWorker -> server-grpc.connect()
+	connectRequest  chan pendingConnect   // server-grpc.connect() -> connectWorker
+	connectResponse chan *rpc.ConnectInfo // connectWorker -> server-grpc.connect()
+
+	// newSession creates the session for a connect request. It's trafficmgr.NewSession unless
+	// replaced by a test.
+	newSession func(context.Context, *scout.Reporter, *rpc.ConnectRequest, trafficmgr.Service, []trafficmgr.SessionService) (context.Context, trafficmgr.Session, *rpc.ConnectInfo)
 
 	// This is used for the service to know which CLI commands it supports
 	getCommands CommandFactory
 }
 
+// pendingConnect is a connect request together with the context of its caller. The creation of
+// the session is aborted when that context is cancelled.
+type pendingConnect struct {
+	ctx context.Context
+	cr  *rpc.ConnectRequest
+}
+
 func (s *service) SetManagerClient(managerClient manager.ManagerClient, callOptions ...grpc.CallOption) {
 	s.managerProxy.SetClient(managerClient, callOptions...)
 }
@@ -110,6 +127,10 @@ func (s *service) LoginExecutor() auth.LoginExecutor {
 	return s.loginExecutor
 }
 
+func (s *service) NotifyUser(msg string) {
+	s.notifyUser(msg + "\n")
+}
+
 // Command returns the CLI sub-command for "connector-foreground"
 func Command(getCommands CommandFactory, daemonServices []DaemonService, sessionServices []trafficmgr.SessionService) *cobra.Command {
 	c := &cobra.Command{
@@ -142,15 +163,17 @@ func (s *service) manageSessions(c context.Context, sessionServices []trafficmgr
 nextSession:
 	for {
 		// Wait for a connection request
-		var cr *rpc.ConnectRequest
+		var pc pendingConnect
 		select {
 		case <-c.Done():
 			break nextSession
-		case cr = <-s.connectRequest:
+		case pc = <-s.connectRequest:
 		}
+		cr := pc.cr
 
 		var session trafficmgr.Session
 		var rsp *rpc.ConnectInfo
+		aborted := false
 
 		s.sessionLock.Lock() // Locked during creation
 		if c.Err() == nil {  // If by the time we've got the session lock we're cancelled, then don't create the session and just leave by way of the select below
@@ -159,18 +182,31 @@ nextSession:
 				rsp = s.session.UpdateStatus(s.sessionContext, cr)
 			} else {
 				sCtx, sCancel := context.WithCancel(c)
-				sCtx, session, rsp = trafficmgr.NewSession(sCtx, s.scout, cr, s, sessionServices)
+				stop := cancelOnDone(pc.ctx, sCancel)
+				sCtx, session, rsp = s.newSession(sCtx, sessionReporter(s.scout, cr), cr, s, sessionServices)
 				sCtx = a8rcloud.WithSystemAPool[*SessionClient](sCtx, a8rcloud.UserdConnName, &SessionClientProvider{session})
-				if sCtx.Err() == nil && rsp.Error == rpc.ConnectInfo_UNSPECIFIED {
+				if !stop() {
+					dlog.Info(c, "connect was cancelled by the caller")
+					aborted = true
+					s.rollbackConnect(c)
+				} else if sCtx.Err() == nil && rsp.Error == rpc.ConnectInfo_UNSPECIFIED {
 					s.sessionContext = session.WithK8sInterface(sCtx)
 					s.sessionCancel = sCancel
 					s.session = session
+					s.emitStateChange(c, rpc.StateChange_CONNECTED, rsp.ClusterContext, "")
+					if max := client.GetConfig(c).Intercept.MaxSessionDuration; max > 0 {
+						go s.capSessionDuration(sCtx, max)
+					}
 				} else {
 					sCancel()
 				}
 			}
 		}
 		s.sessionLock.Unlock()
+		if aborted {
+			// The caller has returned, so there's nobody to respond to.
+			continue
+		}
 
 		select {
 		case <-c.Done():
@@ -189,28 +225,188 @@ nextSession:
 		// Run the session asynchronously. We must be able to respond to connect (with UpdateStatus) while
 		// the session is running. The s.sessionCancel is called from Disconnect
 		wg.Add(1)
-		go func(cr *rpc.ConnectRequest) {
+		go func(cr *rpc.ConnectRequest, clusterContext string) {
 			defer wg.Done()
-			if err := s.session.Run(s.sessionContext); err != nil {
-				if errors.Is(err, trafficmgr.SessionExpiredErr) {
-					// Session has expired. We need to cancel the owner session and reconnect
-					dlog.Info(c, "refreshing session")
-					s.cancelSession()
-					select {
-					case <-c.Done():
-					case s.connectRequest <- cr:
-					}
-					return
-				}
-
-				dlog.Error(c, err)
-			}
-		}(cr)
+			s.serveSession(c, cr, clusterContext, sessionServices)
+		}(cr, rsp.ClusterContext)
 	}
 	wg.Wait()
 	return nil
 }
 
+// serveSession runs the current session, which was created by the given connect request, until it
+// ends. A session whose kubeconfig context has changed is replaced using replaceSession. A session
+// that has expired is recreated by sending the connect request again.
+func (s *service) serveSession(c context.Context, cr *rpc.ConnectRequest, clusterContext string, sessionServices []trafficmgr.SessionService) {
+	err := s.runSession(c, clusterContext)
+	for errors.Is(err, trafficmgr.KubeconfigChangedErr) {
+		var rsp *rpc.ConnectInfo
+		if rsp, err = s.replaceSession(c, cr, clusterContext, sessionServices); rsp != nil {
+			clusterContext = rsp.ClusterContext
+			err = s.runSession(c, clusterContext)
+		}
+	}
+	if err != nil {
+		if errors.Is(err, trafficmgr.SessionExpiredErr) {
+			// Session has expired and the traffic-manager couldn't be reconnected. We need to
+			// cancel the owner session and reconnect
+			dlog.Info(c, "refreshing session")
+			s.cancelSession()
+			select {
+			case <-c.Done():
+			case s.connectRequest <- pendingConnect{ctx: c, cr: cr}:
+			}
+			return
+		}
+		dlog.Error(c, err)
+	}
+	s.emitStateChange(c, rpc.StateChange_DISCONNECTED, clusterContext, "")
+}
+
+// runSession runs the current session. When the session with the traffic-manager expires, only the
+// traffic-manager connection is re-established, so that the connection to the cluster, its watchers,
+// and all calls that use the session remain intact. The SessionExpiredErr is returned when that
+// reconnect fails, in which case the whole session must be recreated. A RECONNECTING state change has
+// then already been emitted.
+func (s *service) runSession(c context.Context, clusterContext string) error {
+	for {
+		err := s.session.Run(s.sessionContext)
+		if !errors.Is(err, trafficmgr.SessionExpiredErr) || s.sessionContext.Err() != nil {
+			return err
+		}
+		dlog.Info(c, "traffic-manager session expired, reconnecting to traffic-manager")
+		s.emitStateChange(c, rpc.StateChange_RECONNECTING, clusterContext, "")
+		if rErr := s.session.ReconnectTrafficManager(s.sessionContext); rErr != nil {
+			dlog.Error(c, rErr)
+			return err
+		}
+		s.emitStateChange(c, rpc.StateChange_CONNECTED, clusterContext, "")
+	}
+}
+
+// replaceSession replaces the current session with a new one that is created using the given connect
+// request, so that the cluster is reached using the credentials that the kubeconfig now contains.
+// Unlike a disconnect, the intercepts are kept. The new session resumes the session with the
+// traffic-manager that the old one used, so the intercepts remain active. A RECONNECTING state change
+// is emitted first, and a CONNECTED state change once the new session has been created, in which case
+// its ConnectInfo is returned. The returned ConnectInfo is nil when the session was ended by other
+// means, e.g. a disconnect, while it was being replaced.
+func (s *service) replaceSession(
+	c context.Context,
+	cr *rpc.ConnectRequest,
+	clusterContext string,
+	sessionServices []trafficmgr.SessionService,
+) (*rpc.ConnectInfo, error) {
+	dlog.Info(c, "kubeconfig changed, replacing session")
+	s.emitStateChange(c, rpc.StateChange_RECONNECTING, clusterContext, "")
+
+	// The old session must be cancelled before the write-lock can be acquired, because long-running
+	// RPCs may be holding the read-lock.
+	s.sessionLock.RLock()
+	old := s.session
+	if old != nil {
+		s.sessionCancel()
+	}
+	s.sessionLock.RUnlock()
+
+	s.sessionLock.Lock()
+	defer s.sessionLock.Unlock()
+	if old == nil || s.session != old || c.Err() != nil {
+		return nil, nil
+	}
+	s.session = nil
+	s.sessionCancel = nil
+
+	sCtx, sCancel := context.WithCancel(c)
+	sCtx, session, rsp := s.newSession(sCtx, sessionReporter(s.scout, cr), cr, s, sessionServices)
+	if sCtx.Err() != nil || rsp.Error != rpc.ConnectInfo_UNSPECIFIED {
+		sCancel()
+		if c.Err() != nil {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("unable to replace session: %s", rsp.ErrorText)
+	}
+	sCtx = a8rcloud.WithSystemAPool[*SessionClient](sCtx, a8rcloud.UserdConnName, &SessionClientProvider{session})
+	s.sessionContext = session.WithK8sInterface(sCtx)
+	s.sessionCancel = sCancel
+	s.session = session
+	s.emitStateChange(c, rpc.StateChange_CONNECTED, rsp.ClusterContext, "")
+	if max := client.GetConfig(c).Intercept.MaxSessionDuration; max > 0 {
+		go s.capSessionDuration(sCtx, max)
+	}
+	return rsp, nil
+}
+
+// sessionReporter returns the scout reporter for the session that is created by the given connect
+// request, restricted by the request's telemetry settings.
+func sessionReporter(sr *scout.Reporter, cr *rpc.ConnectRequest) *scout.Reporter {
+	ts := cr.Telemetry
+	if ts == nil {
+		return sr
+	}
+	return sr.ForSession(ts.Disabled, ts.MinInterval.AsDuration())
+}
+
+// cancelOnDone calls cancel when ctx is done, unless the returned stop function has been called
+// before that. The stop function returns false if cancel was called.
+func cancelOnDone(ctx context.Context, cancel context.CancelFunc) (stop func() bool) {
+	var lock sync.Mutex
+	stopped, cancelled := false, false
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			lock.Lock()
+			if !stopped {
+				cancelled = true
+				cancel()
+			}
+			lock.Unlock()
+		case <-done:
+		}
+	}()
+	return func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+		stopped = true
+		close(done)
+		return !cancelled
+	}
+}
+
+// rollbackConnect undoes what a connect may have done before it was cancelled. The manager proxy
+// no longer uses the traffic-manager client of the aborted session, and the root daemon is told
+// to disconnect the session that it may have started.
+func (s *service) rollbackConnect(c context.Context) {
+	s.SetManagerClient(nil)
+	c, cancel := context.WithTimeout(c, 5*time.Second)
+	defer cancel()
+	rd, err := s.RootDaemonClient(c)
+	if err == nil {
+		_, err = rd.Disconnect(c, &empty.Empty{})
+	}
+	if err != nil {
+		dlog.Errorf(c, "failed to disconnect root daemon after cancelled connect: %v", err)
+	}
+}
+
+// capSessionDuration ends the session of the given context when it has lasted for the given
+// maximum duration. The user is warned, and the intercepts are removed, before the session is
+// cancelled.
+func (s *service) capSessionDuration(c context.Context, max time.Duration) {
+	t := time.NewTimer(max)
+	defer t.Stop()
+	select {
+	case <-c.Done():
+		return
+	case <-t.C:
+	}
+	msg := fmt.Sprintf("session has reached its maximum duration of %s and will be ended", max)
+	dlog.Warn(c, msg)
+	s.NotifyUser("Warning: " + msg)
+	s.cancelSession()
+}
+
 func (s *service) cancelSessionReadLocked() {
 	if s.sessionCancel != nil {
 		if err := s.session.ClearIntercepts(s.sessionContext); err != nil {
@@ -233,6 +429,40 @@ func (s *service) cancelSession() {
 	s.sessionLock.Unlock()
 }
 
+// listenTCP opens the TCP listener that the gRPC server uses in addition to its local socket. Mutual
+// TLS is required, because anyone that can reach the address could otherwise control the connector.
+func listenTCP(addr string, tlsEnabled bool) (net.Listener, error) {
+	if !tlsEnabled {
+		return nil, errcat.Config.Newf("the gRPC listen address %s requires grpc.tls to be configured", addr)
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("unable to listen on gRPC address %s: %w", addr, err)
+	}
+	return l, nil
+}
+
+// serveGRPC serves the gRPC handler of the given config on the given listener until the context
+// is cancelled. Mutual TLS is used when the config has a TLS config.
+func serveGRPC(c context.Context, sc *dhttp.ServerConfig, l net.Listener) (err error) {
+	if sc.TLSConfig != nil {
+		dlog.Infof(c, "gRPC server started on %s with mutual TLS", l.Addr())
+		err = sc.ServeTLS(c, l, "", "")
+	} else {
+		dlog.Infof(c, "gRPC server started on %s", l.Addr())
+		err = sc.Serve(c, l)
+	}
+	if err != nil && c.Err() != nil {
+		err = nil // Normal shutdown
+	}
+	if err != nil {
+		dlog.Errorf(c, "gRPC server on %s ended with: %v", l.Addr(), err)
+	} else {
+		dlog.Debugf(c, "gRPC server on %s ended", l.Addr())
+	}
+	return err
+}
+
 // run is the main function when executing as the connector
 func run(c context.Context, getCommands CommandFactory, daemonServices []DaemonService, sessionServices []trafficmgr.SessionService) error {
 	cfg, err := client.LoadConfig(c)
@@ -258,6 +488,13 @@ func run(c context.Context, getCommands CommandFactory, daemonServices []DaemonS
 	}()
 	dlog.Debug(c, "Listener opened")
 
+	var tcpListener net.Listener
+	if addr := cfg.Grpc.ListenAddress; addr != "" {
+		if tcpListener, err = listenTCP(addr, cfg.Grpc.TLS.Enabled()); err != nil {
+			return err
+		}
+	}
+
 	dlog.Info(c, "---")
 	dlog.Infof(c, "Telepresence %s %s starting...", titleName, client.DisplayVersion())
 	dlog.Infof(c, "PID is %d", os.Getpid())
@@ -271,11 +508,13 @@ func run(c context.Context, getCommands CommandFactory, daemonServices []DaemonS
 
 	s := &service{
 		scout:             sr,
-		connectRequest:    make(chan *rpc.ConnectRequest),
+		connectRequest:    make(chan pendingConnect),
 		connectResponse:   make(chan *rpc.ConnectInfo),
+		newSession:        trafficmgr.NewSession,
 		managerProxy:      trafficmgr.NewManagerProxy(),
 		loginExecutor:     auth.NewStandardLoginExecutor(cliio, sr),
 		userNotifications: func(ctx context.Context) <-chan string { return cliio.Subscribe(ctx) },
+		notifyUser:        cliio.Push,
 		timedLogLevel:     log.NewTimedLevel(cfg.LogLevels.UserDaemon.String(), log.SetLevel),
 		getCommands:       getCommands,
 	}
@@ -284,7 +523,7 @@ func run(c context.Context, getCommands CommandFactory, daemonServices []DaemonS
 	}
 
 	g := dgroup.NewGroup(c, dgroup.GroupConfig{
-		SoftShutdownTimeout:  2 * time.Second,
+		SoftShutdownTimeout:  cfg.Timeouts.Get(client.TimeoutGracefulShutdown),
 		EnableSignalHandling: true,
 		ShutdownOnNonError:   true,
 	})
@@ -300,6 +539,10 @@ func run(c context.Context, getCommands CommandFactory, daemonServices []DaemonS
 		s.svc = grpc.NewServer(opts...)
 		rpc.RegisterConnectorServer(s.svc, s)
 		manager.RegisterManagerServer(s.svc, s.managerProxy)
+		if cfg.Grpc.Reflection {
+			dlog.Info(c, "Registering gRPC server reflection service")
+			reflection.Register(s.svc)
+		}
 		for _, ds := range daemonServices {
 			dlog.Infof(c, "Starting additional daemon service %s", ds.Name())
 			if err := ds.Start(c, sr, s.svc, s.withSession); err != nil {
@@ -308,16 +551,18 @@ func run(c context.Context, getCommands CommandFactory, daemonServices []DaemonS
 		}
 
 		sc := &dhttp.ServerConfig{Handler: s.svc}
-		dlog.Info(c, "gRPC server started")
-		if err = sc.Serve(c, grpcListener); err != nil && c.Err() != nil {
-			err = nil // Normal shutdown
+		if cfg.Grpc.TLS.Enabled() {
+			if sc.TLSConfig, err = cfg.Grpc.TLS.ServerConfig(); err != nil {
+				return err
+			}
 		}
-		if err != nil {
-			dlog.Errorf(c, "gRPC server ended with: %v", err)
-		} else {
-			dlog.Debug(c, "gRPC server ended")
+		if tcpListener == nil {
+			return serveGRPC(c, sc, grpcListener)
 		}
-		return err
+		sg := dgroup.NewGroup(c, dgroup.GroupConfig{})
+		sg.Go("socket", func(c context.Context) error { return serveGRPC(c, sc, grpcListener) })
+		sg.Go("tcp", func(c context.Context) error { return serveGRPC(c, sc, tcpListener) })
+		return sg.Wait()
 	})
 
 	g.Go("config-reload", s.configReload)
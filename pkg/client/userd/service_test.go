@@ -0,0 +1,364 @@
+package userd
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
+	empty "google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/datawire/dlib/dhttp"
+	"github.com/datawire/dlib/dlog"
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/rpc/v2/daemon"
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/scout"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/userd/trafficmgr"
+)
+
+// expiringSession is a trafficmgr.Session whose first run ends because the session with the
+// traffic-manager has expired.
+type expiringSession struct {
+	trafficmgr.Session
+	runs       int32
+	reconnects int32
+	reconnErr  error
+}
+
+func (s *expiringSession) Run(c context.Context) error {
+	if atomic.AddInt32(&s.runs, 1) == 1 {
+		return trafficmgr.SessionExpiredErr
+	}
+	<-c.Done()
+	return nil
+}
+
+func (s *expiringSession) ReconnectTrafficManager(context.Context) error {
+	atomic.AddInt32(&s.reconnects, 1)
+	return s.reconnErr
+}
+
+func (s *expiringSession) WatchWorkloads(c context.Context, _ *rpc.WatchWorkloadsRequest, _ trafficmgr.WatchWorkloadsStream) error {
+	<-c.Done()
+	return c.Err()
+}
+
+func TestService_runSession_reconnectsTrafficManager(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	sCtx, sCancel := context.WithCancel(ctx)
+	session := &expiringSession{}
+	s := &service{session: session, sessionContext: sCtx, sessionCancel: sCancel}
+
+	// A watcher that uses the session, and that must survive the reconnect.
+	watchDone := make(chan error, 1)
+	go func() {
+		watchDone <- s.withSession(ctx, "WatchWorkloads", func(c context.Context, session trafficmgr.Session) error {
+			return session.WatchWorkloads(c, &rpc.WatchWorkloadsRequest{}, nil)
+		})
+	}()
+
+	runDone := make(chan error, 1)
+	go func() { runDone <- s.runSession(ctx, "test-context") }()
+
+	require.Eventually(t, func() bool { return atomic.LoadInt32(&session.runs) == 2 }, 5*time.Second, time.Millisecond)
+	require.Equal(t, int32(1), atomic.LoadInt32(&session.reconnects))
+	select {
+	case err := <-watchDone:
+		t.Fatalf("watcher ended during reconnect: %v", err)
+	default:
+	}
+
+	sCancel()
+	require.NoError(t, <-runDone)
+	require.ErrorIs(t, <-watchDone, context.Canceled)
+}
+
+func TestService_runSession_reconnectFails(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	sCtx, sCancel := context.WithCancel(ctx)
+	defer sCancel()
+	session := &expiringSession{reconnErr: errors.New("manager unavailable")}
+	s := &service{session: session, sessionContext: sCtx, sessionCancel: sCancel}
+
+	// The whole session must be recreated when the traffic-manager cannot be reconnected.
+	require.ErrorIs(t, s.runSession(ctx, "test-context"), trafficmgr.SessionExpiredErr)
+	require.Equal(t, int32(1), session.runs)
+	require.Equal(t, int32(1), session.reconnects)
+}
+
+// kubeconfigSession is a trafficmgr.Session whose run ends because its kubeconfig context changed,
+// unless changed is false. It counts the calls to ClearIntercepts.
+type kubeconfigSession struct {
+	trafficmgr.Session
+	changed bool
+	clears  int32
+}
+
+func (s *kubeconfigSession) Run(c context.Context) error {
+	if s.changed {
+		return trafficmgr.KubeconfigChangedErr
+	}
+	<-c.Done()
+	return nil
+}
+
+func (s *kubeconfigSession) ClearIntercepts(context.Context) error {
+	atomic.AddInt32(&s.clears, 1)
+	return nil
+}
+
+func (s *kubeconfigSession) WithK8sInterface(c context.Context) context.Context {
+	return c
+}
+
+func TestService_serveSession_kubeconfigChanged(t *testing.T) {
+	cfg := client.GetDefaultConfig()
+	ctx, cancel := context.WithCancel(client.WithConfig(dlog.NewTestContext(t, false), &cfg))
+	defer cancel()
+	sCtx, sCancel := context.WithCancel(ctx)
+	old := &kubeconfigSession{changed: true}
+	replacement := &kubeconfigSession{}
+	var creates int32
+	s := &service{
+		session:        old,
+		sessionContext: sCtx,
+		sessionCancel:  sCancel,
+		scout:          scout.NewReporter(ctx, "test"),
+		newSession: func(c context.Context, _ *scout.Reporter, _ *rpc.ConnectRequest, _ trafficmgr.Service, _ []trafficmgr.SessionService) (context.Context, trafficmgr.Session, *rpc.ConnectInfo) {
+			atomic.AddInt32(&creates, 1)
+			return c, replacement, &rpc.ConnectInfo{ClusterContext: "new-context"}
+		},
+	}
+	stateChanges := s.stateChanges.Subscribe(ctx)
+	nextEvent := func() rpc.StateChange_Event {
+		select {
+		case data := <-stateChanges:
+			sc := &rpc.StateChange{}
+			require.NoError(t, proto.Unmarshal([]byte(data), sc))
+			return sc.Event
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for state change")
+			return 0
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.serveSession(ctx, &rpc.ConnectRequest{}, "test-context", nil)
+		close(done)
+	}()
+
+	// The session is replaced in-process, without clearing the intercepts.
+	require.Equal(t, rpc.StateChange_RECONNECTING, nextEvent())
+	require.Equal(t, rpc.StateChange_CONNECTED, nextEvent())
+	require.Equal(t, int32(1), atomic.LoadInt32(&creates))
+	require.Zero(t, atomic.LoadInt32(&old.clears))
+	require.Error(t, sCtx.Err(), "the old session was not cancelled")
+	s.sessionLock.RLock()
+	require.Same(t, replacement, s.session)
+	s.sessionLock.RUnlock()
+
+	// The new session runs until it's disconnected.
+	s.cancelSession()
+	<-done
+	require.Equal(t, rpc.StateChange_DISCONNECTED, nextEvent())
+	require.Equal(t, int32(1), atomic.LoadInt32(&replacement.clears))
+}
+
+// disconnectCounter is a daemon.DaemonClient that counts the calls to Disconnect.
+type disconnectCounter struct {
+	daemon.DaemonClient
+	disconnects int32
+}
+
+func (d *disconnectCounter) Disconnect(context.Context, *empty.Empty, ...grpc.CallOption) (*empty.Empty, error) {
+	atomic.AddInt32(&d.disconnects, 1)
+	return &empty.Empty{}, nil
+}
+
+func TestService_Connect_cancelled(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	rd := &disconnectCounter{}
+	started := make(chan struct{})
+	s := &service{
+		connectRequest:  make(chan pendingConnect),
+		connectResponse: make(chan *rpc.ConnectInfo),
+		managerProxy:    trafficmgr.NewManagerProxy(),
+		daemonClient:    rd,
+		newSession: func(c context.Context, _ *scout.Reporter, _ *rpc.ConnectRequest, _ trafficmgr.Service, _ []trafficmgr.SessionService) (context.Context, trafficmgr.Session, *rpc.ConnectInfo) {
+			// A connect that is stuck until its context is cancelled.
+			close(started)
+			<-c.Done()
+			return c, nil, &rpc.ConnectInfo{Error: rpc.ConnectInfo_CLUSTER_FAILED}
+		},
+	}
+	mgrCtx, mgrCancel := context.WithCancel(ctx)
+	mgrDone := make(chan error, 1)
+	go func() { mgrDone <- s.manageSessions(mgrCtx, nil) }()
+
+	cCtx, cCancel := context.WithCancel(ctx)
+	go func() {
+		<-started
+		cCancel()
+	}()
+	_, err := s.Connect(cCtx, &rpc.ConnectRequest{})
+	require.Equal(t, codes.Canceled, status.Code(err))
+	require.Eventually(t, func() bool { return atomic.LoadInt32(&rd.disconnects) == 1 }, 5*time.Second, time.Millisecond)
+
+	s.sessionLock.RLock()
+	require.Nil(t, s.session)
+	s.sessionLock.RUnlock()
+
+	mgrCancel()
+	require.NoError(t, <-mgrDone)
+}
+
+func TestSessionReporter(t *testing.T) {
+	sr := scout.NewReporter(dlog.NewTestContext(t, false), "test")
+
+	// Without telemetry settings, the session uses the connector's reporter
+	require.Same(t, sr, sessionReporter(sr, &rpc.ConnectRequest{}))
+	require.Same(t, sr, sessionReporter(sr, &rpc.ConnectRequest{Telemetry: &rpc.TelemetrySettings{}}))
+
+	// With restricting settings, the session gets a reporter of its own
+	require.NotSame(t, sr, sessionReporter(sr, &rpc.ConnectRequest{Telemetry: &rpc.TelemetrySettings{Disabled: true}}))
+	require.NotSame(t, sr, sessionReporter(sr, &rpc.ConnectRequest{Telemetry: &rpc.TelemetrySettings{
+		MinInterval: durationpb.New(time.Minute),
+	}}))
+}
+
+func TestCancelOnDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var cancels int32
+	stop := cancelOnDone(ctx, func() { atomic.AddInt32(&cancels, 1) })
+	require.True(t, stop())
+	cancel()
+	time.Sleep(10 * time.Millisecond)
+	require.Equal(t, int32(0), atomic.LoadInt32(&cancels))
+
+	ctx, cancel = context.WithCancel(context.Background())
+	stop = cancelOnDone(ctx, func() { atomic.AddInt32(&cancels, 1) })
+	cancel()
+	require.Eventually(t, func() bool { return atomic.LoadInt32(&cancels) == 1 }, 5*time.Second, time.Millisecond)
+	require.False(t, stop())
+}
+
+// clearingSession is a trafficmgr.Session that counts the calls to ClearIntercepts.
+type clearingSession struct {
+	trafficmgr.Session
+	clears int32
+}
+
+func (s *clearingSession) ClearIntercepts(context.Context) error {
+	atomic.AddInt32(&s.clears, 1)
+	return nil
+}
+
+func TestService_capSessionDuration(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	sCtx, sCancel := context.WithCancel(ctx)
+	defer sCancel()
+	session := &clearingSession{}
+	notified := make(chan string, 1)
+	s := &service{
+		session:        session,
+		sessionContext: sCtx,
+		sessionCancel:  sCancel,
+		notifyUser:     func(msg string) { notified <- msg },
+	}
+
+	s.capSessionDuration(sCtx, 10*time.Millisecond)
+	require.Contains(t, <-notified, "maximum duration of 10ms")
+	require.Equal(t, int32(1), atomic.LoadInt32(&session.clears))
+	require.Error(t, sCtx.Err())
+	s.sessionLock.RLock()
+	require.Nil(t, s.session)
+	s.sessionLock.RUnlock()
+}
+
+func TestService_capSessionDuration_ended(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	sCtx, sCancel := context.WithCancel(ctx)
+	session := &clearingSession{}
+	s := &service{session: session, sessionContext: sCtx, sessionCancel: sCancel}
+
+	// A session that ends before its maximum duration is left alone.
+	sCancel()
+	s.capSessionDuration(sCtx, time.Hour)
+	require.Zero(t, atomic.LoadInt32(&session.clears))
+	require.NotNil(t, s.session)
+}
+
+func TestListenTCP(t *testing.T) {
+	// Without mutual TLS, the connector API would be open to anyone that can reach the address.
+	_, err := listenTCP("127.0.0.1:0", false)
+	require.Error(t, err)
+	require.Equal(t, errcat.Config, errcat.GetCategory(err))
+
+	l, err := listenTCP("127.0.0.1:0", true)
+	require.NoError(t, err)
+	require.NoError(t, l.Close())
+}
+
+func TestServeGRPC_TCP(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	svc := grpc.NewServer()
+	rpc.RegisterConnectorServer(svc, &service{})
+	done := make(chan error, 1)
+	go func() { done <- serveGRPC(ctx, &dhttp.ServerConfig{Handler: svc}, l) }()
+
+	conn, err := grpc.DialContext(ctx, l.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+	vi, err := rpc.NewConnectorClient(conn).Version(ctx, &empty.Empty{})
+	require.NoError(t, err)
+	require.Equal(t, client.Version(), vi.Version)
+
+	cancel()
+	require.NoError(t, <-done)
+}
+
+// ingressSession is a trafficmgr.Session that records the namespaces that ingress detection is
+// asked to search.
+type ingressSession struct {
+	trafficmgr.Session
+	namespaces []string
+}
+
+func (s *ingressSession) DetectIngressInfos(_ context.Context, namespaces []string) ([]*manager.IngressInfo, *rpc.IngressDiagnostics, error) {
+	s.namespaces = namespaces
+	return []*manager.IngressInfo{{Host: "edge.team-b", Port: 443, UseTls: true}}, &rpc.IngressDiagnostics{Namespaces: namespaces}, nil
+}
+
+func TestService_DetectIngressInfos(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	session := &ingressSession{}
+	s := &service{session: session, sessionContext: ctx}
+
+	result, err := s.DetectIngressInfos(ctx, &rpc.DetectIngressRequest{Namespaces: []string{"team-b"}})
+	require.NoError(t, err)
+	require.Equal(t, []string{"team-b"}, session.namespaces)
+	require.Len(t, result.IngressInfos, 1)
+	require.Equal(t, "edge.team-b", result.IngressInfos[0].Host)
+	require.Equal(t, []string{"team-b"}, result.Diagnostics.Namespaces)
+
+	// Without a session, there's nothing to detect with.
+	_, err = (&service{}).DetectIngressInfos(ctx, &rpc.DetectIngressRequest{})
+	require.Equal(t, codes.Unavailable, status.Code(err))
+}
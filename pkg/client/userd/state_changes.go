@@ -0,0 +1,49 @@
+package userd
+
+import (
+	"context"
+
+	"google.golang.org/protobuf/proto"
+	empty "google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/datawire/dlib/dlog"
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/connector"
+)
+
+// emitStateChange broadcasts a StateChange to all subscribers of the StateChanges stream. The
+// event is sent through a broadcastqueue.BroadcastQueue in its marshalled form so that slow
+// subscribers never block the caller.
+func (s *service) emitStateChange(c context.Context, event rpc.StateChange_Event, clusterContext, interceptName string) {
+	s.pushStateChange(c, &rpc.StateChange{
+		Event:          event,
+		ClusterContext: clusterContext,
+		InterceptName:  interceptName,
+	})
+}
+
+// pushStateChange broadcasts the given StateChange after setting its time.
+func (s *service) pushStateChange(c context.Context, sc *rpc.StateChange) {
+	sc.Time = timestamppb.Now()
+	data, err := proto.Marshal(sc)
+	if err != nil {
+		dlog.Errorf(c, "unable to marshal state change %s: %v", sc.Event, err)
+		return
+	}
+	s.stateChanges.Push(string(data))
+}
+
+func (s *service) StateChanges(_ *empty.Empty, stream rpc.Connector_StateChangesServer) (err error) {
+	s.logCall(stream.Context(), "StateChanges", func(c context.Context) {
+		for data := range s.stateChanges.Subscribe(c) {
+			sc := &rpc.StateChange{}
+			if err = proto.Unmarshal([]byte(data), sc); err != nil {
+				return
+			}
+			if err = stream.Send(sc); err != nil {
+				return
+			}
+		}
+	})
+	return err
+}
@@ -2,10 +2,14 @@ package trafficmgr
 
 import (
 	"context"
+	"fmt"
+	"strings"
 
 	core "k8s.io/api/core/v1"
 
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/connector"
 	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
 	"github.com/telepresenceio/telepresence/v2/pkg/k8sapi"
 )
 
@@ -16,22 +20,91 @@ func (tm *TrafficManager) IngressInfos(c context.Context) ([]*manager.IngressInf
 	ingressInfo := tm.ingressInfo
 	if ingressInfo == nil {
 		tm.insLock.Unlock()
-		ingressInfo, err := tm.detectIngressBehavior(c)
+		ingressInfo, diags, err := tm.detectIngressBehavior(c)
 		if err != nil {
 			return nil, err
 		}
 		tm.insLock.Lock()
 		tm.ingressInfo = ingressInfo
+		tm.ingressDiagnostics = diags
 	}
 	is := make([]*manager.IngressInfo, len(tm.ingressInfo))
 	copy(is, tm.ingressInfo)
 	return is, nil
 }
 
-func (tm *TrafficManager) detectIngressBehavior(c context.Context) ([]*manager.IngressInfo, error) {
-	loadBalancers, err := tm.findAllSvcByType(c, core.ServiceTypeLoadBalancer)
+// DetectIngressInfos re-runs the detection of ingress behavior, so that load balancers in
+// namespaces that were added after the first detection are found. When no namespaces are given, all
+// mapped namespaces are searched, and the result replaces the one that IngressInfos returns.
+// Otherwise, only the given namespaces are searched, and they must be mapped.
+func (tm *TrafficManager) DetectIngressInfos(c context.Context, namespaces []string) ([]*manager.IngressInfo, *rpc.IngressDiagnostics, error) {
+	mapped := tm.GetCurrentNamespaces(true)
+	if len(namespaces) > 0 {
+		if um := unmappedNamespaces(namespaces, mapped); len(um) > 0 {
+			return nil, nil, errcat.User.Newf("namespaces %s are not mapped", strings.Join(um, ", "))
+		}
+		return detectIngressBehavior(c, namespaces)
+	}
+	iis, diags, err := detectIngressBehavior(c, mapped)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	tm.insLock.Lock()
+	tm.ingressInfo = iis
+	tm.ingressDiagnostics = diags
+	tm.insLock.Unlock()
+	is := make([]*manager.IngressInfo, len(iis))
+	copy(is, iis)
+	return is, diags, nil
+}
+
+// unmappedNamespaces returns the namespaces in requested that aren't in mapped.
+func unmappedNamespaces(requested, mapped []string) []string {
+	var um []string
+	for _, ns := range requested {
+		found := false
+		for _, m := range mapped {
+			if ns == m {
+				found = true
+				break
+			}
+		}
+		if !found {
+			um = append(um, ns)
+		}
+	}
+	return um
+}
+
+// getIngressDiagnostics returns the diagnostics of the last detection of ingress behavior, or nil
+// if no detection has been made since the mapped namespaces changed.
+func (tm *TrafficManager) getIngressDiagnostics() *rpc.IngressDiagnostics {
+	tm.insLock.Lock()
+	defer tm.insLock.Unlock()
+	return tm.ingressDiagnostics
+}
+
+func (tm *TrafficManager) detectIngressBehavior(c context.Context) ([]*manager.IngressInfo, *rpc.IngressDiagnostics, error) {
+	return detectIngressBehavior(c, tm.GetCurrentNamespaces(true))
+}
+
+// lbAnnotationPrefixes are the prefixes of the service annotations that configure load balancers.
+var lbAnnotationPrefixes = []string{
+	"service.beta.kubernetes.io/",
+	"service.kubernetes.io/",
+	"cloud.google.com/",
+	"networking.gke.io/",
+	"metallb.universe.tf/",
+	"getambassador.io/",
+}
+
+// detectIngressBehavior finds the LoadBalancer services in the given namespaces, or in all
+// namespaces when none are given, and returns the ingress info of each one that has a TCP port,
+// together with the diagnostics that describe how the result was arrived at.
+func detectIngressBehavior(c context.Context, namespaces []string) ([]*manager.IngressInfo, *rpc.IngressDiagnostics, error) {
+	loadBalancers, err := findAllSvcByType(c, namespaces, core.ServiceTypeLoadBalancer)
+	if err != nil {
+		return nil, nil, err
 	}
 	type portFilter func(p *core.ServicePort) bool
 	findTCPPort := func(ports []core.ServicePort, filter portFilter) *core.ServicePort {
@@ -45,40 +118,79 @@ func (tm *TrafficManager) detectIngressBehavior(c context.Context) ([]*manager.I
 	}
 
 	// filters in priority order.
-	portFilters := []portFilter{
-		func(p *core.ServicePort) bool { return p.Name == "https" },
-		func(p *core.ServicePort) bool { return p.Port == 443 },
-		func(p *core.ServicePort) bool { return p.Name == "http" },
-		func(p *core.ServicePort) bool { return p.Port == 80 },
-		func(p *core.ServicePort) bool { return true },
+	portFilters := []struct {
+		reason string
+		filter portFilter
+	}{
+		{"port named https", func(p *core.ServicePort) bool { return p.Name == "https" }},
+		{"port 443", func(p *core.ServicePort) bool { return p.Port == 443 }},
+		{"port named http", func(p *core.ServicePort) bool { return p.Name == "http" }},
+		{"port 80", func(p *core.ServicePort) bool { return p.Port == 80 }},
+		{"first TCP port", func(p *core.ServicePort) bool { return true }},
 	}
 
+	diags := &rpc.IngressDiagnostics{Namespaces: namespaces}
 	iis := make([]*manager.IngressInfo, 0, len(loadBalancers))
 	for _, lb := range loadBalancers {
 		spec := &lb.Spec
+		host := lb.Name + "." + lb.Namespace
+		cd := &rpc.IngressDiagnostics_Candidate{Service: host}
+		for k, v := range lb.Annotations {
+			for _, pfx := range lbAnnotationPrefixes {
+				if strings.HasPrefix(k, pfx) {
+					if cd.Annotations == nil {
+						cd.Annotations = make(map[string]string)
+					}
+					cd.Annotations[k] = v
+					break
+				}
+			}
+		}
+		diags.Candidates = append(diags.Candidates, cd)
+
 		var port *core.ServicePort
 		for _, pf := range portFilters {
-			if p := findTCPPort(spec.Ports, pf); p != nil {
+			if p := findTCPPort(spec.Ports, pf.filter); p != nil {
 				port = p
+				cd.Reason = "selected " + pf.reason
 				break
 			}
 		}
 		if port == nil {
+			cd.Reason = "rejected, no TCP port"
 			continue
 		}
+		cd.Port = port.Port
+		if port.Port != 443 {
+			for k := range cd.Annotations {
+				if strings.Contains(k, "ssl-cert") || strings.Contains(k, "ssl-ports") {
+					diags.Notes = append(diags.Notes, fmt.Sprintf(
+						"service %s has annotation %q, so its load balancer may terminate TLS, but TLS is only assumed for port 443", host, k))
+					break
+				}
+			}
+		}
 
 		iis = append(iis, &manager.IngressInfo{
-			Host:   lb.Name + "." + lb.Namespace,
+			Host:   host,
 			UseTls: port.Port == 443,
 			Port:   port.Port,
 		})
 	}
-	return iis, nil
+	switch len(iis) {
+	case 0:
+		diags.Notes = append(diags.Notes, "no LoadBalancer service with a TCP port was found")
+	case 1:
+	default:
+		diags.Notes = append(diags.Notes, fmt.Sprintf(
+			"%d LoadBalancer services with a TCP port were found, %s is used by default", len(iis), iis[0].Host))
+	}
+	return iis, diags, nil
 }
 
-// findAllSvcByType finds services with the given service type in all namespaces of the cluster returns
-// a slice containing a copy of those services.
-func (tm *TrafficManager) findAllSvcByType(c context.Context, svcType core.ServiceType) ([]*core.Service, error) {
+// findAllSvcByType finds services with the given service type in the given namespaces, or in all
+// namespaces of the cluster when none are given, and returns a slice containing a copy of those services.
+func findAllSvcByType(c context.Context, namespaces []string, svcType core.ServiceType) ([]*core.Service, error) {
 	// NOTE: This is expensive in terms of bandwidth on a large cluster. We currently only use this
 	// to retrieve ingress info and that task could be moved to the traffic-manager instead.
 	var typedSvcs []*core.Service
@@ -96,9 +208,8 @@ func (tm *TrafficManager) findAllSvcByType(c context.Context, svcType core.Servi
 		return nil
 	}
 
-	mns := tm.GetCurrentNamespaces(true)
-	if len(mns) > 0 {
-		for _, ns := range mns {
+	if len(namespaces) > 0 {
+		for _, ns := range namespaces {
 			if err := findTyped(ns); err != nil {
 				return nil, err
 			}
@@ -0,0 +1,86 @@
+package trafficmgr
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/k8sapi"
+)
+
+func loadBalancer(name, namespace string, annotations map[string]string, ports ...core.ServicePort) *core.Service {
+	return &core.Service{
+		ObjectMeta: meta.ObjectMeta{Name: name, Namespace: namespace, Annotations: annotations},
+		Spec:       core.ServiceSpec{Type: core.ServiceTypeLoadBalancer, Ports: ports},
+	}
+}
+
+func Test_detectIngressBehaviorDiagnostics(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	ctx = k8sapi.WithK8sInterface(ctx, fake.NewSimpleClientset(
+		loadBalancer("edge", "ambassador", map[string]string{
+			"service.beta.kubernetes.io/aws-load-balancer-ssl-cert": "arn:aws:acm:cert",
+			"app.kubernetes.io/managed-by":                          "helm",
+		}, core.ServicePort{Name: "http", Port: 80, Protocol: core.ProtocolTCP}),
+		loadBalancer("nginx", "ingress", nil,
+			core.ServicePort{Name: "metrics", Port: 9000, Protocol: core.ProtocolTCP},
+			core.ServicePort{Name: "https", Port: 443, Protocol: core.ProtocolTCP}),
+		loadBalancer("dns", "kube-system", nil, core.ServicePort{Name: "dns", Port: 53, Protocol: core.ProtocolUDP}),
+		&core.Service{
+			ObjectMeta: meta.ObjectMeta{Name: "echo", Namespace: "default"},
+			Spec:       core.ServiceSpec{Type: core.ServiceTypeClusterIP},
+		},
+	))
+
+	iis, diags, err := detectIngressBehavior(ctx, []string{"ambassador", "ingress", "kube-system"})
+	require.NoError(t, err)
+
+	// The primary result is unaffected by the diagnostics.
+	assert.Equal(t, []*manager.IngressInfo{
+		{Host: "edge.ambassador", Port: 80},
+		{Host: "nginx.ingress", Port: 443, UseTls: true},
+	}, iis)
+
+	require.NotNil(t, diags)
+	assert.Equal(t, []string{"ambassador", "ingress", "kube-system"}, diags.Namespaces)
+	require.Len(t, diags.Candidates, 3)
+
+	edge := diags.Candidates[0]
+	assert.Equal(t, "edge.ambassador", edge.Service)
+	assert.Equal(t, int32(80), edge.Port)
+	assert.Equal(t, "selected port named http", edge.Reason)
+	assert.Equal(t, map[string]string{"service.beta.kubernetes.io/aws-load-balancer-ssl-cert": "arn:aws:acm:cert"}, edge.Annotations)
+
+	assert.Equal(t, "selected port named https", diags.Candidates[1].Reason)
+
+	dns := diags.Candidates[2]
+	assert.Equal(t, "dns.kube-system", dns.Service)
+	assert.Zero(t, dns.Port)
+	assert.Equal(t, "rejected, no TCP port", dns.Reason)
+
+	require.Len(t, diags.Notes, 2)
+	assert.Contains(t, diags.Notes[0], "may terminate TLS")
+	assert.Contains(t, diags.Notes[1], "2 LoadBalancer services")
+}
+
+func Test_detectIngressBehaviorNone(t *testing.T) {
+	ctx := k8sapi.WithK8sInterface(dlog.NewTestContext(t, false), fake.NewSimpleClientset())
+	iis, diags, err := detectIngressBehavior(ctx, nil)
+	require.NoError(t, err)
+	assert.Empty(t, iis)
+	assert.Empty(t, diags.Candidates)
+	assert.Equal(t, []string{"no LoadBalancer service with a TCP port was found"}, diags.Notes)
+}
+
+func Test_unmappedNamespaces(t *testing.T) {
+	mapped := []string{"ambassador", "default", "ingress"}
+	assert.Empty(t, unmappedNamespaces([]string{"ingress", "default"}, mapped))
+	assert.Equal(t, []string{"team-b"}, unmappedNamespaces([]string{"ingress", "team-b"}, mapped))
+	assert.Equal(t, []string{"team-b"}, unmappedNamespaces([]string{"team-b"}, nil))
+}
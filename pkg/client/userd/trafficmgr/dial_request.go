@@ -2,7 +2,12 @@ package trafficmgr
 
 import (
 	"context"
+	"path"
 
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+	"github.com/telepresenceio/telepresence/v2/pkg/ipproto"
+	"github.com/telepresenceio/telepresence/v2/pkg/iputil"
 	"github.com/telepresenceio/telepresence/v2/pkg/tunnel"
 )
 
@@ -12,5 +17,48 @@ func (tm *TrafficManager) dialRequestWatcher(ctx context.Context) error {
 	if err != nil {
 		return err
 	}
-	return tunnel.DialWaitLoop(ctx, tm.managerClient, dialerStream, tm.sessionInfo.SessionId)
+	ctx = tunnel.WithDialRedirector(ctx, tm.socketRedirect)
+	return tunnel.DialWaitLoop(ctx, tm.managerClient, dialerStream, tm.sessionInfo.SessionId, tm.dialers)
+}
+
+// socketRedirect is a tunnel.DialRedirector that redirects the TCP connections of an intercept that
+// has a local_socket_path to that Unix socket. The intercept is found using the connection's
+// destination, which the traffic-agent sets to the target host and port of the intercept.
+func (tm *TrafficManager) socketRedirect(id tunnel.ConnID) (string, string, bool) {
+	if id.Protocol() != ipproto.TCP {
+		return "", "", false
+	}
+	tm.currentInterceptsLock.Lock()
+	defer tm.currentInterceptsLock.Unlock()
+	for _, ii := range tm.currentIntercepts {
+		spec := ii.Spec
+		if spec.LocalSocketPath != "" &&
+			uint16(spec.TargetPort) == id.DestinationPort() &&
+			iputil.Parse(spec.TargetHost).Equal(id.Destination()) {
+			return "unix", spec.LocalSocketPath, true
+		}
+	}
+	return "", "", false
+}
+
+// validateSocketPaths checks that the socket paths of the given spec can be used. The target host of
+// an intercept with a socket must be an IP address, because that's what the socketRedirect and the
+// traffic-agent use to identify the connections of the intercept, and a remote socket must have an
+// absolute path, because it is found relative to the volume mounts of the intercepted container.
+func validateSocketPaths(spec *manager.InterceptSpec) error {
+	if spec.LocalSocketPath == "" && spec.RemoteSocketPath == "" {
+		return nil
+	}
+	if iputil.Parse(spec.TargetHost) == nil {
+		return errcat.User.Newf("the target host %q of an intercept with a socket must be an IP address", spec.TargetHost)
+	}
+	if spec.RemoteSocketPath != "" {
+		if spec.Agent == "" {
+			return errcat.User.New("a local-only intercept cannot have a remote socket")
+		}
+		if !path.IsAbs(spec.RemoteSocketPath) {
+			return errcat.User.Newf("the remote socket path %q is not absolute", spec.RemoteSocketPath)
+		}
+	}
+	return nil
 }
@@ -0,0 +1,61 @@
+package trafficmgr
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/ipproto"
+	"github.com/telepresenceio/telepresence/v2/pkg/iputil"
+	"github.com/telepresenceio/telepresence/v2/pkg/tunnel"
+)
+
+func TestTrafficManager_socketRedirect(t *testing.T) {
+	tm := &TrafficManager{currentIntercepts: []*manager.InterceptInfo{
+		{Spec: &manager.InterceptSpec{Name: "tcp", TargetHost: "127.0.0.1", TargetPort: 8080}},
+		{Spec: &manager.InterceptSpec{Name: "socket", TargetHost: "127.0.0.1", TargetPort: 8081, LocalSocketPath: "/tmp/app.sock"}},
+	}}
+	src := iputil.Parse("10.0.0.1")
+	local := iputil.Parse("127.0.0.1")
+
+	network, addr, ok := tm.socketRedirect(tunnel.NewConnID(ipproto.TCP, src, local, 4711, 8081))
+	assert.True(t, ok)
+	assert.Equal(t, "unix", network)
+	assert.Equal(t, "/tmp/app.sock", addr)
+
+	// Connections to intercepts without a socket, to other hosts, and UDP are dialed as usual
+	_, _, ok = tm.socketRedirect(tunnel.NewConnID(ipproto.TCP, src, local, 4711, 8080))
+	assert.False(t, ok)
+	_, _, ok = tm.socketRedirect(tunnel.NewConnID(ipproto.TCP, src, iputil.Parse("10.0.0.2"), 4711, 8081))
+	assert.False(t, ok)
+	_, _, ok = tm.socketRedirect(tunnel.NewConnID(ipproto.UDP, src, local, 4711, 8081))
+	assert.False(t, ok)
+}
+
+func Test_validateSocketPaths(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    *manager.InterceptSpec
+		wantErr bool
+	}{
+		{"no socket", &manager.InterceptSpec{TargetHost: "localhost"}, false},
+		{"local socket", &manager.InterceptSpec{TargetHost: "127.0.0.1", LocalSocketPath: "/tmp/app.sock"}, false},
+		{"local socket with hostname", &manager.InterceptSpec{TargetHost: "localhost", LocalSocketPath: "/tmp/app.sock"}, true},
+		{"remote socket", &manager.InterceptSpec{Agent: "echo", TargetHost: "::1", RemoteSocketPath: "/var/run/app.sock"}, false},
+		{"remote socket with hostname", &manager.InterceptSpec{Agent: "echo", TargetHost: "localhost", RemoteSocketPath: "/var/run/app.sock"}, true},
+		{"relative remote socket", &manager.InterceptSpec{Agent: "echo", TargetHost: "127.0.0.1", RemoteSocketPath: "app.sock"}, true},
+		{"local-only remote socket", &manager.InterceptSpec{TargetHost: "127.0.0.1", RemoteSocketPath: "/var/run/app.sock"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateSocketPaths(tt.spec)
+			if tt.wantErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,43 @@
+package trafficmgr
+
+import (
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"k8s.io/client-go/rest"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+)
+
+// execPluginInstallHints are the install instructions for the most common exec credential plugins.
+// They are used when the kubeconfig doesn't provide an installHint of its own.
+var execPluginInstallHints = map[string]string{
+	"aws":                    "see https://docs.aws.amazon.com/cli/latest/userguide/getting-started-install.html",
+	"aws-iam-authenticator":  "see https://docs.aws.amazon.com/eks/latest/userguide/install-aws-iam-authenticator.html",
+	"gke-gcloud-auth-plugin": "run \"gcloud components install gke-gcloud-auth-plugin\"",
+	"kubelogin":              "see https://azure.github.io/kubelogin/install.html",
+}
+
+// checkExecPlugin returns an errcat.User error when the kubeconfig uses an exec credential plugin
+// that isn't installed. Without this check, the connect fails when the first request is made to
+// the cluster, with an error that doesn't tell the user what's wrong.
+func checkExecPlugin(rc *rest.Config) error {
+	ep := rc.ExecProvider
+	if ep == nil || ep.Command == "" {
+		return nil
+	}
+	if _, err := exec.LookPath(ep.Command); err == nil {
+		return nil
+	}
+	name := filepath.Base(ep.Command)
+	hint := strings.TrimSpace(ep.InstallHint)
+	if hint == "" {
+		if h, ok := execPluginInstallHints[name]; ok {
+			hint = "To install it, " + h + "."
+		} else {
+			hint = "Install it, or make sure that it's in your PATH."
+		}
+	}
+	return errcat.User.Newf("the kubeconfig uses the credential plugin %q, but it could not be found in your PATH. %s", ep.Command, hint)
+}
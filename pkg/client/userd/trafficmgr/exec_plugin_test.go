@@ -0,0 +1,36 @@
+package trafficmgr
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd/api"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+)
+
+func Test_checkExecPlugin(t *testing.T) {
+	require.NoError(t, checkExecPlugin(&rest.Config{}))
+	require.NoError(t, checkExecPlugin(&rest.Config{ExecProvider: &api.ExecConfig{Command: "go"}}))
+
+	err := checkExecPlugin(&rest.Config{ExecProvider: &api.ExecConfig{Command: "gke-gcloud-auth-plugin-missing"}})
+	require.Error(t, err)
+	assert.Equal(t, errcat.User, errcat.GetCategory(err))
+	assert.Contains(t, err.Error(), `"gke-gcloud-auth-plugin-missing"`)
+	assert.Contains(t, err.Error(), "make sure that it's in your PATH")
+
+	t.Setenv("PATH", t.TempDir())
+	err = checkExecPlugin(&rest.Config{ExecProvider: &api.ExecConfig{Command: "gke-gcloud-auth-plugin"}})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "gcloud components install gke-gcloud-auth-plugin")
+
+	err = checkExecPlugin(&rest.Config{ExecProvider: &api.ExecConfig{
+		Command:     "aws-iam-authenticator",
+		InstallHint: "Ask your admin for the installer.\n",
+	}})
+	require.Error(t, err)
+	assert.True(t, strings.HasSuffix(err.Error(), "Ask your admin for the installer."))
+}
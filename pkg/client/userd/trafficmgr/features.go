@@ -0,0 +1,27 @@
+package trafficmgr
+
+import (
+	"github.com/blang/semver"
+)
+
+// managerFeatures are the optional features that a traffic-manager may support, in the order that
+// they were introduced, along with the first traffic-manager version that supports them. Managers
+// don't advertise their features, so they are derived from the version.
+var managerFeatures = []struct {
+	name  string
+	since semver.Version
+}{
+	{name: "watch-agents-ns", since: semver.MustParse("2.5.5")},
+	{name: "agent-config-map", since: firstAgentConfigMapVersion},
+}
+
+// featuresOf returns the names of the features that a traffic-manager of the given version supports.
+func featuresOf(managerVersion semver.Version) []string {
+	var fs []string
+	for _, f := range managerFeatures {
+		if managerVersion.GE(f.since) {
+			fs = append(fs, f.name)
+		}
+	}
+	return fs
+}
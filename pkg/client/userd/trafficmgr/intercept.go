@@ -366,7 +366,16 @@ func (tm *TrafficManager) CanIntercept(c context.Context, ir *rpc.CreateIntercep
 		return nil, interceptError(common.InterceptError_ALREADY_EXISTS, errcat.User.Newf(spec.Name))
 	}
 
-	for _, iCept := range tm.getCurrentIntercepts() {
+	if err := validateSocketPaths(spec); err != nil {
+		return nil, interceptError(common.InterceptError_FAILED_TO_ESTABLISH, err)
+	}
+
+	currentIntercepts := tm.getCurrentIntercepts()
+	if mx := client.GetConfig(c).Intercept.MaxConcurrent; mx > 0 && len(currentIntercepts)+len(tm.localIntercepts) >= mx {
+		return nil, interceptError(common.InterceptError_TOO_MANY_INTERCEPTS, errcat.User.New(strconv.Itoa(mx)))
+	}
+
+	for _, iCept := range currentIntercepts {
 		if iCept.Spec.Name == spec.Name {
 			return nil, interceptError(common.InterceptError_ALREADY_EXISTS, errcat.User.Newf(spec.Name))
 		}
@@ -686,11 +695,17 @@ func (tm *TrafficManager) AddIntercept(c context.Context, ir *rpc.CreateIntercep
 			code := grpcCodes.Canceled
 			if errors.Is(err, context.DeadlineExceeded) {
 				code = grpcCodes.DeadlineExceeded
+				if pr := podProblemsResult(c, spec, err); pr != nil {
+					return pr, nil
+				}
 			}
 			err = grpcStatus.Error(code, err.Error())
 			return nil, err
 		case wr := <-waitCh:
 			if wr.err != nil {
+				if pr := podProblemsResult(c, spec, wr.err); pr != nil {
+					return pr, nil
+				}
 				return interceptError(common.InterceptError_FAILED_TO_ESTABLISH, errcat.User.New(wr.err)), nil
 			}
 			ii = wr.intercept
@@ -893,6 +908,35 @@ func (tm *TrafficManager) removeIntercept(c context.Context, ii *manager.Interce
 	return err
 }
 
+// SetInterceptPaused pauses or resumes the intercept with the given name. The traffic of a paused intercept
+// is routed to the intercepted container, but the port forwards and mounts are kept intact so that the
+// intercept can be resumed instantly. A grpc NotFound error is returned when no such intercept exists.
+func (tm *TrafficManager) SetInterceptPaused(c context.Context, name string, paused bool) error {
+	if _, ok := tm.localIntercepts[name]; ok {
+		return errcat.User.Newf("intercept %s is a local-only intercept and cannot be paused", name)
+	}
+	var ii *manager.InterceptInfo
+	for _, cept := range tm.getCurrentIntercepts() {
+		if cept.Spec.Name == name {
+			ii = cept
+			break
+		}
+	}
+	if ii == nil {
+		return grpcStatus.Errorf(grpcCodes.NotFound, "intercept %s not found", name)
+	}
+	if ii.Paused == paused {
+		return nil
+	}
+	dlog.Debugf(c, "telling manager to set paused=%t for intercept %s", paused, name)
+	_, err := tm.managerClient.UpdateIntercept(c, &manager.UpdateInterceptRequest{
+		Session:     tm.session(),
+		Name:        name,
+		PauseAction: &manager.UpdateInterceptRequest_SetPaused{SetPaused: paused},
+	})
+	return err
+}
+
 // AddInterceptor associates the given interceptId with a pid of a running process. This ensures that
 // the running process will be signalled when the intercept is removed
 func (tm *TrafficManager) AddInterceptor(s string, i int) error {
@@ -0,0 +1,93 @@
+package trafficmgr
+
+import (
+	"context"
+	"time"
+
+	grpcCodes "google.golang.org/grpc/codes"
+	grpcStatus "google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/datawire/dlib/dlog"
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/tunnel"
+)
+
+// activityReporter is implemented by the handlers in the dialers pool.
+type activityReporter interface {
+	LastActivity() time.Time
+}
+
+// InterceptDetails returns the intercepts of this session together with details about their health. The
+// active connections and the last activity are derived from the connections to each intercept's local port.
+func (tm *TrafficManager) InterceptDetails() []*rpc.InterceptDetails {
+	intercepts := tm.getCurrentIntercepts()
+	details := make([]*rpc.InterceptDetails, len(intercepts))
+	for i, ii := range intercepts {
+		spec := ii.Spec
+		d := &rpc.InterceptDetails{
+			Name:        spec.Name,
+			Workload:    spec.Agent,
+			Namespace:   spec.Namespace,
+			LocalPort:   spec.TargetPort,
+			Disposition: ii.Disposition,
+			Message:     ii.Message,
+			Paused:      ii.Paused,
+		}
+		var lastActivity time.Time
+		tm.rangeInterceptConns(spec, func(_ tunnel.ConnID, h tunnel.Handler) {
+			d.ActiveConnections++
+			if ar, ok := h.(activityReporter); ok {
+				if la := ar.LastActivity(); la.After(lastActivity) {
+					lastActivity = la
+				}
+			}
+		})
+		if !lastActivity.IsZero() {
+			d.LastActivity = timestamppb.New(lastActivity)
+		}
+		details[i] = d
+	}
+	return details
+}
+
+// TerminateIntercept removes the intercept with the given name and closes its active connections. The
+// reason is logged. A grpc NotFound error is returned when no such intercept exists.
+func (tm *TrafficManager) TerminateIntercept(c context.Context, name, reason string) error {
+	if ns, ok := tm.localIntercepts[name]; ok {
+		dlog.Infof(c, "Terminating intercept %s: %s", name, reason)
+		return tm.RemoveLocalOnlyIntercept(c, name, ns)
+	}
+	var ii *manager.InterceptInfo
+	for _, cept := range tm.getCurrentIntercepts() {
+		if cept.Spec.Name == name {
+			ii = cept
+			break
+		}
+	}
+	if ii == nil {
+		return grpcStatus.Errorf(grpcCodes.NotFound, "intercept %s not found", name)
+	}
+	dlog.Infof(c, "Terminating intercept %s: %s", name, reason)
+	if err := tm.removeIntercept(c, ii); err != nil {
+		return err
+	}
+	tm.rangeInterceptConns(ii.Spec, func(_ tunnel.ConnID, h tunnel.Handler) {
+		h.Stop(c)
+	})
+	return nil
+}
+
+// rangeInterceptConns calls f for each active connection to the local port of the given intercept.
+func (tm *TrafficManager) rangeInterceptConns(spec *manager.InterceptSpec, f func(tunnel.ConnID, tunnel.Handler)) {
+	if tm.dialers == nil {
+		return
+	}
+	tm.dialers.Range(func(id tunnel.ConnID, h tunnel.Handler) bool {
+		if int32(id.DestinationPort()) == spec.TargetPort {
+			f(id, h)
+		}
+		return true
+	})
+}
@@ -0,0 +1,119 @@
+package trafficmgr
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	grpcCodes "google.golang.org/grpc/codes"
+	grpcStatus "google.golang.org/grpc/status"
+	empty "google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/ipproto"
+	"github.com/telepresenceio/telepresence/v2/pkg/tunnel"
+)
+
+type fakeConn struct {
+	lastActivity time.Time
+	stopped      bool
+}
+
+func (f *fakeConn) Start(context.Context) {}
+
+func (f *fakeConn) Stop(context.Context) {
+	f.stopped = true
+}
+
+func (f *fakeConn) LastActivity() time.Time {
+	return f.lastActivity
+}
+
+type fakeManagerClient struct {
+	manager.ManagerClient
+	removed []string
+}
+
+func (f *fakeManagerClient) RemoveIntercept(_ context.Context, rr *manager.RemoveInterceptRequest2, _ ...grpc.CallOption) (*empty.Empty, error) {
+	f.removed = append(f.removed, rr.Name)
+	return &empty.Empty{}, nil
+}
+
+func newDetailsTestManager(t *testing.T) (*TrafficManager, *fakeManagerClient, map[int32]*fakeConn) {
+	ctx := dlog.NewTestContext(t, false)
+	mc := &fakeManagerClient{}
+	tm := &TrafficManager{
+		managerClient:       mc,
+		sessionInfo:         &manager.SessionInfo{SessionId: "session-1"},
+		dialers:             tunnel.NewPool(),
+		currentInterceptors: map[string]int{},
+		currentIntercepts: []*manager.InterceptInfo{
+			{
+				Id:          "id-1",
+				Spec:        &manager.InterceptSpec{Name: "echo", Agent: "echo", Namespace: "default", TargetPort: 8080},
+				Disposition: manager.InterceptDispositionType_ACTIVE,
+			},
+			{
+				Id:          "id-2",
+				Spec:        &manager.InterceptSpec{Name: "quote", Agent: "quote", Namespace: "other", TargetPort: 8081},
+				Disposition: manager.InterceptDispositionType_ACTIVE,
+				Paused:      true,
+			},
+		},
+	}
+	now := time.Now()
+	conns := map[int32]*fakeConn{}
+	for i, port := range []uint16{8080, 8080, 9000} {
+		fc := &fakeConn{lastActivity: now.Add(time.Duration(i) * time.Second)}
+		id := tunnel.NewConnID(ipproto.TCP, net.IP{10, 0, 0, 1}, net.IP{127, 0, 0, 1}, uint16(4711+i), port)
+		_, _, err := tm.dialers.GetOrCreate(ctx, id, func(context.Context, func()) (tunnel.Handler, error) {
+			return fc, nil
+		})
+		require.NoError(t, err)
+		conns[int32(i)] = fc
+	}
+	return tm, mc, conns
+}
+
+func TestTrafficManager_InterceptDetails(t *testing.T) {
+	tm, _, conns := newDetailsTestManager(t)
+	details := tm.InterceptDetails()
+	require.Len(t, details, 2)
+
+	echo := details[0]
+	assert.Equal(t, "echo", echo.Name)
+	assert.Equal(t, "echo", echo.Workload)
+	assert.Equal(t, "default", echo.Namespace)
+	assert.Equal(t, int32(8080), echo.LocalPort)
+	assert.Equal(t, manager.InterceptDispositionType_ACTIVE, echo.Disposition)
+	assert.False(t, echo.Paused)
+	assert.Equal(t, int32(2), echo.ActiveConnections)
+	require.NotNil(t, echo.LastActivity)
+	assert.True(t, conns[1].lastActivity.Equal(echo.LastActivity.AsTime()))
+
+	quote := details[1]
+	assert.Equal(t, "quote", quote.Name)
+	assert.True(t, quote.Paused)
+	assert.Equal(t, int32(0), quote.ActiveConnections)
+	assert.Nil(t, quote.LastActivity)
+}
+
+func TestTrafficManager_TerminateIntercept(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	tm, mc, conns := newDetailsTestManager(t)
+
+	err := tm.TerminateIntercept(ctx, "missing", "testing")
+	assert.Equal(t, grpcCodes.NotFound, grpcStatus.Code(err))
+	assert.Empty(t, mc.removed)
+
+	require.NoError(t, tm.TerminateIntercept(ctx, "echo", "testing"))
+	assert.Equal(t, []string{"echo"}, mc.removed)
+	assert.True(t, conns[0].stopped)
+	assert.True(t, conns[1].stopped)
+	assert.False(t, conns[2].stopped, "connections to other ports must not be stopped")
+}
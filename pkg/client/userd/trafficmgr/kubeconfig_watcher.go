@@ -0,0 +1,98 @@
+package trafficmgr
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/userd/k8s"
+)
+
+// KubeconfigChangedErr is returned from Run when the cluster or the credentials of the active
+// kubeconfig context have changed, e.g. because a token was rotated. The session must then be
+// recreated so that the new credentials are used.
+var KubeconfigChangedErr = errors.New("kubeconfig changed")
+
+// kubeconfigReloadDelay is the time that the watcher waits after a kubeconfig file was modified
+// before it's re-read, in case there are more modifications.
+const kubeconfigReloadDelay = 50 * time.Millisecond
+
+func (tm *TrafficManager) kubeconfigWatcher(c context.Context) error {
+	return watchKubeconfig(c, tm.Config, nil)
+}
+
+// watchKubeconfig watches the kubeconfig files of the given configuration and returns the
+// KubeconfigChangedErr when a modification affects its context. Modifications of other contexts
+// are ignored. The ready function, unless nil, is called once the files are being watched.
+func watchKubeconfig(c context.Context, kf *k8s.Config, ready func()) error {
+	files := kf.KubeconfigFiles()
+	if len(files) == 0 {
+		return nil
+	}
+	fp, err := kf.ActiveContextFingerprint()
+	if err != nil {
+		dlog.Errorf(c, "unable to read kubeconfig, credential changes will not be detected: %v", err)
+		return nil
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		dlog.Errorf(c, "unable to watch kubeconfig, credential changes will not be detected: %v", err)
+		return nil
+	}
+	defer watcher.Close()
+
+	// The directories are watched because editors and tools such as kubectl typically replace the
+	// file by renaming a new one, and a watcher that follows the inode won't see that.
+	watched := make(map[string]struct{}, len(files))
+	for i, file := range files {
+		files[i] = filepath.Clean(file)
+		dir := filepath.Dir(files[i])
+		if _, ok := watched[dir]; ok {
+			continue
+		}
+		if err := watcher.Add(dir); err != nil {
+			dlog.Debugf(c, "unable to watch %s: %v", dir, err)
+			continue
+		}
+		watched[dir] = struct{}{}
+	}
+	if ready != nil {
+		ready()
+	}
+
+	var reload <-chan time.Time
+	for {
+		select {
+		case <-c.Done():
+			return nil
+		case err := <-watcher.Errors:
+			dlog.Error(c, err)
+		case event := <-watcher.Events:
+			if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename|fsnotify.Remove) == 0 {
+				continue
+			}
+			for _, file := range files {
+				if filepath.Clean(event.Name) == file {
+					reload = time.After(kubeconfigReloadDelay)
+					break
+				}
+			}
+		case <-reload:
+			reload = nil
+			nfp, err := kf.ActiveContextFingerprint()
+			if err != nil {
+				// The file may be in the middle of being rewritten. The next event will tell.
+				dlog.Debugf(c, "unable to read modified kubeconfig: %v", err)
+				continue
+			}
+			if nfp != fp {
+				dlog.Infof(c, "Cluster or credentials of kubeconfig context %q changed, reconnecting", kf.Context)
+				return KubeconfigChangedErr
+			}
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package trafficmgr
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/userd/k8s"
+)
+
+const kubeconfigTemplate = `apiVersion: v1
+kind: Config
+clusters:
+- name: a
+  cluster:
+    server: https://a.example.com
+- name: b
+  cluster:
+    server: https://b.example.com
+contexts:
+- name: ctx-a
+  context:
+    cluster: a
+    user: alice
+- name: ctx-b
+  context:
+    cluster: b
+    user: bob
+current-context: ctx-a
+users:
+- name: alice
+  user:
+    token: %s
+- name: bob
+  user:
+    token: %s
+`
+
+func writeKubeconfig(t *testing.T, file, aliceToken, bobToken string) {
+	// Replace the file the way that kubectl does, by renaming a new file.
+	tmp := file + ".tmp"
+	require.NoError(t, os.WriteFile(tmp, []byte(fmt.Sprintf(kubeconfigTemplate, aliceToken, bobToken)), 0o600))
+	require.NoError(t, os.Rename(tmp, file))
+}
+
+func Test_watchKubeconfig(t *testing.T) {
+	t.Setenv("KUBECONFIG", "")
+	ctx := client.WithEnv(dlog.NewTestContext(t, false), &client.Env{})
+	file := filepath.Join(t.TempDir(), "config")
+	writeKubeconfig(t, file, "alice-1", "bob-1")
+	kf, err := k8s.NewConfig(ctx, map[string]string{"KUBECONFIG": file})
+	require.NoError(t, err)
+	require.Equal(t, "ctx-a", kf.Context)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	done := make(chan error, 1)
+	ready := make(chan struct{})
+	go func() { done <- watchKubeconfig(ctx, kf, func() { close(ready) }) }()
+	select {
+	case <-ready:
+	case err := <-done:
+		t.Fatalf("watcher ended before it was ready: %v", err)
+	}
+
+	// A change of another context's credentials doesn't affect the session.
+	writeKubeconfig(t, file, "alice-1", "bob-2")
+	select {
+	case err := <-done:
+		t.Fatalf("watcher ended when another context changed: %v", err)
+	case <-time.After(4 * kubeconfigReloadDelay):
+	}
+
+	// A rotated token of the active context makes the session reconnect.
+	writeKubeconfig(t, file, "alice-2", "bob-2")
+	select {
+	case err := <-done:
+		require.ErrorIs(t, err, KubeconfigChangedErr)
+	case <-time.After(5 * time.Second):
+		t.Fatal("watcher didn't detect the credential change")
+	}
+}
@@ -0,0 +1,55 @@
+package trafficmgr
+
+import (
+	"context"
+	"net"
+	"net/url"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/vif/routing"
+)
+
+// detectMTU returns the MTU of the network interface that is used when communicating with the
+// given cluster server. The interface of the default route is used when the server's address
+// cannot be routed. Zero is returned when the MTU cannot be detected.
+func detectMTU(ctx context.Context, server string) int {
+	var route routing.Route
+	ip, err := serverIP(ctx, server)
+	if err == nil {
+		mask := net.CIDRMask(128, 128)
+		if ip4 := ip.To4(); ip4 != nil {
+			ip = ip4
+			mask = net.CIDRMask(32, 32)
+		}
+		route, err = routing.GetRoute(ctx, &net.IPNet{IP: ip, Mask: mask})
+	}
+	if err != nil {
+		dlog.Debugf(ctx, "unable to find the route to %s, using the default route: %v", server, err)
+		if route, err = routing.DefaultRoute(ctx); err != nil {
+			dlog.Warnf(ctx, "unable to detect the MTU: %v", err)
+			return 0
+		}
+	}
+	if route.Interface == nil {
+		return 0
+	}
+	dlog.Debugf(ctx, "MTU of interface %s is %d", route.Interface.Name, route.Interface.MTU)
+	return route.Interface.MTU
+}
+
+// serverIP returns the IP of the host in the given cluster server URL.
+func serverIP(ctx context.Context, server string) (net.IP, error) {
+	u, err := url.Parse(server)
+	if err != nil {
+		return nil, err
+	}
+	host := u.Hostname()
+	if ip := net.ParseIP(host); ip != nil {
+		return ip, nil
+	}
+	ips, err := net.DefaultResolver.LookupIP(ctx, "ip", host)
+	if err != nil {
+		return nil, err
+	}
+	return ips[0], nil
+}
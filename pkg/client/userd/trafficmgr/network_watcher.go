@@ -0,0 +1,121 @@
+package trafficmgr
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	empty "google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/a8rcloud"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+)
+
+var (
+	// networkCheckInterval is the interval between checks for changes in the local network configuration.
+	networkCheckInterval = 3 * time.Second
+
+	// currentNetworkFingerprint is the function that networkWatcher uses to read the fingerprint.
+	currentNetworkFingerprint = networkFingerprint
+)
+
+// networkFingerprint returns a string that identifies the local network interfaces that are up, and
+// their addresses. The fingerprint changes when the laptop switches networks or wakes up from sleep
+// with a new lease.
+func networkFingerprint() (string, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return "", err
+	}
+	var entries []string
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			entries = append(entries, iface.Name+"="+addr.String())
+		}
+	}
+	sort.Strings(entries)
+	return strings.Join(entries, ","), nil
+}
+
+// networkWatcher detects changes in the local network configuration and re-validates the connections
+// to the root daemon and the traffic-manager when that happens, so that the session survives a network
+// switch instead of hanging until the next failed call.
+func (tm *TrafficManager) networkWatcher(c context.Context) error {
+	fp, err := currentNetworkFingerprint()
+	if err != nil {
+		dlog.Errorf(c, "unable to read network interfaces, network changes will not be detected: %v", err)
+		return nil
+	}
+	ticker := time.NewTicker(networkCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.Done():
+			return nil
+		case <-ticker.C:
+		}
+		nfp, err := currentNetworkFingerprint()
+		if err != nil || nfp == fp {
+			continue
+		}
+		fp = nfp
+		dlog.Info(c, "Local network configuration changed, validating session")
+		if err = tm.validateSession(c); err != nil {
+			if gErr, ok := status.FromError(err); ok && gErr.Code() == codes.NotFound {
+				// Session has expired. We need to cancel the owner session and reconnect
+				return SessionExpiredErr
+			}
+			dlog.Errorf(c, "session validation after network change failed: %v", err)
+		}
+	}
+}
+
+// validateSession ensures that the traffic-manager still knows about this session and that the root
+// daemon is still connected using it.
+func (tm *TrafficManager) validateSession(c context.Context) error {
+	// The gRPC connection may be waiting in a long backoff after the network went down. Make
+	// it reconnect immediately.
+	if tm.managerConn != nil {
+		tm.managerConn.ResetConnectBackoff()
+	}
+
+	tc, cancel := client.GetConfig(c).Timeouts.TimeoutContext(c, client.TimeoutTrafficManagerAPI)
+	defer cancel()
+	_, err := tm.managerClient.Remain(tc, &manager.RemainRequest{
+		Session: tm.session(),
+		ApiKey: func() string {
+			tok, _ := tm.getCloudAPIKey(tc, a8rcloud.KeyDescTrafficManager, false)
+			return tok
+		}(),
+	})
+	if err != nil {
+		return client.CheckTimeout(tc, err)
+	}
+
+	ds, err := tm.rootDaemon.Status(tc, &empty.Empty{})
+	if err != nil {
+		return client.CheckTimeout(tc, fmt.Errorf("root daemon status: %w", err))
+	}
+	if oc := ds.OutboundConfig; oc == nil || oc.Session == nil || oc.Session.SessionId != tm.session().SessionId {
+		dlog.Info(c, "Root daemon lost the session, reconnecting it")
+		if _, err = tm.rootDaemon.Connect(tc, tm.getOutboundInfo(c)); err != nil {
+			return client.CheckTimeout(tc, fmt.Errorf("root daemon connect: %w", err))
+		}
+	}
+	dlog.Info(c, "Session is valid after network change")
+	return nil
+}
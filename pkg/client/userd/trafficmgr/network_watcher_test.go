@@ -0,0 +1,126 @@
+package trafficmgr
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	empty "google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/rpc/v2/daemon"
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/userd/k8s"
+)
+
+type validateManagerClient struct {
+	manager.ManagerClient
+	remainCh chan *manager.RemainRequest
+	err      error
+}
+
+func (m *validateManagerClient) Remain(_ context.Context, rr *manager.RemainRequest, _ ...grpc.CallOption) (*empty.Empty, error) {
+	m.remainCh <- rr
+	return &empty.Empty{}, m.err
+}
+
+type sessionDaemonClient struct {
+	daemon.DaemonClient
+	sessionID string
+	connectCh chan *daemon.OutboundInfo
+}
+
+func (d *sessionDaemonClient) Status(context.Context, *empty.Empty, ...grpc.CallOption) (*daemon.DaemonStatus, error) {
+	return &daemon.DaemonStatus{OutboundConfig: &daemon.OutboundInfo{Session: &manager.SessionInfo{SessionId: d.sessionID}}}, nil
+}
+
+func (d *sessionDaemonClient) Connect(_ context.Context, oi *daemon.OutboundInfo, _ ...grpc.CallOption) (*daemon.DaemonStatus, error) {
+	d.connectCh <- oi
+	return &daemon.DaemonStatus{OutboundConfig: oi}, nil
+}
+
+func TestTrafficManager_networkWatcher(t *testing.T) {
+	defer func(i time.Duration, f func() (string, error)) {
+		networkCheckInterval, currentNetworkFingerprint = i, f
+	}(networkCheckInterval, currentNetworkFingerprint)
+	networkCheckInterval = time.Millisecond
+
+	const sessionID = "session-1"
+	tests := []struct {
+		name          string
+		remainErr     error
+		daemonSession string
+		wantErr       error
+		wantConnect   bool
+	}{
+		{"remain", nil, sessionID, nil, false},
+		{"session expired", status.Error(codes.NotFound, "no such session"), sessionID, SessionExpiredErr, false},
+		{"root daemon lost session", nil, "session-0", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := client.GetDefaultConfig()
+			ctx, cancel := context.WithCancel(client.WithConfig(dlog.NewTestContext(t, false), &cfg))
+			defer cancel()
+
+			mc := &validateManagerClient{remainCh: make(chan *manager.RemainRequest, 10), err: tt.remainErr}
+			dc := &sessionDaemonClient{sessionID: tt.daemonSession, connectCh: make(chan *daemon.OutboundInfo, 10)}
+			tm := &TrafficManager{
+				installer:      &installer{Cluster: &k8s.Cluster{Config: &k8s.Config{Server: "https://10.0.0.1"}}},
+				getCloudAPIKey: func(context.Context, string, bool) (string, error) { return "", nil },
+				managerClient:  mc,
+				rootDaemon:     dc,
+				sessionInfo:    &manager.SessionInfo{SessionId: sessionID},
+			}
+
+			// The fingerprint changes once, after the watcher has read the initial fingerprint.
+			var calls int32
+			currentNetworkFingerprint = func() (string, error) {
+				if atomic.AddInt32(&calls, 1) < 3 {
+					return "eth0=10.0.0.2/24", nil
+				}
+				return "eth0=192.168.1.2/24", nil
+			}
+			errCh := make(chan error, 1)
+			go func() {
+				errCh <- tm.networkWatcher(ctx)
+			}()
+
+			select {
+			case rr := <-mc.remainCh:
+				assert.Equal(t, sessionID, rr.Session.SessionId)
+			case <-time.After(5 * time.Second):
+				t.Fatal("no call to Remain")
+			}
+			if tt.wantConnect {
+				select {
+				case oi := <-dc.connectCh:
+					assert.Equal(t, sessionID, oi.Session.SessionId)
+				case <-time.After(5 * time.Second):
+					t.Fatal("no call to Connect")
+				}
+			}
+			if tt.wantErr == nil {
+				// The watcher continues until it's cancelled.
+				cancel()
+			}
+			select {
+			case err := <-errCh:
+				require.ErrorIs(t, err, tt.wantErr)
+			case <-time.After(5 * time.Second):
+				t.Fatal("networkWatcher didn't return")
+			}
+
+			// The session is validated only once, because the fingerprint changed only once.
+			assert.Empty(t, mc.remainCh)
+			assert.Empty(t, dc.connectCh)
+		})
+	}
+}
@@ -0,0 +1,32 @@
+package trafficmgr
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"google.golang.org/protobuf/types/known/durationpb"
+	empty "google.golang.org/protobuf/types/known/emptypb"
+
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/v2/pkg/k8sapi"
+)
+
+// Ping measures the round-trip time of a lightweight request to the traffic-manager, and of a
+// request for the version of the cluster's API server.
+func (tm *TrafficManager) Ping(c context.Context) (*rpc.PingResult, error) {
+	start := time.Now()
+	if _, err := tm.managerClient.Version(c, &empty.Empty{}); err != nil {
+		return nil, fmt.Errorf("unable to ping the traffic-manager: %w", err)
+	}
+	managerRTT := time.Since(start)
+
+	start = time.Now()
+	if _, err := k8sapi.GetK8sInterface(c).Discovery().ServerVersion(); err != nil {
+		return nil, fmt.Errorf("unable to ping the API server: %w", err)
+	}
+	return &rpc.PingResult{
+		ManagerRtt:   durationpb.New(managerRTT),
+		ApiServerRtt: durationpb.New(time.Since(start)),
+	}, nil
+}
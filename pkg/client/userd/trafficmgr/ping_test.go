@@ -0,0 +1,41 @@
+package trafficmgr
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	empty "google.golang.org/protobuf/types/known/emptypb"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/k8sapi"
+)
+
+type slowManagerClient struct {
+	manager.ManagerClient
+	delay time.Duration
+	err   error
+}
+
+func (m *slowManagerClient) Version(context.Context, *empty.Empty, ...grpc.CallOption) (*manager.VersionInfo2, error) {
+	time.Sleep(m.delay)
+	return &manager.VersionInfo2{}, m.err
+}
+
+func TestTrafficManager_Ping(t *testing.T) {
+	ctx := k8sapi.WithK8sInterface(dlog.NewTestContext(t, false), fake.NewSimpleClientset())
+	tm := &TrafficManager{managerClient: &slowManagerClient{delay: 20 * time.Millisecond}}
+	result, err := tm.Ping(ctx)
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, result.ManagerRtt.AsDuration(), 20*time.Millisecond)
+	require.NotNil(t, result.ApiServerRtt)
+
+	tm.managerClient = &slowManagerClient{err: errors.New("connection refused")}
+	_, err = tm.Ping(ctx)
+	require.ErrorContains(t, err, "traffic-manager")
+}
@@ -0,0 +1,140 @@
+package trafficmgr
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/datawire/dlib/dcontext"
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/rpc/v2/common"
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+	"github.com/telepresenceio/telepresence/v2/pkg/k8sapi"
+)
+
+// maxPodProblems is the maximum number of problems that are reported for a workload.
+const maxPodProblems = 3
+
+// podProblem is a problem with a pod, such as a container that cannot be started, or a warning event.
+type podProblem struct {
+	// reason is the Kubernetes reason, e.g. "ImagePullBackOff"
+	reason string
+
+	// description is a human readable description of the problem
+	description string
+}
+
+// podProblemsTimeout limits the time spent on finding pod problems. The context of a failed
+// intercept is often done already, so a new one is used.
+const podProblemsTimeout = 5 * time.Second
+
+// benignWaitingReasons are reasons for a container to wait that don't indicate a problem.
+var benignWaitingReasons = map[string]bool{
+	"ContainerCreating": true,
+	"PodInitializing":   true,
+}
+
+// workloadPodProblems returns the problems of the pods of the given workload, as found in the
+// statuses of their containers and in their warning events. Problems that can't be obtained are
+// logged and ignored, since they're only used to explain other errors.
+func workloadPodProblems(c context.Context, name, namespace, workloadKind string) []podProblem {
+	wl, err := k8sapi.GetWorkload(c, name, namespace, workloadKind)
+	if err != nil {
+		dlog.Debugf(c, "unable to get workload %s.%s: %v", name, namespace, err)
+		return nil
+	}
+	sel, err := wl.Selector()
+	if err != nil {
+		dlog.Debugf(c, "unable to get selector of workload %s.%s: %v", name, namespace, err)
+		return nil
+	}
+	ki := k8sapi.GetK8sInterface(c)
+	pods, err := ki.CoreV1().Pods(namespace).List(c, meta.ListOptions{LabelSelector: sel.String()})
+	if err != nil {
+		dlog.Debugf(c, "unable to list pods of workload %s.%s: %v", name, namespace, err)
+		return nil
+	}
+
+	var problems []podProblem
+	podNames := make(map[string]bool, len(pods.Items))
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		podNames[pod.Name] = true
+		statuses := make([]core.ContainerStatus, 0, len(pod.Status.InitContainerStatuses)+len(pod.Status.ContainerStatuses))
+		statuses = append(statuses, pod.Status.InitContainerStatuses...)
+		statuses = append(statuses, pod.Status.ContainerStatuses...)
+		for j := range statuses {
+			cs := &statuses[j]
+			if w := cs.State.Waiting; w != nil && w.Reason != "" && !benignWaitingReasons[w.Reason] {
+				problems = appendProblem(problems, w.Reason, pod.Name, cs.Name, w.Message)
+			}
+		}
+	}
+
+	events, err := ki.CoreV1().Events(namespace).List(c, meta.ListOptions{FieldSelector: "type=Warning,involvedObject.kind=Pod"})
+	if err != nil {
+		dlog.Debugf(c, "unable to list events in namespace %s: %v", namespace, err)
+		return problems
+	}
+	items := events.Items
+	sort.Slice(items, func(i, j int) bool { return items[j].LastTimestamp.Before(&items[i].LastTimestamp) })
+	for i := range items {
+		ev := &items[i]
+		if ev.Type == core.EventTypeWarning && ev.InvolvedObject.Kind == "Pod" && podNames[ev.InvolvedObject.Name] {
+			problems = appendProblem(problems, ev.Reason, ev.InvolvedObject.Name, "", ev.Message)
+		}
+	}
+	return problems
+}
+
+// appendProblem appends a problem unless a problem with the same reason has been appended already,
+// or the maximum number of problems has been reached.
+func appendProblem(problems []podProblem, reason, podName, containerName, message string) []podProblem {
+	if len(problems) >= maxPodProblems {
+		return problems
+	}
+	for _, p := range problems {
+		if p.reason == reason {
+			return problems
+		}
+	}
+	var sb strings.Builder
+	sb.WriteString(reason)
+	if containerName != "" {
+		fmt.Fprintf(&sb, " on %s", containerName)
+	}
+	fmt.Fprintf(&sb, " in pod %s", podName)
+	if message != "" {
+		fmt.Fprintf(&sb, ": %s", message)
+	}
+	return append(problems, podProblem{reason: reason, description: sb.String()})
+}
+
+// podProblemsResult returns a FAILED_TO_ESTABLISH result with the given error extended with the
+// problems of the pods of the intercepted workload, or nil when no such problems were found.
+func podProblemsResult(c context.Context, spec *manager.InterceptSpec, err error) *rpc.InterceptResult {
+	c, cancel := context.WithTimeout(dcontext.WithoutCancel(c), podProblemsTimeout)
+	defer cancel()
+	problems := workloadPodProblems(c, spec.Agent, spec.Namespace, spec.WorkloadKind)
+	if len(problems) == 0 {
+		return nil
+	}
+	descs := make([]string, len(problems))
+	reasons := make([]string, len(problems))
+	for i, p := range problems {
+		descs[i] = p.description
+		reasons[i] = p.reason
+	}
+	result := interceptError(common.InterceptError_FAILED_TO_ESTABLISH,
+		errcat.User.Newf("%v: %s", err, strings.Join(descs, "; ")))
+	result.WorkloadKind = spec.WorkloadKind
+	result.PodProblemReasons = reasons
+	return result
+}
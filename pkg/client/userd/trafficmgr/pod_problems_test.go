@@ -0,0 +1,88 @@
+package trafficmgr
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apps "k8s.io/api/apps/v1"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/rpc/v2/common"
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+	"github.com/telepresenceio/telepresence/v2/pkg/k8sapi"
+)
+
+func Test_podProblemsResult(t *testing.T) {
+	labels := map[string]string{"app": "echo"}
+	waiting := func(name, reason, msg string) core.ContainerStatus {
+		return core.ContainerStatus{
+			Name:  name,
+			State: core.ContainerState{Waiting: &core.ContainerStateWaiting{Reason: reason, Message: msg}},
+		}
+	}
+	ctx := dlog.NewTestContext(t, false)
+	cs := fake.NewSimpleClientset(
+		&apps.Deployment{
+			ObjectMeta: meta.ObjectMeta{Name: "echo", Namespace: "default"},
+			Spec:       apps.DeploymentSpec{Selector: &meta.LabelSelector{MatchLabels: labels}},
+		},
+		&core.Pod{
+			ObjectMeta: meta.ObjectMeta{Name: "echo-1", Namespace: "default", Labels: labels},
+			Status: core.PodStatus{
+				InitContainerStatuses: []core.ContainerStatus{waiting("tel-agent-init", "PodInitializing", "")},
+				ContainerStatuses: []core.ContainerStatus{
+					waiting("echo", "ContainerCreating", ""),
+					waiting("traffic-agent", "ImagePullBackOff", `Back-off pulling image "tel2:bad"`),
+				},
+			},
+		},
+		&core.Pod{
+			ObjectMeta: meta.ObjectMeta{Name: "other-1", Namespace: "default", Labels: map[string]string{"app": "other"}},
+			Status:     core.PodStatus{ContainerStatuses: []core.ContainerStatus{waiting("other", "CrashLoopBackOff", "")}},
+		},
+		&core.Event{
+			ObjectMeta:     meta.ObjectMeta{Name: "echo-1.1", Namespace: "default"},
+			InvolvedObject: core.ObjectReference{Kind: "Pod", Name: "echo-1"},
+			Type:           core.EventTypeWarning,
+			Reason:         "FailedMount",
+			Message:        "secret not found",
+		},
+		&core.Event{
+			ObjectMeta:     meta.ObjectMeta{Name: "echo-1.2", Namespace: "default"},
+			InvolvedObject: core.ObjectReference{Kind: "Pod", Name: "echo-1"},
+			Type:           core.EventTypeNormal,
+			Reason:         "Scheduled",
+		},
+	)
+
+	// The fake clientset ignores field selectors, so record the one that is used.
+	var eventSelector string
+	cs.PrependReactor("list", "events", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		eventSelector = action.(k8stesting.ListAction).GetListRestrictions().Fields.String()
+		return false, nil, nil
+	})
+	ctx = k8sapi.WithK8sInterface(ctx, cs)
+
+	spec := &manager.InterceptSpec{Agent: "echo", Namespace: "default", WorkloadKind: "Deployment"}
+	result := podProblemsResult(ctx, spec, errors.New("request timed out"))
+	require.NotNil(t, result)
+	assert.Equal(t, common.InterceptError_FAILED_TO_ESTABLISH, result.Error)
+	assert.Equal(t, int32(errcat.User), result.ErrorCategory)
+	assert.Equal(t, "Deployment", result.WorkloadKind)
+	assert.Equal(t, []string{"ImagePullBackOff", "FailedMount"}, result.PodProblemReasons)
+	assert.Equal(t, `request timed out: ImagePullBackOff on traffic-agent in pod echo-1: Back-off pulling image "tel2:bad"; `+
+		`FailedMount in pod echo-1: secret not found`, result.ErrorText)
+	assert.Equal(t, "involvedObject.kind=Pod,type=Warning", eventSelector)
+
+	// No result when there are no problems to report.
+	spec.Agent = "missing"
+	assert.Nil(t, podProblemsResult(ctx, spec, errors.New("request timed out")))
+}
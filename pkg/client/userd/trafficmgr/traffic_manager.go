@@ -47,6 +47,7 @@ import (
 	"github.com/telepresenceio/telepresence/v2/pkg/k8sapi"
 	"github.com/telepresenceio/telepresence/v2/pkg/matcher"
 	"github.com/telepresenceio/telepresence/v2/pkg/restapi"
+	"github.com/telepresenceio/telepresence/v2/pkg/tunnel"
 )
 
 // A SessionService represents a service that should be started together with each daemon session.
@@ -71,8 +72,12 @@ type Session interface {
 	InterceptsForWorkload(string, string) []*manager.InterceptSpec
 	Status(context.Context) *rpc.ConnectInfo
 	IngressInfos(c context.Context) ([]*manager.IngressInfo, error)
+	DetectIngressInfos(c context.Context, namespaces []string) ([]*manager.IngressInfo, *rpc.IngressDiagnostics, error)
 	ClearIntercepts(context.Context) error
 	RemoveIntercept(context.Context, string) error
+	SetInterceptPaused(context.Context, string, bool) error
+	TerminateIntercept(context.Context, string, string) error
+	InterceptDetails() []*rpc.InterceptDetails
 	Run(context.Context) error
 	Uninstall(context.Context, *rpc.UninstallRequest) (*rpc.UninstallResult, error)
 	UpdateStatus(context.Context, *rpc.ConnectRequest) *rpc.ConnectInfo
@@ -80,17 +85,24 @@ type Session interface {
 	WithK8sInterface(context.Context) context.Context
 	WorkloadInfoSnapshot(context.Context, []string, rpc.ListRequest_Filter, bool) (*rpc.WorkloadInfoSnapshot, error)
 	ManagerClient() manager.ManagerClient
+	Ping(context.Context) (*rpc.PingResult, error)
 	GetCurrentNamespaces(forClientAccess bool) []string
 	ActualNamespace(string) string
 	RemainWithToken(context.Context) error
+	ReconnectTrafficManager(context.Context) error
 	AddNamespaceListener(k8s.NamespaceListener)
 	GatherLogs(context.Context, *connector.LogsRequest) (*connector.LogsResponse, error)
+	SetDNSSuffixes(ctx context.Context, excludes, includes []string) error
+	RedactedFlagMap() map[string]string
 }
 
 type Service interface {
 	RootDaemonClient(context.Context) (daemon.DaemonClient, error)
 	SetManagerClient(manager.ManagerClient, ...grpc.CallOption)
 	LoginExecutor() auth.LoginExecutor
+
+	// NotifyUser sends a line of text to the users of the CLI.
+	NotifyUser(msg string)
 }
 
 type apiServer struct {
@@ -112,7 +124,8 @@ type TrafficManager struct {
 
 	getCloudAPIKey func(context.Context, string, bool) (string, error)
 
-	ingressInfo []*manager.IngressInfo
+	ingressInfo        []*manager.IngressInfo
+	ingressDiagnostics *rpc.IngressDiagnostics
 
 	// manager client
 	managerClient manager.ManagerClient
@@ -126,8 +139,19 @@ type TrafficManager struct {
 	// search paths are propagated to the rootDaemon
 	rootDaemon daemon.DaemonClient
 
+	// svc is the service that owns this session
+	svc Service
+
+	// redialManager establishes a new connection and session with the traffic-manager. It's used
+	// by ReconnectTrafficManager.
+	redialManager func(context.Context) (*managerConnection, error)
+
 	sessionInfo *manager.SessionInfo // sessionInfo returned by the traffic-manager
 
+	// dialers contains the active connections that have been dialed on behalf of the traffic-manager,
+	// i.e. the connections to the local ports of intercepts
+	dialers *tunnel.Pool
+
 	// Map of desired mount points for intercepts
 	mountPoints sync.Map
 
@@ -174,6 +198,18 @@ type TrafficManager struct {
 
 	sessionServices []SessionService
 	sr              *scout.Reporter
+
+	// mtu is the MTU of the network interface that is used when communicating with the cluster
+	mtu int
+
+	// disableDNS is true when the DNS search path must not be posted to the root daemon
+	disableDNS bool
+
+	// allowedNamespaces are the namespaces that the root daemon allows outbound connections to
+	allowedNamespaces []string
+
+	// additionalDNS are host names that the root daemon's resolver resolves to the given IPs
+	additionalDNS map[string][]byte
 }
 
 // interceptResult is what gets written to the activeInterceptsWaiters channels
@@ -186,10 +222,38 @@ type interceptResult struct {
 // TODO: Change to released version
 var firstAgentConfigMapVersion = semver.MustParse("2.6.0-alpha.64")
 
+// minManagerVersion is the oldest traffic-manager version that this client can talk to
+var minManagerVersion = semver.MustParse("2.4.5")
+
+// versionMismatchError is returned when the traffic-manager's version isn't compatible with the client's.
+type versionMismatchError struct {
+	clientVersion  semver.Version
+	managerVersion semver.Version
+}
+
+func (e *versionMismatchError) Error() string {
+	return fmt.Sprintf("traffic-manager version %s is not compatible with client version %s. Minimum supported version is %s. "+
+		"Please run \"telepresence uninstall --everything\" so that a compatible traffic-manager is installed on next connect",
+		e.managerVersion, e.clientVersion, minManagerVersion)
+}
+
+// checkManagerVersion returns a VersionMismatch error if the given managerVersion isn't supported by this client.
+func checkManagerVersion(clientVersion, managerVersion semver.Version) error {
+	if managerVersion.LT(minManagerVersion) {
+		return errcat.VersionMismatch.New(&versionMismatchError{clientVersion: clientVersion, managerVersion: managerVersion})
+	}
+	return nil
+}
+
 func NewSession(c context.Context, sr *scout.Reporter, cr *rpc.ConnectRequest, svc Service, extraServices []SessionService) (context.Context, Session, *connector.ConnectInfo) {
 	dlog.Info(c, "-- Starting new session")
 	sr.Report(c, "connect")
 
+	additionalDNS, err := additionalDNSRecords(cr.AdditionalDns)
+	if err != nil {
+		return c, nil, connectError(rpc.ConnectInfo_DAEMON_FAILED, err)
+	}
+
 	rootDaemon, err := svc.RootDaemonClient(c)
 	if err != nil {
 		return c, nil, connectError(rpc.ConnectInfo_DAEMON_FAILED, err)
@@ -214,7 +278,7 @@ func NewSession(c context.Context, sr *scout.Reporter, cr *rpc.ConnectRequest, s
 	connectStart := time.Now()
 
 	dlog.Info(c, "Connecting to traffic manager...")
-	tmgr, err := connectMgr(c, cluster, sr.InstallID(), svc, rootDaemon)
+	tmgr, err := connectMgr(c, cluster, sr.InstallID(), svc, rootDaemon, cr.AssumeManagerInstalled || !cluster.IsDefaultManager())
 
 	if err != nil {
 		dlog.Errorf(c, "Unable to connect to TrafficManager: %s", err)
@@ -223,9 +287,45 @@ func NewSession(c context.Context, sr *scout.Reporter, cr *rpc.ConnectRequest, s
 
 	tmgr.sessionServices = extraServices
 	tmgr.sr = sr
+	tmgr.mtu = detectMTU(c, cluster.Server)
+	tmgr.disableDNS = cr.DisableDns
+	tmgr.allowedNamespaces = allowedNamespaces(c, cluster.AllowedNamespaces, cr.AllowedNamespaces)
+	tmgr.additionalDNS = additionalDNS
 
 	// Must call SetManagerClient before calling daemon.Connect which tells the
 	// daemon to use the proxy.
+	svc.SetManagerClient(tmgr.managerClient, managerCallOptions(c)...)
+
+	dlog.Debug(c, "Connecting to root daemon")
+	if err = tmgr.connectRootDaemon(c); err != nil {
+		return c, nil, connectError(rpc.ConnectInfo_DAEMON_FAILED, err)
+	}
+	dlog.Debug(c, "Connected to root daemon")
+	tmgr.AddNamespaceListener(tmgr.updateDaemonNamespaces)
+
+	// Collect data on how long connection time took
+	dlog.Debug(c, "Finished connecting to traffic manager")
+	sr.Report(c, "finished_connecting_traffic_manager", scout.Entry{
+		Key: "connect_duration", Value: time.Since(connectStart).Seconds()})
+
+	ret := &rpc.ConnectInfo{
+		Error:           rpc.ConnectInfo_UNSPECIFIED,
+		ClusterContext:  cluster.Config.Context,
+		ClusterServer:   cluster.Config.Server,
+		ClusterId:       cluster.GetClusterId(c),
+		SessionInfo:     tmgr.session(),
+		Intercepts:      &manager.InterceptInfoSnapshot{Intercepts: tmgr.getCurrentIntercepts()},
+		Mtu:             int32(tmgr.mtu),
+		DnsDisabled:     tmgr.disableDNS,
+		ManagerVersion:  tmgr.managerVersion.String(),
+		ManagerFeatures: featuresOf(tmgr.managerVersion),
+	}
+	c = WithSession(c, tmgr)
+	return c, tmgr, ret
+}
+
+// managerCallOptions returns the options used by the proxy when calling the traffic-manager.
+func managerCallOptions(c context.Context) []grpc.CallOption {
 	var opts []grpc.CallOption
 	cfg := client.GetConfig(c)
 	if !cfg.Grpc.MaxReceiveSize.IsZero() {
@@ -233,55 +333,59 @@ func NewSession(c context.Context, sr *scout.Reporter, cr *rpc.ConnectRequest, s
 			opts = append(opts, grpc.MaxCallRecvMsgSize(int(mz)))
 		}
 	}
-	svc.SetManagerClient(tmgr.managerClient, opts...)
-
-	// Tell daemon what it needs to know in order to establish outbound traffic to the cluster
-	oi := tmgr.getOutboundInfo(c)
+	return opts
+}
 
-	dlog.Debug(c, "Connecting to root daemon")
-	var rootStatus *daemon.DaemonStatus
+// connectRootDaemon tells the root daemon what it needs to know in order to establish outbound
+// traffic to the cluster. A root daemon that is connected using another session is disconnected
+// first.
+func (tm *TrafficManager) connectRootDaemon(c context.Context) error {
+	oi := tm.getOutboundInfo(c)
 	for attempt := 1; ; attempt++ {
-		if rootStatus, err = rootDaemon.Connect(c, oi); err != nil {
+		rootStatus, err := tm.rootDaemon.Connect(c, oi)
+		if err != nil {
 			dlog.Errorf(c, "failed to connect to root daemon: %v", err)
-			return c, nil, connectError(rpc.ConnectInfo_DAEMON_FAILED, err)
+			return err
 		}
 		oc := rootStatus.OutboundConfig
 		if oc == nil || oc.Session == nil {
 			// This is an internal error. Something is wrong with the root daemon.
-			return c, nil, connectError(rpc.ConnectInfo_DAEMON_FAILED, errors.New("root daemon's OutboundConfig has no Session"))
+			return errors.New("root daemon's OutboundConfig has no Session")
 		}
 		if oc.Session.SessionId == oi.Session.SessionId {
-			break
+			return nil
 		}
 
-		// Root daemon was running an old session. This indicates that this daemon somehow
-		// crashed without disconnecting. So let's do that now, and then reconnect...
+		// Root daemon was running another session. This indicates that this daemon somehow
+		// crashed without disconnecting, or that the session with the traffic-manager has
+		// been replaced. So let's disconnect now, and then reconnect...
 		if attempt == 2 {
 			// ...or not, since we've already done it.
-			return c, nil, connectError(rpc.ConnectInfo_DAEMON_FAILED, errors.New("unable to reconnect"))
+			return errors.New("unable to reconnect")
 		}
-		if _, err = rootDaemon.Disconnect(c, &empty.Empty{}); err != nil {
-			return c, nil, connectError(rpc.ConnectInfo_DAEMON_FAILED, fmt.Errorf("failed to disconnect from the root daemon: %w", err))
+		if _, err = tm.rootDaemon.Disconnect(c, &empty.Empty{}); err != nil {
+			return fmt.Errorf("failed to disconnect from the root daemon: %w", err)
 		}
 	}
-	dlog.Debug(c, "Connected to root daemon")
-	tmgr.AddNamespaceListener(tmgr.updateDaemonNamespaces)
-
-	// Collect data on how long connection time took
-	dlog.Debug(c, "Finished connecting to traffic manager")
-	sr.Report(c, "finished_connecting_traffic_manager", scout.Entry{
-		Key: "connect_duration", Value: time.Since(connectStart).Seconds()})
+}
 
-	ret := &rpc.ConnectInfo{
-		Error:          rpc.ConnectInfo_UNSPECIFIED,
-		ClusterContext: cluster.Config.Context,
-		ClusterServer:  cluster.Config.Server,
-		ClusterId:      cluster.GetClusterId(c),
-		SessionInfo:    tmgr.session(),
-		Intercepts:     &manager.InterceptInfoSnapshot{Intercepts: tmgr.getCurrentIntercepts()},
+// ReconnectTrafficManager replaces the connection and the session with the traffic-manager, e.g.
+// after the session has expired because the traffic-manager was upgraded. The connection to the
+// cluster and its watchers are retained. The root daemon is reconnected using the new session.
+// This method must not be called while Run is running.
+func (tm *TrafficManager) ReconnectTrafficManager(c context.Context) error {
+	dlog.Info(c, "Reconnecting to traffic manager...")
+	mc, err := tm.redialManager(c)
+	if err != nil {
+		return fmt.Errorf("unable to reconnect to traffic manager: %w", err)
 	}
-	c = WithSession(c, tmgr)
-	return c, tmgr, ret
+	tm.setManagerConnection(mc)
+	tm.svc.SetManagerClient(mc.client, managerCallOptions(c)...)
+	if err = tm.connectRootDaemon(c); err != nil {
+		return fmt.Errorf("unable to reconnect root daemon: %w", err)
+	}
+	dlog.Infof(c, "Reconnected to traffic manager with session %s", mc.session.SessionId)
+	return nil
 }
 
 func (tm *TrafficManager) RemainWithToken(ctx context.Context) error {
@@ -303,12 +407,29 @@ func (tm *TrafficManager) ManagerClient() manager.ManagerClient {
 	return tm.managerClient
 }
 
+// newConfig returns the kubernetes configuration for the given request.
+func newConfig(c context.Context, cr *rpc.ConnectRequest) (config *k8s.Config, err error) {
+	if cr.InCluster {
+		config, err = k8s.NewInClusterConfig(c)
+	} else {
+		config, err = k8s.NewConfig(c, cr.KubeFlags)
+	}
+	if err != nil {
+		return nil, err
+	}
+	config.SetManager(cr.ManagerNamespace, cr.ManagerName)
+	return config, nil
+}
+
 // connectCluster returns a configured cluster instance
 func connectCluster(c context.Context, cr *rpc.ConnectRequest) (*k8s.Cluster, error) {
-	config, err := k8s.NewConfig(c, cr.KubeFlags)
+	config, err := newConfig(c, cr)
 	if err != nil {
 		return nil, err
 	}
+	if err = checkExecPlugin(config.RestConfig); err != nil {
+		return nil, err
+	}
 
 	mappedNamespaces := cr.MappedNamespaces
 	if len(mappedNamespaces) == 1 && mappedNamespaces[0] == "all" {
@@ -325,7 +446,14 @@ func connectCluster(c context.Context, cr *rpc.ConnectRequest) (*k8s.Cluster, er
 }
 
 // connectMgr returns a session for the given cluster that is connected to the traffic-manager.
-func connectMgr(c context.Context, cluster *k8s.Cluster, installID string, svc Service, rootDaemon daemon.DaemonClient) (*TrafficManager, error) {
+func connectMgr(
+	c context.Context,
+	cluster *k8s.Cluster,
+	installID string,
+	svc Service,
+	rootDaemon daemon.DaemonClient,
+	assumeInstalled bool,
+) (*TrafficManager, error) {
 	clientConfig := client.GetConfig(c)
 	tos := &clientConfig.Timeouts
 
@@ -341,30 +469,106 @@ func connectMgr(c context.Context, cluster *k8s.Cluster, installID string, svc S
 		return nil, stacktrace.Wrap(err, "os.Hostname()")
 	}
 
-	apiKey, err := svc.LoginExecutor().GetAPIKey(c, a8rcloud.KeyDescTrafficManager)
-	if err != nil {
-		dlog.Errorf(c, "unable to get APIKey: %v", err)
-	}
-
 	// Ensure that we have a traffic-manager to talk to.
 	ti, err := NewTrafficManagerInstaller(cluster)
 	if err != nil {
 		return nil, stacktrace.Wrap(err, "new installer")
 	}
 
+	if err = ensureManager(c, ti, assumeInstalled); err != nil {
+		return nil, err
+	}
+	if assumeInstalled {
+		if err = checkManagerExists(c, cluster.GetManagerNamespace(), cluster.GetManagerName()); err != nil {
+			return nil, err
+		}
+	}
+
+	dlog.Debug(c, "traffic-manager started, creating port-forward")
+	userAndHost := fmt.Sprintf("%s@%s", userinfo.Username, host)
+	mc, err := dialManager(c, cluster, svc, installID, userAndHost)
+	if err != nil {
+		if assumeInstalled {
+			err = errcat.User.Newf("unable to reach the traffic-manager %q in namespace %q: %w",
+				cluster.GetManagerName(), cluster.GetManagerNamespace(), err)
+		}
+		return nil, err
+	}
+
+	tm := &TrafficManager{
+		installer:           ti.(*installer),
+		installID:           installID,
+		userAndHost:         userAndHost,
+		getCloudAPIKey:      svc.LoginExecutor().GetCloudAPIKey,
+		svc:                 svc,
+		dialers:             tunnel.NewPool(),
+		rootDaemon:          rootDaemon,
+		localIntercepts:     map[string]string{},
+		currentInterceptors: map[string]int{},
+		wlWatcher:           newWASWatcher(),
+	}
+	tm.setManagerConnection(mc)
+	tm.redialManager = func(c context.Context) (*managerConnection, error) {
+		c, cancel := tos.TimeoutContext(c, client.TimeoutTrafficManagerConnect)
+		defer cancel()
+		return dialManager(c, cluster, svc, installID, userAndHost)
+	}
+	return tm, nil
+}
+
+// ensureManager ensures that the traffic-manager is installed, unless assumeInstalled is true,
+// in which case nothing is checked and a missing traffic-manager is detected when it is dialed.
+func ensureManager(c context.Context, ti Installer, assumeInstalled bool) error {
+	if assumeInstalled {
+		dlog.Debug(c, "traffic-manager is assumed to be installed")
+		return nil
+	}
 	dlog.Debug(c, "ensure that traffic-manager exists")
-	if err = ti.EnsureManager(c); err != nil {
+	if err := ti.EnsureManager(c); err != nil {
 		dlog.Errorf(c, "failed to ensure traffic-manager, %v", err)
-		return nil, fmt.Errorf("failed to ensure traffic manager: %w", err)
+		return fmt.Errorf("failed to ensure traffic manager: %w", err)
+	}
+	return nil
+}
+
+// checkManagerExists returns an error unless the service of the traffic-manager with the given name
+// exists in the given namespace.
+func checkManagerExists(c context.Context, namespace, name string) error {
+	_, err := k8sapi.GetK8sInterface(c).CoreV1().Services(namespace).Get(c, name, meta.GetOptions{})
+	switch {
+	case err == nil:
+		return nil
+	case k8serrors.IsNotFound(err):
+		return errcat.User.Newf("traffic-manager %q not found in namespace %q", name, namespace)
+	default:
+		return fmt.Errorf("unable to get the service of traffic-manager %q in namespace %q: %w", name, namespace, err)
+	}
+}
+
+// managerConnection is a connection to the traffic-manager together with the session that the
+// traffic-manager has assigned to this client.
+type managerConnection struct {
+	conn    *grpc.ClientConn
+	client  manager.ManagerClient
+	version semver.Version
+	session *manager.SessionInfo
+}
+
+// dialManager establishes a port-forward to the traffic-manager, checks its version, and then
+// makes this client known to it, unless the session cached for the cluster is still valid.
+func dialManager(c context.Context, cluster *k8s.Cluster, svc Service, installID, userAndHost string) (mc *managerConnection, err error) {
+	tos := &client.GetConfig(c).Timeouts
+	apiKey, err := svc.LoginExecutor().GetAPIKey(c, a8rcloud.KeyDescTrafficManager)
+	if err != nil {
+		dlog.Errorf(c, "unable to get APIKey: %v", err)
 	}
 
-	dlog.Debug(c, "traffic-manager started, creating port-forward")
 	grpcDialer, err := dnet.NewK8sPortForwardDialer(c, cluster.Config.RestConfig, k8sapi.GetK8sInterface(c))
 	if err != nil {
 		return nil, err
 	}
 	grpcAddr := net.JoinHostPort(
-		"svc/traffic-manager."+cluster.GetManagerNamespace(),
+		"svc/"+cluster.GetManagerName()+"."+cluster.GetManagerNamespace(),
 		fmt.Sprint(install.ManagerPortHTTP))
 
 	// First check. Establish connection
@@ -387,7 +591,6 @@ func connectMgr(c context.Context, cluster *k8s.Cluster, installID string, svc S
 		}
 	}()
 
-	userAndHost := fmt.Sprintf("%s@%s", userinfo.Username, host)
 	mClient := manager.NewManagerClient(conn)
 
 	vi, err := mClient.Version(tc, &empty.Empty{})
@@ -398,6 +601,9 @@ func connectMgr(c context.Context, cluster *k8s.Cluster, installID string, svc S
 	if err != nil {
 		return nil, client.CheckTimeout(tc, fmt.Errorf("unable to parse manager.Version: %w", err))
 	}
+	if err = checkManagerVersion(client.Semver(), managerVersion); err != nil {
+		return nil, err
+	}
 
 	clusterHost := cluster.Config.RestConfig.Host
 	si, err := LoadSessionFromUserCache(c, clusterHost)
@@ -440,28 +646,28 @@ func connectMgr(c context.Context, cluster *k8s.Cluster, installID string, svc S
 		}
 	}
 
-	return &TrafficManager{
-		installer:           ti.(*installer),
-		installID:           installID,
-		userAndHost:         userAndHost,
-		getCloudAPIKey:      svc.LoginExecutor().GetCloudAPIKey,
-		managerClient:       mClient,
-		managerConn:         conn,
-		managerVersion:      managerVersion,
-		sessionInfo:         si,
-		rootDaemon:          rootDaemon,
-		localIntercepts:     map[string]string{},
-		currentInterceptors: map[string]int{},
-		wlWatcher:           newWASWatcher(),
-	}, nil
+	return &managerConnection{conn: conn, client: mClient, version: managerVersion, session: si}, nil
+}
+
+func (tm *TrafficManager) setManagerConnection(mc *managerConnection) {
+	tm.managerClient = mc.client
+	tm.managerConn = mc.conn
+	tm.managerVersion = mc.version
+	tm.sessionInfo = mc.session
 }
 
 func connectError(t rpc.ConnectInfo_ErrType, err error) *rpc.ConnectInfo {
-	return &rpc.ConnectInfo{
+	ci := &rpc.ConnectInfo{
 		Error:         t,
 		ErrorText:     err.Error(),
 		ErrorCategory: int32(errcat.GetCategory(err)),
 	}
+	var vmErr *versionMismatchError
+	if errors.As(err, &vmErr) {
+		ci.ClientVersion = vmErr.clientVersion.String()
+		ci.ManagerVersion = vmErr.managerVersion.String()
+	}
+	return ci
 }
 
 func (tm *TrafficManager) setInterceptedNamespaces(c context.Context, interceptedNamespaces map[string]struct{}) {
@@ -490,6 +696,11 @@ func (tm *TrafficManager) updateDaemonNamespaces(c context.Context) {
 	tm.insLock.Unlock()
 	sort.Strings(namespaces)
 
+	if tm.disableDNS {
+		dlog.Debugf(c, "DNS is disabled, not posting search paths for namespaces %v", namespaces)
+		return
+	}
+
 	// Pass current mapped namespaces as plain names (no ending dot). The DNS-resolver will
 	// create special mapping for those, allowing names like myservice.mynamespace to be resolved
 	paths := tm.GetCurrentNamespaces(false)
@@ -500,6 +711,21 @@ func (tm *TrafficManager) updateDaemonNamespaces(c context.Context) {
 	dlog.Debug(c, "search paths posted successfully")
 }
 
+// SetDNSSuffixes changes the suffixes that the DNS-resolver in the daemon will never (excludes) or
+// always (includes) resolve using the cluster. An empty excludes means that the default excludes are used.
+// The suffixes are retained so that they are used also when the daemon reconnects.
+func (tm *TrafficManager) SetDNSSuffixes(c context.Context, excludes, includes []string) error {
+	if tm.DNS != nil {
+		tm.DNS.ExcludeSuffixes = excludes
+		tm.DNS.IncludeSuffixes = includes
+	}
+	dlog.Debugf(c, "posting DNS exclude suffixes %v and include suffixes %v", excludes, includes)
+	if _, err := tm.rootDaemon.SetDnsSuffixes(c, &daemon.Suffixes{ExcludeSuffixes: excludes, IncludeSuffixes: includes}); err != nil {
+		return fmt.Errorf("error posting DNS suffixes to root daemon: %w", err)
+	}
+	return nil
+}
+
 // Run (1) starts up with ensuring that the manager is installed and running,
 // but then for most of its life
 //  - (2) calls manager.ArriveAsClient and then periodically calls manager.Remain
@@ -515,6 +741,8 @@ func (tm *TrafficManager) Run(c context.Context) error {
 	g.Go("intercept-port-forward", tm.workerPortForwardIntercepts)
 	g.Go("agent-watcher", tm.agentInfoWatcher)
 	g.Go("dial-request-watcher", tm.dialRequestWatcher)
+	g.Go("network-watcher", tm.networkWatcher)
+	g.Go("kubeconfig-watcher", tm.kubeconfigWatcher)
 	for _, svc := range tm.sessionServices {
 		func(svc SessionService) {
 			dlog.Infof(c, "Starting additional session service %s", svc.Name())
@@ -757,10 +985,16 @@ nextIs:
 
 var SessionExpiredErr = errors.New("session expired")
 
+// The interval between calls to manager.Remain, and the capped exponential backoff that is used
+// instead when a call fails.
+var (
+	remainInterval       = 5 * time.Second
+	remainInitialBackoff = 1 * time.Second
+	remainMaxBackoff     = 15 * time.Second
+)
+
 func (tm *TrafficManager) remain(c context.Context) error {
-	ticker := time.NewTicker(5 * time.Second)
 	defer func() {
-		ticker.Stop()
 		c = dcontext.WithoutCancel(c)
 		c, cancel := context.WithTimeout(c, 3*time.Second)
 		defer cancel()
@@ -775,39 +1009,73 @@ func (tm *TrafficManager) remain(c context.Context) error {
 		tm.managerConn.Close()
 	}()
 
+	return tm.remainLoop(c)
+}
+
+// remainLoop calls manager.Remain periodically until the context is cancelled or the session has
+// expired. Failed calls are retried with a capped exponential backoff, so that a brief
+// unavailability of the API server or the traffic-manager doesn't end the session. The user is
+// notified when the retries start and when the connection has recovered.
+func (tm *TrafficManager) remainLoop(c context.Context) error {
+	timer := time.NewTimer(remainInterval)
+	defer timer.Stop()
+
+	var backoff time.Duration
 	for {
 		select {
 		case <-c.Done():
 			return nil
-		case <-ticker.C:
-			_, err := tm.managerClient.Remain(c, &manager.RemainRequest{
-				Session: tm.session(),
-				ApiKey: func() string {
-					// Discard any errors; including an apikey with this request
-					// is optional.  We might not even be logged in.
-					tok, _ := tm.getCloudAPIKey(c, a8rcloud.KeyDescTrafficManager, false)
-					return tok
-				}(),
-			})
-			if err != nil && c.Err() == nil {
-				dlog.Error(c, err)
-				if gErr, ok := status.FromError(err); ok && gErr.Code() == codes.NotFound {
-					// Session has expired. We need to cancel the owner session and reconnect
-					return SessionExpiredErr
-				}
+		case <-timer.C:
+		}
+		_, err := tm.managerClient.Remain(c, &manager.RemainRequest{
+			Session: tm.session(),
+			ApiKey: func() string {
+				// Discard any errors; including an apikey with this request
+				// is optional.  We might not even be logged in.
+				tok, _ := tm.getCloudAPIKey(c, a8rcloud.KeyDescTrafficManager, false)
+				return tok
+			}(),
+		})
+		switch {
+		case err == nil:
+			if backoff > 0 {
+				backoff = 0
+				dlog.Info(c, "Connection to the traffic-manager recovered")
+				tm.svc.NotifyUser("Connection to the traffic-manager recovered")
 			}
+			timer.Reset(remainInterval)
+		case c.Err() != nil:
+			return nil
+		case status.Code(err) == codes.NotFound:
+			// Session has expired. We need to cancel the owner session and reconnect
+			dlog.Error(c, err)
+			return SessionExpiredErr
+		default:
+			dlog.Error(c, err)
+			if backoff == 0 {
+				backoff = remainInitialBackoff
+				tm.svc.NotifyUser(fmt.Sprintf("Connection to the traffic-manager is degraded, retrying: %v", err))
+			} else if backoff *= 2; backoff > remainMaxBackoff {
+				backoff = remainMaxBackoff
+			}
+			timer.Reset(backoff)
 		}
 	}
 }
 
 func (tm *TrafficManager) UpdateStatus(c context.Context, cr *rpc.ConnectRequest) *rpc.ConnectInfo {
-	config, err := k8s.NewConfig(c, cr.KubeFlags)
+	config, err := newConfig(c, cr)
 	if err != nil {
 		return connectError(rpc.ConnectInfo_CLUSTER_FAILED, err)
 	}
-	if !tm.Config.ContextServiceAndFlagsEqual(config) {
+	if changes := tm.Config.ContextServiceAndFlagsDiff(config); len(changes) > 0 {
+		descs := make([]string, len(changes))
+		for i, change := range changes {
+			descs[i] = change.String()
+		}
 		return &rpc.ConnectInfo{
 			Error:          rpc.ConnectInfo_MUST_RESTART,
+			ErrorText:      strings.Join(descs, ", "),
 			ClusterContext: tm.Config.Context,
 			ClusterServer:  tm.Config.Server,
 			ClusterId:      tm.GetClusterId(c),
@@ -817,6 +1085,7 @@ func (tm *TrafficManager) UpdateStatus(c context.Context, cr *rpc.ConnectRequest
 	if tm.SetMappedNamespaces(c, cr.MappedNamespaces) {
 		tm.insLock.Lock()
 		tm.ingressInfo = nil
+		tm.ingressDiagnostics = nil
 		tm.insLock.Unlock()
 	}
 	return tm.Status(c)
@@ -825,12 +1094,17 @@ func (tm *TrafficManager) UpdateStatus(c context.Context, cr *rpc.ConnectRequest
 func (tm *TrafficManager) Status(c context.Context) *rpc.ConnectInfo {
 	cfg := tm.Config
 	ret := &rpc.ConnectInfo{
-		Error:          rpc.ConnectInfo_ALREADY_CONNECTED,
-		ClusterContext: cfg.Context,
-		ClusterServer:  cfg.Server,
-		ClusterId:      tm.GetClusterId(c),
-		SessionInfo:    tm.session(),
-		Intercepts:     &manager.InterceptInfoSnapshot{Intercepts: tm.getCurrentIntercepts()},
+		Error:              rpc.ConnectInfo_ALREADY_CONNECTED,
+		ClusterContext:     cfg.Context,
+		ClusterServer:      cfg.Server,
+		ClusterId:          tm.GetClusterId(c),
+		SessionInfo:        tm.session(),
+		Intercepts:         &manager.InterceptInfoSnapshot{Intercepts: tm.getCurrentIntercepts()},
+		Mtu:                int32(tm.mtu),
+		DnsDisabled:        tm.disableDNS,
+		ManagerVersion:     tm.managerVersion.String(),
+		ManagerFeatures:    featuresOf(tm.managerVersion),
+		IngressDiagnostics: tm.getIngressDiagnostics(),
 	}
 	return ret
 }
@@ -1039,6 +1313,59 @@ func (tm *TrafficManager) Uninstall(ctx context.Context, ur *rpc.UninstallReques
 	return result(nil)
 }
 
+// allowedNamespaces returns the namespaces that outbound connections are allowed to reach, given
+// the namespaces configured in the kubeconfig extension and the ones in the connect request. The
+// request can narrow the configured list, but never widen it.
+func allowedNamespaces(ctx context.Context, configured, requested []string) []string {
+	if len(configured) == 0 {
+		return requested
+	}
+	if len(requested) == 0 {
+		return configured
+	}
+	var allowed []string
+	for _, ns := range requested {
+		found := false
+		for _, cns := range configured {
+			if ns == cns {
+				found = true
+				break
+			}
+		}
+		if found {
+			allowed = append(allowed, ns)
+		} else {
+			dlog.Warnf(ctx, "namespace %q is not in the configured allowed namespaces and is ignored", ns)
+		}
+	}
+	if len(allowed) == 0 {
+		return configured
+	}
+	return allowed
+}
+
+// additionalDNSRecords validates the host names and IP addresses of the given records and returns
+// them in the form that the root daemon expects. The host names are made lower case and have no
+// trailing dot.
+func additionalDNSRecords(records map[string]string) (map[string][]byte, error) {
+	if len(records) == 0 {
+		return nil, nil
+	}
+	rs := make(map[string][]byte, len(records))
+	for host, addr := range records {
+		name := strings.ToLower(strings.TrimSuffix(host, "."))
+		if name == "" || strings.ContainsAny(name, " \t/:") {
+			return nil, errcat.User.Newf("invalid host name %q in additional DNS records", host)
+		}
+		ip := iputil.Parse(addr)
+		if ip == nil {
+			return nil, errcat.User.Newf("invalid IP address %q for host %q in additional DNS records", addr, host)
+		}
+		rs[name] = ip
+	}
+	return rs, nil
+}
+
 // getClusterCIDRs finds the service CIDR and the pod CIDRs of all nodes in the cluster
 func (tm *TrafficManager) getOutboundInfo(ctx context.Context) *daemon.OutboundInfo {
 	// We'll figure out the IP address of the API server(s) so that we can tell the daemon never to proxy them.
@@ -1079,6 +1406,8 @@ func (tm *TrafficManager) getOutboundInfo(ctx context.Context) *daemon.OutboundI
 	info := &daemon.OutboundInfo{
 		Session:           tm.sessionInfo,
 		NeverProxySubnets: neverProxy,
+		Mtu:               int32(tm.mtu),
+		AllowedNamespaces: tm.allowedNamespaces,
 	}
 
 	if tm.DNS != nil {
@@ -1094,6 +1423,12 @@ func (tm *TrafficManager) getOutboundInfo(ctx context.Context) *daemon.OutboundI
 			info.Dns.RemoteIp = tm.DNS.RemoteIP.IP()
 		}
 	}
+	if len(tm.additionalDNS) > 0 {
+		if info.Dns == nil {
+			info.Dns = &daemon.DNSConfig{}
+		}
+		info.Dns.AdditionalRecords = tm.additionalDNS
+	}
 
 	if len(tm.AlsoProxy) > 0 {
 		info.AlsoProxySubnets = make([]*manager.IPNet, len(tm.AlsoProxy))
@@ -0,0 +1,164 @@
+package trafficmgr
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/blang/semver"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	empty "google.golang.org/protobuf/types/known/emptypb"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/datawire/dlib/dlog"
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+	"github.com/telepresenceio/telepresence/v2/pkg/k8sapi"
+)
+
+func Test_checkManagerVersion(t *testing.T) {
+	clientVersion := semver.MustParse("2.6.9")
+	require.NoError(t, checkManagerVersion(clientVersion, semver.MustParse("2.4.5")))
+	require.NoError(t, checkManagerVersion(clientVersion, semver.MustParse("2.6.8")))
+
+	err := checkManagerVersion(clientVersion, semver.MustParse("2.4.4"))
+	require.Error(t, err)
+	assert.Equal(t, errcat.VersionMismatch, errcat.GetCategory(err))
+
+	// The category and the versions must survive wrapping
+	ci := connectError(rpc.ConnectInfo_TRAFFIC_MANAGER_FAILED, fmt.Errorf("connect failed: %w", err))
+	assert.Equal(t, rpc.ConnectInfo_TRAFFIC_MANAGER_FAILED, ci.Error)
+	assert.Equal(t, int32(errcat.VersionMismatch), ci.ErrorCategory)
+	assert.Equal(t, "2.6.9", ci.ClientVersion)
+	assert.Equal(t, "2.4.4", ci.ManagerVersion)
+	assert.Contains(t, ci.ErrorText, "telepresence uninstall --everything")
+}
+
+func Test_featuresOf(t *testing.T) {
+	assert.Empty(t, featuresOf(semver.MustParse("2.4.5")))
+	assert.Equal(t, []string{"watch-agents-ns"}, featuresOf(semver.MustParse("2.5.5")))
+	assert.Equal(t, []string{"watch-agents-ns"}, featuresOf(semver.MustParse("2.6.0-alpha.63")))
+	assert.Equal(t, []string{"watch-agents-ns", "agent-config-map"}, featuresOf(semver.MustParse("2.6.8")))
+}
+
+func Test_additionalDNSRecords(t *testing.T) {
+	rs, err := additionalDNSRecords(nil)
+	require.NoError(t, err)
+	assert.Nil(t, rs)
+
+	rs, err = additionalDNSRecords(map[string]string{"Fake.Example.com.": "10.1.2.3", "laptop": "::1"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]byte{
+		"fake.example.com": {10, 1, 2, 3},
+		"laptop":           net.ParseIP("::1"),
+	}, rs)
+
+	_, err = additionalDNSRecords(map[string]string{"fake.example.com": "10.1.2"})
+	require.Error(t, err)
+	assert.Equal(t, errcat.User, errcat.GetCategory(err))
+
+	_, err = additionalDNSRecords(map[string]string{"http://fake": "10.1.2.3"})
+	require.Error(t, err)
+	assert.Equal(t, errcat.User, errcat.GetCategory(err))
+}
+
+type ensureCountingInstaller struct {
+	Installer
+	ensureCalls int
+}
+
+func (i *ensureCountingInstaller) EnsureManager(context.Context) error {
+	i.ensureCalls++
+	return nil
+}
+
+func Test_ensureManager(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	ti := &ensureCountingInstaller{}
+	require.NoError(t, ensureManager(ctx, ti, true))
+	assert.Equal(t, 0, ti.ensureCalls, "install path must not run when the manager is assumed to be installed")
+
+	require.NoError(t, ensureManager(ctx, ti, false))
+	assert.Equal(t, 1, ti.ensureCalls)
+}
+
+func Test_checkManagerExists(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	ctx = k8sapi.WithK8sInterface(ctx, fake.NewSimpleClientset(&core.Service{
+		ObjectMeta: meta.ObjectMeta{Name: "team-a-manager", Namespace: "team-a"},
+	}))
+	require.NoError(t, checkManagerExists(ctx, "team-a", "team-a-manager"))
+
+	err := checkManagerExists(ctx, "team-b", "team-a-manager")
+	require.Error(t, err)
+	assert.Equal(t, errcat.User, errcat.GetCategory(err))
+	assert.Contains(t, err.Error(), `"team-b"`)
+
+	require.Error(t, checkManagerExists(ctx, "team-a", "traffic-manager"))
+}
+
+type remainManagerClient struct {
+	manager.ManagerClient
+	errs  []error
+	calls int
+}
+
+func (m *remainManagerClient) Remain(context.Context, *manager.RemainRequest, ...grpc.CallOption) (*empty.Empty, error) {
+	m.calls++
+	if len(m.errs) == 0 {
+		return &empty.Empty{}, nil
+	}
+	err := m.errs[0]
+	m.errs = m.errs[1:]
+	return &empty.Empty{}, err
+}
+
+type notifyingService struct {
+	Service
+	notifications []string
+}
+
+func (s *notifyingService) NotifyUser(msg string) {
+	s.notifications = append(s.notifications, msg)
+}
+
+func Test_remainLoop(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	defer func(i, b, m time.Duration) {
+		remainInterval, remainInitialBackoff, remainMaxBackoff = i, b, m
+	}(remainInterval, remainInitialBackoff, remainMaxBackoff)
+	remainInterval = time.Millisecond
+	remainInitialBackoff = time.Millisecond
+	remainMaxBackoff = 4 * time.Millisecond
+
+	unavailable := status.Error(codes.Unavailable, "connection refused")
+	mc := &remainManagerClient{errs: []error{
+		unavailable, unavailable, unavailable, unavailable, nil,
+		unavailable, nil,
+		status.Error(codes.NotFound, "session expired"),
+	}}
+	svc := &notifyingService{}
+	tm := &TrafficManager{
+		managerClient:  mc,
+		svc:            svc,
+		getCloudAPIKey: func(context.Context, string, bool) (string, error) { return "", nil },
+	}
+
+	// Transient errors don't end the session, but an expired session does.
+	require.Equal(t, SessionExpiredErr, tm.remainLoop(ctx))
+	assert.Equal(t, 8, mc.calls)
+	require.Len(t, svc.notifications, 4)
+	assert.Contains(t, svc.notifications[0], "degraded")
+	assert.Contains(t, svc.notifications[1], "recovered")
+	assert.Contains(t, svc.notifications[2], "degraded")
+	assert.Contains(t, svc.notifications[3], "recovered")
+}
@@ -21,6 +21,7 @@ type Interceptor interface {
 	Serve(context.Context, chan<- net.Addr) error
 	SetIntercepting(*manager.InterceptInfo)
 	SetManager(*manager.SessionInfo, manager.ManagerClient, semver.Version)
+	SetSocketRoot(string)
 	Target() (string, uint16)
 }
 
@@ -41,6 +42,10 @@ type interceptor struct {
 
 	intercept  *manager.InterceptInfo
 	mgrVersion semver.Version
+
+	// socketRoot is the directory where the volumes of the intercepted container are mounted. The
+	// remote socket of an intercept is only served when it's set.
+	socketRoot string
 }
 
 func NewInterceptor(addr net.Addr, targetHost string, targetPort uint16) Interceptor {
@@ -62,6 +67,14 @@ func (f *interceptor) SetManager(sessionInfo *manager.SessionInfo, manager manag
 	f.mgrVersion = version
 }
 
+// SetSocketRoot sets the directory where the volumes of the intercepted container are mounted, so
+// that the remote_socket_path of an intercept can be served.
+func (f *interceptor) SetSocketRoot(root string) {
+	f.mu.Lock()
+	f.socketRoot = root
+	f.mu.Unlock()
+}
+
 func (f *interceptor) Close() error {
 	f.lCancel()
 	return nil
@@ -124,4 +137,41 @@ func (f *interceptor) SetIntercepting(intercept *manager.InterceptInfo) {
 	// Set up new target and lifetime
 	f.tCtx, f.tCancel = context.WithCancel(f.lCtx)
 	f.intercept = intercept
+	if intercept != nil && intercept.Spec.RemoteSocketPath != "" {
+		if f.socketRoot == "" {
+			dlog.Errorf(f.lCtx, "Unable to serve the remote socket %s of intercept %s", intercept.Spec.RemoteSocketPath, iceptInfo(intercept))
+		} else {
+			go f.serveSocket(f.tCtx, intercept)
+		}
+	}
+}
+
+// filterMatches returns true if a connection from the given source IP to the given container
+// port matches the filter. A nil filter matches all connections. A source CIDR that can't be
+// parsed never matches.
+func filterMatches(filter *manager.InterceptFilter, src net.IP, port uint16) bool {
+	if filter == nil {
+		return true
+	}
+	if ports := filter.Ports; len(ports) > 0 {
+		found := false
+		for _, p := range ports {
+			if uint16(p) == port {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if cidrs := filter.SourceCidrs; len(cidrs) > 0 {
+		for _, cidr := range cidrs {
+			if _, sn, err := net.ParseCIDR(cidr); err == nil && sn.Contains(src) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
 }
@@ -0,0 +1,36 @@
+package forwarder
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+)
+
+func Test_filterMatches(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter *manager.InterceptFilter
+		src    string
+		port   uint16
+		want   bool
+	}{
+		{"nil filter", nil, "10.0.0.1", 8080, true},
+		{"empty filter", &manager.InterceptFilter{}, "10.0.0.1", 8080, true},
+		{"port match", &manager.InterceptFilter{Ports: []int32{80, 8080}}, "10.0.0.1", 8080, true},
+		{"port mismatch", &manager.InterceptFilter{Ports: []int32{80}}, "10.0.0.1", 8080, false},
+		{"source match", &manager.InterceptFilter{SourceCidrs: []string{"192.168.0.0/16", "10.0.0.0/24"}}, "10.0.0.1", 8080, true},
+		{"source mismatch", &manager.InterceptFilter{SourceCidrs: []string{"10.0.1.0/24"}}, "10.0.0.1", 8080, false},
+		{"invalid cidr", &manager.InterceptFilter{SourceCidrs: []string{"10.0.0.1"}}, "10.0.0.1", 8080, false},
+		{"both match", &manager.InterceptFilter{SourceCidrs: []string{"10.0.0.0/8"}, Ports: []int32{8080}}, "10.0.0.1", 8080, true},
+		{"source match, port mismatch", &manager.InterceptFilter{SourceCidrs: []string{"10.0.0.0/8"}, Ports: []int32{80}}, "10.0.0.1", 8080, false},
+		{"ipv6 source", &manager.InterceptFilter{SourceCidrs: []string{"fd00::/8"}}, "fd00::1", 8080, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, filterMatches(tt.filter, net.ParseIP(tt.src), tt.port))
+		})
+	}
+}
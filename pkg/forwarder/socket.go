@@ -0,0 +1,90 @@
+package forwarder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/ipproto"
+	"github.com/telepresenceio/telepresence/v2/pkg/iputil"
+	"github.com/telepresenceio/telepresence/v2/pkg/tunnel"
+)
+
+// shadowSuffix is appended to the name of a file that is moved aside while a socket is served in its place.
+const shadowSuffix = ".telepresence"
+
+// serveSocket serves the remote_socket_path of the given intercept until the given context is
+// cancelled. Each connection that is accepted is sent to the client session of the intercept
+// using a connection id with the target host and port of the intercept as its destination.
+func (f *interceptor) serveSocket(ctx context.Context, iCept *manager.InterceptInfo) {
+	f.mu.Lock()
+	path := filepath.Join(f.socketRoot, iCept.Spec.RemoteSocketPath)
+	f.mu.Unlock()
+
+	spec := iCept.Spec
+	srcIP := net.IP{127, 0, 0, 1}
+	dstIP := iputil.Parse(spec.TargetHost)
+	ctx = dlog.WithField(ctx, "socket", spec.RemoteSocketPath)
+	err := listenSocket(ctx, path, func(conn net.Conn, port uint16) {
+		id := tunnel.NewConnID(ipproto.TCP, srcIP, dstIP, port, uint16(spec.TargetPort))
+		if err := f.tunnelConn(ctx, conn, iCept, id); err != nil {
+			dlog.Error(ctx, err)
+		}
+	})
+	if err != nil {
+		dlog.Errorf(ctx, "unable to serve socket: %v", err)
+	}
+}
+
+// listenSocket listens on a Unix socket at the given path until the given context is cancelled,
+// and calls handle in a goroutine of its own for each connection that is accepted. A connection
+// has no address of its own, so each one is given a port number from a counter that skips
+// zero. An existing file at the path is moved aside while the socket is
+// served, and is then moved back.
+func listenSocket(ctx context.Context, path string, handle func(net.Conn, uint16)) (err error) {
+	shadow := path + shadowSuffix
+	if err = os.Rename(path, shadow); err == nil {
+		defer func() {
+			if rerr := os.Rename(shadow, path); rerr != nil && err == nil {
+				err = fmt.Errorf("unable to restore %s: %w", path, rerr)
+			}
+		}()
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("unable to move %s aside: %w", path, err)
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	defer listener.Close() // Also removes the socket file
+
+	dlog.Debugf(ctx, "Forwarding from %s", path)
+	defer dlog.Debugf(ctx, "Done forwarding from %s", path)
+
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	var port uint16
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			dlog.Infof(ctx, "Error on accept: %+v", err)
+			continue
+		}
+		if port++; port == 0 {
+			port = 1
+		}
+		go handle(conn, port)
+	}
+}
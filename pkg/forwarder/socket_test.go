@@ -0,0 +1,93 @@
+package forwarder
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+)
+
+func Test_listenSocket(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+
+	// An existing file at the path is moved aside while the socket is served.
+	path := filepath.Join(t.TempDir(), "app.sock")
+	require.NoError(t, os.WriteFile(path, []byte("original"), 0o600))
+
+	type accepted struct {
+		data string
+		port uint16
+	}
+	acceptCh := make(chan accepted, 2)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- listenSocket(ctx, path, func(conn net.Conn, port uint16) {
+			defer conn.Close()
+			data, _ := io.ReadAll(conn)
+			acceptCh <- accepted{data: string(data), port: port}
+		})
+	}()
+
+	dial := func(data string) {
+		var conn net.Conn
+		require.Eventually(t, func() bool {
+			var err error
+			conn, err = net.Dial("unix", path)
+			return err == nil
+		}, 5*time.Second, 10*time.Millisecond)
+		_, err := conn.Write([]byte(data))
+		require.NoError(t, err)
+		require.NoError(t, conn.Close())
+	}
+
+	dial("first")
+	first := <-acceptCh
+	assert.Equal(t, "first", first.data)
+	dial("second")
+	second := <-acceptCh
+	assert.Equal(t, "second", second.data)
+	assert.NotZero(t, first.port)
+	assert.NotEqual(t, first.port, second.port)
+
+	shadowed, err := os.ReadFile(path + shadowSuffix)
+	require.NoError(t, err)
+	assert.Equal(t, "original", string(shadowed))
+
+	// The original file is restored when the context is cancelled.
+	cancel()
+	select {
+	case err = <-errCh:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("listenSocket didn't return")
+	}
+	restored, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "original", string(restored))
+	assert.NoFileExists(t, path+shadowSuffix)
+}
+
+func Test_listenSocketNoFile(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	path := filepath.Join(t.TempDir(), "app.sock")
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- listenSocket(ctx, path, func(conn net.Conn, _ uint16) { conn.Close() })
+	}()
+	require.Eventually(t, func() bool {
+		_, err := os.Stat(path)
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	cancel()
+	require.NoError(t, <-errCh)
+	assert.NoFileExists(t, path)
+}
@@ -93,7 +93,11 @@ func (f *tcp) forwardConn(clientConn *net.TCPConn) error {
 	intercept := f.intercept
 	f.mu.Unlock()
 	if intercept != nil {
-		return f.interceptConn(ctx, clientConn, intercept)
+		src, _, err := iputil.SplitToIPPort(clientConn.RemoteAddr())
+		if err == nil && filterMatches(intercept.Spec.Filter, src, targetPort) {
+			return f.interceptConn(ctx, clientConn, intercept)
+		}
+		dlog.Debugf(ctx, "Connection from %s doesn't match the filter of intercept %s, passing it through", clientConn.RemoteAddr(), intercept.Spec.Name)
 	}
 
 	targetAddr, err := net.ResolveTCPAddr("tcp", fmt.Sprintf("%s:%d", targetHost, targetPort))
@@ -156,7 +160,13 @@ func (f *interceptor) interceptConn(ctx context.Context, conn net.Conn, iCept *m
 	spec := iCept.Spec
 	destIp := iputil.Parse(spec.TargetHost)
 	id := tunnel.NewConnID(tunnel.IPProto(addr.Network()), srcIp, destIp, srcPort, uint16(spec.TargetPort))
+	return f.tunnelConn(ctx, conn, iCept, id)
+}
 
+// tunnelConn sends the given connection through a tunnel to the client session of the intercept,
+// using the given id, and returns when the connection is closed.
+func (f *interceptor) tunnelConn(ctx context.Context, conn net.Conn, iCept *manager.InterceptInfo, id tunnel.ConnID) error {
+	spec := iCept.Spec
 	ms, err := f.manager.Tunnel(ctx)
 	if err != nil {
 		return fmt.Errorf("call to manager.Tunnel() failed. Id %s: %v", id, err)
@@ -56,6 +56,9 @@ func doPipe(ctx context.Context, a, b Stream, wg *sync.WaitGroup) {
 			if m == nil {
 				return
 			}
+			if m.Code() == Push && !SupportsPush(b) {
+				m = msg(append([]byte{byte(Normal)}, m.Payload()...))
+			}
 			select {
 			case <-ctx.Done():
 				return
@@ -35,6 +35,9 @@ type dialer struct {
 	conn      net.Conn
 	connected int32
 	done      chan struct{}
+
+	// readDone is closed when the conn-to-stream loop ends
+	readDone chan struct{}
 }
 
 // NewDialer creates a new handler that dispatches messages in both directions between the given gRPC stream
@@ -61,6 +64,7 @@ func NewConnEndpoint(stream Stream, conn net.Conn) Endpoint {
 		conn:         conn,
 		connected:    state,
 		done:         make(chan struct{}),
+		readDone:     make(chan struct{}),
 	}
 }
 
@@ -75,11 +79,18 @@ func (h *dialer) Start(ctx context.Context) {
 			h.connected = connecting
 
 			dlog.Debugf(ctx, "   CONN %s, dialing", id)
+			network, addr := id.ProtocolString(), id.DestinationAddr().String()
+			if redirect := DialRedirectorFrom(ctx); redirect != nil {
+				if rn, ra, ok := redirect(id); ok {
+					dlog.Debugf(ctx, "   CONN %s, redirected to %s %s", id, rn, ra)
+					network, addr = rn, ra
+				}
+			}
 			d := net.Dialer{Timeout: h.stream.DialTimeout()}
-			conn, err := d.DialContext(ctx, id.ProtocolString(), id.DestinationAddr().String())
+			conn, err := d.DialContext(ctx, network, addr)
 			if err != nil {
 				dlog.Errorf(ctx, "!! CONN %s, failed to establish connection: %v", id, err)
-				if err = h.stream.Send(ctx, NewMessage(DialReject, nil)); err != nil {
+				if err = h.stream.Send(ctx, DialRejectMessage(err.Error())); err != nil {
 					dlog.Errorf(ctx, "!! CONN %s, failed to send DialReject: %v", id, err)
 				}
 				h.connected = notConnected
@@ -169,6 +180,7 @@ func (h *dialer) connToStreamLoop(ctx context.Context, wg *sync.WaitGroup) {
 			}
 		}
 		close(outgoing)
+		close(h.readDone)
 		dlog.Logf(ctx, endLevel, "   CONN %s conn-to-stream loop ended because %s", id, endReason)
 		wg.Done()
 	}()
@@ -221,6 +233,10 @@ func (h *dialer) streamToConnLoop(ctx context.Context, wg *sync.WaitGroup) {
 
 	incoming, errCh := ReadLoop(ctx, h.stream)
 
+	// readDone is set when the peer has closed its sending side while the connection's
+	// read side remains open.
+	var readDone <-chan struct{}
+
 	dlog.Debugf(ctx, "   CONN %s stream-to-conn loop started", id)
 	for atomic.LoadInt32(&h.connected) != notConnected {
 		select {
@@ -230,11 +246,22 @@ func (h *dialer) streamToConnLoop(ctx context.Context, wg *sync.WaitGroup) {
 		case <-h.Idle():
 			endReason = "it was idle for too long"
 			return
+		case <-readDone:
+			endReason = "both directions were closed"
+			return
 		case err := <-errCh:
 			dlog.Error(ctx, err)
 		case dg := <-incoming:
 			if dg == nil {
-				// h.incoming was closed by the reader and is now drained.
+				// h.incoming was closed by the reader and is now drained. The peer will not send
+				// more data, so half-close the connection if possible, and let the conn-to-stream
+				// loop continue until the connection's read side ends.
+				if cw, ok := h.conn.(interface{ CloseWrite() error }); ok && cw.CloseWrite() == nil {
+					dlog.Debugf(ctx, "   CONN %s, half-closed", id)
+					incoming = nil
+					readDone = h.readDone
+					continue
+				}
 				endReason = "there was no more input"
 				return
 			}
@@ -242,7 +269,7 @@ func (h *dialer) streamToConnLoop(ctx context.Context, wg *sync.WaitGroup) {
 				endReason = "it was idle for too long"
 				return
 			}
-			if dg.Code() != Normal {
+			if !dg.Code().IsData() {
 				h.handleControl(ctx, dg)
 				continue
 			}
@@ -266,8 +293,8 @@ func (h *dialer) streamToConnLoop(ctx context.Context, wg *sync.WaitGroup) {
 
 // DialWaitLoop reads from the given dialStream. A new goroutine that creates a Tunnel to the manager and then
 // attaches a dialer Endpoint to that tunnel is spawned for each request that arrives. The method blocks until
-// the dialStream is closed.
-func DialWaitLoop(ctx context.Context, manager rpc.ManagerClient, dialStream rpc.Manager_WatchDialClient, sessionID string) error {
+// the dialStream is closed. The dialers are added to the given pool while they're active, unless the pool is nil.
+func DialWaitLoop(ctx context.Context, manager rpc.ManagerClient, dialStream rpc.Manager_WatchDialClient, sessionID string, pool *Pool) error {
 	for ctx.Err() == nil {
 		dr, err := dialStream.Recv()
 		if err != nil {
@@ -276,12 +303,12 @@ func DialWaitLoop(ctx context.Context, manager rpc.ManagerClient, dialStream rpc
 			}
 			return nil
 		}
-		go dialRespond(ctx, manager, dr, sessionID)
+		go dialRespond(ctx, manager, dr, sessionID, pool)
 	}
 	return nil
 }
 
-func dialRespond(ctx context.Context, manager rpc.ManagerClient, dr *rpc.DialRequest, sessionID string) {
+func dialRespond(ctx context.Context, manager rpc.ManagerClient, dr *rpc.DialRequest, sessionID string, pool *Pool) {
 	id := ConnID(dr.ConnId)
 	mt, err := manager.Tunnel(ctx)
 	if err != nil {
@@ -294,6 +321,43 @@ func dialRespond(ctx context.Context, manager rpc.ManagerClient, dr *rpc.DialReq
 		return
 	}
 	d := NewDialer(s)
-	d.Start(ctx)
+	if pool == nil {
+		d.Start(ctx)
+		<-d.Done()
+		return
+	}
+	var release func()
+	_, found, err := pool.GetOrCreate(ctx, id, func(_ context.Context, r func()) (Handler, error) {
+		release = r
+		return d.(*dialer), nil
+	})
+	if err != nil {
+		dlog.Error(ctx, err)
+		return
+	}
+	if found {
+		dlog.Errorf(ctx, "!! CONN %s, a dialer for this connection is already active", id)
+		_ = s.CloseSend(ctx)
+		return
+	}
 	<-d.Done()
+	release()
+}
+
+// A DialRedirector returns the network and address that a dialer dials instead of the destination
+// of the given connection, or false when the destination should be dialed.
+type DialRedirector func(id ConnID) (network, address string, ok bool)
+
+type dialRedirectorKey struct{}
+
+// WithDialRedirector returns a context with the given DialRedirector. It's used by the dialers
+// that are started using that context.
+func WithDialRedirector(ctx context.Context, r DialRedirector) context.Context {
+	return context.WithValue(ctx, dialRedirectorKey{}, r)
+}
+
+// DialRedirectorFrom returns the DialRedirector of the given context, or nil if it has none.
+func DialRedirectorFrom(ctx context.Context) DialRedirector {
+	r, _ := ctx.Value(dialRedirectorKey{}).(DialRedirector)
+	return r
 }
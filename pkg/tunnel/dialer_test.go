@@ -0,0 +1,73 @@
+package tunnel
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/ipproto"
+	"github.com/telepresenceio/telepresence/v2/pkg/iputil"
+)
+
+func TestDialer_Redirect(t *testing.T) {
+	ctx, cancel := testContext(t, 5*time.Second)
+	defer cancel()
+
+	socketPath := filepath.Join(t.TempDir(), "app.sock")
+	l, err := net.Listen("unix", socketPath)
+	require.NoError(t, err)
+	defer l.Close()
+
+	id := NewConnID(ipproto.TCP, iputil.Parse("10.0.0.1"), iputil.Parse("127.0.0.1"), 4711, 8080)
+	ctx = WithDialRedirector(ctx, func(rid ConnID) (string, string, bool) {
+		if rid == id {
+			return "unix", socketPath, true
+		}
+		return "", "", false
+	})
+
+	// The dialer runs on the client side of the tunnel, like it does on a workstation that
+	// responds to a dial request.
+	tunnel := newBidi(10, ctx.Done())
+	clientCh := make(chan Stream, 1)
+	go func() {
+		client, err := NewClientStream(ctx, tunnel.clientSide(), id, uuid.New().String(), 0, time.Second)
+		assert.NoError(t, err)
+		clientCh <- client
+	}()
+	server, err := NewServerStream(ctx, tunnel.serverSide())
+	require.NoError(t, err)
+
+	d := NewDialer(<-clientCh)
+	d.Start(ctx)
+	m, err := server.Receive(ctx)
+	require.NoError(t, err)
+	require.Equal(t, DialOK, m.Code())
+
+	conn, err := l.Accept()
+	require.NoError(t, err)
+
+	// The dialer logs until it's done, and logging after the test has completed is a panic.
+	defer func() {
+		conn.Close()
+		cancel()
+		<-d.Done()
+	}()
+
+	require.NoError(t, server.Send(ctx, NewMessage(Normal, []byte("hello"))))
+	buf := make([]byte, 5)
+	_, err = conn.Read(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(buf))
+
+	_, err = conn.Write([]byte("world"))
+	require.NoError(t, err)
+	m, err = server.Receive(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "world", string(m.Payload()))
+}
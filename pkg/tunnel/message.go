@@ -5,6 +5,7 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"math"
 	"time"
 
 	"github.com/telepresenceio/telepresence/rpc/v2/manager"
@@ -22,8 +23,22 @@ const (
 	Disconnect
 	KeepAlive
 	Session
+
+	// Push is a Normal message that the receiver should deliver without delay, because the sender
+	// received it with the TCP PSH flag set. It's only sent to peers of version 3 or higher.
+	Push
+
+	// WindowUpdate tells the receiver how many bytes the sender is currently willing to accept,
+	// so that a sender that can't keep up can throttle the data that it's given. Receivers that
+	// don't know about it will ignore it.
+	WindowUpdate
 )
 
+// IsData returns true if the code is for a message that carries data, i.e. Normal or Push.
+func (c MessageCode) IsData() bool {
+	return c == Normal || c == Push
+}
+
 func (c MessageCode) String() string {
 	switch c {
 	case streamInfo:
@@ -42,6 +57,10 @@ func (c MessageCode) String() string {
 		return "KEEP_ALIVE"
 	case Session:
 		return "SESSION"
+	case Push:
+		return "PUSH"
+	case WindowUpdate:
+		return "WINDOW_UPDATE"
 	default:
 		return fmt.Sprintf("** unknown control code: %d **", c)
 	}
@@ -68,6 +87,9 @@ func (c msg) String() string {
 	if code == Normal {
 		return fmt.Sprintf("len %d", len(c.Payload()))
 	}
+	if code == Push {
+		return fmt.Sprintf("len %d, push", len(c.Payload()))
+	}
 	return fmt.Sprintf("code %s, len %d", code, len(c.Payload()))
 }
 
@@ -124,6 +146,34 @@ func GetSession(m Message) string {
 	return string(m.Payload())
 }
 
+// DialRejectMessage returns a DialReject message that carries the reason for the rejection.
+// Peers that don't know about the reason will ignore it.
+func DialRejectMessage(reason string) Message {
+	return NewMessage(DialReject, []byte(reason))
+}
+
+// GetRejectReason returns the reason carried by a DialReject message, or an empty string when
+// the peer didn't give one.
+func GetRejectReason(m Message) string {
+	return string(m.Payload())
+}
+
+// WindowUpdateMessage returns a WindowUpdate message for a receive window of the given size.
+func WindowUpdateMessage(size uint32) Message {
+	m := makeMessage(WindowUpdate, binary.MaxVarintLen32)
+	n := binary.PutUvarint(m.Payload(), uint64(size))
+	return m[:n+1]
+}
+
+// GetWindowSize returns the size of the receive window that a WindowUpdate message carries.
+func GetWindowSize(m Message) (uint32, error) {
+	v, n := binary.Uvarint(m.Payload())
+	if n <= 0 || v > math.MaxUint32 {
+		return 0, errors.New("malformed WindowUpdate message")
+	}
+	return uint32(v), nil
+}
+
 func makeMessage(code MessageCode, payloadLength int) msg {
 	m := make(msg, 1+payloadLength)
 	m[0] = byte(code)
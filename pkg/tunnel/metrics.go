@@ -0,0 +1,124 @@
+package tunnel
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// Counters are running totals that the handlers of a Pool add to. They are kept by the Pool, so
+// that aggregated metrics can be obtained without visiting each handler.
+type Counters struct {
+	bytesIn     uint64
+	bytesOut    uint64
+	retransmits uint64
+
+	zeroWindowStalls uint64
+	zeroWindowTime   int64
+}
+
+// AddBytesIn adds n to the number of bytes that were received from the cluster. It is a no-op
+// when c is nil.
+func (c *Counters) AddBytesIn(n uint64) {
+	if c != nil {
+		atomic.AddUint64(&c.bytesIn, n)
+	}
+}
+
+// AddBytesOut adds n to the number of bytes that were sent to the cluster. It is a no-op when c
+// is nil.
+func (c *Counters) AddBytesOut(n uint64) {
+	if c != nil {
+		atomic.AddUint64(&c.bytesOut, n)
+	}
+}
+
+// AddRetransmits adds n to the number of retransmitted segments. It is a no-op when c is nil.
+func (c *Counters) AddRetransmits(n uint64) {
+	if c != nil {
+		atomic.AddUint64(&c.retransmits, n)
+	}
+}
+
+// AddZeroWindowStall adds a stall of the given duration, caused by a receiver that didn't accept
+// more data, to the zero-window counters. It is a no-op when c is nil.
+func (c *Counters) AddZeroWindowStall(d time.Duration) {
+	if c != nil {
+		atomic.AddUint64(&c.zeroWindowStalls, 1)
+		atomic.AddInt64(&c.zeroWindowTime, int64(d))
+	}
+}
+
+type countersKey struct{}
+
+// WithCounters returns a context with the given Counters.
+func WithCounters(ctx context.Context, c *Counters) context.Context {
+	return context.WithValue(ctx, countersKey{}, c)
+}
+
+// CountersFrom returns the Counters of the given context, or nil if it has none. The Pool adds
+// its Counters to the context that it passes to the handlers that it creates.
+func CountersFrom(ctx context.Context) *Counters {
+	c, ok := ctx.Value(countersKey{}).(*Counters)
+	if !ok {
+		return nil
+	}
+	return c
+}
+
+// StateReporter is implemented by handlers that have a connection state, such as the TCP
+// handlers. The Pool counts such handlers per state in its Metrics.
+type StateReporter interface {
+	// State returns the name of the handler's current state, e.g. "CLOSE_WAIT".
+	State() string
+}
+
+// Metrics are the aggregated metrics of the handlers in a Pool. The byte and retransmit counts
+// include handlers that have been released.
+type Metrics struct {
+	ActiveHandlers int
+	BytesIn        uint64
+	BytesOut       uint64
+	Retransmits    uint64
+
+	// ZeroWindowStalls is the number of times that a handler had to wait for its receiver to
+	// accept more data, and ZeroWindowTime is the total time spent waiting.
+	ZeroWindowStalls uint64
+	ZeroWindowTime   time.Duration
+
+	// HandlerStates is the number of active handlers in each state, for the handlers that are
+	// StateReporters. It's nil when there are no such handlers.
+	HandlerStates map[string]int
+}
+
+// Metrics returns the aggregated metrics of the handlers in the pool.
+func (p *Pool) Metrics() Metrics {
+	p.lock.RLock()
+	count := len(p.handlers)
+	var reporters []StateReporter
+	for _, h := range p.handlers {
+		if sr, ok := h.(StateReporter); ok {
+			reporters = append(reporters, sr)
+		}
+	}
+	p.lock.RUnlock()
+
+	// The states are obtained without holding the lock, because a handler may need to
+	// release itself while it's asked.
+	var states map[string]int
+	if len(reporters) > 0 {
+		states = make(map[string]int)
+		for _, sr := range reporters {
+			states[sr.State()]++
+		}
+	}
+	return Metrics{
+		ActiveHandlers:   count,
+		BytesIn:          atomic.LoadUint64(&p.counters.bytesIn),
+		BytesOut:         atomic.LoadUint64(&p.counters.bytesOut),
+		Retransmits:      atomic.LoadUint64(&p.counters.retransmits),
+		ZeroWindowStalls: atomic.LoadUint64(&p.counters.zeroWindowStalls),
+		ZeroWindowTime:   time.Duration(atomic.LoadInt64(&p.counters.zeroWindowTime)),
+		HandlerStates:    states,
+	}
+}
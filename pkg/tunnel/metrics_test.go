@@ -0,0 +1,109 @@
+package tunnel
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/ipproto"
+)
+
+// countingHandler adds to the counters of its context when it's started.
+type countingHandler struct {
+	in, out, retransmits uint64
+	release              func()
+}
+
+func (h *countingHandler) Stop(context.Context) {
+	h.release()
+}
+
+func (h *countingHandler) Start(ctx context.Context) {
+	c := CountersFrom(ctx)
+	c.AddBytesIn(h.in)
+	c.AddBytesOut(h.out)
+	c.AddRetransmits(h.retransmits)
+}
+
+func TestPool_Metrics(t *testing.T) {
+	ctx := context.Background()
+	pool := NewPool()
+	assert.Equal(t, Metrics{}, pool.Metrics())
+
+	src := net.IP{192, 168, 0, 1}
+	dst := net.IP{10, 0, 0, 1}
+	h1 := &countingHandler{in: 1000, out: 200, retransmits: 1}
+	h2 := &countingHandler{in: 3000, out: 400, retransmits: 2}
+	for i, h := range []*countingHandler{h1, h2} {
+		h := h
+		id := NewConnID(ipproto.TCP, src, dst, uint16(4000+i), 8080)
+		_, found, err := pool.GetOrCreate(ctx, id, func(_ context.Context, release func()) (Handler, error) {
+			h.release = release
+			return h, nil
+		})
+		require.NoError(t, err)
+		require.False(t, found)
+	}
+	assert.Equal(t, Metrics{ActiveHandlers: 2, BytesIn: 4000, BytesOut: 600, Retransmits: 3}, pool.Metrics())
+
+	// Released handlers no longer count as active, but their bytes remain in the totals
+	h1.Stop(ctx)
+	assert.Equal(t, Metrics{ActiveHandlers: 1, BytesIn: 4000, BytesOut: 600, Retransmits: 3}, pool.Metrics())
+}
+
+func TestCounters_Nil(t *testing.T) {
+	c := CountersFrom(context.Background())
+	require.Nil(t, c)
+	c.AddBytesIn(1)
+	c.AddBytesOut(1)
+	c.AddRetransmits(1)
+}
+
+// stateHandler is a handler that reports a state.
+type stateHandler struct {
+	countingHandler
+	state string
+}
+
+func (h *stateHandler) State() string {
+	return h.state
+}
+
+func TestPool_MetricsHandlerStates(t *testing.T) {
+	ctx := context.Background()
+	pool := NewPool()
+
+	src := net.IP{192, 168, 0, 1}
+	dst := net.IP{10, 0, 0, 1}
+	handlers := []Handler{
+		&stateHandler{state: "ESTABLISHED"},
+		&stateHandler{state: "CLOSE_WAIT"},
+		&stateHandler{state: "CLOSE_WAIT"},
+		&countingHandler{}, // has no state, so it isn't counted
+	}
+	for i, h := range handlers {
+		h := h
+		id := NewConnID(ipproto.TCP, src, dst, uint16(4000+i), 8080)
+		_, _, err := pool.GetOrCreate(ctx, id, func(context.Context, func()) (Handler, error) {
+			return h, nil
+		})
+		require.NoError(t, err)
+	}
+	m := pool.Metrics()
+	assert.Equal(t, 4, m.ActiveHandlers)
+	assert.Equal(t, map[string]int{"ESTABLISHED": 1, "CLOSE_WAIT": 2}, m.HandlerStates)
+}
+
+func TestCounters_ZeroWindowStall(t *testing.T) {
+	pool := NewPool()
+	c := CountersFrom(WithCounters(context.Background(), &pool.counters))
+	c.AddZeroWindowStall(time.Second)
+	c.AddZeroWindowStall(500 * time.Millisecond)
+	m := pool.Metrics()
+	assert.Equal(t, uint64(2), m.ZeroWindowStalls)
+	assert.Equal(t, 1500*time.Millisecond, m.ZeroWindowTime)
+}
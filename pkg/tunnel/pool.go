@@ -3,6 +3,7 @@ package tunnel
 import (
 	"context"
 	"errors"
+	"net"
 	"sync"
 
 	"github.com/datawire/dlib/dlog"
@@ -11,6 +12,7 @@ import (
 type Pool struct {
 	handlers map[ConnID]Handler
 	lock     sync.RWMutex
+	counters Counters
 }
 
 type Handler interface {
@@ -55,7 +57,7 @@ func (p *Pool) GetOrCreate(ctx context.Context, id ConnID, createHandler Handler
 		return handler, true, nil
 	}
 
-	handlerCtx, cancel := context.WithCancel(ctx)
+	handlerCtx, cancel := context.WithCancel(WithCounters(ctx, &p.counters))
 	release := func() {
 		p.release(ctx, id)
 		cancel()
@@ -86,6 +88,39 @@ func (p *Pool) GetOrCreate(ctx context.Context, id ConnID, createHandler Handler
 	return handler, false, nil
 }
 
+// Range calls f for each handler in the pool until f returns false. The handlers are collected
+// before f is called, so f may add handlers to, or remove handlers from, the pool.
+func (p *Pool) Range(f func(ConnID, Handler) bool) {
+	p.lock.RLock()
+	ids := make([]ConnID, 0, len(p.handlers))
+	handlers := make([]Handler, 0, len(p.handlers))
+	for id, handler := range p.handlers {
+		ids = append(ids, id)
+		handlers = append(handlers, handler)
+	}
+	p.lock.RUnlock()
+
+	for i, handler := range handlers {
+		if !f(ids[i], handler) {
+			return
+		}
+	}
+}
+
+// HandlersTo returns the handlers of the connections to the given destination. A nil ip matches
+// all destination IPs, and a zero port matches all destination ports.
+func (p *Pool) HandlersTo(ip net.IP, port uint16) map[ConnID]Handler {
+	found := make(map[ConnID]Handler)
+	p.lock.RLock()
+	for id, handler := range p.handlers {
+		if (ip == nil || ip.Equal(id.Destination())) && (port == 0 || port == id.DestinationPort()) {
+			found[id] = handler
+		}
+	}
+	p.lock.RUnlock()
+	return found
+}
+
 func (p *Pool) CloseAll(ctx context.Context) {
 	p.lock.RLock()
 	handlers := make([]Handler, len(p.handlers))
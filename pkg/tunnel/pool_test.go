@@ -0,0 +1,48 @@
+package tunnel
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/ipproto"
+)
+
+func TestPool_HandlersTo(t *testing.T) {
+	ctx := context.Background()
+	pool := NewPool()
+
+	src := net.IP{192, 168, 0, 1}
+	dst1 := net.IP{10, 0, 0, 1}
+	dst2 := net.IP{10, 0, 0, 2}
+	ids := []ConnID{
+		NewConnID(ipproto.TCP, src, dst1, 4000, 80),
+		NewConnID(ipproto.TCP, src, dst1, 4001, 443),
+		NewConnID(ipproto.TCP, src, dst2, 4002, 80),
+		NewConnID(ipproto.UDP, src, dst2, 4003, 53),
+	}
+	for _, id := range ids {
+		_, _, err := pool.GetOrCreate(ctx, id, func(_ context.Context, release func()) (Handler, error) {
+			return &countingHandler{release: release}, nil
+		})
+		require.NoError(t, err)
+	}
+
+	keys := func(m map[ConnID]Handler) []ConnID {
+		var found []ConnID
+		for id := range m {
+			found = append(found, id)
+		}
+		return found
+	}
+	assert.ElementsMatch(t, ids, keys(pool.HandlersTo(nil, 0)))
+	assert.ElementsMatch(t, ids[:2], keys(pool.HandlersTo(dst1, 0)))
+	assert.ElementsMatch(t, []ConnID{ids[0], ids[2]}, keys(pool.HandlersTo(nil, 80)))
+	assert.ElementsMatch(t, ids[2:3], keys(pool.HandlersTo(dst2, 80)))
+	assert.ElementsMatch(t, ids[2:3], keys(pool.HandlersTo(dst2.To16(), 80)))
+	assert.Empty(t, pool.HandlersTo(dst1, 53))
+	assert.Empty(t, pool.HandlersTo(net.IP{10, 0, 0, 3}, 0))
+}
@@ -15,7 +15,16 @@ import (
 // Version
 //   0 which didn't report versions and didn't do synchronization
 //   1 used MuxTunnel instead of one tunnel per connection.
-const Version = uint16(2)
+//   2 didn't support the Push message code.
+const Version = uint16(3)
+
+// pushVersion is the first version that supports the Push message code.
+const pushVersion = uint16(3)
+
+// SupportsPush returns true if the peer of the given stream understands the Push message code.
+func SupportsPush(s Stream) bool {
+	return s.PeerVersion() >= pushVersion
+}
 
 // Endpoint is an endpoint for a Stream such as a Dialer or a bidirectional pipe.
 type Endpoint interface {
@@ -67,7 +76,7 @@ type Stream interface {
 // will be max one error since the error also terminates the loop.
 func ReadLoop(ctx context.Context, s Stream) (<-chan Message, <-chan error) {
 	msgCh := make(chan Message)
-	errCh := make(chan error)
+	errCh := make(chan error, 1)
 	dlog.Debugf(ctx, "   %s %s, ReadLoop starting", s.Tag(), s.ID())
 	go func() {
 		defer func() {
@@ -77,14 +86,12 @@ func ReadLoop(ctx context.Context, s Stream) (<-chan Message, <-chan error) {
 		for ctx.Err() == nil {
 			m, err := s.Receive(ctx)
 			if err != nil {
-				close(msgCh) // Must close before posting the error to avoid potential deadlock
+				// The errCh is buffered, so the error is posted before the msgCh is closed. A
+				// reader that finds the msgCh closed can therefore tell if the read failed.
 				if ctx.Err() == nil && !(errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed)) {
-					err = fmt.Errorf("!! %s %s, read from grpc.ClientStream failed", s.Tag(), s.ID())
-					select {
-					case errCh <- err:
-					default:
-					}
+					errCh <- fmt.Errorf("!! %s %s, read from grpc.ClientStream failed: %w", s.Tag(), s.ID(), err)
 				}
+				close(msgCh)
 				return
 			}
 			select {
@@ -3,6 +3,7 @@ package tunnel
 import (
 	"context"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -12,6 +13,9 @@ type TimedHandler struct {
 	remove    func()
 	idleTimer *time.Timer
 	idleLock  sync.Mutex
+
+	// lastActivity is the time, in Unix nanoseconds, of when the handler was started or last reset
+	lastActivity int64
 }
 
 func NewTimedHandler(id ConnID, ttl time.Duration, remove func()) TimedHandler {
@@ -35,7 +39,13 @@ func (h *TimedHandler) SetTTL(ttl time.Duration) {
 	h.idleLock.Unlock()
 }
 
+// LastActivity returns the time when the handler was started or when its idle timer was last reset.
+func (h *TimedHandler) LastActivity() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&h.lastActivity))
+}
+
 func (h *TimedHandler) ResetIdle() bool {
+	atomic.StoreInt64(&h.lastActivity, time.Now().UnixNano())
 	h.idleLock.Lock()
 	stopped := h.idleTimer.Stop()
 	if stopped {
@@ -46,6 +56,7 @@ func (h *TimedHandler) ResetIdle() bool {
 }
 
 func (h *TimedHandler) Start(_ context.Context) {
+	atomic.StoreInt64(&h.lastActivity, time.Now().UnixNano())
 	h.idleLock.Lock()
 	h.idleTimer = time.NewTimer(h.ttl)
 	h.idleLock.Unlock()
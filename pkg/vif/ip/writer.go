@@ -1,7 +1,23 @@
 package ip
 
-import "context"
+import (
+	"context"
+	"errors"
+	"syscall"
+)
 
 type Writer interface {
 	Write(context.Context, Packet) error
 }
+
+// IsRetriable returns true when the given error, returned from a Writer, denotes a transient
+// condition, so that the write may succeed when it's retried. That's the case for the EAGAIN,
+// EINTR, and ENOBUFS errors of the OS, and for errors that have a Temporary method that
+// returns true.
+func IsRetriable(err error) bool {
+	if errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.EINTR) || errors.Is(err, syscall.ENOBUFS) {
+		return true
+	}
+	var te interface{ Temporary() bool }
+	return errors.As(err, &te) && te.Temporary()
+}
@@ -0,0 +1,22 @@
+package ip
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsRetriable(t *testing.T) {
+	assert.True(t, IsRetriable(syscall.EAGAIN))
+	assert.True(t, IsRetriable(&os.PathError{Op: "write", Path: "/dev/net/tun", Err: syscall.ENOBUFS}))
+	assert.True(t, IsRetriable(fmt.Errorf("write failed: %w", syscall.EINTR)))
+	assert.True(t, IsRetriable(&net.DNSError{IsTemporary: true}))
+	assert.False(t, IsRetriable(nil))
+	assert.False(t, IsRetriable(errors.New("device gone")))
+	assert.False(t, IsRetriable(&os.PathError{Op: "write", Path: "/dev/net/tun", Err: syscall.EBADF}))
+}
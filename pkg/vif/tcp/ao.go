@@ -0,0 +1,348 @@
+package tcp
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha1" //nolint:gosec // HMAC-SHA-1-96 is mandated by RFC 5926
+	"crypto/subtle"
+	"encoding/binary"
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/ipproto"
+	"github.com/telepresenceio/telepresence/v2/pkg/vif/ip"
+)
+
+// AOAlgorithm is a MAC algorithm that can be used with the TCP Authentication Option (RFC 5926).
+type AOAlgorithm int
+
+const (
+	// AOHMACSHA1 is HMAC-SHA-1-96 using KDF_HMAC_SHA1.
+	AOHMACSHA1 = AOAlgorithm(iota)
+
+	// AOAESCMAC is AES-128-CMAC-96 using KDF_AES_128_CMAC.
+	AOAESCMAC
+)
+
+func (a AOAlgorithm) String() string {
+	switch a {
+	case AOHMACSHA1:
+		return "HMAC-SHA-1-96"
+	case AOAESCMAC:
+		return "AES-128-CMAC-96"
+	default:
+		return "unknown"
+	}
+}
+
+// AuthOption is the master key tuple used by the TCP Authentication Option (RFC 5925).
+type AuthOption struct {
+	// Algorithm is the MAC algorithm.
+	Algorithm AOAlgorithm
+
+	// Key is the master key that the traffic keys of each connection are derived from.
+	Key []byte
+
+	// SendID is the KeyID that the handler puts in the segments that it sends.
+	SendID uint8
+
+	// RecvID is the KeyID that the peer must use in the segments that it sends.
+	RecvID uint8
+}
+
+const (
+	authOption = optionKind(29)
+
+	// aoMACLen is the length of the MAC produced by both supported algorithms (96 bits).
+	aoMACLen = 12
+
+	// aoOptionLen is the length of the option: kind, length, KeyID, RNextKeyID, and the MAC.
+	aoOptionLen = 4 + aoMACLen
+)
+
+// aoConn contains the traffic keys and the sequence number extensions of a connection that uses
+// the TCP Authentication Option.
+type aoConn struct {
+	cfg       *AuthOption
+	localISN  uint32
+	remoteISN uint32
+	sendKey   []byte
+	recvKey   []byte
+
+	// sendLock protects sendSNE. Segments are signed by several goroutines.
+	sendLock sync.Mutex
+	sendSNE  sneTracker
+
+	// recvSNE is only used by the goroutine that processes the packets.
+	recvSNE sneTracker
+}
+
+// newAOConn derives the traffic keys for the connection with the given initial sequence numbers.
+func newAOConn(cfg *AuthOption, local, remote net.IP, localPort, remotePort uint16, localISN, remoteISN uint32) *aoConn {
+	return &aoConn{
+		cfg:       cfg,
+		localISN:  localISN,
+		remoteISN: remoteISN,
+		sendKey:   aoTrafficKey(cfg, local, remote, localPort, remotePort, localISN, remoteISN),
+		recvKey:   aoTrafficKey(cfg, remote, local, remotePort, localPort, remoteISN, localISN),
+	}
+}
+
+// sign returns a copy of the given packet that has the authentication option added to its TCP header.
+func (a *aoConn) sign(pkt Packet) Packet {
+	a.sendLock.Lock()
+	sne := a.sendSNE.sne(pkt.Header().Sequence())
+	a.sendSNE.update(pkt.Header().Sequence())
+	a.sendLock.Unlock()
+	return aoSign(a.cfg, a.sendKey, sne, pkt)
+}
+
+// verify returns true if the given packet carries a valid authentication option.
+func (a *aoConn) verify(pkt Packet) bool {
+	seq := pkt.Header().Sequence()
+	if !aoVerify(a.cfg, a.recvKey, a.recvSNE.sne(seq), pkt) {
+		return false
+	}
+	a.recvSNE.update(seq)
+	return true
+}
+
+// authenticate returns true if the given packet is authentic. It always returns true when the
+// handler has no AuthOption. The initial SYN is verified using a traffic key that is derived from
+// its own sequence number. All other segments are verified using the traffic key of the connection.
+func (h *handler) authenticate(ctx context.Context, pkt Packet) bool {
+	cfg := h.cfg.AuthOption
+	if cfg == nil {
+		return true
+	}
+	var ok bool
+	tcpHdr := pkt.Header()
+	switch {
+	case h.ao != nil:
+		ok = h.ao.verify(pkt)
+	case tcpHdr.SYN() && !tcpHdr.ACK():
+		key := aoTrafficKey(cfg, h.id.Source(), h.id.Destination(), h.id.SourcePort(), h.id.DestinationPort(), tcpHdr.Sequence(), 0)
+		ok = aoVerify(cfg, key, 0, pkt)
+	}
+	if !ok {
+		atomic.AddUint64(&h.authFailures, 1)
+		dlog.Debugf(ctx, "!! CON %s, discarding %s segment that failed authentication", h.name, pkt)
+	}
+	return ok
+}
+
+// findAuthOption returns the authentication option of the given header, or nil if it has none.
+func findAuthOption(tcpHdr Header) option {
+	opts, err := options(tcpHdr)
+	if err != nil {
+		return nil
+	}
+	for _, opt := range opts {
+		if opt.kind() == authOption {
+			return opt
+		}
+	}
+	return nil
+}
+
+// aoSign returns a copy of the given packet where the authentication option, computed using the
+// given traffic key, is appended to the TCP options.
+func aoSign(cfg *AuthOption, key []byte, sne uint32, orig Packet) Packet {
+	origHdr := orig.Header()
+	hl := origHdr.DataOffset() * 4
+	origIP := orig.IPHeader()
+	ipLen := origIP.PayloadLen() + aoOptionLen
+	pkt := NewPacket(ipLen, origIP.Source(), origIP.Destination(), true)
+	ipHdr := pkt.IPHeader()
+	ipHdr.SetL4Protocol(ipproto.TCP)
+	ipHdr.SetPayloadLen(ipLen)
+	ipHdr.SetChecksum()
+
+	tcpHdr := pkt.Header()
+	copy(tcpHdr, origHdr[:hl])
+	o := tcpHdr[hl : hl+aoOptionLen]
+	o[0] = byte(authOption)
+	o[1] = aoOptionLen
+	o[2] = cfg.SendID
+	o[3] = cfg.RecvID
+	copy(tcpHdr[hl+aoOptionLen:], origHdr[hl:])
+	tcpHdr.SetDataOffset((hl + aoOptionLen) / 4)
+
+	copy(o[4:], aoMAC(cfg.Algorithm, key, sne, ipHdr, tcpHdr, o[4:]))
+	tcpHdr.SetChecksum(ipHdr)
+	return pkt
+}
+
+// aoVerify returns true if the given packet has an authentication option with the expected KeyID
+// and a MAC that matches the one computed using the given traffic key.
+func aoVerify(cfg *AuthOption, key []byte, sne uint32, pkt Packet) bool {
+	tcpHdr := pkt.Header()
+	opt := findAuthOption(tcpHdr)
+	if opt == nil || opt.len() != aoOptionLen || opt[2] != cfg.RecvID {
+		return false
+	}
+	mac := make([]byte, aoMACLen)
+	copy(mac, opt[4:])
+	expected := aoMAC(cfg.Algorithm, key, sne, pkt.IPHeader(), tcpHdr, opt[4:])
+	copy(opt[4:], mac)
+	return subtle.ConstantTimeCompare(mac, expected) == 1
+}
+
+// aoMAC computes the MAC of a segment as described in RFC 5925 section 5.1. The given mac slice
+// is the MAC field of the segment's authentication option. It's zeroed by this function.
+func aoMAC(alg AOAlgorithm, key []byte, sne uint32, ipHdr ip.Header, tcpHdr Header, mac []byte) []byte {
+	for i := range mac {
+		mac[i] = 0
+	}
+	ph := ipHdr.PseudoHeader(ipproto.TCP)
+	hl := tcpHdr.DataOffset() * 4
+	msg := make([]byte, 4, 4+len(ph)+len(tcpHdr))
+	binary.BigEndian.PutUint32(msg, sne)
+	msg = append(msg, ph...)
+	msg = append(msg, tcpHdr[:hl]...)
+	msg[4+len(ph)+16] = 0 // the checksum is excluded
+	msg[4+len(ph)+17] = 0
+	msg = append(msg, tcpHdr[hl:]...)
+	return aoPRF(alg, key, msg)[:aoMACLen]
+}
+
+// aoTrafficKey derives a traffic key from the master key as described in RFC 5926 section 3.1. The
+// context is the connection as seen by the sender of the segments that the key is used for.
+func aoTrafficKey(cfg *AuthOption, src, dst net.IP, srcPort, dstPort uint16, srcISN, dstISN uint32) []byte {
+	if v4 := src.To4(); v4 != nil {
+		src, dst = v4, dst.To4()
+	}
+	input := make([]byte, 0, 1+6+2*len(src)+12+2)
+	input = append(input, 1)
+	input = append(input, "TCP-AO"...)
+	input = append(input, src...)
+	input = append(input, dst...)
+	n := len(input)
+	input = input[:n+14]
+	binary.BigEndian.PutUint16(input[n:], srcPort)
+	binary.BigEndian.PutUint16(input[n+2:], dstPort)
+	binary.BigEndian.PutUint32(input[n+4:], srcISN)
+	binary.BigEndian.PutUint32(input[n+8:], dstISN)
+	key := cfg.Key
+	var bits uint16
+	if cfg.Algorithm == AOAESCMAC {
+		bits = 128
+		if len(key) != aes.BlockSize {
+			key = aesCMAC(make([]byte, aes.BlockSize), key)
+		}
+	} else {
+		bits = 160
+	}
+	binary.BigEndian.PutUint16(input[n+12:], bits)
+	return aoPRF(cfg.Algorithm, key, input)
+}
+
+// aoPRF is the pseudo-random function of the given algorithm.
+func aoPRF(alg AOAlgorithm, key, msg []byte) []byte {
+	if alg == AOAESCMAC {
+		return aesCMAC(key, msg)
+	}
+	m := hmac.New(sha1.New, key)
+	m.Write(msg)
+	return m.Sum(nil)
+}
+
+// aesCMAC computes the AES-CMAC (RFC 4493) of the given message using a 128 bit key.
+func aesCMAC(key, msg []byte) []byte {
+	c, err := aes.NewCipher(key)
+	if err != nil {
+		panic(err) // key length is always aes.BlockSize
+	}
+	k1, k2 := cmacSubkeys(c)
+	n := (len(msg) + aes.BlockSize - 1) / aes.BlockSize
+	last := make([]byte, aes.BlockSize)
+	if n > 0 && len(msg)%aes.BlockSize == 0 {
+		xorBlock(last, msg[(n-1)*aes.BlockSize:], k1)
+	} else {
+		if n == 0 {
+			n = 1
+		}
+		rest := msg[(n-1)*aes.BlockSize:]
+		copy(last, rest)
+		last[len(rest)] = 0x80
+		xorBlock(last, last, k2)
+	}
+	x := make([]byte, aes.BlockSize)
+	for i := 0; i < n-1; i++ {
+		xorBlock(x, x, msg[i*aes.BlockSize:])
+		c.Encrypt(x, x)
+	}
+	xorBlock(x, x, last)
+	c.Encrypt(x, x)
+	return x
+}
+
+// xorBlock sets dst to the xor of the first aes.BlockSize bytes of a and b.
+func xorBlock(dst, a, b []byte) {
+	for i := 0; i < aes.BlockSize; i++ {
+		dst[i] = a[i] ^ b[i]
+	}
+}
+
+// cmacSubkeys generates the two subkeys of AES-CMAC.
+func cmacSubkeys(c cipher.Block) ([]byte, []byte) {
+	l := make([]byte, aes.BlockSize)
+	c.Encrypt(l, l)
+	k1 := cmacDouble(l)
+	return k1, cmacDouble(k1)
+}
+
+// cmacDouble multiplies the given block by x in GF(2^128).
+func cmacDouble(b []byte) []byte {
+	d := make([]byte, aes.BlockSize)
+	for i := 0; i < aes.BlockSize-1; i++ {
+		d[i] = b[i]<<1 | b[i+1]>>7
+	}
+	d[aes.BlockSize-1] = b[aes.BlockSize-1] << 1
+	if b[0]&0x80 != 0 {
+		d[aes.BlockSize-1] ^= 0x87
+	}
+	return d
+}
+
+// sneTracker keeps track of the sequence number extension (SNE), i.e. the number of times that the
+// 32 bit sequence numbers in one direction of a connection have wrapped.
+type sneTracker struct {
+	valid bool
+	high  uint32
+	last  uint32
+}
+
+// sne returns the extension of the given sequence number. A sequence that precedes the last wrap
+// (a retransmit) gets the previous extension.
+func (s *sneTracker) sne(seq uint32) uint32 {
+	if s.valid {
+		after := int32(seq-s.last) > 0
+		switch {
+		case after && seq < s.last:
+			return s.high + 1
+		case !after && seq > s.last:
+			return s.high - 1
+		}
+	}
+	return s.high
+}
+
+// update records the given sequence number of an authentic segment.
+func (s *sneTracker) update(seq uint32) {
+	if !s.valid {
+		s.valid = true
+		s.last = seq
+		return
+	}
+	if int32(seq-s.last) > 0 {
+		if seq < s.last {
+			s.high++
+		}
+		s.last = seq
+	}
+}
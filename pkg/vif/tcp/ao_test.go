@@ -0,0 +1,206 @@
+package tcp
+
+import (
+	"context"
+	"encoding/hex"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/tunnel"
+)
+
+func TestAESCMAC(t *testing.T) {
+	// Test vectors from RFC 4493, section 4.
+	key, _ := hex.DecodeString("2b7e151628aed2a6abf7158809cf4f3c")
+	msg, _ := hex.DecodeString("6bc1bee22e409f96e93d7e117393172aae2d8a571e03ac9c9eb76fac45af8e5130c81c46a35ce411")
+	tests := []struct {
+		len int
+		mac string
+	}{
+		{0, "bb1d6929e95937287fa37d129b756746"},
+		{16, "070a16b46b4d4144f79bdd9dd04a287c"},
+		{40, "dfa66747de9ae63030ca32611497c827"},
+	}
+	for _, tt := range tests {
+		require.Equal(t, tt.mac, hex.EncodeToString(aesCMAC(key, msg[:tt.len])), "message length %d", tt.len)
+	}
+}
+
+func TestSNETracker(t *testing.T) {
+	var s sneTracker
+	s.update(0xfffffff0)
+	require.Equal(t, uint32(0), s.sne(0xfffffff8))
+	require.Equal(t, uint32(1), s.sne(0x10))
+	s.update(0x10)
+	require.Equal(t, uint32(1), s.sne(0x20))
+	require.Equal(t, uint32(0), s.sne(0xfffffff8), "retransmit from before the wrap")
+}
+
+// aoTestPeer is a testPeer that uses the TCP Authentication Option.
+type aoTestPeer struct {
+	*testPeer
+	cfg *AuthOption
+	ao  *aoConn
+}
+
+func newAOTestPeer(ctx context.Context, t *testing.T, alg AOAlgorithm) *aoTestPeer {
+	key := []byte("the master key")
+	p := &aoTestPeer{
+		testPeer: newTestPeer(ctx, t, HandlerConfig{AuthOption: &AuthOption{Algorithm: alg, Key: key, SendID: 1, RecvID: 2}}),
+		cfg:      &AuthOption{Algorithm: alg, Key: key, SendID: 2, RecvID: 1},
+	}
+	p.sign = func(pkt Packet) Packet {
+		defer pkt.Release()
+		if p.ao != nil {
+			return p.ao.sign(pkt)
+		}
+		id := p.id
+		return aoSign(p.cfg, aoTrafficKey(p.cfg, id.Source(), id.Destination(), id.SourcePort(), id.DestinationPort(), pkt.Header().Sequence(), 0), 0, pkt)
+	}
+	return p
+}
+
+// connect performs the three-way handshake and derives the traffic keys of the connection.
+func (p *aoTestPeer) connect(ctx context.Context) {
+	isn := p.seq
+	p.testPeer.send(ctx, true, false, false, nil)
+	synAck := p.recv()
+	id := p.id
+	p.ao = newAOConn(p.cfg, id.Source(), id.Destination(), id.SourcePort(), id.DestinationPort(), isn, synAck.Header().Sequence())
+	require.True(p.t, p.ao.verify(synAck), "SYN-ACK is not authentic")
+	require.True(p.t, synAck.Header().SYN())
+	p.ack = synAck.Header().Sequence() + 1
+	p.send(ctx, false, true, false, nil)
+	require.Eventually(p.t, func() bool { return p.h.state() == stateEstablished }, 5*time.Second, time.Millisecond)
+}
+
+// recvAuthentic returns the next packet that the handler writes to the TUN device and verifies it.
+func (p *aoTestPeer) recvAuthentic() Header {
+	pkt := p.recv()
+	require.True(p.t, p.ao.verify(pkt), "segment is not authentic")
+	return pkt.Header()
+}
+
+func TestHandler_AuthOption(t *testing.T) {
+	for _, alg := range []AOAlgorithm{AOHMACSHA1, AOAESCMAC} {
+		t.Run(alg.String(), func(t *testing.T) {
+			ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+			defer cancel()
+			p := newAOTestPeer(ctx, t, alg)
+			p.connect(ctx)
+
+			// Steady state, both directions.
+			p.send(ctx, false, true, false, []byte("hello"))
+			select {
+			case m := <-p.stream.toMgr:
+				require.Equal(t, []byte("hello"), m.Payload())
+			case <-time.After(5 * time.Second):
+				t.Fatal("timeout waiting for message to manager")
+			}
+			require.Equal(t, p.seq, p.recvAuthentic().AckNumber())
+
+			p.stream.fromMgr <- tunnel.NewMessage(tunnel.Normal, []byte("world"))
+			data := p.recvAuthentic()
+			require.Equal(t, []byte("world"), data.Payload())
+			p.ack += 5
+			p.send(ctx, false, true, false, nil)
+			require.Equal(t, uint64(0), p.h.Stats().AuthFailures)
+		})
+	}
+}
+
+func TestHandler_AuthOptionTampered(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+	p := newAOTestPeer(ctx, t, AOHMACSHA1)
+	p.connect(ctx)
+
+	// A segment that is modified after it was signed is discarded.
+	sign := p.sign
+	p.sign = func(pkt Packet) Packet {
+		signed := sign(pkt)
+		signed.Header().Payload()[0] = 'j'
+		signed.Header().SetChecksum(signed.IPHeader())
+		return signed
+	}
+	p.send(ctx, false, true, false, []byte("hello"))
+	require.Eventually(t, func() bool { return p.h.Stats().AuthFailures == 1 }, 5*time.Second, time.Millisecond)
+	select {
+	case m := <-p.stream.toMgr:
+		t.Fatalf("tampered segment was delivered: %q", m.Payload())
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// The peer retransmits the segment, this time unmodified.
+	p.sign = sign
+	p.seq -= 5
+	p.send(ctx, false, true, false, []byte("hello"))
+	select {
+	case m := <-p.stream.toMgr:
+		require.Equal(t, []byte("hello"), m.Payload())
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for message to manager")
+	}
+	require.Equal(t, p.seq, p.recvAuthentic().AckNumber())
+}
+
+func TestHandler_AuthOptionMissing(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+	p := newAOTestPeer(ctx, t, AOAESCMAC)
+
+	// A SYN without the option is discarded and the connection is never established.
+	p.sign = nil
+	p.send(ctx, true, false, false, nil)
+	require.Eventually(t, func() bool { return p.h.Stats().AuthFailures == 1 }, 5*time.Second, time.Millisecond)
+	select {
+	case pkt := <-p.fromTun:
+		t.Fatalf("unexpected reply %s", pkt)
+	case <-time.After(100 * time.Millisecond):
+	}
+	require.Equal(t, stateIdle, p.h.state())
+}
+
+func TestHandler_AuthOptionRestoreState(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+	p := newAOTestPeer(ctx, t, AOHMACSHA1)
+	p.connect(ctx)
+	p.stream.fromMgr <- tunnel.NewMessage(tunnel.Normal, []byte("hello"))
+	data := p.recvAuthentic()
+	p.ack = data.Sequence() + uint32(len(data.Payload()))
+	p.send(ctx, false, true, false, nil)
+
+	state, err := p.h.MarshalState()
+	require.NoError(t, err)
+
+	// A handler without the authentication option can't restore the state.
+	stream := newTestStream(p.id)
+	tun := make(testTun, 100)
+	h := NewHandler(nil, new(int32), tun, p.id, "", func() {}, rand.NewSource(2), HandlerConfig{})
+	require.Error(t, h.RestoreState(state))
+
+	h = NewHandler(func(context.Context) (tunnel.Stream, error) { return stream, nil },
+		new(int32), tun, p.id, "", func() {}, rand.NewSource(2), p.h.cfg)
+	require.NoError(t, h.RestoreState(state))
+	restored, err := h.(*handler).MarshalState()
+	require.NoError(t, err)
+	require.JSONEq(t, string(state), string(restored))
+
+	// The restored handler verifies the peer's segments and signs its own.
+	p.h, p.stream, p.fromTun = h.(*handler), stream, tun
+	h.Start(ctx)
+	p.send(ctx, false, true, false, []byte("world"))
+	select {
+	case m := <-stream.toMgr:
+		require.Equal(t, []byte("world"), m.Payload())
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for message to manager")
+	}
+	require.Equal(t, p.seq, p.recvAuthentic().AckNumber())
+	require.Equal(t, uint64(0), p.h.Stats().AuthFailures)
+}
@@ -0,0 +1,97 @@
+package tcp
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/datawire/dlib/dlog"
+)
+
+// autoTuneInitialWindow is the receive window that a handler that uses ReceiveWindowAutoTuning
+// starts with. The window never shrinks below this size.
+const autoTuneInitialWindow = 64 << 10
+
+// rcvAutoTuner grows the limit of the receive window based on the amount of data that is received
+// during one round trip, in the same way as the dynamic right-sizing of the Linux kernel does.
+type rcvAutoTuner struct {
+	// limit is the current limit of the receive window.
+	limit int64
+
+	// rtt is the most recently measured round-trip time in nanoseconds.
+	rtt int64
+
+	// lastShrink is the time when the limit was last shrunk, in unix nanoseconds.
+	lastShrink int64
+
+	// The following fields are only used by the goroutine that processes the packets. The
+	// measurement of a round trip ends when data at or beyond seq is received.
+	seq   uint32
+	start time.Time
+	bytes int
+}
+
+// windowLimit returns the maximum size of the receive window.
+func (h *handler) windowLimit() int {
+	if !h.cfg.ReceiveWindowAutoTuning {
+		return maxReceiveWindow
+	}
+	return int(atomic.LoadInt64(&h.tuner.limit))
+}
+
+// autoTuneReceived is called for each segment that is sent to the traffic-manager, where end is the
+// sequence that follows the segment and n is its payload length. A round trip has elapsed when the
+// peer sends data that it couldn't send until it received the window that was advertised when the
+// measurement started. The limit then grows to twice the amount of data received during that round
+// trip, so that the window doesn't limit the throughput of a sender that is able to send more.
+func (h *handler) autoTuneReceived(ctx context.Context, end uint32, n int) {
+	if !h.cfg.ReceiveWindowAutoTuning {
+		return
+	}
+	t := &h.tuner
+	now := h.clock.Now()
+	if t.start.IsZero() {
+		t.start = now
+		t.seq = end + uint32(h.receiveWindow())
+		return
+	}
+	t.bytes += n
+	if seqAfter(t.seq, end) {
+		return
+	}
+	rtt := now.Sub(t.start)
+	atomic.StoreInt64(&t.rtt, int64(rtt))
+	limit := int(atomic.LoadInt64(&t.limit))
+	if space := 2 * t.bytes; space > limit {
+		if space > h.cfg.MaxReceiveWindow {
+			space = h.cfg.MaxReceiveWindow
+		}
+		if space > limit {
+			dlog.Tracef(ctx, "   CON %s, %d bytes received in %s, receive window limit grows to %d", h.name, t.bytes, rtt, space)
+			atomic.StoreInt64(&t.limit, int64(space))
+		}
+	}
+	t.start = now
+	t.seq = end + uint32(h.receiveWindow())
+	t.bytes = 0
+}
+
+// autoTuneShrink halves the limit of the receive window because the traffic-manager doesn't keep
+// up. The limit is shrunk at most once per round trip.
+func (h *handler) autoTuneShrink() {
+	t := &h.tuner
+	now := h.clock.Now().UnixNano()
+	rtt := atomic.LoadInt64(&t.rtt)
+	if rtt <= 0 {
+		rtt = int64(10 * time.Millisecond)
+	}
+	last := atomic.LoadInt64(&t.lastShrink)
+	if now-last < rtt || !atomic.CompareAndSwapInt64(&t.lastShrink, last, now) {
+		return
+	}
+	limit := atomic.LoadInt64(&t.limit) / 2
+	if limit < autoTuneInitialWindow {
+		limit = autoTuneInitialWindow
+	}
+	atomic.StoreInt64(&t.limit, limit)
+}
@@ -0,0 +1,69 @@
+package tcp
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+)
+
+func TestHandler_ReceiveWindowAutoTuning(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+	const maxWindow = 512 << 10
+	p := newTestPeer(ctx, t, HandlerConfig{ReceiveWindowAutoTuning: true, MaxReceiveWindow: maxWindow})
+	p.connect(ctx)
+
+	// The peer sends a full window of data in each round trip, so the window grows until it reaches
+	// the configured maximum. The traffic-manager keeps up, so that the limit is never shrunk.
+	window := autoTuneInitialWindow
+	for i := 0; i < 10; i++ {
+		for sent := 0; sent < window; sent += maxSegmentSize {
+			// PSH makes the payload reach the manager without a flush delay.
+			p.sendWithPSH(ctx, false, true, false, true, make([]byte, maxSegmentSize))
+			select {
+			case <-p.stream.toMgr:
+			case <-time.After(5 * time.Second):
+				t.Fatal("timeout waiting for message to manager")
+			}
+			// Window updates may precede the acknowledgement of the segment.
+			for {
+				ack := p.recv().Header()
+				window = int(ack.WindowSize()) << myWindowScale
+				if ack.AckNumber() == p.seq {
+					break
+				}
+			}
+		}
+	}
+	require.Greater(t, window, autoTuneInitialWindow)
+	require.LessOrEqual(t, window, maxWindow)
+	require.Equal(t, maxWindow, p.h.windowLimit())
+}
+
+func TestHandler_ReceiveWindowAutoTuningShrink(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+	p := newTestPeer(ctx, t, HandlerConfig{ReceiveWindowAutoTuning: true})
+	p.connect(ctx)
+	require.Equal(t, autoTuneInitialWindow, p.h.receiveWindow())
+	atomic.StoreInt64(&p.h.tuner.limit, maxReceiveWindow)
+	atomic.StoreInt64(&p.h.tuner.rtt, int64(time.Minute))
+
+	// The traffic-manager doesn't keep up, so the queue to the manager fills up.
+	p.stream.hold.Lock()
+	defer p.stream.hold.Unlock()
+	for i := 0; i <= ioChannelSize/2+1; i++ {
+		p.h.toMgrCh <- NewPacket(HeaderLen, p.id.Source(), p.id.Destination(), false)
+	}
+	p.h.adjustReceiveWindow()
+	require.Equal(t, maxReceiveWindow/2, p.h.windowLimit())
+
+	// The limit is shrunk at most once per round trip.
+	p.h.adjustReceiveWindow()
+	require.Equal(t, maxReceiveWindow/2, p.h.windowLimit())
+}
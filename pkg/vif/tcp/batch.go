@@ -0,0 +1,154 @@
+package tcp
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/ipproto"
+)
+
+// HandlePackets handles a batch of packets that were read from the TUN device. The whole batch is
+// passed to the goroutine that processes the packets using one channel operation, which reduces the
+// lock contention on high-throughput connections. Coalesced super-segments (produced by TUN devices
+// that use GRO) are split into segments of at most maxSegmentSize bytes before they're enqueued.
+//
+// The handler takes ownership of the given slice and the packets in it.
+func (h *handler) HandlePackets(ctx context.Context, pkts []Packet) {
+	if len(pkts) == 0 {
+		return
+	}
+	for i, pkt := range pkts {
+		if isSuperSegment(pkt) {
+			// Copy what we have so far and split the rest.
+			batch := make([]Packet, i, len(pkts)+pkt.PayloadLen()/maxSegmentSize)
+			copy(batch, pkts[:i])
+			for _, pkt = range pkts[i:] {
+				batch = appendSegments(batch, pkt)
+			}
+			pkts = batch
+			break
+		}
+	}
+	if h.cfg.InputOverflow == InputOverflowDrop {
+		select {
+		case h.fromTun <- pkts:
+		default:
+			atomic.AddUint64(&h.inputDropped, uint64(len(pkts)))
+			dlog.Debugf(ctx, "!! TUN %s dropped %d packets because TCP handler's input buffer is full", h.name, len(pkts))
+			releaseAll(pkts)
+		}
+		return
+	}
+	select {
+	case <-ctx.Done():
+		dlog.Debugf(ctx, "!! TUN %s discarded %d packets because context is cancelled", h.name, len(pkts))
+	case <-h.tunDone:
+		dlog.Debugf(ctx, "!! TUN %s discarded %d packets because TCP handler's input processing was cancelled", h.name, len(pkts))
+	case h.fromTun <- pkts:
+	}
+}
+
+// TryHandlePacket is a non-blocking variant of HandlePacket. It returns false when the handler's
+// input buffer is full, in which case the packet is still owned by the caller, which may retry,
+// drop the packet, or advertise a smaller window to the peer. Dropping a packet costs the peer a
+// retransmit, whereas HandlePacket waits for the handler and thereby stalls every other
+// connection that the caller reads packets for.
+func (h *handler) TryHandlePacket(ctx context.Context, pkt Packet) bool {
+	pkts := splitSegments(nil, pkt)
+	split := len(pkts) > 1
+	select {
+	case <-ctx.Done():
+		dlog.Debugf(ctx, "!! TUN %s discarded packet because context is cancelled", h.name)
+	case <-h.tunDone:
+		dlog.Debugf(ctx, "!! TUN %s discarded packet because TCP handler's input processing was cancelled", h.name)
+	case h.fromTun <- pkts:
+	default:
+		if split {
+			releaseAll(pkts)
+		}
+		return false
+	}
+	if split {
+		pkt.Release()
+	}
+	return true
+}
+
+// nextFromTun returns the next packet from the current batch, or from the next batch that
+// is read from the fromTun channel when the current batch is exhausted. It must only be called
+// from the goroutine that processes the packets.
+func (h *handler) nextFromTun(ctx context.Context) (Packet, bool) {
+	for len(h.tunBatch) == 0 {
+		select {
+		case <-ctx.Done():
+			return nil, false
+		case h.tunBatch = <-h.fromTun:
+		}
+	}
+	pkt := h.tunBatch[0]
+	h.tunBatch[0] = nil
+	h.tunBatch = h.tunBatch[1:]
+	atomic.AddUint64(&h.segmentsReceived, 1)
+	return pkt, true
+}
+
+// isSuperSegment returns true if the given packet carries more payload than a segment can.
+func isSuperSegment(pkt Packet) bool {
+	tcpHdr := pkt.Header()
+	return !tcpHdr.SYN() && len(tcpHdr.Payload()) > maxSegmentSize
+}
+
+// appendSegments appends the given packet to pkts. A super-segment is split into segments of at most
+// maxSegmentSize bytes, where only the last segment retains the PSH and FIN flags of the original.
+func appendSegments(pkts []Packet, pkt Packet) []Packet {
+	if !isSuperSegment(pkt) {
+		return append(pkts, pkt)
+	}
+	defer pkt.Release()
+	return splitSegments(pkts, pkt)
+}
+
+// splitSegments is like appendSegments, but it never releases the given packet.
+func splitSegments(pkts []Packet, pkt Packet) []Packet {
+	if !isSuperSegment(pkt) {
+		return append(pkts, pkt)
+	}
+	ipHdr := pkt.IPHeader()
+	tcpHdr := pkt.Header()
+	hl := tcpHdr.DataOffset() * 4
+	seq := tcpHdr.Sequence()
+	payload := tcpHdr.Payload()
+	for len(payload) > 0 {
+		n := len(payload)
+		if n > maxSegmentSize {
+			n = maxSegmentSize
+		}
+		seg := NewPacket(hl+n, ipHdr.Source(), ipHdr.Destination(), false)
+		segIPHdr := seg.IPHeader()
+		segIPHdr.SetL4Protocol(ipproto.TCP)
+		segIPHdr.SetChecksum()
+
+		segHdr := seg.Header()
+		copy(segHdr, tcpHdr[:hl])
+		segHdr.SetSequence(seq)
+		if n < len(payload) {
+			segHdr.SetPSH(false)
+			segHdr.SetFIN(false)
+		}
+		copy(segHdr.Payload(), payload[:n])
+		segHdr.SetChecksum(segIPHdr)
+		pkts = append(pkts, seg)
+
+		seq += uint32(n)
+		payload = payload[n:]
+	}
+	return pkts
+}
+
+// releaseAll releases the given packets.
+func releaseAll(pkts []Packet) {
+	for _, pkt := range pkts {
+		pkt.Release()
+	}
+}
@@ -0,0 +1,174 @@
+package tcp
+
+import (
+	"context"
+	"math/rand"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/ipproto"
+	"github.com/telepresenceio/telepresence/v2/pkg/tunnel"
+)
+
+func newDataPacket(seq uint32, payloadLen int, psh, fin bool) Packet {
+	pkt := NewPacket(HeaderLen+payloadLen, net.IP{10, 0, 0, 1}, net.IP{10, 0, 0, 2}, false)
+	ipHdr := pkt.IPHeader()
+	ipHdr.SetL4Protocol(ipproto.TCP)
+	ipHdr.SetChecksum()
+	tcpHdr := pkt.Header()
+	tcpHdr.SetDataOffset(HeaderLen / 4)
+	tcpHdr.SetSourcePort(4711)
+	tcpHdr.SetDestinationPort(80)
+	tcpHdr.SetSequence(seq)
+	tcpHdr.SetACK(true)
+	tcpHdr.SetPSH(psh)
+	tcpHdr.SetFIN(fin)
+	payload := tcpHdr.Payload()
+	for i := range payload {
+		payload[i] = byte(i)
+	}
+	tcpHdr.SetChecksum(ipHdr)
+	return pkt
+}
+
+func Test_appendSegments(t *testing.T) {
+	mss := maxSegmentSize
+	orig := newDataPacket(1000, 2*mss+10, true, true)
+	origPayload := append([]byte(nil), orig.Header().Payload()...)
+
+	segs := appendSegments(nil, orig)
+	require.Len(t, segs, 3)
+	var payload []byte
+	seq := uint32(1000)
+	for i, seg := range segs {
+		hdr := seg.Header()
+		last := i == len(segs)-1
+		assert.Equal(t, seq, hdr.Sequence())
+		assert.Equal(t, uint16(4711), hdr.SourcePort())
+		assert.True(t, hdr.ACK())
+		assert.Equal(t, last, hdr.PSH())
+		assert.Equal(t, last, hdr.FIN())
+		if last {
+			assert.Len(t, hdr.Payload(), 10)
+		} else {
+			assert.Len(t, hdr.Payload(), mss)
+		}
+		payload = append(payload, hdr.Payload()...)
+		seq += uint32(len(hdr.Payload()))
+	}
+	assert.Equal(t, origPayload, payload)
+
+	// Normal segments are not split
+	small := newDataPacket(1000, mss, false, false)
+	segs = appendSegments(nil, small)
+	require.Len(t, segs, 1)
+	assert.Same(t, small, segs[0])
+}
+
+func TestHandler_HandlePackets(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+	p := newTestPeer(ctx, t, HandlerConfig{})
+	p.connect(ctx)
+
+	// A batch with a normal segment followed by a super-segment
+	mss := maxSegmentSize
+	first := newDataPacket(p.seq, 10, false, false)
+	first.Header().SetAckNumber(p.ack)
+	super := newDataPacket(p.seq+10, 2*mss, true, false)
+	super.Header().SetAckNumber(p.ack)
+	p.h.HandlePackets(ctx, []Packet{first, super})
+
+	var received int
+	for received < 10+2*mss {
+		m := <-p.stream.toMgr
+		received += len(m.Payload())
+	}
+	assert.Equal(t, 10+2*mss, received)
+}
+
+func benchmarkHandlePackets(b *testing.B, batched bool) {
+	const batchSize = 64
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(b, false))
+	defer cancel()
+	id := tunnel.NewConnID(ipproto.TCP, net.IP{10, 0, 0, 1}, net.IP{10, 0, 0, 2}, 4711, 80)
+	h := NewHandler(nil, new(int32), nil, id, "", func() {}, rand.NewSource(1), HandlerConfig{}).(*handler)
+	go func() {
+		for {
+			if _, ok := h.nextFromTun(ctx); !ok {
+				return
+			}
+		}
+	}()
+
+	pkts := make([]Packet, batchSize)
+	for i := range pkts {
+		pkts[i] = newDataPacket(uint32(i*100), 100, false, false)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if !batched {
+			for _, pkt := range pkts {
+				h.HandlePacket(ctx, pkt)
+			}
+		} else {
+			h.HandlePackets(ctx, append(make([]Packet, 0, batchSize), pkts...))
+		}
+	}
+}
+
+func BenchmarkHandler_HandlePacket(b *testing.B) {
+	benchmarkHandlePackets(b, false)
+}
+
+func BenchmarkHandler_HandlePackets(b *testing.B) {
+	benchmarkHandlePackets(b, true)
+}
+
+func TestHandler_TryHandlePacket(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	id := tunnel.NewConnID(ipproto.TCP, net.IP{10, 0, 0, 1}, net.IP{10, 0, 0, 2}, 4711, 80)
+	h := NewHandler(nil, new(int32), discardTun{}, id, "", func() {}, rand.NewSource(1), HandlerConfig{}).(*handler)
+
+	// The handler isn't started, so nothing consumes its input buffer.
+	for i := 0; i < cap(h.fromTun); i++ {
+		require.True(t, h.TryHandlePacket(ctx, newDataPacket(uint32(i), 10, false, false)))
+	}
+	pkt := newDataPacket(1000, 10, false, false)
+	require.False(t, h.TryHandlePacket(ctx, pkt))
+
+	// A rejected super-segment is left intact.
+	super := newDataPacket(1000, 2*maxSegmentSize, false, false)
+	require.False(t, h.TryHandlePacket(ctx, super))
+	require.Len(t, super.Header().Payload(), 2*maxSegmentSize)
+
+	// Once the buffer has room, the super-segment is accepted as a batch of segments.
+	<-h.fromTun
+	require.True(t, h.TryHandlePacket(ctx, super))
+	require.False(t, h.TryHandlePacket(ctx, pkt))
+	for i := 0; i < cap(h.fromTun)-1; i++ {
+		<-h.fromTun
+	}
+	require.Len(t, <-h.fromTun, 2)
+}
+
+func TestHandler_InputOverflowDrop(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	id := tunnel.NewConnID(ipproto.TCP, net.IP{10, 0, 0, 1}, net.IP{10, 0, 0, 2}, 4711, 80)
+	h := NewHandler(nil, new(int32), discardTun{}, id, "", func() {}, rand.NewSource(1), HandlerConfig{InputOverflow: InputOverflowDrop}).(*handler)
+
+	// The handler isn't started, so nothing consumes its input buffer. Once it's full, packets are
+	// dropped instead of blocking the caller.
+	for i := 0; i < cap(h.fromTun); i++ {
+		h.HandlePacket(ctx, newDataPacket(uint32(i), 10, false, false))
+	}
+	require.Zero(t, h.Stats().InputDropped)
+	h.HandlePacket(ctx, newDataPacket(1000, 10, false, false))
+	h.HandlePackets(ctx, []Packet{newDataPacket(1010, 10, false, false), newDataPacket(1020, 10, false, false)})
+	require.Equal(t, uint64(3), h.Stats().InputDropped)
+	require.Len(t, h.fromTun, cap(h.fromTun))
+}
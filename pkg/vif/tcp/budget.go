@@ -0,0 +1,128 @@
+package tcp
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// MemoryBudget is a hard cap on the number of bytes that all TCP handlers that share it may
+// keep buffered in their queues. Handlers scale down their advertised receive windows when
+// the budget is under pressure, and new connections are reset when the budget is exhausted.
+type MemoryBudget struct {
+	limit    int64
+	used     int64
+	handlers int64
+}
+
+type memoryBudgetKey struct{}
+
+// NewMemoryBudget creates a MemoryBudget that allows at most limit bytes to be buffered.
+func NewMemoryBudget(limit int64) *MemoryBudget {
+	return &MemoryBudget{limit: limit}
+}
+
+// WithMemoryBudget returns a context with the given MemoryBudget. Handlers that are started
+// using that context will account their buffered bytes in the budget.
+func WithMemoryBudget(ctx context.Context, b *MemoryBudget) context.Context {
+	return context.WithValue(ctx, memoryBudgetKey{}, b)
+}
+
+func getMemoryBudget(ctx context.Context) *MemoryBudget {
+	b, ok := ctx.Value(memoryBudgetKey{}).(*MemoryBudget)
+	if !ok {
+		return nil
+	}
+	return b
+}
+
+// Limit returns the maximum number of bytes that may be buffered.
+func (b *MemoryBudget) Limit() int64 {
+	return b.limit
+}
+
+// Used returns the number of bytes that are currently buffered.
+func (b *MemoryBudget) Used() int64 {
+	return atomic.LoadInt64(&b.used)
+}
+
+// Handlers returns the number of handlers that currently share the budget.
+func (b *MemoryBudget) Handlers() int {
+	return int(atomic.LoadInt64(&b.handlers))
+}
+
+// register adds a handler that has the given number of bytes buffered to the budget. A nil
+// budget is a no-op.
+func (b *MemoryBudget) register(buffered int64) {
+	if b != nil {
+		atomic.AddInt64(&b.handlers, 1)
+		atomic.AddInt64(&b.used, buffered)
+	}
+}
+
+// unregister removes a handler that has the given number of bytes buffered from the budget.
+func (b *MemoryBudget) unregister(buffered int64) {
+	if b != nil {
+		atomic.AddInt64(&b.handlers, -1)
+		atomic.AddInt64(&b.used, -buffered)
+	}
+}
+
+func (b *MemoryBudget) add(n int64) {
+	if b != nil {
+		atomic.AddInt64(&b.used, n)
+	}
+}
+
+// exhausted returns true when no more bytes can be buffered.
+func (b *MemoryBudget) exhausted() bool {
+	return b != nil && b.Used() >= b.limit
+}
+
+// share returns the receive window that each handler may advertise so that the remaining
+// budget is distributed fairly among the handlers. It never exceeds maxReceiveWindow.
+func (b *MemoryBudget) share() int {
+	if b == nil {
+		return maxReceiveWindow
+	}
+	free := b.limit - b.Used()
+	if free <= 0 {
+		return 0
+	}
+	if n := atomic.LoadInt64(&b.handlers); n > 1 {
+		free /= n
+	}
+	if free > maxReceiveWindow {
+		return maxReceiveWindow
+	}
+	// Strip the last 8 bits since that's what the window scale discards anyway
+	return int(free) &^ 0xff
+}
+
+// account adds n (which may be negative) to the number of bytes that the handler keeps
+// buffered in its queues. The sendLock must be held when calling this method.
+func (h *handler) account(n int) {
+	atomic.AddInt64(&h.bufferedBytes, int64(n))
+	if !h.budgetReleased {
+		h.budget.add(int64(n))
+	}
+}
+
+// releaseBudget removes the handler and all bytes that it has accounted from the memory budget.
+// Bytes that are accounted after this call are not added to the budget.
+func (h *handler) releaseBudget() {
+	h.sendLock.Lock()
+	if !h.budgetReleased {
+		h.budgetReleased = true
+		h.budget.unregister(atomic.LoadInt64(&h.bufferedBytes))
+	}
+	h.sendLock.Unlock()
+}
+
+// capReceiveWindow returns the given window size, scaled down to the handler's share of the
+// memory budget when the budget is under pressure.
+func (h *handler) capReceiveWindow(windowSize int) int {
+	if s := h.budget.share(); s < windowSize {
+		return s
+	}
+	return windowSize
+}
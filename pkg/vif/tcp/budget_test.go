@@ -0,0 +1,67 @@
+package tcp
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/tunnel"
+)
+
+func TestMemoryBudget_SharedWindows(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+	budget := NewMemoryBudget(4 * maxReceiveWindow)
+	ctx = WithMemoryBudget(ctx, budget)
+
+	const connections = 16
+	windows := make([]int, connections)
+	for i := 0; i < connections; i++ {
+		p := newTestPeer(ctx, t, HandlerConfig{})
+		p.connect(ctx)
+		windows[i] = p.h.receiveWindow()
+	}
+	require.Equal(t, connections, budget.Handlers())
+
+	// The first connections get a full window. Once the budget is under pressure, each new
+	// connection gets a smaller share.
+	require.Equal(t, maxReceiveWindow, windows[0])
+	for i := 1; i < connections; i++ {
+		require.LessOrEqual(t, windows[i], windows[i-1])
+	}
+	require.Equal(t, 4*maxReceiveWindow/connections, windows[connections-1])
+}
+
+func TestMemoryBudget_Exhausted(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+	budget := NewMemoryBudget(5)
+	ctx = WithMemoryBudget(ctx, budget)
+
+	p := newTestPeer(ctx, t, HandlerConfig{})
+	p.connect(ctx)
+
+	// The manager sends data that the peer doesn't acknowledge, which exhausts the budget.
+	p.stream.fromMgr <- tunnel.NewMessage(tunnel.Normal, []byte("hello"))
+	data := p.recv().Header()
+	require.Equal(t, []byte("hello"), data.Payload())
+	require.Equal(t, int64(5), budget.Used())
+	require.Equal(t, int64(5), p.h.Stats().BufferedBytes)
+
+	// New connections are reset while the budget is exhausted.
+	p2 := newTestPeer(ctx, t, HandlerConfig{})
+	p2.send(ctx, true, false, false, nil)
+	require.True(t, p2.recv().Header().RST())
+
+	// Acknowledging the data releases it from the budget.
+	p.ack += 5
+	p.send(ctx, false, true, false, nil)
+	require.Eventually(t, func() bool { return budget.Used() == 0 }, 5*time.Second, time.Millisecond)
+	require.Equal(t, int64(0), p.h.Stats().BufferedBytes)
+
+	p3 := newTestPeer(ctx, t, HandlerConfig{})
+	p3.connect(ctx)
+}
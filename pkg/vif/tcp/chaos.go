@@ -0,0 +1,81 @@
+package tcp
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ChaosConfig makes a handler simulate an adverse network between itself and the peer, so that the
+// behavior of the intercepted application and of the recovery machinery can be tested without an
+// external tool like netem. It's only honored by binaries that are built with the "chaos" build
+// tag, and ignored otherwise.
+type ChaosConfig struct {
+	// MaxJitter is the maximum time that a write to the TUN device is delayed. Each write is delayed
+	// by a random duration between zero and MaxJitter.
+	MaxJitter time.Duration
+
+	// DropPercent is the percentage, between 0 and 100, of the segments to the peer that are dropped
+	// as if they were lost on their way. RST segments are never dropped, since they aren't
+	// retransmitted.
+	DropPercent float64
+}
+
+// chaos injects the faults of a ChaosConfig. It's safe for concurrent use.
+type chaos struct {
+	cfg     ChaosConfig
+	lock    sync.Mutex
+	rnd     *rand.Rand
+	dropped uint64
+	clock   Clock
+}
+
+// newChaos returns the chaos for the given config, or nil when the config is nil, or when the
+// binary wasn't built with the "chaos" build tag. The delays are timed using the given clock.
+func newChaos(cfg *ChaosConfig, seed int64, clock Clock) *chaos {
+	if cfg == nil || !chaosEnabled {
+		return nil
+	}
+	return &chaos{cfg: *cfg, rnd: rand.New(rand.NewSource(seed)), clock: clock}
+}
+
+// drop returns true when the given packet should be dropped.
+func (c *chaos) drop(pkt Packet) bool {
+	if c == nil || c.cfg.DropPercent <= 0 || pkt.Header().RST() {
+		return false
+	}
+	c.lock.Lock()
+	d := c.rnd.Float64()*100 < c.cfg.DropPercent
+	c.lock.Unlock()
+	if d {
+		atomic.AddUint64(&c.dropped, 1)
+	}
+	return d
+}
+
+// delay waits for a random duration between zero and MaxJitter, or until the context is done.
+func (c *chaos) delay(ctx context.Context) {
+	if c == nil || c.cfg.MaxJitter <= 0 {
+		return
+	}
+	c.lock.Lock()
+	d := time.Duration(c.rnd.Int63n(int64(c.cfg.MaxJitter) + 1))
+	c.lock.Unlock()
+	if d == 0 {
+		return
+	}
+	select {
+	case <-ctx.Done():
+	case <-c.clock.After(d):
+	}
+}
+
+// droppedCount returns the number of segments that were dropped.
+func (c *chaos) droppedCount() uint64 {
+	if c == nil {
+		return 0
+	}
+	return atomic.LoadUint64(&c.dropped)
+}
@@ -0,0 +1,6 @@
+//go:build chaos
+
+package tcp
+
+// chaosEnabled is true when the binary is built with the "chaos" build tag.
+var chaosEnabled = true
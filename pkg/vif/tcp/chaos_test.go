@@ -0,0 +1,81 @@
+package tcp
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+)
+
+// withChaos enables the chaos mode for the duration of the test, as if the "chaos" build tag was used.
+func withChaos(t *testing.T) {
+	saved := chaosEnabled
+	chaosEnabled = true
+	t.Cleanup(func() { chaosEnabled = saved })
+}
+
+func TestChaos_DisabledByDefault(t *testing.T) {
+	if chaosEnabled {
+		t.Skip("built with the chaos build tag")
+	}
+	assert.Nil(t, newChaos(&ChaosConfig{DropPercent: 100}, 1, realClock{}))
+}
+
+func TestChaos_Drop(t *testing.T) {
+	withChaos(t)
+	c := newChaos(&ChaosConfig{DropPercent: 25}, 1, realClock{})
+	pkt := NewPacket(HeaderLen, net.IP{10, 0, 0, 1}, net.IP{10, 0, 0, 2}, true)
+	defer pkt.Release()
+	drops := 0
+	for i := 0; i < 1000; i++ {
+		if c.drop(pkt) {
+			drops++
+		}
+	}
+	assert.InDelta(t, 250, drops, 50)
+	assert.Equal(t, uint64(drops), c.droppedCount())
+
+	// RST segments are never dropped
+	c = newChaos(&ChaosConfig{DropPercent: 100}, 1, realClock{})
+	pkt.Header().SetRST(true)
+	assert.False(t, c.drop(pkt))
+}
+
+func TestChaos_Delay(t *testing.T) {
+	withChaos(t)
+	ctx := context.Background()
+	c := newChaos(&ChaosConfig{MaxJitter: 20 * time.Millisecond}, 1, realClock{})
+	for i := 0; i < 10; i++ {
+		start := time.Now()
+		c.delay(ctx)
+		assert.Less(t, time.Since(start), time.Second)
+	}
+
+	// A cancelled context ends the delay
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	c = newChaos(&ChaosConfig{MaxJitter: time.Hour}, 1, realClock{})
+	start := time.Now()
+	c.delay(ctx)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestHandler_ChaosDropsSegments(t *testing.T) {
+	withChaos(t)
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+
+	p := newTestPeer(ctx, t, HandlerConfig{Chaos: &ChaosConfig{DropPercent: 100}})
+	p.send(ctx, true, false, false, nil)
+	select {
+	case pkt := <-p.fromTun:
+		t.Fatalf("segment %s was not dropped", pkt)
+	case <-time.After(200 * time.Millisecond):
+	}
+	require.Equal(t, uint64(1), p.h.Stats().ChaosDropped)
+}
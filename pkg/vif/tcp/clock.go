@@ -0,0 +1,66 @@
+package tcp
+
+import "time"
+
+// Clock is the source of time for a handler. All timing of a handler, such as retransmissions,
+// timeouts, and the TIME-WAIT state, is derived from its Clock, so that tests can control it.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+
+	// After returns a channel that receives the current time once the given duration has elapsed.
+	After(d time.Duration) <-chan time.Time
+
+	// NewTicker returns a Ticker that delivers ticks with the given period.
+	NewTicker(d time.Duration) Ticker
+
+	// AfterFunc calls f in its own goroutine once the given duration has elapsed.
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Ticker is the Clock's equivalent of a time.Ticker.
+type Ticker interface {
+	// C returns the channel on which the ticks are delivered.
+	C() <-chan time.Time
+
+	// Stop turns off the ticker.
+	Stop()
+}
+
+// Timer is the Clock's equivalent of a time.Timer created with time.AfterFunc.
+type Timer interface {
+	// Stop prevents the timer from firing. It returns false if the timer has already fired or
+	// been stopped.
+	Stop() bool
+
+	// Reset changes the timer to fire after the given duration. It returns true if the timer had
+	// been active.
+	Reset(d time.Duration) bool
+}
+
+// realClock is the Clock that uses the time package. It's used when no Clock is configured.
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+func (realClock) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
+
+type realTicker struct {
+	*time.Ticker
+}
+
+func (t realTicker) C() <-chan time.Time {
+	return t.Ticker.C
+}
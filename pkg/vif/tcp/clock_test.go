@@ -0,0 +1,347 @@
+package tcp
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/tunnel"
+)
+
+// fakeClock is a Clock whose time only moves when Advance is called.
+type fakeClock struct {
+	lock   sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+// fakeTimer is the Timer and Ticker of a fakeClock. A ticker has a period and delivers to ch. A
+// timer either calls f or delivers to ch.
+type fakeTimer struct {
+	clock  *fakeClock
+	at     time.Time
+	period time.Duration
+	f      func()
+	ch     chan time.Time
+	active bool
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) add(d time.Duration, period time.Duration, f func()) *fakeTimer {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	t := &fakeTimer{clock: c, at: c.now.Add(d), period: period, f: f, active: true}
+	if f == nil {
+		t.ch = make(chan time.Time, 1)
+	}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	return c.add(d, 0, nil).ch
+}
+
+func (c *fakeClock) NewTicker(d time.Duration) Ticker {
+	return fakeTicker{c.add(d, d, nil)}
+}
+
+func (c *fakeClock) AfterFunc(d time.Duration, f func()) Timer {
+	return c.add(d, 0, f)
+}
+
+// Advance moves the time forward and fires the timers and tickers that are due.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.lock.Lock()
+	c.now = c.now.Add(d)
+	now := c.now
+	var fs []func()
+	for _, t := range c.timers {
+		if !t.active || t.at.After(now) {
+			continue
+		}
+		if t.period > 0 {
+			for !t.at.After(now) {
+				t.at = t.at.Add(t.period)
+			}
+		} else {
+			t.active = false
+		}
+		if t.f != nil {
+			fs = append(fs, t.f)
+			continue
+		}
+		select {
+		case t.ch <- now:
+		default:
+		}
+	}
+	c.lock.Unlock()
+	for _, f := range fs {
+		go f()
+	}
+}
+
+// hasTimer returns true when a timer that isn't a ticker is due exactly d from now.
+func (c *fakeClock) hasTimer(d time.Duration) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	at := c.now.Add(d)
+	for _, t := range c.timers {
+		if t.active && t.period == 0 && t.at.Equal(at) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.ch
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.lock.Lock()
+	defer t.clock.lock.Unlock()
+	wasActive := t.active
+	t.active = false
+	return wasActive
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	t.clock.lock.Lock()
+	defer t.clock.lock.Unlock()
+	wasActive := t.active
+	t.at = t.clock.now.Add(d)
+	t.active = true
+	return wasActive
+}
+
+// fakeTicker adapts a fakeTimer with a period to the Ticker interface.
+type fakeTicker struct {
+	*fakeTimer
+}
+
+func (t fakeTicker) Stop() {
+	t.fakeTimer.Stop()
+}
+
+func TestHandler_FakeClockTimeWait(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+	fc := newFakeClock()
+	p := newTestPeer(ctx, t, HandlerConfig{Clock: fc})
+	p.connect(ctx)
+	require.Zero(t, p.h.Stats().Age, "time must not pass unless the clock is advanced")
+
+	// The peer closes its side, then the traffic-manager closes its side.
+	p.send(ctx, false, true, true, nil)
+	require.Equal(t, p.seq, p.recv().Header().AckNumber())
+	p.stream.closeFromMgr()
+	fin := p.recv().Header()
+	require.True(t, fin.FIN())
+	p.ack = fin.Sequence() + 1
+	p.send(ctx, false, true, false, nil)
+
+	// The handler lingers in TIME-WAIT until the clock has moved.
+	require.Eventually(t, func() bool { return fc.hasTimer(time.Second) }, 5*time.Second, time.Millisecond)
+	require.Equal(t, stateTimedWait, p.h.state())
+	select {
+	case <-p.h.tunDone:
+		t.Fatal("handler terminated before the TIME-WAIT timer fired")
+	default:
+	}
+	fc.Advance(time.Second)
+	select {
+	case <-p.h.tunDone:
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("handler didn't terminate when the TIME-WAIT timer fired")
+	}
+}
+
+func TestHandler_FakeClockResend(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+	fc := newFakeClock()
+	p := newTestPeer(ctx, t, HandlerConfig{Clock: fc})
+	p.connect(ctx)
+
+	p.stream.fromMgr <- tunnel.NewMessage(tunnel.Normal, []byte("hello"))
+	data := p.recv().Header()
+	require.Equal(t, []byte("hello"), data.Payload())
+
+	// The segment isn't acknowledged, so it's resent once the initial resend delay has passed.
+	fc.Advance(initialResendDelay*time.Second - 100*time.Millisecond)
+	select {
+	case <-p.fromTun:
+		t.Fatal("segment resent before the initial resend delay passed")
+	case <-time.After(100 * time.Millisecond):
+	}
+	fc.Advance(200 * time.Millisecond)
+	resent := p.recv().Header()
+	require.Equal(t, data.Sequence(), resent.Sequence())
+	require.Equal(t, []byte("hello"), resent.Payload())
+	require.Equal(t, uint64(1), p.h.Stats().TimerRetransmits)
+}
+
+func TestHandler_FakeClockPushFlush(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+	fc := newFakeClock()
+	p := newTestPeer(ctx, t, HandlerConfig{Clock: fc})
+	p.connect(ctx)
+
+	toMgr := func() []byte {
+		select {
+		case m := <-p.stream.toMgr:
+			return m.Payload()
+		case <-time.After(100 * time.Millisecond):
+			return nil
+		}
+	}
+
+	// Data without PSH is held back until the flush delay has passed.
+	p.send(ctx, false, true, false, []byte("bulk"))
+	require.Nil(t, toMgr())
+	fc.Advance(2 * mgrFlushDelay)
+	require.Equal(t, []byte("bulk"), toMgr())
+
+	// Data with PSH is sent right away, together with what was buffered before it.
+	p.sendWithPSH(ctx, false, true, false, true, []byte("interactive"))
+	require.Equal(t, []byte("interactive"), toMgr())
+	p.send(ctx, false, true, false, []byte("request-"))
+	p.sendWithPSH(ctx, false, true, false, true, []byte("line"))
+	require.Equal(t, []byte("request-line"), toMgr())
+}
+
+func TestHandler_FakeClockStopGrace(t *testing.T) {
+	const grace = 500 * time.Millisecond
+
+	t.Run("graceful", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+		defer cancel()
+		fc := newFakeClock()
+		p := newTestPeer(ctx, t, HandlerConfig{Clock: fc, StopGracePeriod: grace})
+		p.connect(ctx)
+
+		// The peer completes the FIN exchange within the grace period.
+		p.h.Stop(ctx)
+		fin := p.recv().Header()
+		require.True(t, fin.FIN())
+		p.ack = fin.Sequence() + 1
+		p.send(ctx, false, true, true, nil)
+		require.Equal(t, p.seq, p.recv().Header().AckNumber())
+		require.Eventually(t, func() bool { return p.h.state() == stateTimedWait }, 5*time.Second, time.Millisecond)
+
+		fc.Advance(grace + time.Millisecond)
+		select {
+		case pkt := <-p.fromTun:
+			t.Fatalf("unexpected segment after a graceful close, RST = %t", pkt.Header().RST())
+		case <-time.After(100 * time.Millisecond):
+		}
+	})
+
+	t.Run("reset", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+		defer cancel()
+		fc := newFakeClock()
+		p := newTestPeer(ctx, t, HandlerConfig{Clock: fc, StopGracePeriod: grace})
+		p.connect(ctx)
+
+		// The peer never answers the FIN, so the connection is reset when the grace period ends,
+		// long before the FIN is resent.
+		p.h.Stop(ctx)
+		require.True(t, p.recv().Header().FIN())
+		require.Eventually(t, func() bool { return fc.hasTimer(grace) }, 5*time.Second, time.Millisecond)
+		fc.Advance(grace - time.Millisecond)
+		select {
+		case <-p.fromTun:
+			t.Fatal("segment sent before the grace period ended")
+		case <-time.After(100 * time.Millisecond):
+		}
+		fc.Advance(2 * time.Millisecond)
+		rst := p.recv().Header()
+		require.True(t, rst.RST())
+		require.False(t, rst.FIN())
+	})
+}
+
+func TestHandler_FakeClockZeroWindowStall(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+	fc := newFakeClock()
+	p := newTestPeer(ctx, t, HandlerConfig{Clock: fc})
+	p.window = 10
+	p.connect(ctx)
+
+	// The response is twice the size of the peer's window, so the handler must wait for the
+	// peer to acknowledge the first half before it can send the second.
+	p.stream.fromMgr <- tunnel.NewMessage(tunnel.Normal, []byte("0123456789abcdefghij"))
+	first := p.recv().Header()
+	require.Equal(t, []byte("0123456789"), first.Payload())
+	require.Eventually(t, func() bool { return p.h.Stats().ZeroWindowStalls == 1 }, 5*time.Second, time.Millisecond)
+
+	fc.Advance(time.Second)
+	p.ack = first.Sequence() + uint32(len(first.Payload()))
+	p.send(ctx, false, true, false, nil)
+	second := p.recv().Header()
+	require.Equal(t, []byte("abcdefghij"), second.Payload())
+
+	stats := p.h.Stats()
+	require.Equal(t, uint64(1), stats.ZeroWindowStalls)
+	require.Equal(t, time.Second, stats.ZeroWindowTime)
+}
+
+func TestHandler_FakeClockTimeWaitFinRetransmit(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+	fc := newFakeClock()
+	p := newTestPeer(ctx, t, HandlerConfig{Clock: fc})
+	p.connect(ctx)
+
+	// The handler closes first, and the peer answers with its own FIN.
+	p.h.Stop(ctx)
+	fin := p.recv().Header()
+	require.True(t, fin.FIN())
+	p.ack = fin.Sequence() + 1
+	p.send(ctx, false, true, true, nil)
+	require.Equal(t, p.seq, p.recv().Header().AckNumber())
+	require.Eventually(t, func() bool { return fc.hasTimer(timeWaitDuration) }, 5*time.Second, time.Millisecond)
+	require.Equal(t, stateTimedWait, p.h.state())
+
+	// The ACK is lost, so the peer retransmits its FIN late in the TIME-WAIT period. It's
+	// acknowledged again, and the timer is restarted.
+	fc.Advance(timeWaitDuration - 100*time.Millisecond)
+	p.seq--
+	p.send(ctx, false, true, true, nil)
+	ack := p.recv().Header()
+	require.True(t, ack.ACK())
+	require.Equal(t, p.seq, ack.AckNumber())
+	require.Eventually(t, func() bool { return fc.hasTimer(timeWaitDuration) }, 5*time.Second, time.Millisecond)
+
+	// The original deadline passes without ending the handler.
+	fc.Advance(200 * time.Millisecond)
+	select {
+	case <-p.h.tunDone:
+		t.Fatal("handler terminated although the TIME-WAIT timer was restarted")
+	case <-time.After(100 * time.Millisecond):
+	}
+	fc.Advance(timeWaitDuration)
+	select {
+	case <-p.h.tunDone:
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("handler didn't terminate when the restarted TIME-WAIT timer fired")
+	}
+}
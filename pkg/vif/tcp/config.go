@@ -0,0 +1,187 @@
+package tcp
+
+import "time"
+
+// HandlerConfig contains settings that control the behavior of a TCP handler. The zero
+// value is valid and gives a handler that uses the defaults.
+type HandlerConfig struct {
+	// UserTimeout is the maximum time that sent data may remain unacknowledged before the
+	// connection is reset, regardless of the number of retransmits (RFC 5482). Zero means
+	// that no user timeout is used.
+	UserTimeout time.Duration
+
+	// SynRetries is the maximum number of times that the SYN-ACK is retransmitted while waiting for
+	// the peer to complete the handshake. The connection is reset when the SYN-ACK is due for one
+	// more retransmit. The handler only performs passive opens, so this is what limits the handshake
+	// with an unreachable peer. Zero means that the general limit for retransmits applies, which
+	// makes the handshake last for several minutes.
+	SynRetries int
+
+	// SynTimeout is the maximum time that the handler waits for the peer to acknowledge its SYN-ACK.
+	// The connection is reset when it expires, regardless of the number of retransmits. Zero means
+	// no timeout.
+	SynTimeout time.Duration
+
+	// SendBufferHighWatermark is the maximum number of bytes sent to the peer that may remain
+	// unacknowledged. When it's exceeded, the handler stops reading from the traffic-manager
+	// until enough data has been acknowledged to bring the number of unacknowledged bytes down
+	// to SendBufferLowWatermark. Zero means no limit.
+	SendBufferHighWatermark int
+
+	// SendBufferLowWatermark is the number of unacknowledged bytes that the handler waits for
+	// before it resumes reading from the traffic-manager. Defaults to half of the
+	// SendBufferHighWatermark.
+	SendBufferLowWatermark int
+
+	// ManagerQueueHighWatermark is the number of packets waiting to be sent to the traffic-manager
+	// at which the handler closes its advertised receive window, so that the peer stops sending.
+	// The window is reopened when the number of waiting packets has dropped to
+	// ManagerQueueLowWatermark. Zero means that the window shrinks gradually as the queue grows.
+	ManagerQueueHighWatermark int
+
+	// ManagerQueueLowWatermark is the number of packets waiting to be sent to the traffic-manager
+	// at which a closed receive window is reopened. Defaults to half of the ManagerQueueHighWatermark.
+	ManagerQueueLowWatermark int
+
+	// TunWriteTimeout is the maximum time that a write to the TUN device may take. A connection
+	// that is unable to write within this time is terminated, so that a congested TUN device
+	// cannot wedge it. The writes of a connection are then made by a goroutine of its own. Zero
+	// means no timeout.
+	TunWriteTimeout time.Duration
+
+	// MTU is the MTU of the network that is used when communicating with the cluster. When
+	// it's smaller than the MTU of the TUN device, it limits the maximum segment size that the
+	// handler announces to the peer and uses when sending. Zero means that the MTU of the TUN
+	// device is used.
+	MTU int
+
+	// DisableSACK makes the handler ignore the "SACK permitted" option in the peer's SYN, so that
+	// selective acknowledgments are never negotiated and all recovery is timer driven. It's
+	// intended for debugging environments where middleboxes mishandle SACK options.
+	DisableSACK bool
+
+	// ReceiveWindowAutoTuning makes the handler start with a small receive window that grows based
+	// on the amount of data that the peer sends during one round trip, up to MaxReceiveWindow. The
+	// window shrinks again when the traffic-manager doesn't keep up. This keeps the memory used by
+	// many short-lived connections low while bulk transfers still scale up.
+	ReceiveWindowAutoTuning bool
+
+	// MaxReceiveWindow caps the receive window when ReceiveWindowAutoTuning is enabled. Zero, or a
+	// value that exceeds the default receive window of 1MB, means 1MB.
+	MaxReceiveWindow int
+
+	// AuthOption enables the TCP Authentication Option (RFC 5925) using the given master key tuple.
+	// The peer's SYN must carry the option, and the traffic keys of the connection are derived
+	// from the initial sequence numbers that are exchanged in the handshake. All segments that
+	// the handler sends are signed, and received segments that fail verification are discarded.
+	// Nil means that the option isn't used.
+	AuthOption *AuthOption
+
+	// FastOpen enables TCP Fast Open (RFC 7413). The handler hands out a cookie to peers that
+	// request one in their SYN, and accepts the data of a SYN that presents a valid cookie, so that
+	// it's forwarded to the traffic-manager without waiting for the handshake to complete. It's
+	// disabled by default since middleboxes often drop SYNs that carry data.
+	FastOpen bool
+
+	// InputOverflow is what HandlePacket and HandlePackets do when the handler's input buffer is
+	// full. Defaults to InputOverflowBlock.
+	InputOverflow InputOverflowPolicy
+
+	// ResumeOnStreamLoss makes an ESTABLISHED connection survive the loss of its stream to the
+	// traffic-manager. The handler advertises a zero window to pause the peer, creates a new
+	// stream, and then resumes without touching the TCP sequence state. The connection is reset
+	// if no new stream can be created. Data that was in transit on the lost stream may be lost,
+	// and the traffic-manager dials the destination again, so this is only suitable for
+	// idempotent protocols. When false, the connection is closed when its stream is lost.
+	ResumeOnStreamLoss bool
+
+	// InitialCongestionWindow is the number of segments that may be sent to the peer before the
+	// first acknowledgement arrives. The congestion window then grows using slow start and
+	// congestion avoidance (RFC 5681). A small value suits lossy links and a large value suits
+	// clean networks. The initial window never exceeds the receive window advertised by the peer.
+	// Zero means 10 segments (RFC 6928).
+	InitialCongestionWindow int
+
+	// MaxOutOfOrderQueueLength is the maximum number of segments that are kept while waiting for
+	// the segments before them to arrive. When the queue is full, the segment that is farthest
+	// ahead of the next expected sequence is dropped, since the gap in front of it is the least
+	// likely to be filled soon. The peer retransmits dropped segments. Zero means no limit.
+	MaxOutOfOrderQueueLength int
+
+	// PathMTUDiscovery enables Packetization Layer Path MTU Discovery (RFC 4821). The handler starts
+	// out with the smallest maximum segment size that every path must support, and now and then
+	// sends a segment of a larger size as a probe. When a probe is acknowledged, its size becomes
+	// the maximum segment size. When it isn't acknowledged after three attempts, the smaller size
+	// is kept and the probe's data is resent in segments of that size. Unlike HandlePacketTooBig,
+	// this finds the path MTU on networks that filter ICMP. The maximum segment size never exceeds
+	// the one announced by the peer or derived from the MTU.
+	PathMTUDiscovery bool
+
+	// LazyStream defers the creation of the stream to the traffic-manager until the peer sends its
+	// first payload byte, so that connections that are closed right after the handshake, such as
+	// those made by port scanners and health checks, never use a stream. The traffic-manager then
+	// doesn't dial the destination until the peer has sent something, which means that protocols
+	// where the server speaks first, like SSH and SMTP, will hang, so it's disabled by default.
+	LazyStream bool
+
+	// Chaos makes the handler delay and drop the segments that it sends to the peer, to simulate an
+	// adverse network. It's ignored unless the binary is built with the "chaos" build tag, so it
+	// can never be enabled in a production build. Nil means no chaos.
+	Chaos *ChaosConfig
+
+	// Clock is the source of time for the handler, used for all of its timers and timestamps. It
+	// exists so that tests can control the timing of retransmissions, timeouts, and the TIME-WAIT
+	// state. Nil means the real clock.
+	Clock Clock
+
+	// StreamResumeTimeout is how long a handler that uses ResumeOnStreamLoss keeps retrying to
+	// create a new stream when the creation fails, e.g. because the traffic-manager is briefly
+	// unreachable. The peer remains paused by a zero window meanwhile, so data that it wants to
+	// send is held back rather than lost. Zero means that the creation is attempted only once.
+	StreamResumeTimeout time.Duration
+
+	// EchoOptions are the kinds of TCP options that the handler doesn't understand but copies from
+	// the peer's SYN into the SYN-ACK and every segment after it, for middleboxes that require
+	// custom options to be echoed. The options are dropped if they don't fit in the SYN-ACK. Other
+	// unknown options are always dropped.
+	EchoOptions []uint8
+
+	// StopGracePeriod bounds the graceful close that Stop starts by sending a FIN. A connection
+	// that hasn't completed the FIN exchange with the peer when the period has passed is reset.
+	// Zero means that the close is only bounded by the resends of the FIN and the UserTimeout.
+	StopGracePeriod time.Duration
+
+	// StopPolicy is how Stop closes the connection. Defaults to StopGraceful. It only applies to
+	// calls to Stop, e.g. when the session ends, and not to closes that the handler initiates
+	// itself, such as when the traffic-manager closes its side.
+	StopPolicy StopPolicy
+}
+
+// InputOverflowPolicy determines what happens to packets from the TUN device when a handler's
+// input buffer is full.
+type InputOverflowPolicy int
+
+const (
+	// InputOverflowBlock makes the caller wait until the handler has room for the packets. The
+	// caller is typically the single loop that reads the TUN device, so a slow handler stalls all
+	// other connections.
+	InputOverflowBlock = InputOverflowPolicy(iota)
+
+	// InputOverflowDrop makes the handler drop the packets and count them in the InputDropped
+	// stats. The peer will retransmit the dropped segments, so only the slow connection suffers.
+	InputOverflowDrop
+)
+
+// StopPolicy determines how a handler closes its connection when Stop is called.
+type StopPolicy int
+
+const (
+	// StopGraceful makes Stop close the connection using a FIN in the states where that's legal,
+	// i.e. SYN-RECEIVED, ESTABLISHED, and CLOSE-WAIT. The connection is only reset when the
+	// StopGracePeriod is exceeded.
+	StopGraceful = StopPolicy(iota)
+
+	// StopAbrupt makes Stop reset the connection in every state except TIME-WAIT, so that
+	// it's torn down without waiting for the peer.
+	StopAbrupt
+)
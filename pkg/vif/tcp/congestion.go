@@ -0,0 +1,84 @@
+package tcp
+
+import (
+	"math"
+	"sync/atomic"
+)
+
+// defaultInitialCongestionWindow is the initial congestion window in segments, as recommended by
+// RFC 6928.
+const defaultInitialCongestionWindow = 10
+
+// initCongestionWindow sets the congestion window to the configured number of segments of the
+// current maximum segment size. A window that exceeds what the peer has advertised is reduced to
+// the peer's window, but never to less than one segment. It's called when the connection is
+// established.
+func (h *handler) initCongestionWindow() {
+	h.sendLock.Lock()
+	defer h.sendLock.Unlock()
+	iw := h.cfg.InitialCongestionWindow
+	if iw <= 0 {
+		iw = defaultInitialCongestionWindow
+	}
+	mss := h.maxSegmentSize()
+	cwnd := iw * mss
+	if pw := int(atomic.LoadInt64(&h.peerWindow)); pw > 0 && cwnd > pw {
+		cwnd = pw
+		if cwnd < mss {
+			cwnd = mss
+		}
+	}
+	h.cwnd = cwnd
+	h.ssthresh = math.MaxInt32
+}
+
+// sendWindow returns the number of bytes that may be sent to the peer before more data has been
+// acknowledged. It's limited by both the peer's receive window and the congestion window, once the
+// latter has been initialized. The sendLock must be held.
+func (h *handler) sendWindow() int {
+	window := int(atomic.LoadInt64(&h.peerWindow))
+	if h.cwnd > 0 && h.cwnd < window {
+		window = h.cwnd
+	}
+	return window - h.unackedBytes()
+}
+
+// growCongestionWindow grows the congestion window when the peer has acknowledged the given
+// number of new bytes. The growth is exponential in slow start and linear in congestion avoidance
+// (RFC 5681). The sendLock must be held.
+func (h *handler) growCongestionWindow(acked int) {
+	if h.cwnd == 0 || acked <= 0 {
+		return
+	}
+	mss := h.maxSegmentSize()
+	if h.cwnd < h.ssthresh {
+		if acked > mss {
+			acked = mss
+		}
+		h.cwnd += acked
+	} else {
+		inc := mss * mss / h.cwnd
+		if inc < 1 {
+			inc = 1
+		}
+		h.cwnd += inc
+	}
+	if h.cwnd > math.MaxInt32 {
+		h.cwnd = math.MaxInt32
+	}
+}
+
+// collapseCongestionWindow reduces the congestion window to one segment after a retransmit timeout,
+// and sets the slow start threshold to half the amount of unacknowledged data (RFC 5681). The
+// sendLock must be held.
+func (h *handler) collapseCongestionWindow() {
+	if h.cwnd == 0 {
+		return
+	}
+	mss := h.maxSegmentSize()
+	h.ssthresh = h.unackedBytes() / 2
+	if h.ssthresh < 2*mss {
+		h.ssthresh = 2 * mss
+	}
+	h.cwnd = mss
+}
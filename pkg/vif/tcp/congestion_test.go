@@ -0,0 +1,90 @@
+package tcp
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/tunnel"
+)
+
+// recvBurst returns the data segments that the handler sends before it waits for an acknowledgement.
+func (p *testPeer) recvBurst() []Header {
+	var burst []Header
+	for {
+		select {
+		case pkt := <-p.fromTun:
+			if tcpHdr := pkt.Header(); len(tcpHdr.Payload()) > 0 {
+				burst = append(burst, tcpHdr)
+			}
+		case <-time.After(200 * time.Millisecond):
+			return burst
+		}
+	}
+}
+
+func TestHandler_InitialCongestionWindow(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+
+	for _, iw := range []int{0, 2} {
+		p := newTestPeer(ctx, t, HandlerConfig{InitialCongestionWindow: iw})
+		p.connect(ctx)
+		mss := p.h.maxSegmentSize()
+		p.stream.fromMgr <- tunnel.NewMessage(tunnel.Normal, bytes.Repeat([]byte{'x'}, 20*mss))
+
+		expected := iw
+		if expected == 0 {
+			expected = defaultInitialCongestionWindow
+		}
+		burst := p.recvBurst()
+		require.Len(t, burst, expected)
+		for _, seg := range burst {
+			assert.Len(t, seg.Payload(), mss)
+		}
+
+		// Acknowledging the first segment grows the window by one segment in slow start, so two
+		// more segments are sent.
+		p.ack = burst[0].Sequence() + uint32(mss)
+		p.send(ctx, false, true, false, nil)
+		assert.Len(t, p.recvBurst(), 2)
+	}
+}
+
+func TestHandler_InitialCongestionWindowExceedsPeerWindow(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+
+	// The peer advertises a window of 0xffff bytes, which is less than 100 segments
+	p := newTestPeer(ctx, t, HandlerConfig{InitialCongestionWindow: 100})
+	p.connect(ctx)
+	p.h.sendLock.Lock()
+	cwnd := p.h.cwnd
+	p.h.sendLock.Unlock()
+	assert.Equal(t, 0xffff, cwnd)
+}
+
+func TestHandler_CongestionWindowCollapse(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+
+	p := newTestPeer(ctx, t, HandlerConfig{InitialCongestionWindow: 4})
+	p.connect(ctx)
+	mss := p.h.maxSegmentSize()
+	p.stream.fromMgr <- tunnel.NewMessage(tunnel.Normal, bytes.Repeat([]byte{'x'}, 4*mss))
+	require.Len(t, p.recvBurst(), 4)
+
+	// Nothing is acknowledged, so the segments are retransmitted and the window collapses to one
+	// segment, with a slow start threshold of half the unacknowledged data.
+	require.Eventually(t, func() bool { return p.h.Stats().TimerRetransmits > 0 }, 5*time.Second, 10*time.Millisecond)
+	p.h.sendLock.Lock()
+	cwnd, ssthresh := p.h.cwnd, p.h.ssthresh
+	p.h.sendLock.Unlock()
+	assert.Equal(t, mss, cwnd)
+	assert.Equal(t, 2*mss, ssthresh)
+}
@@ -0,0 +1,67 @@
+package tcp
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/ipproto"
+)
+
+// maxOptionsLen is the maximum length of the options of a TCP header.
+const maxOptionsLen = HeaderMaxLen - HeaderLen
+
+// echoesOption returns true if the given option kind is one of the HandlerConfig.EchoOptions.
+func (h *handler) echoesOption(kind optionKind) bool {
+	for _, k := range h.cfg.EchoOptions {
+		if optionKind(k) == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// initEchoOptions makes the handler include the given options from the peer's SYN in every
+// segment that it sends, padded with NOPs to a multiple of four bytes. The options are dropped
+// if they don't fit in the SYN-ACK. The maximum segment size is reduced to leave room for them.
+func (h *handler) initEchoOptions(ctx context.Context, opts []option) {
+	var echo []byte
+	for _, opt := range opts {
+		echo = append(echo, opt[:opt.len()]...)
+	}
+	if len(echo) == 0 {
+		return
+	}
+	for len(echo)%4 != 0 {
+		echo = append(echo, byte(noOp))
+	}
+	// The echoOptions aren't set yet, so the added options are the authentication option only.
+	if room := maxOptionsLen - h.synOptionsLen() - h.addedOptionsLen(); len(echo) > room {
+		dlog.Debugf(ctx, "   CON %s, options to echo need %d bytes but only %d are available, dropping them", h.name, len(echo), room)
+		return
+	}
+	h.echoOptions = echo
+	atomic.StoreInt32(&h.localMaxSegmentSize, int32(h.localSegmentSize(h.cfg.MTU)))
+}
+
+// withOptions returns a copy of the given packet with the given options appended to the options
+// of its TCP header.
+func withOptions(orig Packet, opts []byte) Packet {
+	origHdr := orig.Header()
+	hl := origHdr.DataOffset() * 4
+	origIP := orig.IPHeader()
+	ipLen := origIP.PayloadLen() + len(opts)
+	pkt := NewPacket(ipLen, origIP.Source(), origIP.Destination(), true)
+	ipHdr := pkt.IPHeader()
+	ipHdr.SetL4Protocol(ipproto.TCP)
+	ipHdr.SetPayloadLen(ipLen)
+	ipHdr.SetChecksum()
+
+	tcpHdr := pkt.Header()
+	copy(tcpHdr, origHdr[:hl])
+	copy(tcpHdr[hl:], opts)
+	copy(tcpHdr[hl+len(opts):], origHdr[hl:])
+	tcpHdr.SetDataOffset((hl + len(opts)) / 4)
+	tcpHdr.SetChecksum(ipHdr)
+	return pkt
+}
@@ -0,0 +1,61 @@
+package tcp
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/tunnel"
+)
+
+// experimentalOption is an option of a kind reserved for experiments (RFC 4727).
+var experimentalOption = []byte{253, 4, 0xab, 0xcd}
+
+func findOption(t *testing.T, hdr Header, kind optionKind) option {
+	opts, err := options(hdr)
+	require.NoError(t, err)
+	for _, opt := range opts {
+		if opt.kind() == kind {
+			return opt
+		}
+	}
+	return nil
+}
+
+func TestHandler_EchoOptions(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+	for _, echo := range []bool{false, true} {
+		cfg := HandlerConfig{}
+		if echo {
+			cfg.EchoOptions = []uint8{253}
+		}
+		p := newTestPeer(ctx, t, cfg)
+		p.synOptions = experimentalOption
+		p.send(ctx, true, false, false, nil)
+		synAck := p.recv().Header()
+		require.True(t, synAck.SYN())
+		opt := findOption(t, synAck, 253)
+		if !echo {
+			require.Nil(t, opt, "unknown options must be dropped by default")
+			continue
+		}
+		require.Equal(t, option(experimentalOption), opt)
+
+		p.ack = synAck.Sequence() + 1
+		p.send(ctx, false, true, false, nil)
+		require.Eventually(t, func() bool { return p.h.state() == stateEstablished }, 5*time.Second, time.Millisecond)
+
+		// Subsequent segments carry the option too, and it's accounted for in the segment size.
+		mss := p.h.maxSegmentSize()
+		require.Equal(t, maxSegmentSize-len(experimentalOption), mss)
+		p.stream.fromMgr <- tunnel.NewMessage(tunnel.Normal, make([]byte, mss))
+		seg := p.recv().Header()
+		require.Len(t, seg.Payload(), mss)
+		require.Equal(t, option(experimentalOption), findOption(t, seg, 253))
+		require.Len(t, seg, HeaderLen+len(experimentalOption)+mss)
+	}
+}
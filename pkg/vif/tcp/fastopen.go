@@ -0,0 +1,66 @@
+package tcp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"net"
+	"sync"
+)
+
+const (
+	// fastOpen is the kind of the TCP Fast Open Cookie option (RFC 7413).
+	fastOpen = optionKind(34)
+
+	// fastOpenCookieLen is the length of the cookies that handlers hand out.
+	fastOpenCookieLen = 8
+
+	// fastOpenOptionLen is the length of the option, including a cookie and two NOPs of padding.
+	fastOpenOptionLen = 2 + fastOpenCookieLen + 2
+)
+
+var (
+	fastOpenKeyOnce sync.Once
+	fastOpenKey     []byte
+)
+
+// fastOpenCookie returns the cookie that a client with the given IP must present in order to send
+// data in its SYN. Cookies are valid for the lifetime of the process.
+func fastOpenCookie(clientIP net.IP) []byte {
+	fastOpenKeyOnce.Do(func() {
+		fastOpenKey = make([]byte, sha256.Size)
+		if _, err := rand.Read(fastOpenKey); err != nil {
+			panic(err)
+		}
+	})
+	mac := hmac.New(sha256.New, fastOpenKey)
+	if ip4 := clientIP.To4(); ip4 != nil {
+		clientIP = ip4
+	}
+	mac.Write(clientIP)
+	return mac.Sum(nil)[:fastOpenCookieLen]
+}
+
+// fastOpenSyn examines the Fast Open option of a SYN from the peer. It returns true when the SYN
+// carries a valid cookie, so that its payload can be accepted. The handler's fastOpenCookie is set
+// when the SYN-ACK must give the peer a cookie, i.e. when the peer requested one or presented one
+// that isn't valid.
+func (h *handler) fastOpenSyn(opt option, clientIP net.IP) bool {
+	cookie := fastOpenCookie(clientIP)
+	if hmac.Equal(opt.data(), cookie) {
+		return true
+	}
+	h.fastOpenCookie = cookie
+	return false
+}
+
+// putFastOpenOption writes the Fast Open option with the handler's cookie to opts and returns the
+// number of bytes written.
+func (h *handler) putFastOpenOption(opts []byte) int {
+	opts[0] = byte(fastOpen)
+	opts[1] = byte(2 + len(h.fastOpenCookie))
+	copy(opts[2:], h.fastOpenCookie)
+	opts[2+fastOpenCookieLen] = byte(noOp)
+	opts[3+fastOpenCookieLen] = byte(noOp)
+	return fastOpenOptionLen
+}
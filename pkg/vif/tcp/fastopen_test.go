@@ -0,0 +1,94 @@
+package tcp
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+)
+
+// fastOpenOption returns a Fast Open option with the given cookie, padded with NOPs.
+func fastOpenOption(cookie []byte) []byte {
+	opt := []byte{byte(fastOpen), byte(2 + len(cookie))}
+	opt = append(opt, cookie...)
+	for len(opt)%4 != 0 {
+		opt = append(opt, byte(noOp))
+	}
+	return opt
+}
+
+// synAckCookie returns the Fast Open cookie of the given SYN-ACK, or nil if it has none.
+func synAckCookie(t *testing.T, synAck Header) []byte {
+	opts, err := options(synAck)
+	require.NoError(t, err)
+	for _, opt := range opts {
+		if opt.kind() == fastOpen {
+			return opt.data()
+		}
+	}
+	return nil
+}
+
+func TestHandler_FastOpen(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+
+	// The peer requests a cookie.
+	p := newTestPeer(ctx, t, HandlerConfig{FastOpen: true})
+	p.synOptions = fastOpenOption(nil)
+	p.send(ctx, true, false, false, nil)
+	cookie := synAckCookie(t, p.recv().Header())
+	require.Len(t, cookie, fastOpenCookieLen)
+
+	// The peer presents the cookie in a SYN with data. The SYN-ACK acknowledges the data, which is
+	// sent to the traffic-manager.
+	p = newTestPeer(ctx, t, HandlerConfig{FastOpen: true})
+	p.synOptions = fastOpenOption(cookie)
+	p.send(ctx, true, false, false, []byte("hello"))
+	synAck := p.recv().Header()
+	require.True(t, synAck.SYN())
+	require.Equal(t, p.seq, synAck.AckNumber())
+	require.Nil(t, synAckCookie(t, synAck))
+	p.ack = synAck.Sequence() + 1
+	p.send(ctx, false, true, false, nil)
+	require.Eventually(t, func() bool { return p.h.state() == stateEstablished }, 5*time.Second, time.Millisecond)
+	select {
+	case m := <-p.stream.toMgr:
+		require.Equal(t, []byte("hello"), m.Payload())
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for message to manager")
+	}
+}
+
+func TestHandler_FastOpenRejected(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+	cookie := fastOpenOption([]byte("notvalid"))
+	for _, enabled := range []bool{true, false} {
+		// The data of a SYN with an invalid cookie, or of any SYN when fast open is disabled, isn't
+		// acknowledged, so the peer retransmits it after the handshake.
+		p := newTestPeer(ctx, t, HandlerConfig{FastOpen: enabled})
+		p.synOptions = cookie
+		isn := p.seq
+		p.send(ctx, true, false, false, []byte("hello"))
+		synAck := p.recv().Header()
+		require.Equal(t, isn+1, synAck.AckNumber())
+		if enabled {
+			require.Len(t, synAckCookie(t, synAck), fastOpenCookieLen, "a valid cookie must be handed out")
+		} else {
+			require.Nil(t, synAckCookie(t, synAck))
+		}
+		p.seq = isn + 1
+		p.ack = synAck.Sequence() + 1
+		p.send(ctx, false, true, false, nil)
+		require.Eventually(t, func() bool { return p.h.state() == stateEstablished }, 5*time.Second, time.Millisecond)
+		select {
+		case m := <-p.stream.toMgr:
+			t.Fatalf("unexpected message to manager %q", m.Payload())
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
@@ -3,11 +3,15 @@ package tcp
 import (
 	"context"
 	"encoding/binary"
+	"errors"
 	"math/rand"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"golang.org/x/net/ipv4"
+
+	"github.com/datawire/dlib/dcontext"
 	"github.com/datawire/dlib/dlog"
 	"github.com/telepresenceio/telepresence/v2/pkg/ipproto"
 	"github.com/telepresenceio/telepresence/v2/pkg/tunnel"
@@ -26,6 +30,13 @@ const (
 	stateFinWait2
 	stateTimedWait
 	stateIdle
+
+	// stateCloseWait is entered when the peer has sent a FIN while the traffic-manager still
+	// has data to send. Only the direction from the traffic-manager to the peer remains open.
+	stateCloseWait
+
+	// stateLastAck is entered when our FIN has been sent in stateCloseWait
+	stateLastAck
 )
 
 func (s state) String() (txt string) {
@@ -44,14 +55,33 @@ func (s state) String() (txt string) {
 		txt = "FIN_WAIT_2"
 	case stateTimedWait:
 		txt = "TIMED WAIT"
+	case stateCloseWait:
+		txt = "CLOSE_WAIT"
+	case stateLastAck:
+		txt = "LAST_ACK"
 	default:
 		panic("unknown state")
 	}
 	return txt
 }
 
+// State returns the name of the handler's current state.
+func (h *handler) State() string {
+	return h.state().String()
+}
+
+// canSend returns true if data from the traffic-manager can be sent to the peer in this state.
+func (s state) canSend() bool {
+	return s == stateEstablished || s == stateCloseWait
+}
+
 const myWindowScale = 8
 const maxReceiveWindow = 4096 << myWindowScale // 1MB
+const maxUnscaledWindow = 0xffff
+
+// maxWindowScale is the largest shift count that the Window Scale option may carry (RFC 7323,
+// section 2.3). A larger count from the peer is treated as this one.
+const maxWindowScale = 14
 
 var maxSegmentSize = buffer.DataPool.MTU - (20 + HeaderLen) // Ethernet MTU of 1500 - 20 byte IP header and 20 byte TCP header
 var ioChannelSize = maxReceiveWindow / maxSegmentSize
@@ -62,6 +92,9 @@ type queueElement struct {
 	cTime    time.Time
 	packet   Packet
 	next     *queueElement
+
+	// probe is true when the segment is a path MTU probe, see HandlerConfig.PathMTUDiscovery
+	probe bool
 }
 
 type quitReason int
@@ -80,26 +113,105 @@ type PacketHandler interface {
 
 	// HandlePacket handles a packet that was read from the TUN device
 	HandlePacket(ctx context.Context, pkt Packet)
+
+	// TryHandlePacket handles a packet that was read from the TUN device unless the handler's input
+	// buffer is full, in which case it returns false without consuming the packet
+	TryHandlePacket(ctx context.Context, pkt Packet) bool
+
+	// HandlePackets handles a batch of packets that were read from the TUN device
+	HandlePackets(ctx context.Context, pkts []Packet)
+
+	// HandlePacketTooBig handles an ICMP "fragmentation needed" (IPv4) or "packet too big" (IPv6)
+	// for a segment that this handler sent. The mtu is the next-hop MTU reported by the ICMP message
+	// and sequence is the sequence number of the segment that was too big.
+	HandlePacketTooBig(ctx context.Context, mtu int, sequence uint32)
+
+	// HandleMTUChange makes the handler use segments that fit the given mtu when it sends from now
+	// on. It's called when the MTU of the TUN device, or of the network used when communicating
+	// with the cluster, has changed.
+	HandleMTUChange(ctx context.Context, mtu int)
+
+	// Stats returns a snapshot of the handler's properties and counters
+	Stats() Stats
+
+	// NegotiatedParams returns the parameters that were negotiated during the handshake
+	NegotiatedParams() NegotiatedParams
+
+	// State returns the name of the connection's current state, e.g. "ESTABLISHED" or
+	// "CLOSE_WAIT". It makes the handler a tunnel.StateReporter.
+	State() string
+
+	// MarshalState returns a versioned snapshot of the state of an ESTABLISHED connection
+	MarshalState() ([]byte, error)
+
+	// RestoreState restores a state produced by MarshalState. It must be called before Start
+	RestoreState(data []byte) error
+
+	// SetPacketInspector installs a function that is called with each segment that is read from,
+	// or written to, the TUN device, and that may drop it. It's intended for debugging.
+	SetPacketInspector(inspector PacketInspector)
 }
 
 type StreamCreator func(ctx context.Context) (tunnel.Stream, error)
 
+// connName is the connection ID, optionally followed by a descriptive label in parentheses.
+type connName struct {
+	id    tunnel.ConnID
+	label string
+}
+
+func (n connName) String() string {
+	if n.label == "" {
+		return n.id.String()
+	}
+	return n.id.String() + " (" + n.label + ")"
+}
+
 type handler struct {
 	streamCreator StreamCreator
 
+	// cfg is the configuration that this handler was created with
+	cfg HandlerConfig
+
+	// cancel cancels the context that the handler was started with
+	cancel context.CancelFunc
+
 	// Handle will have either a connection specific stream or a muxTunnel (the old style)
-	// depending on what the handler is talking to
+	// depending on what the handler is talking to. It's protected by the streamLock once the
+	// handler's goroutines are running, because it's replaced when a lost stream is resumed.
 	stream tunnel.Stream
 
+	// streamLock protects the stream and the stopStreamWriter
+	streamLock sync.Mutex
+
+	// stopStreamWriter stops the loop that writes the toMgrMsgCh to the stream. It's nil until
+	// the writeToMgrLoop has started.
+	stopStreamWriter context.CancelFunc
+
+	// streamPaused is set to 1 while a lost stream is being resumed. The receive window remains
+	// closed while it's set.
+	streamPaused int32
+
 	// id identifies this connection. It contains source and destination IPs and ports
 	id tunnel.ConnID
 
+	// name is what identifies this connection in logs and errors
+	name connName
+
 	// remove is the function that removes this instance from the pool
 	remove func()
 
 	// TUN I/O
 	toTun   ip.Writer
-	fromTun chan Packet
+	fromTun chan []Packet
+
+	// tunWrites is where tunWrite hands packets over to the tunWriter when a TunWriteTimeout is
+	// configured, and tunWriterDone is closed when the tunWriter has returned.
+	tunWrites     chan tunWriteRequest
+	tunWriterDone chan struct{}
+
+	// tunBatch is what remains of the batch that is currently processed
+	tunBatch []Packet
 
 	// the dispatcher signals its intent to close in dispatcherClosing. 0 == running, 1 == closing, 2 == closed
 	dispatcherClosing *int32
@@ -124,13 +236,23 @@ type handler struct {
 	// oooQueue is where out-of-order packets are placed until they can be processed
 	oooQueue *queueElement
 
+	// oooQueueLen is the number of packets in the oooQueue. It's only modified by the goroutine
+	// that processes the packets, but it's loaded atomically by Stats.
+	oooQueueLen int32
+
 	// wfState is the current workflow state
 	wfState state
 
 	// seq is the sequence that we provide in the packets we send to TUN
 	seq uint32
 
-	// seqAcked is the last sequence acked by the peer
+	// cwnd is the congestion window in bytes and ssthresh is the slow start threshold. They are
+	// protected by the sendLock. The cwnd is zero until data is sent for the first time.
+	cwnd     int
+	ssthresh int
+
+	// seqAcked is the last sequence acked by the peer. It's protected by the sendLock, but it's
+	// always stored atomically so that it can be loaded without holding the lock.
 	seqAcked uint32
 
 	// lastKnown is generally the same as last ACK except for when packets are lost when sending them
@@ -140,18 +262,25 @@ type handler struct {
 
 	// packetLostTimer starts on first packet loss and is reset when a packet succeeds. The connection is
 	// closed if the timer fires.
-	packetLostTimer *time.Timer
+	packetLostTimer Timer
 
 	// Packets lost counts the total number of packets that are lost, regardless of if they were
 	// recovered again.
 	packetsLost int64
 
-	// finalSeq is the ack sent with FIN when a connection is closing.
+	// finalSeq is the ack sent with FIN when a connection is closing. It's written by Stop, which
+	// may run concurrently with processPackets, so it must be accessed atomically.
 	finalSeq uint32
 
 	// myWindow and is the actual size of my window
 	myWindow int64
 
+	// advertisedWindow is the receive window that was last advertised to the peer
+	advertisedWindow int64
+
+	// tuner limits the receive window when ReceiveWindowAutoTuning is enabled
+	tuner rcvAutoTuner
+
 	// peerSeqToAck is the peer sequence that will be acked on next send
 	peerSeqToAck uint32
 
@@ -165,38 +294,248 @@ type handler struct {
 	// determine the actual peerWindow
 	peerWindowScale uint8
 
+	// windowScaling is true when the peer offered the Window Scale option in its SYN. Window sizes
+	// are neither scaled nor advertised with a scale in either direction unless it did.
+	windowScaling bool
+
+	// fastOpenCookie is the TCP Fast Open cookie that the SYN-ACK gives to the peer, or nil
+	fastOpenCookie []byte
+
+	// fastOpenAccepted is true when the payload of the peer's SYN was accepted using TCP Fast Open
+	fastOpenAccepted bool
+
 	// peerMaxSegmentSize is the maximum size of a segment sent to the peer (not counting IP-header)
 	peerMaxSegmentSize uint16
 
+	// localMaxSegmentSize is the maximum segment size that is announced to the peer. It's derived
+	// from the configured MTU, and updated by HandleMTUChange. Accessed atomically.
+	localMaxSegmentSize int32
+
+	// pathMaxSegmentSize is the maximum size of a segment imposed by the path MTU, as discovered
+	// by ICMP "fragmentation needed" messages or by probes. Zero means that no such limit has
+	// been discovered.
+	pathMaxSegmentSize int32
+
+	// mtu is the state of the path MTU discovery that uses probes
+	mtu mtuProber
+
+	// echoOptions are the options from the peer's SYN that are included in every segment that the
+	// handler sends, see HandlerConfig.EchoOptions. They're set before the SYN-ACK is sent.
+	echoOptions []byte
+
+	// chaos injects faults in the writes to the TUN device. Nil unless HandlerConfig.Chaos is set
+	// and the binary is built with the "chaos" build tag.
+	chaos *chaos
+
+	// clock is the source of time for the handler
+	clock Clock
+
+	// peerPermitsSACK is set to 1 when the peer's SYN contains the "SACK permitted" option
+	peerPermitsSACK int32
+
+	// negotiated is set to 1 once the options of the peer's SYN have been processed, so that the
+	// values that they determine can be read by NegotiatedParams
+	negotiated int32
+
+	// timerRetransmits counts the segments that were retransmitted by processResends
+	timerRetransmits uint64
+
+	// bytesToMgr counts the payload bytes that were delivered to the traffic-manager
+	bytesToMgr uint64
+
+	// bytesToTun counts the payload bytes that were sent to the peer, not counting retransmits
+	bytesToTun uint64
+
+	// retransmittedBytes counts the payload bytes that were retransmitted to the peer
+	retransmittedBytes uint64
+
+	// segmentsSent and segmentsReceived count all segments written to and read from the TUN device
+	segmentsSent     uint64
+	segmentsReceived uint64
+
+	// ao is the state of the TCP Authentication Option. It's set when the SYN is received, provided
+	// that the handler has an AuthOption.
+	ao *aoConn
+
+	// outOfWindow is the number of received segments that were discarded because their sequence
+	// was far outside the receive window.
+	outOfWindow uint64
+
+	// authFailures is the number of received segments that were discarded because they failed
+	// authentication.
+	authFailures uint64
+
+	// inputDropped is the number of received segments that were dropped because fromTun was full
+	inputDropped uint64
+
+	// tunWriteRetries is the number of writes to the TUN device that were retried
+	tunWriteRetries uint64
+
+	// streamResumes is the number of times that a lost stream to the traffic-manager was replaced
+	streamResumes uint64
+
+	// zeroWindowStalls is the number of times that sending to the peer had to wait for its
+	// window to open, and zeroWindowTime is the total time in nanoseconds spent waiting
+	zeroWindowStalls uint64
+	zeroWindowTime   int64
+
+	// dialAnswered is non-zero once the traffic-manager has answered the dial, either with a
+	// DialOK or with data. A stream that fails before that is considered rejected.
+	dialAnswered int32
+
+	// outOfOrderDropped is the number of out-of-order segments that were dropped because the
+	// oooQueue was full
+	outOfOrderDropped uint64
+
+	// inspector holds the PacketInspector, if any
+	inspector atomic.Value
+
+	// counters are the running totals of the tunnel.Pool that the handler belongs to, or nil
+	counters *tunnel.Counters
+
+	// createdAt is when the handler was created
+	createdAt time.Time
+
+	// lastActivity is when a segment was last received from or sent to the peer, in unix nanoseconds
+	lastActivity int64
+
+	// mgrQueueThrottled is set to 1 when the receive window has been closed because the number of
+	// packets waiting to be sent to the traffic-manager reached the ManagerQueueHighWatermark
+	mgrQueueThrottled int32
+
+	// mgrDropClosed is set to 1 when the receive window has been closed because sendToMgr dropped
+	// a packet. It's reset when the window is reopened.
+	mgrDropClosed int32
+
+	// mgrDropped is the number of packets that sendToMgr dropped because the queue to the
+	// traffic-manager was full
+	mgrDropped uint64
+
+	// mgrWindow is the largest receive window that the traffic-manager side has asked for using a
+	// WindowUpdate message, or -1 when it hasn't asked for any limit
+	mgrWindow int64
+
 	// sendLock and sendCondition are used when throttling writes to the TUN device
 	sendLock      sync.Mutex
 	sendCondition *sync.Cond
 
+	// writeLock is held while a segment that occupies sequence space is sent, so that such segments
+	// are written to the TUN device in sequence order without holding the sendLock during the write.
+	// It must be acquired before the sendLock.
+	writeLock sync.Mutex
+
+	// writing is the segment that sendToTun is writing while holding the writeLock, and
+	// writingReleased is set when that segment is removed from the ackWaitQueue during the write.
+	// Both are protected by the sendLock.
+	writing         Packet
+	writingReleased bool
+
 	// random generator for initial sequence number
 	rnd *rand.Rand
+
+	// goroutines tracks the goroutines of this handler when a LeakWatchdog is in use
+	goroutines *goroutineTracker
+
+	// stallWatchdog watches the progress of processPackets, if stall detection is enabled
+	stallWatchdog *StallWatchdog
+
+	// tracer receives the state transitions of this handler, if tracing is enabled
+	tracer StateTracer
+
+	// metadata describes what this connection belongs to, for the tracer
+	metadata ConnMetadata
+
+	// sink receives copies of the payloads of this handler, if traffic is captured
+	sink TrafficSink
+
+	// recorder receives the ConnRecord of this handler when it terminates, if any
+	recorder ConnRecorder
+
+	// closeReason is the reason why processPackets ended
+	closeReason quitReason
+
+	// budget is the memory budget that this handler shares with other handlers, if any
+	budget *MemoryBudget
+
+	// budgetReleased is set when the handler's buffered bytes have been removed from the budget
+	budgetReleased bool
+
+	// bufferedBytes is the number of payload bytes in the ackWaitQueue and the oooQueue
+	bufferedBytes int64
 }
 
+// NewHandler creates a handler for the TCP connection with the given id. The label, when not
+// empty, describes the connection in human terms (e.g. the name of the service that it's
+// directed to) and is included in all log messages that concern the connection.
 func NewHandler(
 	streamCreator StreamCreator,
 	dispatcherClosing *int32,
 	toTun ip.Writer,
 	id tunnel.ConnID,
+	label string,
 	remove func(),
 	rndSource rand.Source,
+	cfg HandlerConfig,
 ) PacketHandler {
+	clock := cfg.Clock
+	if clock == nil {
+		clock = realClock{}
+	}
+	now := clock.Now()
+	// A handler that is torn down by the StallWatchdog is removed before its processPackets
+	// returns, and must not remove a new handler with the same id from the pool at that point.
+	var removeOnce sync.Once
 	h := &handler{
 		streamCreator:     streamCreator,
+		cfg:               cfg,
+		createdAt:         now,
+		lastActivity:      now.UnixNano(),
 		id:                id,
-		remove:            remove,
+		name:              connName{id: id, label: label},
+		remove:            func() { removeOnce.Do(remove) },
 		toTun:             toTun,
 		dispatcherClosing: dispatcherClosing,
-		fromTun:           make(chan Packet, ioChannelSize),
+		fromTun:           make(chan []Packet, ioChannelSize),
 		toMgrCh:           make(chan Packet, ioChannelSize),
 		toMgrMsgCh:        make(chan tunnel.Message),
 		myWindow:          maxReceiveWindow,
+		mgrWindow:         -1,
 		wfState:           stateIdle,
 		rnd:               rand.New(rndSource),
 		tunDone:           make(chan struct{}),
+		clock:             clock,
+	}
+	h.localMaxSegmentSize = int32(h.localSegmentSize(h.cfg.MTU))
+	if h.cfg.TunWriteTimeout > 0 {
+		h.tunWrites = make(chan tunWriteRequest)
+		h.tunWriterDone = make(chan struct{})
+	}
+	if h.cfg.ReceiveWindowAutoTuning {
+		if mx := h.cfg.MaxReceiveWindow; mx <= 0 || mx > maxReceiveWindow {
+			h.cfg.MaxReceiveWindow = maxReceiveWindow
+		}
+		h.tuner.limit = autoTuneInitialWindow
+		if h.cfg.MaxReceiveWindow < autoTuneInitialWindow {
+			h.tuner.limit = int64(h.cfg.MaxReceiveWindow)
+		}
+		h.myWindow = h.tuner.limit
+	}
+	if hw := h.cfg.SendBufferHighWatermark; hw > 0 {
+		if lw := h.cfg.SendBufferLowWatermark; lw <= 0 || lw > hw {
+			h.cfg.SendBufferLowWatermark = hw / 2
+		}
+	}
+	if hw := h.cfg.ManagerQueueHighWatermark; hw > 0 {
+		if hw > ioChannelSize {
+			hw = ioChannelSize
+			h.cfg.ManagerQueueHighWatermark = hw
+		}
+		if lw := h.cfg.ManagerQueueLowWatermark; lw <= 0 || lw > hw {
+			h.cfg.ManagerQueueLowWatermark = hw / 2
+		}
+	}
+	if h.cfg.Chaos != nil {
+		h.chaos = newChaos(h.cfg.Chaos, h.rnd.Int63(), clock)
 	}
 	h.sendCondition = sync.NewCond(&h.sendLock)
 	return h
@@ -207,36 +546,106 @@ func (h *handler) RandomSequence() int32 {
 }
 
 func (h *handler) HandlePacket(ctx context.Context, pkt Packet) {
-	select {
-	case <-ctx.Done():
-		dlog.Debugf(ctx, "!! TUN %s discarded because context is cancelled", pkt)
-	case <-h.tunDone:
-		dlog.Debugf(ctx, "!! TUN %s discarded because TCP handler's input processing was cancelled", pkt)
-	case h.fromTun <- pkt:
-	}
+	h.HandlePackets(ctx, []Packet{pkt})
 }
 
+// Stop closes the connection according to the HandlerConfig.StopPolicy.
 func (h *handler) Stop(ctx context.Context) {
-	if h.state() == stateEstablished || h.state() == stateSynReceived {
-		h.setState(ctx, stateFinWait1)
+	if h.cfg.StopPolicy == StopAbrupt {
+		h.stopAbrupt(ctx)
+		return
+	}
+	h.stopGraceful(ctx)
+}
+
+// stopGraceful starts closing the connection using a FIN in the states where that's legal. It's
+// also used when the traffic-manager closes its side of the connection, regardless of the
+// StopPolicy.
+func (h *handler) stopGraceful(ctx context.Context) {
+	switch h.state() {
+	case stateEstablished, stateSynReceived:
+		h.setState(ctx, stateFinWait1, nil)
+		h.sendFin(ctx, true)
+		h.armStopGrace(ctx)
+	case stateCloseWait:
+		// The peer has already closed its side, so this closes the connection.
+		h.setState(ctx, stateLastAck, nil)
 		h.sendFin(ctx, true)
+		h.armStopGrace(ctx)
 	}
 	// Wake up if waiting for larger window size (ends processPayload)
 	h.sendCondition.Broadcast()
 }
 
+// stopAbrupt resets the connection in the states where the peer knows about it, except
+// TIME-WAIT, where both sides have already closed it.
+func (h *handler) stopAbrupt(ctx context.Context) {
+	switch h.state() {
+	case stateSynReceived, stateEstablished, stateFinWait1, stateFinWait2, stateCloseWait, stateLastAck:
+		select {
+		case <-h.tunDone:
+		default:
+			dlog.Debugf(ctx, "   CON %s, stopped, resetting", h.name)
+			h.sendReset(ctx)
+		}
+	}
+	// Wake up if waiting for larger window size (ends processPayload)
+	h.sendCondition.Broadcast()
+}
+
+// armStopGrace resets the connection if the FIN exchange that Stop started hasn't completed when
+// the HandlerConfig.StopGracePeriod has passed. A connection that reaches TIME-WAIT, or that is
+// closed, within the grace period is left alone.
+func (h *handler) armStopGrace(ctx context.Context) {
+	grace := h.cfg.StopGracePeriod
+	if grace <= 0 {
+		return
+	}
+	// The caller's context is often cancelled right after Stop returns.
+	ctx = dcontext.WithoutCancel(ctx)
+	h.clock.AfterFunc(grace, func() {
+		select {
+		case <-h.tunDone:
+			return
+		default:
+		}
+		switch h.state() {
+		case stateFinWait1, stateFinWait2, stateLastAck:
+			dlog.Debugf(ctx, "   CON %s, not closed within grace period %s, resetting", h.name, grace)
+			h.sendReset(ctx)
+		}
+	})
+}
+
 // Reset replies to the sender of the initialPacket with a RST packet.
 func (h *handler) Reset(ctx context.Context, initialPacket ip.Packet) error {
 	return h.toTun.Write(ctx, initialPacket.(Packet).Reset())
 }
 
 func (h *handler) Start(ctx context.Context) {
-	ctx, cancel := context.WithCancel(ctx)
-	go h.processResends(ctx)
-	go func() {
-		defer cancel()
+	h.goroutines = getLeakWatchdog(ctx).track(h.id)
+	h.budget = getMemoryBudget(ctx)
+	h.tracer = getStateTracer(ctx)
+	h.metadata = getConnMetadata(ctx)
+	h.sink = getTrafficSink(ctx)
+	h.recorder = getConnRecorder(ctx)
+	h.counters = tunnel.CountersFrom(ctx)
+	h.stallWatchdog = getStallWatchdog(ctx)
+	h.budget.register(atomic.LoadInt64(&h.bufferedBytes))
+	ctx, h.cancel = context.WithCancel(ctx)
+	if h.tunWrites != nil {
+		h.goTracked(ctx, "tunWriter", h.tunWriter)
+	}
+	h.goTracked(ctx, "processResends", h.processResends)
+	h.goTracked(ctx, "processPackets", func(ctx context.Context) {
+		defer h.cancel()
+		h.stallWatchdog.watch(h)
 		defer func() {
+			h.stallWatchdog.unwatch(h)
 			h.remove()
+			h.releaseBudget()
+			h.goroutines.close()
+			h.recordClose()
 			// Drain any incoming to unblock
 			for {
 				select {
@@ -247,18 +656,37 @@ func (h *handler) Start(ctx context.Context) {
 			}
 		}()
 		defer func() {
-			if h.stream != nil {
-				_ = h.stream.CloseSend(ctx)
+			if s := h.getStream(); s != nil {
+				_ = s.CloseSend(ctx)
 			}
 		}()
+		if h.state() == stateEstablished {
+			// The state was restored using RestoreState
+			if err := h.adopt(ctx); err != nil {
+				dlog.Errorf(ctx, "!! CON %s, unable to adopt connection: %v", h.name, err)
+				h.sendReset(ctx)
+			}
+		}
 		h.processPackets(ctx)
 		h.wg.Wait()
+	})
+}
+
+// goTracked runs the given function in a goroutine that is registered with the LeakWatchdog, if any.
+func (h *handler) goTracked(ctx context.Context, name string, f func(context.Context)) {
+	h.goroutines.started(name)
+	go func() {
+		defer h.goroutines.stopped(name)
+		f(ctx)
 	}()
 }
 
 func (h *handler) sendToTun(ctx context.Context, pkt Packet, seqAdd uint32, forceAck bool) {
+	if seqAdd > 0 {
+		h.writeLock.Lock()
+		defer h.writeLock.Unlock()
+	}
 	h.sendLock.Lock()
-	defer h.sendLock.Unlock()
 	ackNbr := h.peerSequenceToAck()
 	seq := h.sequence()
 	tcpHdr := pkt.Header()
@@ -266,22 +694,23 @@ func (h *handler) sendToTun(ctx context.Context, pkt Packet, seqAdd uint32, forc
 		sq := h.addSequence(seqAdd)
 		h.ackWaitQueue = &queueElement{
 			sequence: sq,
-			cTime:    time.Now(),
+			cTime:    h.clock.Now(),
 			packet:   pkt,
 			next:     h.ackWaitQueue,
+			probe:    h.takeMTUProbe(int(seqAdd)),
 		}
-		wz := int(h.peerWindow) - int(sq-h.seqAcked)
+		h.account(len(tcpHdr.Payload()))
+		wz := int(atomic.LoadInt64(&h.peerWindow)) - int(sq-h.seqAcked)
 		h.ackWaitQueueSize++
 		if h.ackWaitQueueSize%200 == 0 {
 			dlog.Tracef(ctx, "   CON %s, Ack-queue size %d, seq %d peer window size %d",
-				h.id, h.ackWaitQueueSize, h.ackWaitQueue.sequence, wz)
-		}
-	} else {
-		defer pkt.Release()
-		if !forceAck && ackNbr == h.peerSequenceAcked() && tcpHdr.NoFlags() {
-			// Redundant, skip it
-			return
+				h.name, h.ackWaitQueueSize, h.ackWaitQueue.sequence, wz)
 		}
+	} else if !forceAck && ackNbr == h.peerSequenceAcked() && tcpHdr.NoFlags() {
+		// Redundant, skip it
+		h.sendLock.Unlock()
+		pkt.Release()
+		return
 	}
 
 	tcpHdr.SetACK(true)
@@ -289,15 +718,198 @@ func (h *handler) sendToTun(ctx context.Context, pkt Packet, seqAdd uint32, forc
 	tcpHdr.SetAckNumber(ackNbr)
 	tcpHdr.SetChecksum(pkt.IPHeader())
 	h.setPeerSequenceAcked(ackNbr)
-	if err := h.toTun.Write(ctx, pkt); err != nil {
-		dlog.Errorf(ctx, "!! TUN %s: %v", h.id, err)
+	if seqAdd == 0 {
+		// A segment that doesn't occupy sequence space is owned by this call.
+		h.sendLock.Unlock()
+		if err := h.tunWrite(ctx, pkt); err != nil {
+			dlog.Errorf(ctx, "!! TUN %s: %v", h.name, err)
+		}
+		pkt.Release()
+		return
+	}
+
+	// The segment is in the ackWaitQueue, where it's released when it's acknowledged. It's written
+	// without holding the lock, so that received packets are processed meanwhile, and if it's
+	// removed from the queue during the write, it's released here once the write is complete.
+	h.writing = pkt
+	h.sendLock.Unlock()
+	err := h.tunWrite(ctx, pkt)
+	if err != nil {
+		dlog.Errorf(ctx, "!! TUN %s: %v", h.name, err)
+	}
+	h.sendLock.Lock()
+	released := h.writingReleased
+	h.writing = nil
+	h.writingReleased = false
+	h.sendLock.Unlock()
+	if released {
+		pkt.Release()
+	}
+}
+
+// releaseQueued releases a segment that has been removed from the ackWaitQueue, unless sendToTun
+// is writing it, in which case sendToTun releases it when the write is complete. The sendLock must
+// be held.
+func (h *handler) releaseQueued(pkt Packet) {
+	if pkt == h.writing {
+		h.writingReleased = true
+		return
+	}
+	pkt.Release()
+}
+
+// tunWriteRetries is the maximum number of times that a write to the TUN device that failed with a
+// retriable error is retried. The delay before the first retry is tunWriteRetryDelay, and it's
+// doubled for each retry after that.
+const (
+	tunWriteRetries    = 4
+	tunWriteRetryDelay = time.Millisecond
+)
+
+// writeToTun writes the given packet to the TUN device and counts it when successful. Writes that
+// fail with a retriable error are retried.
+func (h *handler) writeToTun(ctx context.Context, pkt Packet) error {
+	delay := tunWriteRetryDelay
+	for retry := 0; ; retry++ {
+		err := h.toTun.Write(ctx, pkt)
+		if err == nil {
+			atomic.AddUint64(&h.segmentsSent, 1)
+			h.touch()
+			return nil
+		}
+		if retry == tunWriteRetries || !ip.IsRetriable(err) {
+			return err
+		}
+		atomic.AddUint64(&h.tunWriteRetries, 1)
+		dlog.Debugf(ctx, "   CON %s, write to TUN failed, retrying in %s: %v", h.name, delay, err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-h.clock.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// touch records that the connection was active.
+func (h *handler) touch() {
+	atomic.StoreInt64(&h.lastActivity, h.clock.Now().UnixNano())
+}
+
+// errTunWriteTimeout is returned by tunWrite when a write didn't complete within the TunWriteTimeout.
+var errTunWriteTimeout = errors.New("timeout writing to TUN device")
+
+// tunWrite writes the given packet to the TUN device. When a TunWriteTimeout is configured and the
+// write doesn't complete in time, the handler's context is cancelled and errTunWriteTimeout is
+// returned. The handler's context is also cancelled when the write fails with an error that isn't
+// retriable, or when the retries are exhausted. The packet remains owned by the caller.
+func (h *handler) tunWrite(ctx context.Context, pkt Packet) (err error) {
+	defer func() {
+		if err != nil && !errors.Is(err, errTunWriteTimeout) && ctx.Err() == nil {
+			dlog.Errorf(ctx, "!! CON %s, write to TUN failed, terminating connection: %v", h.name, err)
+			h.cancel()
+		}
+	}()
+	if !h.inspect(ToPeer, pkt) || h.chaos.drop(pkt) {
+		// Dropped, as if it was lost on its way to the peer
+		return nil
+	}
+	h.chaos.delay(ctx)
+	out := pkt
+	if h.echoOptions != nil {
+		out = withOptions(pkt, h.echoOptions)
+	}
+	if h.ao != nil {
+		signed := h.ao.sign(out)
+		if out != pkt {
+			out.Release()
+		}
+		out = signed
+	}
+	if h.tunWrites == nil {
+		err = h.writeToTun(ctx, out)
+		if out != pkt {
+			out.Release()
+		}
+		return err
+	}
+	if out == pkt {
+		// A write that times out continues in the tunWriter, so it must be given a packet of its own.
+		out = h.copySegment(pkt)
+	}
+	return h.timedTunWrite(ctx, out)
+}
+
+// tunWriteRequest is a packet that the tunWriter writes to the TUN device, and the channel that
+// receives the result of the write.
+type tunWriteRequest struct {
+	pkt    Packet
+	result chan error
+}
+
+// tunWriter writes the packets that timedTunWrite hands over to the TUN device and releases them.
+// It's the only goroutine that writes to the TUN device on behalf of a handler that uses a
+// TunWriteTimeout, so a stalled write never leaves more than one goroutine behind.
+func (h *handler) tunWriter(ctx context.Context) {
+	defer close(h.tunWriterDone)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case rq := <-h.tunWrites:
+			rq.result <- h.writeToTun(ctx, rq.pkt)
+			rq.pkt.Release()
+		}
+	}
+}
+
+// timedTunWrite hands the given packet over to the tunWriter and waits for the result of the write
+// for at most the TunWriteTimeout. The packet is released by the time the write is complete, also
+// when the write times out.
+func (h *handler) timedTunWrite(ctx context.Context, pkt Packet) error {
+	timeout := h.cfg.TunWriteTimeout
+	expired := make(chan struct{})
+	timer := h.clock.AfterFunc(timeout, func() { close(expired) })
+	defer timer.Stop()
+	rq := tunWriteRequest{pkt: pkt, result: make(chan error, 1)}
+	select {
+	case h.tunWrites <- rq:
+	case <-h.tunWriterDone:
+		// The handler's context is cancelled.
+		pkt.Release()
+		return context.Canceled
+	case <-ctx.Done():
+		pkt.Release()
+		return ctx.Err()
+	case <-expired:
+		pkt.Release()
+		return h.tunWriteTimedOut(ctx, timeout)
+	}
+	select {
+	case err := <-rq.result:
+		return err
+	case <-expired:
+		return h.tunWriteTimedOut(ctx, timeout)
 	}
 }
 
+// tunWriteTimedOut terminates the connection because a write to the TUN device took longer than the
+// given timeout.
+func (h *handler) tunWriteTimedOut(ctx context.Context, timeout time.Duration) error {
+	dlog.Errorf(ctx, "!! CON %s, write to TUN stalled for more than %s, terminating connection", h.name, timeout)
+	h.cancel()
+	return errTunWriteTimeout
+}
+
 func (h *handler) newResponse(ipPayloadLen int, withAck bool) Packet {
 	pkt := NewPacket(ipPayloadLen, h.id.Destination(), h.id.Source(), withAck)
 	ipHdr := pkt.IPHeader()
 	ipHdr.SetL4Protocol(ipproto.TCP)
+	if v4Hdr, ok := ipHdr.(ip.V4Header); ok {
+		// Set the DF bit so that routers reply with ICMP "fragmentation needed" instead of
+		// fragmenting. Those replies are dispatched to HandlePacketTooBig.
+		v4Hdr.SetFlags(ipv4.DontFragment)
+	}
 	ipHdr.SetChecksum()
 
 	tcpHdr := Header(ipHdr.Payload())
@@ -323,28 +935,66 @@ func (h *handler) sendFin(ctx context.Context, expectAck bool) {
 	l := uint32(0)
 	if expectAck {
 		l = 1
-		h.finalSeq = h.sequence()
+		atomic.StoreUint32(&h.finalSeq, h.sequence())
 	}
 	h.sendToTun(ctx, pkt, l, true)
 }
 
+// sendReset sends a RST to the peer and cancels the handler's context, which terminates
+// the connection without further negotiation.
+func (h *handler) sendReset(ctx context.Context) {
+	pkt := h.newResponse(HeaderLen, false)
+	tcpHdr := pkt.Header()
+	tcpHdr.SetRST(true)
+	tcpHdr.SetACK(true)
+	h.sendLock.Lock()
+	tcpHdr.SetSequence(h.seqAcked)
+	h.sendLock.Unlock()
+	tcpHdr.SetAckNumber(h.peerSequenceToAck())
+	tcpHdr.SetChecksum(pkt.IPHeader())
+	if err := h.tunWrite(ctx, pkt); err != nil {
+		dlog.Errorf(ctx, "!! CON %s, send of RST failed: %v", h.name, err)
+	}
+	pkt.Release()
+	h.cancel()
+}
+
 func (h *handler) sendSynReply(ctx context.Context, syn Packet) {
 	synHdr := syn.Header()
 	if !synHdr.SYN() {
 		return
 	}
-	h.setPeerSequenceToAck(synHdr.Sequence() + 1)
+	ack := synHdr.Sequence() + 1
+	if h.fastOpenAccepted {
+		ack += uint32(len(synHdr.Payload()))
+	}
+	h.setPeerSequenceToAck(ack)
 	h.sendSyn(ctx)
 }
 
+// synOptionsLen returns the length of the options that sendSyn puts in the SYN-ACK.
+func (h *handler) synOptionsLen() int {
+	l := 4 // for the Maximum Segment Size option
+	if h.windowScaling {
+		l += 4 // for the Window Scale option
+	}
+	if h.fastOpenCookie != nil {
+		l += fastOpenOptionLen
+	}
+	return l
+}
+
 func (h *handler) sendSyn(ctx context.Context) {
-	hl := HeaderLen
-	hl += 8 // for the Maximum Segment Size option and for the Window Scale option
+	hl := HeaderLen + h.synOptionsLen()
 
 	pkt := h.newResponse(hl, true)
 	tcpHdr := pkt.Header()
 	tcpHdr.SetSYN(true)
-	tcpHdr.SetWindowSize(maxReceiveWindow >> myWindowScale) // The SYN packet itself is not subject to scaling
+	if h.windowScaling {
+		tcpHdr.SetWindowSize(maxReceiveWindow >> myWindowScale) // The SYN packet itself is not subject to scaling
+	} else {
+		tcpHdr.SetWindowSize(uint16(h.receiveWindow()))
+	}
 
 	// adjust data offset to account for options
 	tcpHdr.SetDataOffset(hl / 4)
@@ -352,32 +1002,90 @@ func (h *handler) sendSyn(ctx context.Context) {
 	opts := tcpHdr.OptionBytes()
 	opts[0] = byte(maximumSegmentSize)
 	opts[1] = 4
-	binary.BigEndian.PutUint16(opts[2:], uint16(maxSegmentSize))
+	binary.BigEndian.PutUint16(opts[2:], uint16(atomic.LoadInt32(&h.localMaxSegmentSize)))
+
+	opts = opts[4:]
 
-	opts[4] = byte(windowScale)
-	opts[5] = 3
-	opts[6] = myWindowScale
-	opts[7] = byte(noOp)
+	if h.windowScaling {
+		opts[0] = byte(windowScale)
+		opts[1] = 3
+		opts[2] = myWindowScale
+		opts[3] = byte(noOp)
+		opts = opts[4:]
+	}
+	if h.fastOpenCookie != nil {
+		h.putFastOpenOption(opts)
+	}
 	h.sendToTun(ctx, pkt, 1, true)
 }
 
+// unackedBytes returns the number of bytes sent to the peer that are not yet acknowledged.
+// The sendLock must be held when calling this method.
+func (h *handler) unackedBytes() int {
+	return int(h.sequence() - h.seqAcked)
+}
+
+// awaitSendBuffer blocks while the number of unacknowledged bytes exceeds the send buffer's high
+// watermark and returns when it has dropped to the low watermark. The sendLock must be held when
+// calling this method. The method returns false if the connection is no longer established.
+func (h *handler) awaitSendBuffer(ctx context.Context) bool {
+	hw := h.cfg.SendBufferHighWatermark
+	if hw <= 0 || h.unackedBytes() < hw {
+		return true
+	}
+	dlog.Debugf(ctx, "   CON %s, %d unacknowledged bytes, pausing reads from manager", h.name, h.unackedBytes())
+	for h.unackedBytes() > h.cfg.SendBufferLowWatermark {
+		h.sendCondition.Wait()
+		if !h.state().canSend() {
+			return false
+		}
+	}
+	dlog.Debugf(ctx, "   CON %s, %d unacknowledged bytes, resuming reads from manager", h.name, h.unackedBytes())
+	return true
+}
+
+// awaitWindowSize blocks while the intended receiver isn't accepting data and returns the send
+// window once it has opened. The wait is counted as a zero-window stall. The sendLock must be held
+// when calling this method. The method returns false if the connection can no longer send.
+func (h *handler) awaitWindowSize(ctx context.Context) (int, bool) {
+	window := h.sendWindow()
+	if window > 0 {
+		return window, true
+	}
+	dlog.Debugf(ctx, "   CON %s TCP window is zero", h.name)
+	start := h.clock.Now()
+	atomic.AddUint64(&h.zeroWindowStalls, 1)
+	defer func() {
+		d := h.clock.Now().Sub(start)
+		atomic.AddInt64(&h.zeroWindowTime, int64(d))
+		h.counters.AddZeroWindowStall(d)
+	}()
+	for window <= 0 {
+		h.sendCondition.Wait()
+		if !h.state().canSend() {
+			return 0, false
+		}
+		window = h.sendWindow()
+	}
+	return window, true
+}
+
 func (h *handler) processPayload(ctx context.Context, data []byte) {
+	h.capture(ToPeer, data)
 	start := 0
 	n := len(data)
 	for n > start {
 		h.sendLock.Lock()
-		window := int(h.peerWindow) - int(h.sequence()-h.seqAcked)
-		for window <= 0 {
-			// The intended receiver is currently not accepting data. We must
-			// wait for the window to increase.
-			dlog.Debugf(ctx, "   CON %s TCP window is zero", h.id)
-			h.sendCondition.Wait()
-			if h.state() != stateEstablished {
-				h.sendLock.Unlock()
-				return
-			}
-			window = int(h.peerWindow) - int(h.sequence()-h.seqAcked)
+		if !h.awaitSendBuffer(ctx) {
+			h.sendLock.Unlock()
+			return
+		}
+		window, ok := h.awaitWindowSize(ctx)
+		if !ok {
+			h.sendLock.Unlock()
+			return
 		}
+		probeSize := h.mtuProbeSize(h.clock.Now(), n-start, window)
 		h.sendLock.Unlock()
 
 		// Give up if done is closed
@@ -390,12 +1098,15 @@ func (h *handler) processPayload(ctx context.Context, data []byte) {
 		}
 
 		mxSend := n - start
-		if mxSend > int(h.peerMaxSegmentSize) {
-			mxSend = int(h.peerMaxSegmentSize)
+		if mss := h.maxSegmentSize(); mxSend > mss {
+			mxSend = mss
 		}
 		if mxSend > window {
 			mxSend = window
 		}
+		if probeSize > 0 {
+			mxSend = probeSize
+		}
 
 		pkt := h.newResponse(HeaderLen+mxSend, true)
 		ipHdr := pkt.IPHeader()
@@ -407,6 +1118,8 @@ func (h *handler) processPayload(ctx context.Context, data []byte) {
 		copy(tcpHdr.Payload(), data[start:end])
 		tcpHdr.SetPSH(end == n)
 		h.sendToTun(ctx, pkt, uint32(mxSend), false)
+		atomic.AddUint64(&h.bytesToTun, uint64(mxSend))
+		h.counters.AddBytesIn(uint64(mxSend))
 
 		// Decrease the window size with the bytes that we just sent unless it's already updated
 		// from a received packet
@@ -418,13 +1131,22 @@ func (h *handler) processPayload(ctx context.Context, data []byte) {
 func (h *handler) idle(ctx context.Context, syn Packet) quitReason {
 	tcpHdr := syn.Header()
 	if tcpHdr.RST() {
-		dlog.Errorf(ctx, "   CON %s, got RST while idle", h.id)
+		dlog.Errorf(ctx, "   CON %s, got RST while idle", h.name)
 		syn.Release()
 		return quitByReset
 	}
 	if !tcpHdr.SYN() {
 		if err := h.toTun.Write(ctx, syn.Reset()); err != nil {
-			dlog.Errorf(ctx, "!! CON %s, send of RST failed: %v", h.id, err)
+			dlog.Errorf(ctx, "!! CON %s, send of RST failed: %v", h.name, err)
+		}
+		syn.Release()
+		return quitByUs
+	}
+
+	if h.budget.exhausted() {
+		dlog.Errorf(ctx, "!! CON %s, memory budget of %d bytes is exhausted, resetting", h.name, h.budget.Limit())
+		if err := h.toTun.Write(ctx, syn.Reset()); err != nil {
+			dlog.Errorf(ctx, "!! CON %s, send of RST failed: %v", h.name, err)
 		}
 		syn.Release()
 		return quitByUs
@@ -434,41 +1156,77 @@ func (h *handler) idle(ctx context.Context, syn Packet) quitReason {
 	if err != nil {
 		dlog.Error(ctx, err)
 		if err := h.toTun.Write(ctx, syn.Reset()); err != nil {
-			dlog.Errorf(ctx, "!! CON %s, send of RST failed: %v", h.id, err)
+			dlog.Errorf(ctx, "!! CON %s, send of RST failed: %v", h.name, err)
 		}
 		syn.Release()
 		return quitByUs
 	}
+	var echoOpts []option
 	for _, synOpt := range synOpts {
 		switch synOpt.kind() {
 		case maximumSegmentSize:
 			h.peerMaxSegmentSize = binary.BigEndian.Uint16(synOpt.data())
-			dlog.Tracef(ctx, "   CON %s maximum segment size %d", h.id, h.peerMaxSegmentSize)
+			dlog.Tracef(ctx, "   CON %s maximum segment size %d", h.name, h.peerMaxSegmentSize)
 		case windowScale:
 			h.peerWindowScale = synOpt.data()[0]
-			dlog.Tracef(ctx, "   CON %s window scale %d", h.id, h.peerWindowScale)
+			if h.peerWindowScale > maxWindowScale {
+				dlog.Debugf(ctx, "   CON %s window scale %d exceeds %d", h.name, h.peerWindowScale, maxWindowScale)
+				h.peerWindowScale = maxWindowScale
+			}
+			h.windowScaling = true
+			dlog.Tracef(ctx, "   CON %s window scale %d", h.name, h.peerWindowScale)
 		case selectiveAckPermitted:
-			dlog.Tracef(ctx, "   CON %s selective acknowledgments permitted", h.id)
+			if h.cfg.DisableSACK {
+				dlog.Tracef(ctx, "   CON %s selective acknowledgments permitted but disabled", h.name)
+				break
+			}
+			atomic.StoreInt32(&h.peerPermitsSACK, 1)
+			dlog.Tracef(ctx, "   CON %s selective acknowledgments permitted", h.name)
+		case fastOpen:
+			if !h.cfg.FastOpen {
+				break
+			}
+			h.fastOpenAccepted = h.fastOpenSyn(synOpt, h.id.Source()) && len(tcpHdr.Payload()) > 0
+			dlog.Tracef(ctx, "   CON %s fast open, %d bytes of data accepted: %t", h.name, len(tcpHdr.Payload()), h.fastOpenAccepted)
 		default:
-			dlog.Tracef(ctx, "   CON %s option %d with len %d", h.id, synOpt.kind(), synOpt.len())
+			if h.echoesOption(synOpt.kind()) {
+				dlog.Tracef(ctx, "   CON %s option %d with len %d is echoed", h.name, synOpt.kind(), synOpt.len())
+				echoOpts = append(echoOpts, synOpt)
+				break
+			}
+			dlog.Tracef(ctx, "   CON %s option %d with len %d", h.name, synOpt.kind(), synOpt.len())
 		}
 	}
+	h.initEchoOptions(ctx, echoOpts)
+	atomic.StoreInt32(&h.negotiated, 1)
+
+	h.setReceiveWindow(h.capReceiveWindow(h.receiveWindow()))
+	h.initPathMTUDiscovery()
 
-	h.setSequence(uint32(h.RandomSequence()))
-	h.setState(ctx, stateSynReceived)
+	h.initSequence(uint32(h.RandomSequence()))
+	if cfg := h.cfg.AuthOption; cfg != nil {
+		h.ao = newAOConn(cfg, h.id.Destination(), h.id.Source(), h.id.DestinationPort(), h.id.SourcePort(), h.sequence(), tcpHdr.Sequence())
+	}
+	h.setState(ctx, stateSynReceived, tcpHdr)
 	// Reply to the SYN, then establish a connection. We send a reset if that fails.
 	h.sendSynReply(ctx, syn)
-	defer syn.Release()
-	if h.stream, err = h.streamCreator(ctx); err == nil {
-		go h.readFromMgrLoop(ctx)
+	if !h.cfg.LazyStream || h.fastOpenAccepted {
+		err = h.startStream(ctx)
 	}
 	if err != nil {
 		dlog.Error(ctx, err)
 		if err := h.toTun.Write(ctx, syn.Reset()); err != nil {
-			dlog.Errorf(ctx, "!! CON %s, send of RST failed: %v", h.id, err)
+			dlog.Errorf(ctx, "!! CON %s, send of RST failed: %v", h.name, err)
 		}
+		syn.Release()
 		return quitByUs
 	}
+	if h.fastOpenAccepted {
+		// The payload of the SYN is queued for the traffic-manager, which releases the packet.
+		h.sendToMgr(ctx, syn)
+	} else {
+		syn.Release()
+	}
 	return pleaseContinue
 }
 
@@ -485,18 +1243,28 @@ func (h *handler) synReceived(ctx context.Context, pkt Packet) quitReason {
 		return quitByReset
 	}
 	if !tcpHdr.ACK() {
+		if tcpHdr.SYN() {
+			// The peer retransmitted its SYN, so our SYN-ACK was probably lost.
+			h.resendSynReply(ctx)
+		}
 		return pleaseContinue
 	}
 
 	h.onAckReceived(ctx, tcpHdr.AckNumber())
-	h.setState(ctx, stateEstablished)
-	go h.writeToMgrLoop(ctx)
+	h.initCongestionWindow()
+	h.setState(ctx, stateEstablished, tcpHdr)
+	if h.getStream() != nil {
+		h.goTracked(ctx, "writeToMgrLoop", h.writeToMgrLoop)
+	}
 
 	pl := len(tcpHdr.Payload())
 	if pl != 0 {
+		if !h.ensureStream(ctx) {
+			return quitByReset
+		}
 		h.lastKnown = tcpHdr.Sequence() + uint32(pl)
 		release = false
-		if h.sendToMgr(ctx, pkt) {
+		if h.sendToMgr(ctx, pkt) != mgrDropped {
 			h.setPeerSequenceToAck(h.lastKnown)
 			h.sendAck(ctx)
 		} else {
@@ -515,6 +1283,16 @@ func (h *handler) handleReceived(ctx context.Context, pkt Packet) quitReason {
 	}()
 
 	tcpHdr := pkt.Header()
+	if !h.inReceiveWindow(tcpHdr.Sequence()) {
+		// Per RFC 793, an unacceptable segment is answered with an ACK that tells the peer what
+		// we expect, unless it's a RST, which is just dropped.
+		atomic.AddUint64(&h.outOfWindow, 1)
+		dlog.Debugf(ctx, "   CON %s, discarding segment with sequence %d outside of receive window", h.name, tcpHdr.Sequence())
+		if !tcpHdr.RST() {
+			h.forceSendAck(ctx)
+		}
+		return pleaseContinue
+	}
 	if tcpHdr.RST() {
 		return quitByReset
 	}
@@ -530,23 +1308,32 @@ func (h *handler) handleReceived(ctx context.Context, pkt Packet) quitReason {
 	sq := tcpHdr.Sequence()
 	lastAck := h.peerSequenceAcked()
 	payloadLen := len(tcpHdr.Payload())
+	// The packet may be released once it has been sent to the traffic-manager, so the flag is retained here
+	fin := tcpHdr.FIN()
+	trigger := tcpHdr
 	state := h.state()
+	finalSeq := atomic.LoadUint32(&h.finalSeq)
 	switch {
 	case sq == lastAck:
-		if state == stateFinWait1 && ackNbr == h.finalSeq && !tcpHdr.FIN() {
-			h.setState(ctx, stateTimedWait)
+		if state == stateFinWait1 && ackNbr == finalSeq && !fin {
+			h.setState(ctx, stateTimedWait, tcpHdr)
 			return quitByUs
 		}
-	case sq > lastAck:
+		if state == stateLastAck && ackNbr == finalSeq+1 {
+			// The peer has acknowledged our FIN
+			h.setState(ctx, stateTimedWait, tcpHdr)
+			return quitByBoth
+		}
+	case seqAfter(sq, lastAck):
 		if payloadLen == 0 {
 			break
 		}
-		if sq <= h.lastKnown {
+		if !seqAfter(sq, h.lastKnown) {
 			// Previous packet lost by us. Don't ack this one, just treat it
 			// as the next lost packet.
 			if payloadLen > 0 {
 				lk := sq + uint32(payloadLen)
-				if lk > h.lastKnown {
+				if seqAfter(lk, h.lastKnown) {
 					h.lastKnown = lk
 					h.packetsLost++
 				}
@@ -561,28 +1348,46 @@ func (h *handler) handleReceived(ctx context.Context, pkt Packet) quitReason {
 		release = false
 		return pleaseContinue
 	case sq == lastAck-1 && payloadLen == 0:
-		// keep alive, force is needed because the ackNbr is unchanged
+		// keep alive (or a retransmitted FIN), force is needed because the ackNbr is unchanged
 		h.forceSendAck(ctx)
-		go h.sendStreamControl(ctx, tunnel.KeepAlive)
+		if state == stateEstablished {
+			go h.sendStreamControl(ctx, tunnel.KeepAlive)
+		}
 		return pleaseContinue
 	default:
 		// resend of already acknowledged packet. Just ignore
 		if payloadLen > 0 {
-			dlog.Debugf(ctx, "   CON %s, resends already acked len=%d, sq=%d", h.id, payloadLen, sq)
+			dlog.Debugf(ctx, "   CON %s, resends already acked len=%d, sq=%d", h.name, payloadLen, sq)
 		}
 		return pleaseContinue
 	}
 
 	switch {
+	case payloadLen > 0 && (state == stateCloseWait || state == stateLastAck):
+		// The peer has closed its side, so it must not send more data.
+		dlog.Debugf(ctx, "   CON %s, discarding %d bytes received after FIN", h.name, payloadLen)
+		return pleaseContinue
 	case payloadLen > 0:
+		if !h.ensureStream(ctx) {
+			return quitByReset
+		}
 		h.lastKnown = sq + uint32(payloadLen)
 		release = false
-		if !h.sendToMgr(ctx, pkt) {
+		if h.tracer != nil {
+			// Retain a copy of the header for the state transitions that are traced below
+			trigger = append(Header(nil), tcpHdr[:tcpHdr.DataOffset()*4]...)
+		}
+		if h.sendToMgr(ctx, pkt) == mgrDropped {
 			h.packetsLost++
 			return pleaseContinue
 		}
-		h.setPeerSequenceToAck(h.lastKnown)
-	case tcpHdr.FIN():
+		if fin {
+			// The FIN occupies one sequence number after the payload
+			h.setPeerSequenceToAck(h.lastKnown + 1)
+		} else {
+			h.setPeerSequenceToAck(h.lastKnown)
+		}
+	case fin:
 		h.setPeerSequenceToAck(lastAck + 1)
 	default:
 		// don't ack an ack
@@ -592,19 +1397,27 @@ func (h *handler) handleReceived(ctx context.Context, pkt Packet) quitReason {
 
 	switch state {
 	case stateEstablished:
-		if tcpHdr.FIN() {
-			h.sendFin(ctx, false)
-			h.setState(ctx, stateTimedWait)
-			return quitByPeer
+		if fin {
+			h.setState(ctx, stateCloseWait, trigger)
+			if h.getStream() == nil {
+				// No data was sent, so the stream was never created and there's no traffic-manager
+				// side to wait for. Close the connection right away.
+				h.stopGraceful(ctx)
+				break
+			}
+			// The peer will not send more data, but the traffic-manager may still have data to
+			// send. Close the direction to the traffic-manager only. Our FIN is sent when the
+			// traffic-manager closes its side.
+			h.closeToMgr(ctx)
 		}
 	case stateFinWait1:
-		if tcpHdr.FIN() {
-			h.setState(ctx, stateTimedWait)
+		if fin {
+			h.setState(ctx, stateTimedWait, trigger)
 			return quitByBoth
 		}
-		h.setState(ctx, stateFinWait2)
+		h.setState(ctx, stateFinWait2, trigger)
 	case stateFinWait2:
-		if tcpHdr.FIN() {
+		if fin {
 			return quitByUs
 		}
 	}
@@ -616,15 +1429,17 @@ func (h *handler) processPackets(ctx context.Context) {
 	defer h.wg.Done()
 	defer func() {
 		close(h.tunDone)
-		h.setState(ctx, stateIdle)
+		h.setState(ctx, stateIdle, nil)
 		h.sendLock.Lock()
 		h.ackWaitQueue = nil
 		h.oooQueue = nil
+		atomic.StoreInt32(&h.oooQueueLen, 0)
+		h.account(-int(atomic.LoadInt64(&h.bufferedBytes)))
 		h.sendLock.Unlock()
-		if h.stream != nil {
+		if s := h.getStream(); s != nil {
 			go func() {
-				if err := h.stream.CloseSend(ctx); err != nil {
-					dlog.Errorf(ctx, "!! CON %s CloseSend() failed %v", h.id, err)
+				if err := s.CloseSend(ctx); err != nil {
+					dlog.Errorf(ctx, "!! CON %s CloseSend() failed %v", h.name, err)
 				}
 			}()
 		}
@@ -641,6 +1456,9 @@ func (h *handler) processPackets(ctx context.Context) {
 		default:
 			end = h.handleReceived(ctx, pkt)
 		}
+		if end != pleaseContinue {
+			h.closeReason = end
+		}
 		switch end {
 		case quitByReset, quitByContext:
 			return false
@@ -654,14 +1472,29 @@ func (h *handler) processPackets(ctx context.Context) {
 	h.processPacketsWithProcessor(ctx, process)
 }
 
+// timeWaitDuration is the time that a closed connection lingers in TIME-WAIT, so that segments
+// that the peer retransmits can be answered.
+const timeWaitDuration = time.Second
+
 func (h *handler) processFinalPackets(ctx context.Context) {
-	ctx, cancel := context.WithTimeout(ctx, time.Second)
+	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
-	defer h.setState(ctx, stateIdle)
+	timer := h.clock.AfterFunc(timeWaitDuration, cancel)
+	defer timer.Stop()
+	defer h.setState(ctx, stateIdle, nil)
 
 	h.processPacketsWithProcessor(ctx, func(ctx context.Context, pkt Packet) bool {
 		h.peerWindowFromHeader(ctx, pkt.Header())
+		// The packet may be released by handleReceived, so the flag is retained here
+		fin := pkt.Header().FIN()
 		end := h.handleReceived(ctx, pkt)
+		if fin && end == pleaseContinue {
+			// The peer retransmitted its FIN, so our ACK of it was lost. The FIN has been
+			// acknowledged again, and the TIME-WAIT period restarts so that the new ACK can be
+			// answered too if it's lost.
+			dlog.Debugf(ctx, "   CON %s, FIN retransmitted in TIME-WAIT, restarting timer", h.name)
+			timer.Reset(timeWaitDuration)
+		}
 		return end == pleaseContinue
 	})
 }
@@ -669,54 +1502,98 @@ func (h *handler) processFinalPackets(ctx context.Context) {
 const initialResendDelay = 2
 const maxResends = 7
 
+// resend is a copy of a segment that is due for retransmission.
 type resend struct {
 	packet Packet
 	secs   int
-	next   *resend
+	syn    bool
+
+	// probe is true when the segment is a path MTU probe. Its loss doesn't indicate congestion.
+	probe bool
+
+	// end is the sequence that acknowledges the whole segment
+	end  uint32
+	next *resend
 }
 
 func (h *handler) processPacketsWithProcessor(ctx context.Context, process func(ctx context.Context, pkt Packet) bool) {
 	for {
-		select {
-		case pkt := <-h.fromTun:
-			if !process(ctx, pkt) {
+		pkt, ok := h.nextFromTun(ctx)
+		if !ok {
+			return
+		}
+		if !h.inspect(ToManager, pkt) {
+			pkt.Release()
+			continue
+		}
+		if !h.authenticate(ctx, pkt) {
+			pkt.Release()
+			if h.state() == stateIdle {
+				// A connection that isn't authenticated from the start is never established.
 				return
 			}
-			for {
-				select {
-				case <-ctx.Done():
-					return
-				default:
-				}
-				continueProcessing, next := h.processNextOutOfOrderPacket(ctx, process)
-				if !continueProcessing {
-					return
-				}
-				if !next {
-					break
-				}
-			}
-		case <-ctx.Done():
+			continue
+		}
+		h.touch()
+		if !process(ctx, pkt) {
 			return
 		}
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			default:
+			}
+			continueProcessing, next := h.processNextOutOfOrderPacket(ctx, process)
+			if !continueProcessing {
+				return
+			}
+			if !next {
+				break
+			}
+		}
 	}
 }
 
-func (h *handler) copyPacket(orig Packet) Packet {
-	origHdr := orig.Header()
-	ipLen := HeaderLen + orig.PayloadLen()
+// resendSynReply retransmits the SYN-ACK that is waiting for an acknowledgement, if any.
+func (h *handler) resendSynReply(ctx context.Context) {
+	var synAck Packet
+	h.sendLock.Lock()
+	for el := h.ackWaitQueue; el != nil; el = el.next {
+		if el.packet.Header().SYN() {
+			synAck = h.copySegment(el.packet)
+			break
+		}
+	}
+	h.sendLock.Unlock()
+	if synAck != nil {
+		h.writeSynReply(ctx, synAck)
+	}
+}
+
+// writeSynReply writes the given copy of a queued SYN-ACK to the TUN device and releases it.
+func (h *handler) writeSynReply(ctx context.Context, synAck Packet) {
+	dlog.Debugf(ctx, "   CON %s SYN-ACK resent", synAck)
+	if err := h.tunWrite(ctx, synAck); err != nil {
+		dlog.Errorf(ctx, "!! TUN %s: %v", h.name, err)
+	}
+	synAck.Release()
+}
+
+// copySegment returns an exact copy of the given packet's TCP segment, including its sequence,
+// flags, options, and checksum.
+func (h *handler) copySegment(orig Packet) Packet {
+	ipLen := orig.IPHeader().PayloadLen()
 	pkt := h.newResponse(ipLen, true)
 	ipHdr := pkt.IPHeader()
-	tcpHdr := pkt.Header()
 	ipHdr.SetPayloadLen(ipLen)
 	ipHdr.SetChecksum()
-	copy(tcpHdr.Payload(), origHdr.Payload())
-	tcpHdr.SetPSH(origHdr.PSH())
+	copy(ipHdr.Payload(), orig.IPHeader().Payload())
 	return pkt
 }
 
 func (h *handler) processResends(ctx context.Context) {
-	ticker := time.NewTicker(100 * time.Millisecond)
+	ticker := h.clock.NewTicker(100 * time.Millisecond)
 	defer ticker.Stop()
 	for {
 		select {
@@ -724,58 +1601,160 @@ func (h *handler) processResends(ctx context.Context) {
 			return
 		case <-h.tunDone:
 			return
-		case <-ticker.C:
+		case <-ticker.C():
 		}
-		now := time.Now()
-		var resends *resend
-		h.sendLock.Lock()
-		var prev *queueElement
-		for el := h.ackWaitQueue; el != nil; {
-			secs := initialResendDelay << el.retries // 2, 4, 8, 16, ...
-			deadLine := el.cTime.Add(time.Duration(secs) * time.Second)
-			if deadLine.Before(now) {
-				el.retries++
-				if el.retries > maxResends {
-					el.packet.Release()
-					dlog.Errorf(ctx, "   CON %s, packet resent %d times, giving up", h.id, maxResends)
-					// Drop from queue and point to next
-					el = el.next
-					if prev == nil {
-						h.ackWaitQueue = el
-					} else {
-						prev.next = el
-					}
-					continue
+		now := h.clock.Now()
+		if h.synExpired(now) {
+			dlog.Errorf(ctx, "   CON %s, handshake not completed by peer, resetting", h.name)
+			h.sendReset(ctx)
+			return
+		}
+		if seq, ok := h.expireMTUProbe(ctx, now); ok {
+			h.resendSplit(ctx, seq, h.maxSegmentSize())
+		}
+		resends, userTimedOut := h.collectResends(ctx, now)
+		if userTimedOut {
+			dlog.Errorf(ctx, "   CON %s, no acknowledgement received within user timeout %s, resetting", h.name, h.cfg.UserTimeout)
+			h.sendReset(ctx)
+			return
+		}
+		h.writeResends(ctx, resends)
+	}
+}
+
+// synExpired returns true when the SYN-ACK hasn't been acknowledged within the SynTimeout, or is due
+// for retransmission after it has been retransmitted SynRetries times.
+func (h *handler) synExpired(now time.Time) bool {
+	if h.cfg.SynRetries <= 0 && h.cfg.SynTimeout <= 0 {
+		return false
+	}
+	h.sendLock.Lock()
+	defer h.sendLock.Unlock()
+	for el := h.ackWaitQueue; el != nil; el = el.next {
+		if !el.packet.Header().SYN() {
+			continue
+		}
+		if to := h.cfg.SynTimeout; to > 0 && now.Sub(el.cTime) > to {
+			return true
+		}
+		secs := initialResendDelay << el.retries
+		return h.cfg.SynRetries > 0 && int(el.retries) >= h.cfg.SynRetries && now.After(el.cTime.Add(time.Duration(secs)*time.Second))
+	}
+	return false
+}
+
+// collectResends returns copies of the segments in the ackWaitQueue that are due for retransmission,
+// in ascending sequence order. The copies are made while the sendLock is held, so that they remain
+// valid when the lock is released even if the originals are acknowledged and released meanwhile.
+// Segments that have been retransmitted maxResends times are dropped from the queue. The returned
+// bool is true when a segment has remained unacknowledged for longer than the UserTimeout.
+func (h *handler) collectResends(ctx context.Context, now time.Time) (*resend, bool) {
+	var resends *resend
+	h.sendLock.Lock()
+	defer h.sendLock.Unlock()
+	ackNbr := h.peerSequenceToAck()
+	var prev *queueElement
+	for el := h.ackWaitQueue; el != nil; {
+		if h.cfg.UserTimeout > 0 && now.Sub(el.cTime) > h.cfg.UserTimeout {
+			return nil, true
+		}
+		secs := initialResendDelay << el.retries // 2, 4, 8, 16, ...
+		deadLine := el.cTime.Add(time.Duration(secs) * time.Second)
+		if deadLine.Before(now) {
+			el.retries++
+			if el.retries > maxResends {
+				h.account(-len(el.packet.Header().Payload()))
+				h.releaseQueued(el.packet)
+				dlog.Errorf(ctx, "   CON %s, packet resent %d times, giving up", h.name, maxResends)
+				// Drop from queue and point to next
+				el = el.next
+				if prev == nil {
+					h.ackWaitQueue = el
+				} else {
+					prev.next = el
 				}
+				continue
+			}
 
-				// reverse (i.e. put in right order since ackWaitQueue is in fact reversed)
-				resends = &resend{packet: el.packet, secs: secs, next: resends}
+			// The segment is retransmitted with its original sequence. Only the SYN-ACK is retransmitted
+			// unchanged. Other segments acknowledge what has been received from the peer since they were sent.
+			pkt := h.copySegment(el.packet)
+			tcpHdr := pkt.Header()
+			syn := tcpHdr.SYN()
+			if !syn {
+				tcpHdr.SetAckNumber(ackNbr)
+				tcpHdr.SetChecksum(pkt.IPHeader())
 			}
-			prev = el
-			el = el.next
+			// reverse (i.e. put in right order since ackWaitQueue is in fact reversed)
+			resends = &resend{packet: pkt, secs: secs, syn: syn, probe: el.probe, end: el.sequence, next: resends}
 		}
-		h.sendLock.Unlock()
-		for resends != nil {
-			pkt := h.copyPacket(resends.packet)
-			dlog.Debugf(ctx, "   CON %s resent after %d seconds", pkt, resends.secs)
-			h.sendToTun(ctx, pkt, uint32(len(pkt.Header().Payload())), false)
-			resends = resends.next
+		prev = el
+		el = el.next
+	}
+	if resends != nil {
+		h.setPeerSequenceAcked(ackNbr)
+	}
+	return resends, false
+}
+
+// writeResends writes the given segments to the TUN device without holding the sendLock. A segment
+// that has been acknowledged since it was collected is skipped.
+func (h *handler) writeResends(ctx context.Context, resends *resend) {
+	collapsed := false
+	for ; resends != nil; resends = resends.next {
+		pkt := resends.packet
+		if !resends.syn && !seqAfter(resends.end, atomic.LoadUint32(&h.seqAcked)) {
+			pkt.Release()
+			continue
+		}
+		atomic.AddUint64(&h.timerRetransmits, 1)
+		h.counters.AddRetransmits(1)
+		if resends.syn {
+			h.writeSynReply(ctx, pkt)
+			continue
+		}
+		if !collapsed && !resends.probe {
+			h.sendLock.Lock()
+			h.collapseCongestionWindow()
+			h.sendLock.Unlock()
+			collapsed = true
+		}
+		dlog.Debugf(ctx, "   CON %s resent after %d seconds", pkt, resends.secs)
+		atomic.AddUint64(&h.retransmittedBytes, uint64(len(pkt.Header().Payload())))
+		err := h.tunWrite(ctx, pkt)
+		pkt.Release()
+		if err != nil {
+			dlog.Errorf(ctx, "!! TUN %s: %v", h.name, err)
+			if errors.Is(err, errTunWriteTimeout) {
+				releaseResends(resends.next)
+				return
+			}
 		}
 	}
 }
 
+// releaseResends releases the segments of the given resends.
+func releaseResends(resends *resend) {
+	for ; resends != nil; resends = resends.next {
+		resends.packet.Release()
+	}
+}
+
 func (h *handler) onAckReceived(ctx context.Context, seq uint32) {
 	h.sendLock.Lock()
 	// ack-queue is guaranteed to be sorted descending on sequence, so we cut from the packet with
 	// a sequence less than or equal to the received sequence.
-	sq := h.sequence()
-	oldWindow := int(h.peerWindow) - int(sq-h.seqAcked)
-	h.seqAcked = seq
-	newWindow := int(h.peerWindow) - int(sq-h.seqAcked)
+	oldWindow := h.sendWindow()
+	if prev := h.seqAcked; seqAfter(seq, prev) {
+		h.growCongestionWindow(int(seq - prev))
+		atomic.StoreUint32(&h.seqAcked, seq)
+	}
+	newWindow := h.sendWindow()
+	sendBufferDrained := h.cfg.SendBufferHighWatermark > 0 && h.unackedBytes() <= h.cfg.SendBufferLowWatermark
 
 	el := h.ackWaitQueue
 	var prev *queueElement
-	for el != nil && el.sequence > seq {
+	for el != nil && seqAfter(el.sequence, seq) {
 		prev = el
 		el = el.next
 	}
@@ -787,7 +1766,11 @@ func (h *handler) onAckReceived(ctx context.Context, seq uint32) {
 			prev.next = nil
 		}
 		for {
-			el.packet.Release()
+			if el.probe {
+				h.onMTUProbeAcked(ctx)
+			}
+			h.account(-len(el.packet.Header().Payload()))
+			h.releaseQueued(el.packet)
 			h.ackWaitQueueSize--
 			if el = el.next; el == nil {
 				break
@@ -796,7 +1779,9 @@ func (h *handler) onAckReceived(ctx context.Context, seq uint32) {
 	}
 	h.sendLock.Unlock()
 	if oldWindow <= 0 && newWindow > 0 {
-		dlog.Debugf(ctx, "   CON %s, TCP window %d after ack", h.id, newWindow)
+		dlog.Debugf(ctx, "   CON %s, TCP window %d after ack", h.name, newWindow)
+		h.sendCondition.Signal()
+	} else if sendBufferDrained {
 		h.sendCondition.Signal()
 	}
 }
@@ -811,7 +1796,11 @@ func (h *handler) processNextOutOfOrderPacket(ctx context.Context, process func(
 			} else {
 				h.oooQueue = el.next
 			}
-			dlog.Debugf(ctx, "   CON %s, Processing out-of-order packet %s", h.id, el.packet)
+			atomic.AddInt32(&h.oooQueueLen, -1)
+			h.sendLock.Lock()
+			h.account(-len(el.packet.Header().Payload()))
+			h.sendLock.Unlock()
+			dlog.Debugf(ctx, "   CON %s, Processing out-of-order packet %s", h.name, el.packet)
 			return process(ctx, el.packet), true
 		}
 		prev = el
@@ -826,14 +1815,29 @@ func (h *handler) addOutOfOrderPacket(ctx context.Context, pkt Packet) {
 	var prev *queueElement
 	for el := h.oooQueue; el != nil; el = el.next {
 		if el.sequence == sq {
+			pkt.Release()
 			return
 		}
 		prev = el
 	}
+	if mx := h.cfg.MaxOutOfOrderQueueLength; mx > 0 && int(atomic.LoadInt32(&h.oooQueueLen)) >= mx {
+		if !h.dropFarthestOutOfOrderPacket(ctx, sq) {
+			// The new packet is the one farthest ahead.
+			atomic.AddUint64(&h.outOfOrderDropped, 1)
+			dlog.Debugf(ctx, "   CON %s, out-of-order queue full, dropped", pkt)
+			pkt.Release()
+			return
+		}
+		// The removed element may have been the last one.
+		prev = nil
+		for el := h.oooQueue; el != nil; el = el.next {
+			prev = el
+		}
+	}
 	dlog.Debugf(ctx, "   CON %s, out-of-order", pkt)
 	el := &queueElement{
 		sequence: sq,
-		cTime:    time.Now(),
+		cTime:    h.clock.Now(),
 		packet:   pkt,
 	}
 	if prev == nil {
@@ -841,24 +1845,70 @@ func (h *handler) addOutOfOrderPacket(ctx context.Context, pkt Packet) {
 	} else {
 		prev.next = el
 	}
+	atomic.AddInt32(&h.oooQueueLen, 1)
+	h.sendLock.Lock()
+	h.account(len(hdr.Payload()))
+	h.sendLock.Unlock()
+}
+
+// dropFarthestOutOfOrderPacket makes room in a full oooQueue for a packet with the given sequence
+// by dropping the queued packet that is farthest ahead of the next expected sequence, since the
+// gap in front of it is the least likely to be filled soon. It returns false, and drops nothing,
+// when the given sequence is farther ahead than all queued packets.
+func (h *handler) dropFarthestOutOfOrderPacket(ctx context.Context, sq uint32) bool {
+	next := h.peerSequenceToAck()
+	var farthest, farthestPrev, prev *queueElement
+	for el := h.oooQueue; el != nil; el = el.next {
+		if farthest == nil || seqAfter(el.sequence-next, farthest.sequence-next) {
+			farthest = el
+			farthestPrev = prev
+		}
+		prev = el
+	}
+	if farthest == nil || !seqAfter(farthest.sequence-next, sq-next) {
+		return false
+	}
+	if farthestPrev == nil {
+		h.oooQueue = farthest.next
+	} else {
+		farthestPrev.next = farthest.next
+	}
+	atomic.AddInt32(&h.oooQueueLen, -1)
+	atomic.AddUint64(&h.outOfOrderDropped, 1)
+	dlog.Debugf(ctx, "   CON %s, out-of-order queue full, dropped", farthest.packet)
+	h.sendLock.Lock()
+	h.account(-len(farthest.packet.Header().Payload()))
+	h.sendLock.Unlock()
+	farthest.packet.Release()
+	return true
 }
 
 func (h *handler) state() state {
 	return state(atomic.LoadInt32((*int32)(&h.wfState)))
 }
 
-func (h *handler) setState(ctx context.Context, s state) {
+// setState sets the state of the handler. The trigger is the header of the packet that caused
+// the transition, or nil if it wasn't caused by a packet.
+func (h *handler) setState(ctx context.Context, s state, trigger Header) {
 	oldState := h.state()
 	if oldState != s {
-		dlog.Debugf(ctx, "   CON %s, state %s -> %s", h.id, h.state(), s)
+		dlog.Debugf(ctx, "   CON %s, state %s -> %s", h.name, h.state(), s)
 		atomic.StoreInt32((*int32)(&h.wfState), int32(s))
-		if oldState == stateEstablished {
-			// Unblock any sender when moving from stateEstablished
+		h.traceStateTransition(oldState, s, trigger)
+		if oldState.canSend() && !s.canSend() {
+			// Unblock any sender when moving from stateEstablished or stateCloseWait
 			h.sendCondition.Signal()
 		}
 	}
 }
 
+// seqAfter returns true when the sequence number a comes after b. Sequence numbers wrap around, so
+// they are compared using serial number arithmetic (RFC 1982), which is correct as long as they are
+// less than 2^31 apart.
+func seqAfter(a, b uint32) bool {
+	return int32(a-b) > 0
+}
+
 // sequence is the sequence number of the packets that this client
 // sends to the TUN device.
 func (h *handler) sequence() uint32 {
@@ -873,6 +1923,13 @@ func (h *handler) setSequence(v uint32) {
 	atomic.StoreUint32(&h.seq, v)
 }
 
+// initSequence sets the initial sequence number. Nothing has been acknowledged by the peer at that
+// point, so it's also the last acknowledged sequence.
+func (h *handler) initSequence(isn uint32) {
+	h.setSequence(isn)
+	atomic.StoreUint32(&h.seqAcked, isn)
+}
+
 // peerSequenceToAck is the received sequence that this will ack on next send
 func (h *handler) peerSequenceToAck() uint32 {
 	return atomic.LoadUint32(&h.peerSeqToAck)
@@ -893,23 +1950,47 @@ func (h *handler) setPeerSequenceAcked(v uint32) {
 
 func (h *handler) peerWindowFromHeader(ctx context.Context, tcpHeader Header) {
 	h.sendLock.Lock()
-	sq := h.sequence()
-	oldWindow := int(h.peerWindow) - int(sq-h.seqAcked)
-	h.peerWindow = int64(tcpHeader.WindowSize()) << h.peerWindowScale
-	newWindow := int(h.peerWindow) - int(sq-h.seqAcked)
+	oldWindow := h.sendWindow()
+	atomic.StoreInt64(&h.peerWindow, int64(tcpHeader.WindowSize())<<h.peerWindowScale)
+	newWindow := h.sendWindow()
 	h.sendLock.Unlock()
 	if oldWindow <= 0 && newWindow > 0 {
-		dlog.Debugf(ctx, "   CON %s, TCP window %d after window update", h.id, newWindow)
+		dlog.Debugf(ctx, "   CON %s, TCP window %d after window update", h.name, newWindow)
 		h.sendCondition.Signal()
 	}
 }
 
+// inReceiveWindow returns true unless the given sequence is so far from the next expected sequence
+// that it can't belong to this connection. The window is the largest receive window that is ever
+// advertised, in both directions, so that data sent before the window shrunk and retransmits of
+// data that has already been acknowledged are still accepted.
+func (h *handler) inReceiveWindow(sq uint32) bool {
+	off := int32(sq - h.peerSequenceAcked())
+	return off >= -maxReceiveWindow && off <= maxReceiveWindow
+}
+
 func (h *handler) myWindowToHeader(tcpHeader Header) {
-	tcpHeader.SetWindowSize(uint16(h.receiveWindow() >> myWindowScale))
+	w := h.receiveWindow()
+	atomic.StoreInt64(&h.advertisedWindow, int64(w))
+	tcpHeader.SetWindowSize(uint16(w >> h.myWindowShift()))
+}
+
+// myWindowShift returns the number of bits that the advertised receive window is shifted by.
+func (h *handler) myWindowShift() uint8 {
+	if h.windowScaling {
+		return myWindowScale
+	}
+	return 0
 }
 
+// receiveWindow returns the size of the receive window. It's capped to what a 16-bit window
+// field can hold when the peer didn't offer window scaling.
 func (h *handler) receiveWindow() int {
-	return int(atomic.LoadInt64(&h.myWindow))
+	w := int(atomic.LoadInt64(&h.myWindow))
+	if !h.windowScaling && w > maxUnscaledWindow {
+		w = maxUnscaledWindow
+	}
+	return w
 }
 
 func (h *handler) setReceiveWindow(v int) {
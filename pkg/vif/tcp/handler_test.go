@@ -0,0 +1,1662 @@
+package tcp
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/ipproto"
+	"github.com/telepresenceio/telepresence/v2/pkg/tunnel"
+	"github.com/telepresenceio/telepresence/v2/pkg/vif/ip"
+)
+
+// testStream is a tunnel.Stream that represents the traffic-manager side of a handler.
+type testStream struct {
+	id          tunnel.ConnID
+	peerVersion uint16
+	fromMgr     chan tunnel.Message
+	toMgr       chan tunnel.Message
+
+	// closed is closed when the handler closes its sending side of the stream
+	closeOnce sync.Once
+	closed    chan struct{}
+
+	// mgrClosed is closed when the traffic-manager closes its sending side of the stream
+	mgrCloseOnce sync.Once
+	mgrClosed    chan struct{}
+
+	// broken is closed when the stream fails, e.g. because the gRPC connection was lost
+	breakOnce sync.Once
+	broken    chan struct{}
+
+	// hold blocks Send while it's locked, which simulates a traffic-manager that doesn't keep up
+	hold sync.Mutex
+}
+
+func newTestStream(id tunnel.ConnID) *testStream {
+	return &testStream{
+		id:          id,
+		peerVersion: tunnel.Version,
+		fromMgr:     make(chan tunnel.Message, 100),
+		toMgr:       make(chan tunnel.Message, 100),
+		closed:      make(chan struct{}),
+		mgrClosed:   make(chan struct{}),
+		broken:      make(chan struct{}),
+	}
+}
+
+func (s *testStream) Tag() string                     { return "TST" }
+func (s *testStream) ID() tunnel.ConnID               { return s.id }
+func (s *testStream) PeerVersion() uint16             { return s.peerVersion }
+func (s *testStream) SessionID() string               { return "test-session" }
+func (s *testStream) DialTimeout() time.Duration      { return time.Second }
+func (s *testStream) RoundtripLatency() time.Duration { return time.Second }
+
+func (s *testStream) Receive(ctx context.Context) (tunnel.Message, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-s.mgrClosed:
+		return nil, net.ErrClosed
+	case <-s.broken:
+		return nil, errStreamBroken
+	case m := <-s.fromMgr:
+		return m, nil
+	}
+}
+
+func (s *testStream) Send(ctx context.Context, m tunnel.Message) error {
+	s.hold.Lock()
+	s.hold.Unlock()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-s.closed:
+		return net.ErrClosed
+	case <-s.broken:
+		return errStreamBroken
+	case s.toMgr <- m:
+		return nil
+	}
+}
+
+func (s *testStream) CloseSend(context.Context) error {
+	s.closeOnce.Do(func() { close(s.closed) })
+	return nil
+}
+
+// closeFromMgr closes the traffic-manager's sending side of the stream.
+func (s *testStream) closeFromMgr() {
+	s.mgrCloseOnce.Do(func() { close(s.mgrClosed) })
+}
+
+var errStreamBroken = errors.New("stream broken")
+
+// breakStream makes all subsequent reads from, and writes to, the stream fail.
+func (s *testStream) breakStream() {
+	s.breakOnce.Do(func() { close(s.broken) })
+}
+
+// testTun is an ip.Writer that represents the TUN device.
+type testTun chan Packet
+
+func (t testTun) Write(_ context.Context, pkt ip.Packet) error {
+	// The handler releases some packets after writing them, so we must make a copy
+	orig := pkt.(Packet)
+	cp := NewPacket(orig.IPHeader().PayloadLen(), orig.IPHeader().Source(), orig.IPHeader().Destination(), false)
+	copy(cp.IPHeader().Payload(), orig.IPHeader().Payload())
+	t <- cp
+	return nil
+}
+
+// stallingTun is a testTun that blocks all writes once it has been stalled.
+type stallingTun struct {
+	testTun
+	stalled int32
+	release chan struct{}
+}
+
+func (t *stallingTun) Write(ctx context.Context, pkt ip.Packet) error {
+	if atomic.LoadInt32(&t.stalled) == 1 {
+		<-t.release
+	}
+	return t.testTun.Write(ctx, pkt)
+}
+
+// failingTun is a testTun that fails writes with the error returned from its fail function.
+type failingTun struct {
+	testTun
+	fail func() error
+}
+
+func (t *failingTun) Write(ctx context.Context, pkt ip.Packet) error {
+	if err := t.fail(); err != nil {
+		return err
+	}
+	return t.testTun.Write(ctx, pkt)
+}
+
+// testPeer represents the client that sends packets to the TUN device.
+type testPeer struct {
+	t       *testing.T
+	id      tunnel.ConnID
+	h       *handler
+	stream  *testStream
+	fromTun testTun
+	seq     uint32
+	ack     uint32
+
+	// sack makes the peer include the "SACK permitted" option in its SYN
+	sack bool
+
+	// noWindowScale makes the peer omit the "Window Scale" option from its SYN
+	noWindowScale bool
+
+	// window, when non-zero, is the window that the peer advertises instead of 0xffff
+	window uint16
+
+	// synOptions are additional options that the peer includes in its SYN. The length must be a
+	// multiple of four.
+	synOptions []byte
+
+	// sign, when set, is applied to each packet before it's sent to the handler
+	sign func(Packet) Packet
+}
+
+// quietTB is a testing.TB that discards log output once the test has completed. Goroutines that
+// aren't tracked by the handler, like the ReadLoop of a stream, may log after the handler has been
+// removed, and logging to a completed test is a data race.
+type quietTB struct {
+	testing.TB
+	sync.RWMutex
+	done bool
+}
+
+func (q *quietTB) Log(args ...any) {
+	q.RLock()
+	defer q.RUnlock()
+	if !q.done {
+		q.TB.Log(args...)
+	}
+}
+
+func (q *quietTB) Error(args ...any) {
+	q.RLock()
+	defer q.RUnlock()
+	if !q.done {
+		q.TB.Error(args...)
+	}
+}
+
+func newTestPeer(ctx context.Context, t *testing.T, cfg HandlerConfig) *testPeer {
+	tun := make(testTun, 100)
+	return newTestPeerWithTun(ctx, t, cfg, tun, tun)
+}
+
+// newTestPeerWithTun creates a testPeer for a handler that writes to the given tun, which must
+// deliver the packets to fromTun.
+func newTestPeerWithTun(ctx context.Context, t *testing.T, cfg HandlerConfig, tun ip.Writer, fromTun testTun) *testPeer {
+	id := tunnel.NewConnID(ipproto.TCP, net.IP{10, 0, 0, 1}, net.IP{10, 0, 0, 2}, 4711, 80)
+	return newTestPeerWithID(ctx, t, cfg, id, tun, fromTun)
+}
+
+// newTestPeerWithID is like newTestPeerWithTun but uses the given connection id.
+func newTestPeerWithID(ctx context.Context, t *testing.T, cfg HandlerConfig, id tunnel.ConnID, tun ip.Writer, fromTun testTun) *testPeer {
+	qt := &quietTB{TB: t}
+	t.Cleanup(func() {
+		qt.Lock()
+		qt.done = true
+		qt.Unlock()
+	})
+	ctx = dlog.WithLogger(ctx, dlog.WrapTB(qt, false))
+	stream := newTestStream(id)
+	removed := make(chan struct{})
+	h := NewHandler(func(context.Context) (tunnel.Stream, error) { return stream, nil },
+		new(int32), tun, id, "", func() { close(removed) }, rand.NewSource(1), cfg).(*handler)
+	h.Start(ctx)
+
+	// The handler logs until it's removed, and logging after the test has completed is a panic. Tests
+	// cancel the context before the cleanup runs, so the handler will terminate.
+	t.Cleanup(func() {
+		select {
+		case <-removed:
+		case <-time.After(5 * time.Second):
+		}
+	})
+	return &testPeer{t: t, id: id, h: h, stream: stream, fromTun: fromTun, seq: 1000}
+}
+
+// send sends a packet with the given flags and payload to the handler.
+func (p *testPeer) send(ctx context.Context, syn, ack, fin bool, payload []byte) {
+	p.sendWithPSH(ctx, syn, ack, fin, false, payload)
+}
+
+// sendWithPSH sends a packet with the given flags, including PSH, and payload to the handler.
+func (p *testPeer) sendWithPSH(ctx context.Context, syn, ack, fin, psh bool, payload []byte) {
+	hl := HeaderLen
+	if syn {
+		hl += 4 // Maximum Segment Size option
+		if p.sack {
+			hl += 4 // SACK Permitted option, padded with two NOPs
+		}
+		if !p.noWindowScale {
+			hl += 4 // Window Scale option, padded with a NOP
+		}
+		hl += len(p.synOptions)
+	}
+	pkt := NewPacket(hl+len(payload), p.id.Source(), p.id.Destination(), false)
+	ipHdr := pkt.IPHeader()
+	ipHdr.SetL4Protocol(ipproto.TCP)
+	ipHdr.SetChecksum()
+	tcpHdr := pkt.Header()
+	tcpHdr.SetDataOffset(hl / 4)
+	tcpHdr.SetSourcePort(p.id.SourcePort())
+	tcpHdr.SetDestinationPort(p.id.DestinationPort())
+	tcpHdr.SetSequence(p.seq)
+	tcpHdr.SetAckNumber(p.ack)
+	tcpHdr.SetSYN(syn)
+	tcpHdr.SetACK(ack)
+	tcpHdr.SetFIN(fin)
+	tcpHdr.SetPSH(psh)
+	if p.window != 0 {
+		tcpHdr.SetWindowSize(p.window)
+	} else {
+		tcpHdr.SetWindowSize(0xffff)
+	}
+	if syn {
+		opts := tcpHdr.OptionBytes()
+		opts[0] = byte(maximumSegmentSize)
+		opts[1] = 4
+		binary.BigEndian.PutUint16(opts[2:], uint16(maxSegmentSize))
+		opts = opts[4:]
+		if p.sack {
+			opts[0] = byte(selectiveAckPermitted)
+			opts[1] = 2
+			opts[2] = byte(noOp)
+			opts[3] = byte(noOp)
+			opts = opts[4:]
+		}
+		if !p.noWindowScale {
+			opts[0] = byte(windowScale)
+			opts[1] = 3
+			opts[2] = 0 // The peer's window is never scaled
+			opts[3] = byte(noOp)
+			opts = opts[4:]
+		}
+		copy(opts, p.synOptions)
+		p.seq++
+	}
+	copy(tcpHdr.Payload(), payload)
+	tcpHdr.SetChecksum(ipHdr)
+	p.seq += uint32(len(payload))
+	if fin {
+		p.seq++
+	}
+	if p.sign != nil {
+		pkt = p.sign(pkt)
+	}
+	p.h.HandlePacket(ctx, pkt)
+}
+
+// recv returns the next packet that the handler writes to the TUN device.
+func (p *testPeer) recv() Packet {
+	select {
+	case pkt := <-p.fromTun:
+		return pkt
+	case <-time.After(5 * time.Second):
+		p.t.Fatal("timeout waiting for packet from handler")
+		return nil
+	}
+}
+
+// connect performs the three-way handshake.
+func (p *testPeer) connect(ctx context.Context) {
+	p.send(ctx, true, false, false, nil)
+	synAck := p.recv().Header()
+	require.True(p.t, synAck.SYN())
+	require.True(p.t, synAck.ACK())
+	require.Equal(p.t, p.seq, synAck.AckNumber())
+	p.ack = synAck.Sequence() + 1
+	p.send(ctx, false, true, false, nil)
+	require.Eventually(p.t, func() bool { return p.h.state() == stateEstablished }, 5*time.Second, time.Millisecond)
+}
+
+func TestHandler_UserTimeout(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+	p := newTestPeer(ctx, t, HandlerConfig{UserTimeout: 300 * time.Millisecond})
+	p.connect(ctx)
+
+	// The manager sends data, but the peer never acknowledges it.
+	start := time.Now()
+	p.stream.fromMgr <- tunnel.NewMessage(tunnel.Normal, []byte("hello"))
+	data := p.recv().Header()
+	require.Equal(t, []byte("hello"), data.Payload())
+
+	rst := p.recv().Header()
+	require.True(t, rst.RST())
+	require.Equal(t, data.Sequence(), rst.Sequence())
+	require.GreaterOrEqual(t, time.Since(start), 300*time.Millisecond)
+	require.Less(t, time.Since(start), 2*time.Second, "reset must not wait for the retransmit timer")
+	select {
+	case <-p.h.tunDone:
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler did not terminate after user timeout")
+	}
+}
+
+func TestHandler_SendBufferWatermarks(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+	mss := maxSegmentSize
+	p := newTestPeer(ctx, t, HandlerConfig{SendBufferHighWatermark: 2 * mss, SendBufferLowWatermark: mss})
+	p.connect(ctx)
+
+	payload := make([]byte, mss)
+	for i := 0; i < 5; i++ {
+		p.stream.fromMgr <- tunnel.NewMessage(tunnel.Normal, payload)
+	}
+
+	// The high watermark is reached after two segments
+	var last Header
+	for i := 0; i < 2; i++ {
+		last = p.recv().Header()
+		require.Len(t, last.Payload(), mss)
+	}
+	select {
+	case pkt := <-p.fromTun:
+		t.Fatalf("unexpected packet %s when high watermark is exceeded", pkt)
+	case <-time.After(300 * time.Millisecond):
+	}
+
+	// Acknowledging the data brings the unacknowledged bytes below the low watermark
+	p.ack = last.Sequence() + uint32(mss)
+	p.send(ctx, false, true, false, nil)
+	for i := 0; i < 2; i++ {
+		last = p.recv().Header()
+		require.Len(t, last.Payload(), mss)
+	}
+}
+
+func TestHandler_MarshalAndRestoreState(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+	p := newTestPeer(ctx, t, HandlerConfig{})
+	p.sack = true
+	p.connect(ctx)
+
+	// Leave one unacknowledged segment in the ackWaitQueue
+	p.stream.fromMgr <- tunnel.NewMessage(tunnel.Normal, []byte("hello"))
+	data := p.recv().Header()
+	require.Equal(t, []byte("hello"), data.Payload())
+
+	state, err := p.h.MarshalState()
+	require.NoError(t, err)
+
+	// Restore into a new handler in what is normally another process
+	stream := newTestStream(p.id)
+	tun := make(testTun, 100)
+	h := NewHandler(func(context.Context) (tunnel.Stream, error) { return stream, nil },
+		new(int32), tun, p.id, "", func() {}, rand.NewSource(2), HandlerConfig{}).(*handler)
+	require.NoError(t, h.RestoreState(state))
+	require.Equal(t, stateEstablished, h.state())
+
+	restored, err := h.MarshalState()
+	require.NoError(t, err)
+	require.JSONEq(t, string(state), string(restored))
+	require.Equal(t, p.h.sequence(), h.sequence())
+	require.Equal(t, p.h.peerSequenceToAck(), h.peerSequenceToAck())
+	require.Equal(t, uint32(1), h.ackWaitQueueSize)
+	require.Equal(t, data.Sequence(), h.ackWaitQueue.packet.Header().Sequence())
+	require.True(t, h.Stats().PeerPermitsSACK)
+	require.Equal(t, p.h.NegotiatedParams(), h.NegotiatedParams())
+
+	// A handler that has been restored cannot be restored again
+	require.Error(t, h.RestoreState(state))
+
+	// Unsupported versions are rejected
+	h2 := NewHandler(nil, new(int32), tun, p.id, "", func() {}, rand.NewSource(3), HandlerConfig{})
+	require.Error(t, h2.RestoreState([]byte(`{"version":0}`)))
+
+	// The restored handler continues the connection using a new stream
+	p.h, p.stream, p.fromTun = h, stream, tun
+	h.Start(ctx)
+	p.send(ctx, false, true, false, []byte("world"))
+	select {
+	case m := <-stream.toMgr:
+		require.Equal(t, []byte("world"), m.Payload())
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for data to manager")
+	}
+}
+
+func TestHandler_ManagerQueueWatermarks(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+	p := newTestPeer(ctx, t, HandlerConfig{ManagerQueueHighWatermark: 4, ManagerQueueLowWatermark: 1})
+	p.connect(ctx)
+	require.NotZero(t, p.h.receiveWindow())
+
+	// Block the traffic-manager. The first two writes get stuck in the stream and in the write
+	// loop. Subsequent packets remain in the queue to the manager.
+	p.stream.hold.Lock()
+	for i := 0; i < 2; i++ {
+		p.send(ctx, false, true, false, []byte("hello"))
+		require.NotZero(t, p.recv().Header().WindowSize())
+		time.Sleep(20 * time.Millisecond)
+	}
+	var ack Header
+	for i := 0; i < 4; i++ {
+		p.send(ctx, false, true, false, []byte("hello"))
+		ack = p.recv().Header()
+	}
+	require.Zero(t, ack.WindowSize(), "window must be closed when the high watermark is reached")
+	require.Zero(t, p.h.receiveWindow())
+
+	// Unblocking the traffic-manager drains the queue and reopens the window using a window update
+	p.stream.hold.Unlock()
+	update := p.recv().Header()
+	require.Equal(t, p.seq, update.AckNumber())
+	require.NotZero(t, update.WindowSize())
+}
+
+func TestHandler_ManagerDropped(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+	p := newTestPeer(ctx, t, HandlerConfig{})
+	p.connect(ctx)
+
+	// Collect the windows that the handler advertises, so that the handler never blocks on the TUN
+	var windowsLock sync.Mutex
+	var windows []uint16
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case pkt := <-p.fromTun:
+				windowsLock.Lock()
+				windows = append(windows, pkt.Header().WindowSize())
+				windowsLock.Unlock()
+			}
+		}
+	}()
+	lastWindow := func() (uint16, int) {
+		windowsLock.Lock()
+		defer windowsLock.Unlock()
+		if len(windows) == 0 {
+			return 0xffff, 0
+		}
+		return windows[len(windows)-1], len(windows)
+	}
+
+	// Block the traffic-manager. The segments are pushed, so the first one gets stuck in the
+	// stream and the second one in the writeToMgrLoop, which then no longer drains the queue.
+	p.stream.hold.Lock()
+	for i := 0; i < 2; i++ {
+		p.sendWithPSH(ctx, false, true, false, true, []byte("hello"))
+	}
+	require.Eventually(t, func() bool {
+		return p.h.Stats().BytesToManager == 10 && len(p.h.toMgrCh) == 0
+	}, 5*time.Second, time.Millisecond)
+
+	// Fill the queue to the manager. The peer ignores the shrinking window.
+	for i := 0; i < ioChannelSize; i++ {
+		p.sendWithPSH(ctx, false, true, false, true, []byte("hello"))
+	}
+	require.Eventually(t, func() bool { return len(p.h.toMgrCh) == ioChannelSize }, 5*time.Second, time.Millisecond)
+	require.Zero(t, p.h.Stats().ManagerDropped)
+
+	// The next segment is dropped. The ones that follow it are lost because they're out of order.
+	extra := 3
+	for i := 0; i < extra; i++ {
+		p.sendWithPSH(ctx, false, true, false, true, []byte("hello"))
+	}
+	require.Eventually(t, func() bool { return p.h.Stats().SegmentsReceived == uint64(ioChannelSize+extra+4) }, 5*time.Second, time.Millisecond)
+	require.Equal(t, uint64(1), p.h.Stats().ManagerDropped)
+	require.Eventually(t, func() bool { w, _ := lastWindow(); return w == 0 }, 5*time.Second, time.Millisecond,
+		"window must be closed when packets are dropped")
+	require.Zero(t, p.h.receiveWindow())
+	_, sent := lastWindow()
+
+	// Unblocking the traffic-manager drains the queue and reopens the window using a window update
+	p.stream.hold.Unlock()
+	require.Eventually(t, func() bool { w, n := lastWindow(); return n > sent && w > 0 }, 5*time.Second, 10*time.Millisecond,
+		"window must be reopened when the queue has room")
+	require.Equal(t, uint64(1), p.h.Stats().ManagerDropped)
+}
+
+func TestHandler_ManagerWindowUpdate(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+	p := newTestPeer(ctx, t, HandlerConfig{})
+	p.connect(ctx)
+	require.Greater(t, p.h.receiveWindow(), 0x4000)
+
+	// The manager closes the window, so the next ack tells the peer to stop sending
+	p.stream.fromMgr <- tunnel.WindowUpdateMessage(0)
+	require.Eventually(t, func() bool { return p.h.receiveWindow() == 0 }, 5*time.Second, 10*time.Millisecond)
+	p.send(ctx, false, true, false, []byte("hello"))
+	require.Zero(t, p.recv().Header().WindowSize())
+
+	// Reopening the window sends a window update that respects the manager's limit
+	p.stream.fromMgr <- tunnel.WindowUpdateMessage(0x4000)
+	update := p.recv().Header()
+	require.Equal(t, p.seq, update.AckNumber())
+	require.NotZero(t, update.WindowSize())
+	require.LessOrEqual(t, int(update.WindowSize())<<p.h.myWindowShift(), 0x4000)
+	require.LessOrEqual(t, p.h.receiveWindow(), 0x4000)
+}
+
+func TestHandler_ManagerWindowKeepsDropClosed(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+	p := newTestPeer(ctx, t, HandlerConfig{})
+	p.connect(ctx)
+
+	// A window that the manager has closed doesn't reopen a window that was closed by a drop
+	atomic.StoreInt32(&p.h.mgrDropClosed, 1)
+	atomic.StoreInt64(&p.h.mgrWindow, 0)
+	require.False(t, p.h.adjustReceiveWindow())
+	require.Zero(t, p.h.receiveWindow())
+	require.Equal(t, int32(1), atomic.LoadInt32(&p.h.mgrDropClosed))
+
+	// It's reopened once the manager's limit allows it
+	atomic.StoreInt64(&p.h.mgrWindow, 0x4000)
+	require.True(t, p.h.adjustReceiveWindow())
+	require.NotZero(t, p.h.receiveWindow())
+	require.Zero(t, atomic.LoadInt32(&p.h.mgrDropClosed))
+}
+
+func TestWindowUpdateMessage(t *testing.T) {
+	for _, size := range []uint32{0, 1, 0x4000, maxReceiveWindow, math.MaxUint32} {
+		m := tunnel.WindowUpdateMessage(size)
+		require.Equal(t, tunnel.WindowUpdate, m.Code())
+		got, err := tunnel.GetWindowSize(m)
+		require.NoError(t, err)
+		require.Equal(t, size, got)
+	}
+	_, err := tunnel.GetWindowSize(tunnel.NewMessage(tunnel.WindowUpdate, nil))
+	require.Error(t, err)
+}
+
+func TestHandler_PushPropagation(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+	recvMgr := func(p *testPeer) tunnel.Message {
+		select {
+		case m := <-p.stream.toMgr:
+			return m
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for data to manager")
+			return nil
+		}
+	}
+
+	p := newTestPeer(ctx, t, HandlerConfig{})
+	p.connect(ctx)
+	p.sendWithPSH(ctx, false, true, false, true, []byte("interactive"))
+	m := recvMgr(p)
+	assert.Equal(t, tunnel.Push, m.Code())
+	assert.Equal(t, []byte("interactive"), m.Payload())
+
+	p.send(ctx, false, true, false, []byte("bulk"))
+	m = recvMgr(p)
+	assert.Equal(t, tunnel.Normal, m.Code())
+	assert.Equal(t, []byte("bulk"), m.Payload())
+
+	// A peer that doesn't support Push gets Normal messages
+	p = newTestPeer(ctx, t, HandlerConfig{})
+	p.stream.peerVersion = 2
+	p.connect(ctx)
+	p.sendWithPSH(ctx, false, true, false, true, []byte("interactive"))
+	m = recvMgr(p)
+	assert.Equal(t, tunnel.Normal, m.Code())
+	assert.Equal(t, []byte("interactive"), m.Payload())
+}
+
+func TestHandler_Label(t *testing.T) {
+	id := tunnel.NewConnID(ipproto.TCP, net.IP{10, 0, 0, 1}, net.IP{10, 0, 0, 2}, 4711, 80)
+	h := NewHandler(nil, new(int32), nil, id, "foo.default:80", func() {}, rand.NewSource(1), HandlerConfig{}).(*handler)
+	require.Equal(t, id.String()+" (foo.default:80)", h.name.String())
+	_, err := h.MarshalState()
+	require.ErrorContains(t, err, "(foo.default:80)")
+
+	h = NewHandler(nil, new(int32), nil, id, "", func() {}, rand.NewSource(1), HandlerConfig{}).(*handler)
+	require.Equal(t, id.String(), h.name.String())
+}
+
+func TestHandler_HalfClose(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+	p := newTestPeer(ctx, t, HandlerConfig{})
+	p.connect(ctx)
+
+	// The peer sends a request and closes its side.
+	p.sendWithPSH(ctx, false, true, true, true, []byte("request"))
+	ack := p.recv().Header()
+	require.False(t, ack.FIN(), "the handler must not close its side when the peer closes")
+	require.Equal(t, p.seq, ack.AckNumber(), "the FIN must be acknowledged")
+
+	select {
+	case m := <-p.stream.toMgr:
+		require.Equal(t, []byte("request"), m.Payload())
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for request")
+	}
+	select {
+	case <-p.stream.closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for the stream to be half-closed")
+	}
+	require.Equal(t, stateCloseWait, p.h.state())
+
+	// The traffic-manager responds, which must still reach the peer.
+	p.stream.fromMgr <- tunnel.NewMessage(tunnel.Normal, []byte("response"))
+	data := p.recv().Header()
+	require.Equal(t, []byte("response"), data.Payload())
+	p.ack = data.Sequence() + uint32(len(data.Payload()))
+	p.send(ctx, false, true, false, nil)
+
+	// The traffic-manager closes its side, so the handler sends its FIN.
+	p.stream.closeFromMgr()
+	fin := p.recv().Header()
+	require.True(t, fin.FIN())
+	require.Equal(t, stateLastAck, p.h.state())
+	p.ack = fin.Sequence() + 1
+	p.send(ctx, false, true, false, nil)
+	select {
+	case <-p.h.tunDone:
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler didn't terminate")
+	}
+}
+
+func TestHandler_DialReject(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+	p := newTestPeer(ctx, t, HandlerConfig{})
+	p.connect(ctx)
+
+	// The rejection is propagated to the peer as a RST, not as a FIN.
+	p.stream.fromMgr <- tunnel.DialRejectMessage("intercept gone")
+	rst := p.recv().Header()
+	require.True(t, rst.RST())
+	require.False(t, rst.FIN())
+	select {
+	case <-p.h.tunDone:
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler didn't terminate")
+	}
+}
+
+func TestHandler_StreamLostBeforeDialAnswer(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+	p := newTestPeer(ctx, t, HandlerConfig{})
+	p.connect(ctx)
+
+	// The stream fails before the traffic-manager answers the dial, which is a rejection.
+	p.stream.breakStream()
+	require.True(t, p.recv().Header().RST())
+}
+
+func TestHandler_StreamLostAfterDialAnswer(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+	p := newTestPeer(ctx, t, HandlerConfig{})
+	p.connect(ctx)
+
+	// Once the dial has been answered, a lost stream closes the connection gracefully.
+	p.stream.fromMgr <- tunnel.NewMessage(tunnel.DialOK, nil)
+	p.stream.breakStream()
+	fin := p.recv().Header()
+	require.True(t, fin.FIN())
+	require.False(t, fin.RST())
+}
+
+func TestRejectReason(t *testing.T) {
+	assert.Equal(t, "intercept gone", rejectReason(tunnel.DialRejectMessage("intercept gone"), nil))
+	assert.Equal(t, "no reason given", rejectReason(tunnel.NewMessage(tunnel.DialReject, nil), nil))
+	err := fmt.Errorf("read failed: %w", status.Error(codes.NotFound, "intercept gone"))
+	assert.Equal(t, "intercept gone", rejectReason(nil, err))
+	assert.Equal(t, "stream broken", rejectReason(nil, errStreamBroken))
+}
+
+func TestHandler_State(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+	p := newTestPeer(ctx, t, HandlerConfig{})
+	p.connect(ctx)
+	require.Equal(t, "ESTABLISHED", p.h.State())
+
+	// The peer closes its side while the traffic-manager's side remains open.
+	p.send(ctx, false, true, true, []byte("x"))
+	p.recv()
+	require.Eventually(t, func() bool { return p.h.State() == "CLOSE_WAIT" }, 5*time.Second, time.Millisecond)
+}
+
+func TestHandler_StopPolicy(t *testing.T) {
+	t.Run("graceful from SYN-RECEIVED", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+		defer cancel()
+		p := newTestPeer(ctx, t, HandlerConfig{})
+		p.send(ctx, true, false, false, nil)
+		synAck := p.recv().Header()
+		require.True(t, synAck.SYN())
+		require.Equal(t, stateSynReceived, p.h.state())
+
+		p.h.Stop(ctx)
+		fin := p.recv().Header()
+		require.True(t, fin.FIN())
+		require.False(t, fin.RST())
+		require.Equal(t, stateFinWait1, p.h.state())
+	})
+
+	t.Run("abrupt", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+		defer cancel()
+		p := newTestPeer(ctx, t, HandlerConfig{StopPolicy: StopAbrupt})
+		p.connect(ctx)
+
+		p.h.Stop(ctx)
+		rst := p.recv().Header()
+		require.True(t, rst.RST())
+		require.False(t, rst.FIN())
+		select {
+		case <-p.h.tunDone:
+		case <-time.After(5 * time.Second):
+			t.Fatal("handler didn't terminate")
+		}
+	})
+
+	t.Run("abrupt doesn't apply to the traffic-manager's close", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+		defer cancel()
+		p := newTestPeer(ctx, t, HandlerConfig{StopPolicy: StopAbrupt})
+		p.connect(ctx)
+
+		p.stream.closeFromMgr()
+		fin := p.recv().Header()
+		require.True(t, fin.FIN())
+		require.False(t, fin.RST())
+	})
+}
+
+func TestHandler_TunWriteTimeout(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+	id := tunnel.NewConnID(ipproto.TCP, net.IP{10, 0, 0, 1}, net.IP{10, 0, 0, 2}, 4711, 80)
+	stream := newTestStream(id)
+	tun := &stallingTun{testTun: make(testTun, 100), release: make(chan struct{})}
+	defer close(tun.release)
+	h := NewHandler(func(context.Context) (tunnel.Stream, error) { return stream, nil },
+		new(int32), tun, id, "", func() {}, rand.NewSource(1), HandlerConfig{TunWriteTimeout: 100 * time.Millisecond}).(*handler)
+	h.Start(ctx)
+	p := &testPeer{t: t, id: id, h: h, stream: stream, fromTun: tun.testTun, seq: 1000}
+	p.connect(ctx)
+
+	// The TUN device stalls while the manager sends data. The handler must give up on the
+	// write and terminate instead of blocking forever.
+	atomic.StoreInt32(&tun.stalled, 1)
+	start := time.Now()
+	p.stream.fromMgr <- tunnel.NewMessage(tunnel.Normal, []byte("hello"))
+	select {
+	case <-h.tunDone:
+		require.Less(t, time.Since(start), 2*time.Second)
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler didn't terminate when the TUN write stalled")
+	}
+}
+
+func TestHandler_TunWriteTimeoutSingleWriter(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+	ctx = WithLeakWatchdog(ctx, NewLeakWatchdog(time.Minute, func(context.Context, tunnel.ConnID, []string) {}))
+	id := tunnel.NewConnID(ipproto.TCP, net.IP{10, 0, 0, 1}, net.IP{10, 0, 0, 2}, 4711, 80)
+	tun := &stallingTun{testTun: make(testTun, 100), stalled: 1, release: make(chan struct{})}
+	h := NewHandler(nil, new(int32), tun, id, "", func() {}, rand.NewSource(1), HandlerConfig{TunWriteTimeout: 50 * time.Millisecond}).(*handler)
+	h.Start(ctx)
+	tunWriters := func() int {
+		h.goroutines.wd.Lock()
+		defer h.goroutines.wd.Unlock()
+		return h.goroutines.running["tunWriter"]
+	}
+	require.Equal(t, 1, tunWriters())
+
+	// Writes that stall all time out, and they don't leave a goroutine behind, regardless of how
+	// many there are. The packets remain owned by the caller.
+	for i := 0; i < 3; i++ {
+		pkt := h.newResponse(HeaderLen, false)
+		require.ErrorIs(t, h.tunWrite(ctx, pkt), errTunWriteTimeout)
+		pkt.Release()
+	}
+	select {
+	case <-h.tunDone:
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler didn't terminate when the TUN write stalled")
+	}
+	require.Equal(t, 1, tunWriters())
+
+	// The writer returns once the stalled write completes.
+	close(tun.release)
+	select {
+	case <-h.tunWriterDone:
+	case <-time.After(5 * time.Second):
+		t.Fatal("TUN writer didn't return when the stalled write completed")
+	}
+	require.Eventually(t, func() bool { return tunWriters() == 0 }, 5*time.Second, time.Millisecond)
+	require.Len(t, tun.testTun, 1)
+}
+
+func TestHandler_AckDuringDataWrite(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+	id := tunnel.NewConnID(ipproto.TCP, net.IP{10, 0, 0, 1}, net.IP{10, 0, 0, 2}, 4711, 80)
+	tun := &stallingTun{testTun: make(testTun, 100), stalled: 1, release: make(chan struct{})}
+	h := NewHandler(nil, new(int32), tun, id, "", func() {}, rand.NewSource(1), HandlerConfig{}).(*handler)
+
+	payload := []byte("hello")
+	pkt := h.newResponse(HeaderLen+len(payload), true)
+	copy(pkt.Header().Payload(), payload)
+	sent := make(chan struct{})
+	go func() {
+		defer close(sent)
+		h.sendToTun(ctx, pkt, uint32(len(payload)), false)
+	}()
+	writing := func() Packet {
+		h.sendLock.Lock()
+		defer h.sendLock.Unlock()
+		return h.writing
+	}
+	require.Eventually(t, func() bool { return writing() == pkt }, 5*time.Second, time.Millisecond)
+
+	// The segment is acknowledged while the TUN write stalls. The ACK is processed without waiting
+	// for the write, and the segment is left for sendToTun to release.
+	acked := make(chan struct{})
+	go func() {
+		defer close(acked)
+		h.onAckReceived(ctx, h.sequence())
+	}()
+	select {
+	case <-acked:
+	case <-time.After(5 * time.Second):
+		t.Fatal("ACK wasn't processed while a data segment was written")
+	}
+	h.sendLock.Lock()
+	require.Nil(t, h.ackWaitQueue)
+	require.True(t, h.writingReleased)
+	h.sendLock.Unlock()
+
+	close(tun.release)
+	<-sent
+	require.Equal(t, payload, (<-tun.testTun).Header().Payload())
+	h.sendLock.Lock()
+	require.Nil(t, h.writing)
+	require.False(t, h.writingReleased)
+	h.sendLock.Unlock()
+}
+
+func TestHandler_TunWriteRetry(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+	var failures int32
+	var failErr error // only read after failures has been stored
+	tun := &failingTun{testTun: make(testTun, 100), fail: func() error {
+		if atomic.AddInt32(&failures, -1) >= 0 {
+			return failErr
+		}
+		return nil
+	}}
+	p := newTestPeerWithTun(ctx, t, HandlerConfig{}, tun, tun.testTun)
+	h := p.h
+	p.connect(ctx)
+
+	// Transient errors are retried, and the data reaches the peer.
+	failErr = &os.SyscallError{Syscall: "write", Err: syscall.EAGAIN}
+	atomic.StoreInt32(&failures, 2)
+	p.stream.fromMgr <- tunnel.NewMessage(tunnel.Normal, []byte("hello"))
+	require.Equal(t, []byte("hello"), p.recv().Header().Payload())
+	require.Equal(t, uint64(2), h.Stats().TunWriteRetries)
+	require.Equal(t, stateEstablished, h.state())
+
+	// A fatal error terminates the connection without retries.
+	failErr = errors.New("device gone")
+	atomic.StoreInt32(&failures, 1)
+	p.stream.fromMgr <- tunnel.NewMessage(tunnel.Normal, []byte("world"))
+	select {
+	case <-h.tunDone:
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler didn't terminate when the TUN write failed")
+	}
+	require.Equal(t, uint64(2), h.Stats().TunWriteRetries)
+}
+
+func TestHandler_MTU(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+	p := newTestPeer(ctx, t, HandlerConfig{MTU: 1400})
+	const mss = 1400 - (20 + HeaderLen)
+
+	// The maximum segment size announced in the SYN-ACK is derived from the MTU.
+	p.send(ctx, true, false, false, nil)
+	synAck := p.recv().Header()
+	require.True(t, synAck.SYN())
+	opts := synAck.OptionBytes()
+	require.Equal(t, byte(maximumSegmentSize), opts[0])
+	require.Equal(t, uint16(mss), binary.BigEndian.Uint16(opts[2:]))
+	p.ack = synAck.Sequence() + 1
+	p.send(ctx, false, true, false, nil)
+	require.Eventually(t, func() bool { return p.h.state() == stateEstablished }, 5*time.Second, time.Millisecond)
+
+	// Data from the manager is sent in segments that fit the MTU, even though the peer
+	// announced a larger segment size.
+	p.stream.fromMgr <- tunnel.NewMessage(tunnel.Normal, make([]byte, 2*mss))
+	require.Len(t, p.recv().Header().Payload(), mss)
+	require.Len(t, p.recv().Header().Payload(), mss)
+}
+
+func TestHandler_MTUChange(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+	p := newTestPeer(ctx, t, HandlerConfig{})
+	p.connect(ctx)
+	mss := p.h.maxSegmentSize()
+	p.stream.fromMgr <- tunnel.NewMessage(tunnel.Normal, make([]byte, mss))
+	seg := p.recv().Header()
+	require.Len(t, seg.Payload(), mss)
+	p.ack = seg.Sequence() + uint32(mss)
+	p.send(ctx, false, true, false, nil)
+
+	// Subsequent sends use segments that fit the lowered MTU.
+	const lowered = 1000 - (20 + HeaderLen)
+	p.h.HandleMTUChange(ctx, 1000)
+	require.Equal(t, lowered, p.h.maxSegmentSize())
+	p.stream.fromMgr <- tunnel.NewMessage(tunnel.Normal, make([]byte, 2*lowered))
+	require.Len(t, p.recv().Header().Payload(), lowered)
+	require.Len(t, p.recv().Header().Payload(), lowered)
+
+	// The segment size can grow again, but not beyond what the TUN device allows.
+	p.h.HandleMTUChange(ctx, 9000)
+	require.Equal(t, mss, p.h.maxSegmentSize())
+}
+
+func TestHandler_SynAckRetransmit(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+	p := newTestPeer(ctx, t, HandlerConfig{})
+
+	p.send(ctx, true, false, false, nil)
+	synAck := p.recv().Header()
+	require.True(t, synAck.SYN())
+
+	// The SYN is retransmitted because the SYN-ACK was lost. The handler replies with the same SYN-ACK right away.
+	p.seq--
+	p.send(ctx, true, false, false, nil)
+	dup := p.recv().Header()
+	require.Equal(t, synAck, dup)
+
+	// The peer's final ACK of the handshake is lost, so the SYN-ACK is retransmitted by the timer.
+	select {
+	case pkt := <-p.fromTun:
+		require.Equal(t, synAck, pkt.Header())
+	case <-time.After(initialResendDelay*time.Second + time.Second):
+		t.Fatal("timeout waiting for SYN-ACK retransmit")
+	}
+	require.Equal(t, uint64(1), p.h.Stats().TimerRetransmits)
+
+	p.ack = synAck.Sequence() + 1
+	p.send(ctx, false, true, false, nil)
+	require.Eventually(t, func() bool { return p.h.state() == stateEstablished }, 5*time.Second, time.Millisecond)
+}
+
+func TestHandler_WindowScaleClamped(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+	p := newTestPeer(ctx, t, HandlerConfig{})
+	p.noWindowScale = true
+	p.synOptions = []byte{byte(windowScale), 3, 20, byte(noOp)}
+	p.connect(ctx)
+
+	// A shift count larger than 14 is treated as 14.
+	p.send(ctx, false, true, false, nil)
+	require.Eventually(t, func() bool { return atomic.LoadInt64(&p.h.peerWindow) == 0xffff<<maxWindowScale }, 5*time.Second, time.Millisecond)
+}
+
+func TestHandler_DisableSACK(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+	for _, disabled := range []bool{false, true} {
+		p := newTestPeer(ctx, t, HandlerConfig{DisableSACK: disabled})
+		p.sack = true
+		p.connect(ctx)
+		require.Equal(t, !disabled, p.h.Stats().PeerPermitsSACK)
+	}
+}
+
+func TestHandler_DisableSACKRecovery(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+	p := newTestPeer(ctx, t, HandlerConfig{DisableSACK: true})
+	p.sack = true
+	p.send(ctx, true, false, false, nil)
+	synAck := p.recv().Header()
+	opts, err := options(synAck)
+	require.NoError(t, err)
+	for _, opt := range opts {
+		require.NotEqual(t, selectiveAckPermitted, opt.kind(), "SYN-ACK must not permit SACK")
+	}
+	p.ack = synAck.Sequence() + 1
+	p.send(ctx, false, true, false, nil)
+	require.Eventually(t, func() bool { return p.h.state() == stateEstablished }, 5*time.Second, time.Millisecond)
+
+	// The peer loses the segment and keeps acknowledging what it had before. The segment is
+	// recovered by the retransmit timer only.
+	p.stream.fromMgr <- tunnel.NewMessage(tunnel.Normal, []byte("hello"))
+	lost := p.recv().Header()
+	require.Zero(t, p.h.Stats().TimerRetransmits)
+	for i := 0; i < 3; i++ {
+		p.send(ctx, false, true, false, nil)
+	}
+	resent := p.recv().Header()
+	require.Equal(t, lost.Sequence(), resent.Sequence())
+	require.Equal(t, []byte("hello"), resent.Payload())
+	require.Empty(t, resent.OptionBytes())
+	require.Equal(t, uint64(1), p.h.Stats().TimerRetransmits)
+}
+
+func TestHandler_WindowScale(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+	for _, offered := range []bool{true, false} {
+		p := newTestPeer(ctx, t, HandlerConfig{})
+		p.noWindowScale = !offered
+		p.send(ctx, true, false, false, nil)
+		synAck := p.recv().Header()
+		opts, err := options(synAck)
+		require.NoError(t, err)
+		scaled := false
+		for _, opt := range opts {
+			if opt.kind() == windowScale {
+				scaled = true
+				require.Equal(t, byte(myWindowScale), opt.data()[0])
+			}
+		}
+		require.Equal(t, offered, scaled, "window scale must only be advertised when offered by the peer")
+		p.ack = synAck.Sequence() + 1
+		p.send(ctx, false, true, false, nil)
+		require.Eventually(t, func() bool { return p.h.state() == stateEstablished }, 5*time.Second, time.Millisecond)
+
+		p.send(ctx, false, true, false, []byte("hello"))
+		ack := p.recv().Header()
+		if offered {
+			require.Equal(t, uint16(p.h.receiveWindow()>>myWindowScale), ack.WindowSize())
+		} else {
+			require.Equal(t, maxUnscaledWindow, p.h.receiveWindow())
+			require.Equal(t, uint16(maxUnscaledWindow), ack.WindowSize())
+		}
+	}
+}
+
+func TestHandler_NegotiatedParams(t *testing.T) {
+	tests := []struct {
+		name          string
+		cfg           HandlerConfig
+		sack          bool
+		noWindowScale bool
+		synOptions    []byte
+		want          NegotiatedParams
+	}{
+		{
+			name: "defaults",
+			want: NegotiatedParams{PeerMSS: uint16(maxSegmentSize), MyWindowScale: myWindowScale},
+		},
+		{
+			name:          "peer offers SACK and window scale",
+			sack:          true,
+			noWindowScale: true,
+			synOptions:    []byte{byte(windowScale), 3, 7, byte(noOp)},
+			want:          NegotiatedParams{PeerMSS: uint16(maxSegmentSize), MyWindowScale: myWindowScale, PeerWindowScale: 7},
+		},
+		{
+			name:          "no window scale",
+			noWindowScale: true,
+			want:          NegotiatedParams{PeerMSS: uint16(maxSegmentSize)},
+		},
+		{
+			name: "SACK disabled",
+			cfg:  HandlerConfig{DisableSACK: true},
+			sack: true,
+			want: NegotiatedParams{PeerMSS: uint16(maxSegmentSize), MyWindowScale: myWindowScale},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+			defer cancel()
+			p := newTestPeer(ctx, t, tt.cfg)
+			require.Equal(t, NegotiatedParams{}, p.h.NegotiatedParams())
+			p.sack = tt.sack
+			p.noWindowScale = tt.noWindowScale
+			p.synOptions = tt.synOptions
+			p.connect(ctx)
+			require.Equal(t, tt.want, p.h.NegotiatedParams())
+		})
+	}
+}
+
+func TestHandler_Stats(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+	p := newTestPeer(ctx, t, HandlerConfig{})
+	p.connect(ctx)
+
+	p.send(ctx, false, true, false, []byte("hello"))
+	<-p.stream.toMgr
+	require.Equal(t, p.seq, p.recv().Header().AckNumber())
+	require.Equal(t, uint64(5), p.h.Stats().BytesToManager)
+
+	// The peer doesn't acknowledge the data, so it's retransmitted. The retransmit must not be
+	// counted as application bytes sent.
+	p.stream.fromMgr <- tunnel.NewMessage(tunnel.Normal, []byte("world!"))
+	require.Equal(t, []byte("world!"), p.recv().Header().Payload())
+	require.Equal(t, []byte("world!"), p.recv().Header().Payload())
+
+	// SYN-ACK, ACK, data, and retransmit. The count is updated after the write returns.
+	require.Eventually(t, func() bool { return p.h.Stats().SegmentsSent == 4 }, 5*time.Second, time.Millisecond)
+	st := p.h.Stats()
+	require.Equal(t, uint64(6), st.BytesToTun)
+	require.Equal(t, uint64(6), st.RetransmittedBytes)
+	require.Equal(t, uint64(3), st.SegmentsReceived) // SYN, ACK, and data
+}
+
+func TestHandler_Activity(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+	p := newTestPeer(ctx, t, HandlerConfig{})
+	p.connect(ctx)
+
+	// The connection is idle, so the idle time grows with the age.
+	before := atomic.LoadInt64(&p.h.lastActivity)
+	time.Sleep(20 * time.Millisecond)
+	st := p.h.Stats()
+	require.GreaterOrEqual(t, st.Idle, 20*time.Millisecond)
+	require.GreaterOrEqual(t, st.Age, st.Idle)
+	require.Equal(t, before, atomic.LoadInt64(&p.h.lastActivity))
+
+	// Processing a segment, and acknowledging it, advances the activity timestamp.
+	p.send(ctx, false, true, false, []byte("hello"))
+	<-p.stream.toMgr
+	p.recv()
+	require.Greater(t, atomic.LoadInt64(&p.h.lastActivity), before)
+	require.Less(t, p.h.Stats().Idle, st.Idle)
+}
+
+func TestHandler_Resend(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+	p := newTestPeer(ctx, t, HandlerConfig{})
+	p.connect(ctx)
+
+	// The retransmit has the same sequence as the original and doesn't advance the handler's sequence.
+	p.stream.fromMgr <- tunnel.NewMessage(tunnel.Normal, []byte("hello"))
+	data := p.recv().Header()
+	seq := p.h.sequence()
+	resent := p.recv().Header()
+	require.Equal(t, data.Sequence(), resent.Sequence())
+	require.Equal(t, data.Payload(), resent.Payload())
+	require.Equal(t, seq, p.h.sequence())
+
+	// A segment that is acknowledged after it was collected for retransmission isn't written.
+	resends, _ := p.h.collectResends(ctx, time.Now().Add(time.Minute))
+	require.NotNil(t, resends)
+	p.h.onAckReceived(ctx, seq)
+	p.h.writeResends(ctx, resends)
+	select {
+	case pkt := <-p.fromTun:
+		t.Fatalf("unexpected packet %s", pkt)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// discardTun is an ip.Writer that discards all packets.
+type discardTun struct{}
+
+func (discardTun) Write(context.Context, ip.Packet) error {
+	return nil
+}
+
+// BenchmarkHandler_Resend measures a retransmit round under heavy loss, where all segments in the
+// ackWaitQueue are due. The sendLock is acquired once per round, regardless of the number of segments.
+func BenchmarkHandler_Resend(b *testing.B) {
+	ctx := dlog.NewTestContext(b, false)
+	id := tunnel.NewConnID(ipproto.TCP, net.IP{10, 0, 0, 1}, net.IP{10, 0, 0, 2}, 4711, 80)
+	h := NewHandler(nil, new(int32), discardTun{}, id, "", func() {}, rand.NewSource(1), HandlerConfig{}).(*handler)
+
+	const segments = 1000
+	payload := make([]byte, maxSegmentSize)
+	cTime := time.Now().Add(-time.Minute)
+	var els []*queueElement
+	for i := 0; i < segments; i++ {
+		pkt := h.newResponse(HeaderLen+len(payload), true)
+		copy(pkt.Header().Payload(), payload)
+		h.ackWaitQueue = &queueElement{
+			sequence: uint32((i + 1) * len(payload)),
+			cTime:    cTime,
+			packet:   pkt,
+			next:     h.ackWaitQueue,
+		}
+		els = append(els, h.ackWaitQueue)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, el := range els {
+			el.retries = 0
+		}
+		resends, _ := h.collectResends(ctx, time.Now())
+		h.writeResends(ctx, resends)
+	}
+}
+
+// slowTun is an ip.Writer that blocks for a while on each write, like a system call would.
+type slowTun struct{}
+
+func (slowTun) Write(context.Context, ip.Packet) error {
+	time.Sleep(50 * time.Microsecond)
+	return nil
+}
+
+// BenchmarkHandler_SendAck measures how fast acknowledgments are sent to the TUN device while
+// data segments are written concurrently, as happens when the goroutine that processes received
+// packets acknowledges them while data from the traffic-manager is sent to the peer.
+func BenchmarkHandler_SendAck(b *testing.B) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(b, false))
+	defer cancel()
+	id := tunnel.NewConnID(ipproto.TCP, net.IP{10, 0, 0, 1}, net.IP{10, 0, 0, 2}, 4711, 80)
+	h := NewHandler(nil, new(int32), slowTun{}, id, "", func() {}, rand.NewSource(1), HandlerConfig{}).(*handler)
+
+	payload := make([]byte, 100)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for ctx.Err() == nil {
+			pkt := h.newResponse(HeaderLen+len(payload), true)
+			copy(pkt.Header().Payload(), payload)
+			h.sendToTun(ctx, pkt, uint32(len(payload)), false)
+			h.onAckReceived(ctx, h.sequence())
+		}
+	}()
+	b.SetParallelism(4)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			h.sendToTun(ctx, h.newResponse(HeaderLen, false), 0, true)
+		}
+	})
+	b.StopTimer()
+	cancel()
+	<-done
+}
+
+// BenchmarkHandler_SendData measures the cost of sending a data segment and having it acknowledged
+// when nothing else contends for the handler's locks.
+func BenchmarkHandler_SendData(b *testing.B) {
+	ctx := dlog.NewTestContext(b, false)
+	id := tunnel.NewConnID(ipproto.TCP, net.IP{10, 0, 0, 1}, net.IP{10, 0, 0, 2}, 4711, 80)
+	h := NewHandler(nil, new(int32), discardTun{}, id, "", func() {}, rand.NewSource(1), HandlerConfig{}).(*handler)
+
+	payload := make([]byte, 1000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		pkt := h.newResponse(HeaderLen+len(payload), true)
+		copy(pkt.Header().Payload(), payload)
+		h.sendToTun(ctx, pkt, uint32(len(payload)), false)
+		h.onAckReceived(ctx, h.sequence())
+	}
+}
+
+// BenchmarkHandler_PlainAck measures the cost of an acknowledgment on a loss-free connection, where
+// the oooQueue is empty and the ACK carries no options.
+func BenchmarkHandler_PlainAck(b *testing.B) {
+	ctx := dlog.NewTestContext(b, false)
+	id := tunnel.NewConnID(ipproto.TCP, net.IP{10, 0, 0, 1}, net.IP{10, 0, 0, 2}, 4711, 80)
+	h := NewHandler(nil, new(int32), discardTun{}, id, "", func() {}, rand.NewSource(1), HandlerConfig{}).(*handler)
+	atomic.StoreInt32(&h.peerPermitsSACK, 1)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		// Advance the sequence to acknowledge, or the ACK is skipped as redundant.
+		h.setPeerSequenceToAck(uint32(i + 1))
+		h.sendAck(ctx)
+	}
+}
+
+func TestHandler_PlainAckWithoutOptions(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+	p := newTestPeer(ctx, t, HandlerConfig{})
+	p.sack = true
+	p.connect(ctx)
+	require.True(t, p.h.Stats().PeerPermitsSACK)
+
+	// The ACKs of a loss-free transfer have nothing to report, so they carry no SACK blocks.
+	for i := 0; i < 3; i++ {
+		p.send(ctx, false, true, false, []byte("hello"))
+		ack := p.recv().Header()
+		require.True(t, ack.ACK())
+		require.Equal(t, p.seq, ack.AckNumber())
+		require.Equal(t, HeaderLen/4, ack.DataOffset())
+		require.Empty(t, ack.OptionBytes())
+	}
+}
+
+func TestHandler_SequenceWraparound(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+	p := newTestPeer(ctx, t, HandlerConfig{})
+	p.seq = math.MaxUint32 - 1500 // the SYN occupies one sequence number
+	p.connect(ctx)
+
+	segment := func(b byte) []byte { return bytes.Repeat([]byte{b}, 1000) }
+	toMgr := func(n int) []byte {
+		var data []byte
+		for len(data) < n {
+			select {
+			case m := <-p.stream.toMgr:
+				data = append(data, m.Payload()...)
+			case <-time.After(5 * time.Second):
+				t.Fatal("timeout waiting for message to manager")
+			}
+		}
+		return data
+	}
+	awaitAck := func(ack uint32) {
+		for p.recv().Header().AckNumber() != ack {
+		}
+	}
+
+	// The second segment crosses the wraparound boundary, and the third one follows it.
+	p.sendWithPSH(ctx, false, true, false, true, segment('a'))
+	require.Equal(t, segment('a'), toMgr(1000))
+	awaitAck(p.seq)
+	second := p.seq
+	third := second + 1000
+	require.Less(t, third, second)
+
+	// The third segment arrives before the second, so it's queued as out of order.
+	p.seq = third
+	p.sendWithPSH(ctx, false, true, false, true, segment('c'))
+	require.Eventually(t, func() bool { return p.h.Stats().BufferedBytes == 1000 }, 5*time.Second, time.Millisecond)
+	p.seq = second
+	p.sendWithPSH(ctx, false, true, false, true, segment('b'))
+	require.Equal(t, append(segment('b'), segment('c')...), toMgr(2000))
+	awaitAck(third + 1000)
+}
+
+func TestHandler_AckWraparound(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+	id := tunnel.NewConnID(ipproto.TCP, net.IP{10, 0, 0, 1}, net.IP{10, 0, 0, 2}, 4711, 80)
+	h := NewHandler(nil, new(int32), discardTun{}, id, "", func() {}, rand.NewSource(1), HandlerConfig{}).(*handler)
+
+	// Three segments are sent, and the second one crosses the wraparound boundary.
+	h.setSequence(math.MaxUint32 - 1500)
+	var ends []uint32
+	for i := 0; i < 3; i++ {
+		h.sendToTun(ctx, h.newResponse(HeaderLen+1000, true), 1000, false)
+		ends = append(ends, h.sequence())
+	}
+	require.Equal(t, int64(3000), h.Stats().BufferedBytes)
+
+	// Acknowledging the first segment must not release the ones after the boundary.
+	h.onAckReceived(ctx, ends[0])
+	require.Equal(t, int64(2000), h.Stats().BufferedBytes)
+	h.onAckReceived(ctx, ends[1])
+	require.Equal(t, int64(1000), h.Stats().BufferedBytes)
+	h.onAckReceived(ctx, ends[2])
+	require.Equal(t, int64(0), h.Stats().BufferedBytes)
+}
+
+func TestHandler_StaleAckWraparound(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+	id := tunnel.NewConnID(ipproto.TCP, net.IP{10, 0, 0, 1}, net.IP{10, 0, 0, 2}, 4711, 80)
+	h := NewHandler(nil, new(int32), discardTun{}, id, "", func() {}, rand.NewSource(1), HandlerConfig{}).(*handler)
+
+	h.initSequence(math.MaxUint32 - 1500)
+	var ends []uint32
+	for i := 0; i < 3; i++ {
+		h.sendToTun(ctx, h.newResponse(HeaderLen+1000, true), 1000, false)
+		ends = append(ends, h.sequence())
+	}
+	require.Equal(t, 3000, h.unackedBytes())
+
+	// An ACK that is delayed until after an ACK beyond the wraparound boundary must not move the
+	// acknowledged sequence backwards.
+	h.onAckReceived(ctx, ends[1])
+	require.Equal(t, 1000, h.unackedBytes())
+	h.onAckReceived(ctx, ends[0])
+	require.Equal(t, 1000, h.unackedBytes())
+	require.Equal(t, int64(1000), h.Stats().BufferedBytes)
+	h.onAckReceived(ctx, ends[2])
+	require.Equal(t, 0, h.unackedBytes())
+}
+
+func TestSeqAfter(t *testing.T) {
+	tests := []struct {
+		a, b  uint32
+		after bool
+	}{
+		{2, 1, true},
+		{1, 2, false},
+		{1, 1, false},
+		{0, math.MaxUint32, true},
+		{math.MaxUint32, 0, false},
+		{500, math.MaxUint32 - 500, true},
+		{math.MaxUint32 - 500, 500, false},
+		{1 << 31, 1, true},
+		{1<<31 + 2, 1, false},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.after, seqAfter(tt.a, tt.b), "seqAfter(%d, %d)", tt.a, tt.b)
+	}
+}
+
+func TestHandler_OutOfWindow(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+	p := newTestPeer(ctx, t, HandlerConfig{})
+	p.connect(ctx)
+	expected := p.seq
+
+	// Segments far ahead of and far behind the expected sequence are discarded and answered with
+	// an ACK that tells the peer what we expect.
+	for _, sq := range []uint32{expected + 10*maxReceiveWindow, expected - 10*maxReceiveWindow, expected + 1<<31} {
+		p.seq = sq
+		p.send(ctx, false, true, false, []byte("hello"))
+		ack := p.recv().Header()
+		require.True(t, ack.ACK())
+		require.Equal(t, expected, ack.AckNumber())
+	}
+
+	// An out-of-window RST is dropped without reply and doesn't terminate the connection.
+	p.seq = expected + 10*maxReceiveWindow
+	pkt := NewPacket(HeaderLen, p.id.Source(), p.id.Destination(), false)
+	pkt.IPHeader().SetL4Protocol(ipproto.TCP)
+	tcpHdr := pkt.Header()
+	tcpHdr.SetDataOffset(HeaderLen / 4)
+	tcpHdr.SetSourcePort(p.id.SourcePort())
+	tcpHdr.SetDestinationPort(p.id.DestinationPort())
+	tcpHdr.SetSequence(p.seq)
+	tcpHdr.SetRST(true)
+	tcpHdr.SetChecksum(pkt.IPHeader())
+	p.h.HandlePacket(ctx, pkt)
+	require.Eventually(t, func() bool { return p.h.Stats().OutOfWindow == 4 }, 5*time.Second, time.Millisecond)
+	require.Equal(t, stateEstablished, p.h.state())
+	select {
+	case m := <-p.stream.toMgr:
+		t.Fatalf("out-of-window data was delivered: %q", m.Payload())
+	case pkt := <-p.fromTun:
+		t.Fatalf("unexpected reply to RST %s", pkt)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// Data in the window is still accepted.
+	p.seq = expected
+	p.send(ctx, false, true, false, []byte("hello"))
+	select {
+	case m := <-p.stream.toMgr:
+		require.Equal(t, []byte("hello"), m.Payload())
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for message to manager")
+	}
+}
+
+// newLazyTestPeer creates a testPeer for a handler that uses the LazyStream setting and counts the
+// number of times that its stream creator is called.
+func newLazyTestPeer(ctx context.Context, t *testing.T, created *int32) *testPeer {
+	id := tunnel.NewConnID(ipproto.TCP, net.IP{10, 0, 0, 1}, net.IP{10, 0, 0, 2}, 4711, 80)
+	stream := newTestStream(id)
+	tun := make(testTun, 100)
+	removed := make(chan struct{})
+	h := NewHandler(func(context.Context) (tunnel.Stream, error) {
+		atomic.AddInt32(created, 1)
+		return stream, nil
+	}, new(int32), tun, id, "", func() { close(removed) }, rand.NewSource(1), HandlerConfig{LazyStream: true}).(*handler)
+	h.Start(ctx)
+	t.Cleanup(func() {
+		select {
+		case <-removed:
+		case <-time.After(5 * time.Second):
+		}
+	})
+	return &testPeer{t: t, id: id, h: h, stream: stream, fromTun: tun, seq: 1000}
+}
+
+func TestHandler_LazyStreamConnectThenClose(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+	var created int32
+	p := newLazyTestPeer(ctx, t, &created)
+	p.connect(ctx)
+
+	// The peer closes without sending data. The FIN is acknowledged and answered with a FIN.
+	p.send(ctx, false, true, true, nil)
+	ack := p.recv().Header()
+	require.Equal(t, p.seq, ack.AckNumber())
+	fin := ack
+	if !fin.FIN() {
+		fin = p.recv().Header()
+	}
+	require.True(t, fin.FIN())
+	p.ack = fin.Sequence() + 1
+	p.send(ctx, false, true, false, nil)
+	select {
+	case <-p.h.tunDone:
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler didn't terminate")
+	}
+	require.Equal(t, int32(0), atomic.LoadInt32(&created))
+}
+
+func TestHandler_LazyStreamCreatedOnData(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+	var created int32
+	p := newLazyTestPeer(ctx, t, &created)
+	p.connect(ctx)
+	require.Equal(t, int32(0), atomic.LoadInt32(&created))
+
+	p.sendWithPSH(ctx, false, true, false, true, []byte("hello"))
+	select {
+	case m := <-p.stream.toMgr:
+		require.Equal(t, []byte("hello"), m.Payload())
+	case <-time.After(5 * time.Second):
+		t.Fatal("data didn't reach the traffic-manager")
+	}
+	require.Equal(t, int32(1), atomic.LoadInt32(&created))
+
+	// Data from the traffic-manager reaches the peer.
+	p.stream.fromMgr <- tunnel.NewMessage(tunnel.Normal, []byte("world"))
+	for {
+		if pl := p.recv().Header().Payload(); len(pl) > 0 {
+			require.Equal(t, []byte("world"), pl)
+			break
+		}
+	}
+}
+
+// awaitHandshakeReset sends a SYN from a peer that never completes the handshake, and returns the
+// time that it took until the handler reset the connection, and the number of SYN-ACK retransmits.
+func awaitHandshakeReset(ctx context.Context, t *testing.T, p *testPeer) (time.Duration, int) {
+	start := time.Now()
+	p.send(ctx, true, false, false, nil)
+	synAck := p.recv().Header()
+	require.True(t, synAck.SYN())
+	retransmits := 0
+	for {
+		hdr := p.recv().Header()
+		if hdr.RST() {
+			break
+		}
+		require.True(t, hdr.SYN(), "only retransmits of the SYN-ACK are expected")
+		retransmits++
+	}
+	select {
+	case <-p.h.tunDone:
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler didn't terminate")
+	}
+	return time.Since(start), retransmits
+}
+
+func TestHandler_SynTimeout(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+	p := newTestPeer(ctx, t, HandlerConfig{SynTimeout: 300 * time.Millisecond})
+	elapsed, _ := awaitHandshakeReset(ctx, t, p)
+	assert.GreaterOrEqual(t, elapsed, 300*time.Millisecond)
+	assert.Less(t, elapsed, 2*time.Second)
+}
+
+func TestHandler_SynRetries(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+	p := newTestPeer(ctx, t, HandlerConfig{SynRetries: 1})
+
+	// Backdate the SYN-ACK so that it's due for its second retransmit as soon as it has been
+	// retransmitted once.
+	go func() {
+		assert.Eventually(t, func() bool {
+			p.h.sendLock.Lock()
+			defer p.h.sendLock.Unlock()
+			if el := p.h.ackWaitQueue; el != nil && el.packet.Header().SYN() {
+				el.cTime = time.Now().Add(-time.Minute)
+				return true
+			}
+			return false
+		}, 5*time.Second, time.Millisecond)
+	}()
+	elapsed, retransmits := awaitHandshakeReset(ctx, t, p)
+	assert.Less(t, elapsed, 2*time.Second)
+	assert.Equal(t, 1, retransmits)
+}
@@ -0,0 +1,19 @@
+package tcp
+
+// PacketInspector is called with each segment that a handler reads from, or is about to write to,
+// the TUN device. The direction is ToManager for segments from the peer and ToPeer for segments
+// to the peer. Returning false drops the segment, which makes it possible to inject faults when
+// testing. The inspector is called from several goroutines, so it must be safe for concurrent
+// use, and it must neither modify nor retain the packet.
+type PacketInspector func(dir Direction, pkt Packet) bool
+
+// SetPacketInspector installs a PacketInspector in the handler. A nil inspector removes it.
+func (h *handler) SetPacketInspector(inspector PacketInspector) {
+	h.inspector.Store(inspector)
+}
+
+// inspect returns false when the handler's PacketInspector drops the given packet.
+func (h *handler) inspect(dir Direction, pkt Packet) bool {
+	inspector, _ := h.inspector.Load().(PacketInspector)
+	return inspector == nil || inspector(dir, pkt)
+}
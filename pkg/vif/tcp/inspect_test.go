@@ -0,0 +1,77 @@
+package tcp
+
+import (
+	"bytes"
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/tunnel"
+)
+
+func TestHandler_PacketInspector(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+	p := newTestPeer(ctx, t, HandlerConfig{})
+	p.connect(ctx)
+
+	// Drop every third segment to the peer
+	var toPeer, fromPeer, dropped int32
+	p.h.SetPacketInspector(func(dir Direction, pkt Packet) bool {
+		if dir == ToManager {
+			atomic.AddInt32(&fromPeer, 1)
+			return true
+		}
+		if atomic.AddInt32(&toPeer, 1)%3 == 0 {
+			atomic.AddInt32(&dropped, 1)
+			return false
+		}
+		return true
+	})
+
+	var sent []byte
+	for i := 0; i < 4; i++ {
+		data := bytes.Repeat([]byte{byte('a' + i)}, 100)
+		sent = append(sent, data...)
+		p.stream.fromMgr <- tunnel.NewMessage(tunnel.Normal, data)
+	}
+
+	// Receive and acknowledge in order until all data has arrived. The dropped segments are
+	// recovered by retransmits.
+	var received []byte
+	segments := make(map[uint32][]byte)
+	deadline := time.Now().Add(15 * time.Second)
+	for len(received) < len(sent) {
+		require.True(t, time.Now().Before(deadline), "timeout waiting for retransmits")
+		tcpHdr := p.recv().Header()
+		if pl := tcpHdr.Payload(); len(pl) > 0 {
+			segments[tcpHdr.Sequence()] = append([]byte(nil), pl...)
+		}
+		for {
+			pl, ok := segments[p.ack]
+			if !ok {
+				break
+			}
+			delete(segments, p.ack)
+			received = append(received, pl...)
+			p.ack += uint32(len(pl))
+		}
+		p.send(ctx, false, true, false, nil)
+	}
+	assert.Equal(t, sent, received)
+	assert.NotZero(t, atomic.LoadInt32(&dropped))
+	assert.NotZero(t, p.h.Stats().TimerRetransmits)
+	require.Eventually(t, func() bool { return atomic.LoadInt32(&fromPeer) > 0 }, 5*time.Second, time.Millisecond)
+
+	// Removing the inspector stops the dropping
+	p.h.SetPacketInspector(nil)
+	p.stream.fromMgr <- tunnel.NewMessage(tunnel.Normal, []byte("done"))
+	tcpHdr := p.recv().Header()
+	assert.Equal(t, []byte("done"), tcpHdr.Payload())
+	assert.Equal(t, p.ack, tcpHdr.Sequence())
+}
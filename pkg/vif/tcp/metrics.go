@@ -0,0 +1,163 @@
+package tcp
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/datawire/dlib/dlog"
+)
+
+func (r quitReason) String() string {
+	switch r {
+	case quitByReset:
+		return "reset"
+	case quitByUs:
+		return "closed-by-us"
+	case quitByPeer:
+		return "closed-by-peer"
+	case quitByBoth:
+		return "closed-by-both"
+	default:
+		return "aborted"
+	}
+}
+
+// ConnRecord summarizes the lifetime of a TCP connection. It's produced when the connection's
+// handler terminates.
+type ConnRecord struct {
+	// Time is when the handler terminated.
+	Time time.Time `json:"time"`
+
+	// Conn identifies the connection.
+	Conn string `json:"conn"`
+
+	// Duration is the lifetime of the handler in nanoseconds.
+	Duration time.Duration `json:"duration"`
+
+	// CloseReason is one of "reset", "closed-by-us", "closed-by-peer", "closed-by-both", or
+	// "aborted" when the handler terminated before the connection was closed.
+	CloseReason string `json:"closeReason"`
+
+	BytesToManager     uint64 `json:"bytesToManager"`
+	BytesToTun         uint64 `json:"bytesToTun"`
+	RetransmittedBytes uint64 `json:"retransmittedBytes"`
+	TimerRetransmits   uint64 `json:"timerRetransmits"`
+	SegmentsSent       uint64 `json:"segmentsSent"`
+	SegmentsReceived   uint64 `json:"segmentsReceived"`
+
+	// Attributes are the attributes of the connection's ConnMetadata.
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+// ConnRecorder receives a ConnRecord for each TCP connection that terminates. ConnClosed is called
+// on the handler's teardown path, so it must never block.
+type ConnRecorder interface {
+	ConnClosed(rec ConnRecord)
+}
+
+type connRecorderKey struct{}
+
+// WithConnRecorder returns a context with the given ConnRecorder. Handlers that are started using
+// that context will send a ConnRecord to the recorder when they terminate.
+func WithConnRecorder(ctx context.Context, cr ConnRecorder) context.Context {
+	return context.WithValue(ctx, connRecorderKey{}, cr)
+}
+
+func getConnRecorder(ctx context.Context) ConnRecorder {
+	cr, ok := ctx.Value(connRecorderKey{}).(ConnRecorder)
+	if !ok {
+		return nil
+	}
+	return cr
+}
+
+// recordClose sends the handler's ConnRecord to its ConnRecorder, if any.
+func (h *handler) recordClose() {
+	if h.recorder == nil {
+		return
+	}
+	st := h.Stats()
+	h.recorder.ConnClosed(ConnRecord{
+		Time:               h.clock.Now(),
+		Conn:               h.id.String(),
+		Duration:           st.Age,
+		CloseReason:        h.closeReason.String(),
+		BytesToManager:     st.BytesToManager,
+		BytesToTun:         st.BytesToTun,
+		RetransmittedBytes: st.RetransmittedBytes,
+		TimerRetransmits:   st.TimerRetransmits,
+		SegmentsSent:       st.SegmentsSent,
+		SegmentsReceived:   st.SegmentsReceived,
+		Attributes:         h.metadata.Attributes(),
+	})
+}
+
+// ConnMetricsWriter is a ConnRecorder that writes each ConnRecord as a line of JSON to an
+// io.Writer. Records are buffered, and a record that doesn't fit in the buffer is dropped, so a
+// slow writer never delays the teardown of the handlers.
+type ConnMetricsWriter struct {
+	ch      chan ConnRecord
+	w       io.Writer
+	dropped uint64
+}
+
+// NewConnMetricsWriter creates a ConnMetricsWriter that buffers at most bufferSize records. The
+// records are written to w by Run.
+func NewConnMetricsWriter(w io.Writer, bufferSize int) *ConnMetricsWriter {
+	return &ConnMetricsWriter{ch: make(chan ConnRecord, bufferSize), w: w}
+}
+
+// ConnClosed implements ConnRecorder.
+func (m *ConnMetricsWriter) ConnClosed(rec ConnRecord) {
+	select {
+	case m.ch <- rec:
+	default:
+		atomic.AddUint64(&m.dropped, 1)
+	}
+}
+
+// Dropped returns the number of records that were dropped because the buffer was full.
+func (m *ConnMetricsWriter) Dropped() uint64 {
+	return atomic.LoadUint64(&m.dropped)
+}
+
+// Run writes the records until the given context is done. The output is flushed whenever there
+// are no more records to write, and the records that are buffered when the context is done are
+// written before Run returns.
+func (m *ConnMetricsWriter) Run(ctx context.Context) error {
+	bw := bufio.NewWriter(m.w)
+	enc := json.NewEncoder(bw)
+	write := func(rec ConnRecord) error {
+		if err := enc.Encode(&rec); err != nil {
+			return err
+		}
+		if len(m.ch) == 0 {
+			return bw.Flush()
+		}
+		return nil
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			for {
+				select {
+				case rec := <-m.ch:
+					if err := enc.Encode(&rec); err != nil {
+						return err
+					}
+				default:
+					return bw.Flush()
+				}
+			}
+		case rec := <-m.ch:
+			if err := write(rec); err != nil {
+				dlog.Errorf(ctx, "unable to write connection metrics: %v", err)
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package tcp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/ipproto"
+)
+
+func TestHandler_ConnMetrics(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+	out := &lockedBuffer{}
+	mw := NewConnMetricsWriter(out, 10)
+	go func(ctx context.Context) { _ = mw.Run(ctx) }(ctx)
+	ctx = WithConnMetadata(WithConnRecorder(ctx, mw), ConnMetadata{Namespace: "default", Workload: "echo"})
+	p := newTestPeer(ctx, t, HandlerConfig{})
+	p.connect(ctx)
+	p.send(ctx, false, true, false, []byte("hello"))
+	<-p.stream.toMgr
+	p.recv()
+
+	// The peer resets the connection.
+	pkt := NewPacket(HeaderLen, p.id.Source(), p.id.Destination(), false)
+	pkt.IPHeader().SetL4Protocol(ipproto.TCP)
+	tcpHdr := pkt.Header()
+	tcpHdr.SetDataOffset(HeaderLen / 4)
+	tcpHdr.SetSourcePort(p.id.SourcePort())
+	tcpHdr.SetDestinationPort(p.id.DestinationPort())
+	tcpHdr.SetSequence(p.seq)
+	tcpHdr.SetRST(true)
+	tcpHdr.SetChecksum(pkt.IPHeader())
+	p.h.HandlePacket(ctx, pkt)
+
+	require.Eventually(t, func() bool { return strings.HasSuffix(out.String(), "\n") }, 5*time.Second, time.Millisecond)
+	var rec ConnRecord
+	require.NoError(t, json.Unmarshal([]byte(out.String()), &rec))
+	require.Equal(t, p.id.String(), rec.Conn)
+	require.Equal(t, "reset", rec.CloseReason)
+	require.Equal(t, uint64(5), rec.BytesToManager)
+	require.Equal(t, uint64(4), rec.SegmentsReceived) // SYN, ACK, data, and RST
+	require.Greater(t, rec.Duration, time.Duration(0))
+	require.Equal(t, map[string]string{"namespace": "default", "workload": "echo"}, rec.Attributes)
+	require.Zero(t, mw.Dropped())
+}
+
+func TestConnMetricsWriter_Dropped(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	out := &lockedBuffer{}
+	mw := NewConnMetricsWriter(out, 1)
+	mw.ConnClosed(ConnRecord{Conn: "first"})
+	mw.ConnClosed(ConnRecord{Conn: "second"})
+	require.Equal(t, uint64(1), mw.Dropped())
+
+	// Buffered records are written when the context is cancelled.
+	cancel()
+	require.NoError(t, mw.Run(ctx))
+	lines := bytes.Split(bytes.TrimSpace([]byte(out.String())), []byte("\n"))
+	require.Len(t, lines, 1)
+	var rec ConnRecord
+	require.NoError(t, json.Unmarshal(lines[0], &rec))
+	require.Equal(t, "first", rec.Conn)
+}
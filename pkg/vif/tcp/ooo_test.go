@@ -0,0 +1,59 @@
+package tcp
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+)
+
+func TestHandler_MaxOutOfOrderQueueLength(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+	const maxLen = 4
+	const segLen = 100
+	p := newTestPeer(ctx, t, HandlerConfig{MaxOutOfOrderQueueLength: maxLen})
+	p.connect(ctx)
+	expected := p.seq
+
+	// A flood of disjoint segments that all arrive after a gap. They arrive farthest first, so
+	// each one that arrives when the queue is full replaces the one farthest ahead.
+	const count = 20
+	for i := count; i > 0; i-- {
+		p.seq = expected + uint32(i*2*segLen)
+		p.send(ctx, false, true, false, bytes.Repeat([]byte{byte(i)}, segLen))
+		require.Eventually(t, func() bool { return p.h.Stats().SegmentsReceived >= uint64(count-i+3) }, 5*time.Second, time.Millisecond)
+		st := p.h.Stats()
+		require.LessOrEqual(t, st.OutOfOrderQueueLength, maxLen)
+		require.Equal(t, maxLen, st.MaxOutOfOrderQueueLength)
+	}
+	st := p.h.Stats()
+	require.Equal(t, maxLen, st.OutOfOrderQueueLength)
+	require.Equal(t, uint64(count-maxLen), st.OutOfOrderDropped)
+	require.Equal(t, int64(maxLen*segLen), st.BufferedBytes)
+
+	// A segment that is farther ahead than all queued segments is dropped.
+	p.seq = expected + uint32((count+1)*2*segLen)
+	p.send(ctx, false, true, false, bytes.Repeat([]byte{count + 1}, segLen))
+	require.Eventually(t, func() bool { return p.h.Stats().OutOfOrderDropped == count-maxLen+1 }, 5*time.Second, time.Millisecond)
+	require.Equal(t, maxLen, p.h.Stats().OutOfOrderQueueLength)
+
+	// The segments nearest to the gap were kept. Filling the gap in front of the first one delivers it.
+	p.seq = expected
+	p.send(ctx, false, true, false, bytes.Repeat([]byte{0}, 2*segLen))
+	var data []byte
+	for len(data) < 3*segLen {
+		select {
+		case m := <-p.stream.toMgr:
+			data = append(data, m.Payload()...)
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for message to manager")
+		}
+	}
+	require.Equal(t, append(bytes.Repeat([]byte{0}, 2*segLen), bytes.Repeat([]byte{1}, segLen)...), data)
+	require.Eventually(t, func() bool { return p.h.Stats().OutOfOrderQueueLength == maxLen-1 }, 5*time.Second, time.Millisecond)
+}
@@ -0,0 +1,156 @@
+package tcp
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/datawire/dlib/dlog"
+)
+
+const (
+	// mtuProbeAttempts is the number of times that a probe is sent before the handler concludes
+	// that its size doesn't fit the path (MAX_PROBES in RFC 4821).
+	mtuProbeAttempts = 3
+
+	// mtuProbeGranularity ends the search when the largest segment size that is known to pass
+	// and the smallest that is known to fail are this close.
+	mtuProbeGranularity = 32
+
+	// mtuRaiseInterval is the time that the handler waits after a completed search before it
+	// probes again, so that it detects a path MTU that has grown (RFC 4821, section 7.7).
+	mtuRaiseInterval = 10 * time.Minute
+)
+
+// mtuProber contains the state of the Packetization Layer Path MTU Discovery (RFC 4821) that a
+// handler performs when HandlerConfig.PathMTUDiscovery is enabled. The sizes are segment sizes,
+// i.e. the MTU minus the IP and TCP headers. It's protected by the sendLock.
+type mtuProber struct {
+	// ceiling is the smallest segment size that is known not to pass, or zero when none is known
+	ceiling int
+
+	// size is the size of the probe that is pending or in flight, or zero when there's no probe
+	size int
+
+	// pending is true when a probe size has been chosen but the probe hasn't been queued yet
+	pending bool
+
+	// raiseAt is the time when a completed search is restarted. It's zero while searching.
+	raiseAt time.Time
+}
+
+// initPathMTUDiscovery makes the handler start out with the smallest segment size that every
+// path must support. Larger sizes are then used once probes have shown that they pass.
+func (h *handler) initPathMTUDiscovery() {
+	if !h.cfg.PathMTUDiscovery {
+		return
+	}
+	mss := segmentSizeForMTU(0, len(h.id.Source()) != 4) - h.addedOptionsLen()
+	atomic.StoreInt32(&h.pathMaxSegmentSize, int32(mss))
+}
+
+// mtuProbeSize returns the size of the next segment when it should be sent as a probe, or zero
+// when it should be a normal segment. Only full segments are used as probes, so the given number
+// of bytes that are available for sending, and the send window, must both fit the probe. The
+// sendLock must be held.
+func (h *handler) mtuProbeSize(now time.Time, avail, window int) int {
+	p := &h.mtu
+	if !h.cfg.PathMTUDiscovery || p.size > 0 {
+		return 0
+	}
+	if !p.raiseAt.IsZero() {
+		if now.Before(p.raiseAt) {
+			return 0
+		}
+		p.raiseAt = time.Time{}
+		p.ceiling = 0
+	}
+	cur := int(atomic.LoadInt32(&h.pathMaxSegmentSize))
+	limit := h.segmentSizeLimit()
+	ceiling := p.ceiling
+	if ceiling == 0 || ceiling > limit+1 {
+		ceiling = limit + 1
+	}
+	if ceiling-cur <= mtuProbeGranularity {
+		p.raiseAt = now.Add(mtuRaiseInterval)
+		return 0
+	}
+	size := cur + (ceiling-cur)/2
+	if p.ceiling == 0 {
+		// Nothing is known to fail, so go straight for the largest size.
+		size = limit
+	}
+	if avail < size || window < size {
+		return 0
+	}
+	p.size = size
+	p.pending = true
+	return size
+}
+
+// takeMTUProbe returns true when a segment with the given payload length is the pending probe.
+// It's called when the segment is added to the ackWaitQueue. The sendLock must be held.
+func (h *handler) takeMTUProbe(payloadLen int) bool {
+	p := &h.mtu
+	if p.pending && p.size == payloadLen {
+		p.pending = false
+		return true
+	}
+	return false
+}
+
+// onMTUProbeAcked raises the effective maximum segment size to the size of the probe that was
+// just acknowledged. The sendLock must be held.
+func (h *handler) onMTUProbeAcked(ctx context.Context) {
+	p := &h.mtu
+	if p.size > int(atomic.LoadInt32(&h.pathMaxSegmentSize)) {
+		dlog.Debugf(ctx, "   CON %s, probe acknowledged, maximum segment size raised to %d", h.name, p.size)
+		atomic.StoreInt32(&h.pathMaxSegmentSize, int32(p.size))
+	}
+	p.size = 0
+}
+
+// expireMTUProbe checks if the probe in the ackWaitQueue is due for retransmission for the
+// mtuProbeAttempts time. If so, the probe size is considered too large and the sequence of the
+// probe is returned together with true, so that the caller can resend its data using segments of
+// the current maximum segment size.
+func (h *handler) expireMTUProbe(ctx context.Context, now time.Time) (uint32, bool) {
+	h.sendLock.Lock()
+	defer h.sendLock.Unlock()
+	p := &h.mtu
+	if p.size == 0 || p.pending {
+		return 0, false
+	}
+	for el := h.ackWaitQueue; el != nil; el = el.next {
+		if !el.probe {
+			continue
+		}
+		if el.retries+1 < mtuProbeAttempts || now.Before(el.cTime.Add(time.Duration(initialResendDelay<<el.retries)*time.Second)) {
+			return 0, false
+		}
+		dlog.Debugf(ctx, "   CON %s, probe of size %d was not acknowledged, keeping maximum segment size %d",
+			h.name, p.size, h.maxSegmentSize())
+		el.probe = false
+		p.ceiling = p.size
+		p.size = 0
+		return el.packet.Header().Sequence(), true
+	}
+	// The probe is no longer in the queue.
+	p.size = 0
+	return 0, false
+}
+
+// lowerMTUCeiling prevents probes of the given segment size or larger. It's called when an ICMP
+// message reports the path MTU.
+func (h *handler) lowerMTUCeiling(size int) {
+	h.sendLock.Lock()
+	h.lowerMTUCeilingLocked(size)
+	h.sendLock.Unlock()
+}
+
+// lowerMTUCeilingLocked is like lowerMTUCeiling but requires that the sendLock is held.
+func (h *handler) lowerMTUCeilingLocked(size int) {
+	if p := &h.mtu; p.ceiling == 0 || size < p.ceiling {
+		p.ceiling = size
+	}
+}
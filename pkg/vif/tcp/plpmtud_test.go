@@ -0,0 +1,97 @@
+package tcp
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/ipproto"
+	"github.com/telepresenceio/telepresence/v2/pkg/tunnel"
+)
+
+func TestHandler_PathMTUProbeAcked(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+
+	p := newTestPeer(ctx, t, HandlerConfig{PathMTUDiscovery: true})
+	p.connect(ctx)
+	require.Equal(t, minMaxSegmentSizeV4, p.h.Stats().MaxSegmentSize)
+
+	// The first segment is a probe of the largest size, the rest use the minimum size.
+	limit := p.h.segmentSizeLimit()
+	p.stream.fromMgr <- tunnel.NewMessage(tunnel.Normal, bytes.Repeat([]byte{'x'}, 4*limit))
+	burst := p.recvBurst()
+	require.True(t, len(burst) > 1)
+	assert.Len(t, burst[0].Payload(), limit)
+	for _, seg := range burst[1:] {
+		assert.LessOrEqual(t, len(seg.Payload()), minMaxSegmentSizeV4)
+	}
+
+	p.ack = burst[0].Sequence() + uint32(limit)
+	p.send(ctx, false, true, false, nil)
+	require.Eventually(t, func() bool { return p.h.Stats().MaxSegmentSize == limit }, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestHandler_PathMTUProbeLost(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+
+	p := newTestPeer(ctx, t, HandlerConfig{PathMTUDiscovery: true})
+	p.connect(ctx)
+	limit := p.h.segmentSizeLimit()
+	p.stream.fromMgr <- tunnel.NewMessage(tunnel.Normal, bytes.Repeat([]byte{'x'}, limit))
+	burst := p.recvBurst()
+	require.Len(t, burst, 1)
+	probeSeq := burst[0].Sequence()
+	require.Len(t, burst[0].Payload(), limit)
+
+	// Pretend that the probe has been sent mtuProbeAttempts times without being acknowledged.
+	p.h.sendLock.Lock()
+	el := p.h.ackWaitQueue
+	require.NotNil(t, el)
+	require.True(t, el.probe)
+	el.retries = mtuProbeAttempts - 1
+	el.cTime = time.Now().Add(-time.Minute)
+	p.h.sendLock.Unlock()
+
+	// The probe's data is resent using segments of the minimum size.
+	resent := p.recvBurst()
+	require.Len(t, resent, (limit+minMaxSegmentSizeV4-1)/minMaxSegmentSizeV4)
+	seq := probeSeq
+	for _, seg := range resent {
+		assert.Equal(t, seq, seg.Sequence())
+		assert.LessOrEqual(t, len(seg.Payload()), minMaxSegmentSizeV4)
+		seq += uint32(len(seg.Payload()))
+	}
+	assert.Equal(t, probeSeq+uint32(limit), seq)
+	assert.Equal(t, minMaxSegmentSizeV4, p.h.Stats().MaxSegmentSize)
+
+	// The next probe is halfway between the size that passes and the size that failed.
+	p.h.sendLock.Lock()
+	next := p.h.mtuProbeSize(time.Now(), limit, limit)
+	p.h.sendLock.Unlock()
+	assert.Equal(t, minMaxSegmentSizeV4+(limit-minMaxSegmentSizeV4)/2, next)
+}
+
+func TestHandler_PathMTUSearchCompletes(t *testing.T) {
+	id := tunnel.NewConnID(ipproto.TCP, net.IP{10, 0, 0, 1}, net.IP{10, 0, 0, 2}, 4711, 80)
+	h := NewHandler(nil, new(int32), discardTun{}, id, "", func() {}, rand.NewSource(1), HandlerConfig{PathMTUDiscovery: true}).(*handler)
+	h.peerMaxSegmentSize = 1400
+	h.initPathMTUDiscovery()
+	h.mtu.ceiling = minMaxSegmentSizeV4 + mtuProbeGranularity
+
+	now := time.Now()
+	assert.Zero(t, h.mtuProbeSize(now, 1400, 1400))
+	assert.Equal(t, now.Add(mtuRaiseInterval), h.mtu.raiseAt)
+
+	// The search restarts when the raise interval has passed, and then goes for the largest size.
+	assert.Zero(t, h.mtuProbeSize(now.Add(time.Minute), 1400, 1400))
+	assert.Equal(t, 1400, h.mtuProbeSize(now.Add(mtuRaiseInterval), 1400, 1400))
+}
@@ -0,0 +1,224 @@
+package tcp
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+
+	"github.com/datawire/dlib/dlog"
+)
+
+// The smallest segment sizes that we're willing to use when the path MTU shrinks. These
+// are derived from the minimum MTU that every link must be able to handle (576 for IPv4
+// and 1280 for IPv6, see RFC 791 and RFC 8200).
+const (
+	minMaxSegmentSizeV4 = 576 - (ipv4.HeaderLen + HeaderLen)
+	minMaxSegmentSizeV6 = 1280 - (ipv6.HeaderLen + HeaderLen)
+)
+
+// maxSegmentSize returns the effective maximum segment size, i.e. the smallest of the size
+// announced by the peer, the size derived from the configured MTU, and the size imposed by
+// the path MTU.
+func (h *handler) maxSegmentSize() int {
+	mss := h.segmentSizeLimit()
+	if pmss := int(atomic.LoadInt32(&h.pathMaxSegmentSize)); pmss > 0 && pmss < mss {
+		mss = pmss
+	}
+	return mss
+}
+
+// segmentSizeLimit returns the smallest of the size announced by the peer and the size derived
+// from the configured MTU. The path MTU can never raise the maximum segment size above it.
+func (h *handler) segmentSizeLimit() int {
+	mss := int(h.peerMaxSegmentSize)
+	if lmss := int(atomic.LoadInt32(&h.localMaxSegmentSize)); lmss > 0 && lmss < mss {
+		mss = lmss
+	}
+	return mss
+}
+
+// HandlePacketTooBig lowers the effective maximum segment size so that it fits the given mtu
+// and then retransmits the segment starting at the given sequence using the new size. The
+// message is ignored unless the sequence is the start of a segment that hasn't been acknowledged,
+// so that a stale or forged message can't shrink the segment size (RFC 5927, section 4.1).
+func (h *handler) HandlePacketTooBig(ctx context.Context, mtu int, sequence uint32) {
+	mss := segmentSizeForMTU(mtu, len(h.id.Source()) != 4) - h.addedOptionsLen()
+
+	h.sendLock.Lock()
+	prev, el := h.findUnacked(sequence)
+	if el == nil {
+		h.sendLock.Unlock()
+		dlog.Debugf(ctx, "   CON %s, path MTU %d ignored, sequence %d is not in flight", h.name, mtu, sequence)
+		return
+	}
+	if h.cfg.PathMTUDiscovery {
+		h.lowerMTUCeilingLocked(mss + 1)
+	}
+	if mss >= h.maxSegmentSize() {
+		// A message for a segment that was sent before we lowered the size.
+		h.sendLock.Unlock()
+		return
+	}
+	dlog.Debugf(ctx, "   CON %s, path MTU %d, maximum segment size lowered to %d", h.name, mtu, mss)
+	atomic.StoreInt32(&h.pathMaxSegmentSize, int32(mss))
+	pkts := h.splitUnacked(prev, el, mss)
+	h.sendLock.Unlock()
+	h.writeSplit(ctx, pkts)
+}
+
+// addedOptionsLen returns the length of the options that are added to each segment when it's
+// written to the TUN device, i.e. the authentication option and the echoed options.
+func (h *handler) addedOptionsLen() int {
+	l := len(h.echoOptions)
+	if h.cfg.AuthOption != nil {
+		l += aoOptionLen
+	}
+	return l
+}
+
+// localSegmentSize returns the maximum segment size that the handler uses for the given mtu, or
+// for the MTU of the TUN device when mtu is zero. Room is left for the options that are added to
+// each segment.
+func (h *handler) localSegmentSize(mtu int) int {
+	mss := maxSegmentSize
+	if mtu > 0 {
+		if m := segmentSizeForMTU(mtu, len(h.id.Source()) != 4); m < mss {
+			mss = m
+		}
+	}
+	return mss - h.addedOptionsLen()
+}
+
+// HandleMTUChange recomputes the maximum segment size for the given mtu. Segments that have
+// already been sent are left as they are. A lost segment that no longer fits is split by
+// HandlePacketTooBig when the path reports it.
+func (h *handler) HandleMTUChange(ctx context.Context, mtu int) {
+	mss := h.localSegmentSize(mtu)
+	old := int(atomic.SwapInt32(&h.localMaxSegmentSize, int32(mss)))
+	if old == mss {
+		return
+	}
+	dlog.Debugf(ctx, "   CON %s, MTU changed to %d, maximum segment size is now %d", h.name, mtu, mss)
+	if mss < old && h.cfg.PathMTUDiscovery {
+		h.lowerMTUCeiling(mss + 1)
+	}
+}
+
+// segmentSizeForMTU returns the maximum segment size that fits the given mtu. It is never
+// smaller than the minimum segment size for the IP version.
+func segmentSizeForMTU(mtu int, ipv6Hdr bool) int {
+	ipHdrLen := ipv4.HeaderLen
+	minMSS := minMaxSegmentSizeV4
+	if ipv6Hdr {
+		ipHdrLen = ipv6.HeaderLen
+		minMSS = minMaxSegmentSizeV6
+	}
+	mss := mtu - (ipHdrLen + HeaderLen)
+	if mss < minMSS {
+		mss = minMSS
+	}
+	return mss
+}
+
+// resendSplit finds the unacked segment that starts at the given sequence, replaces it
+// in the ackWaitQueue with segments that are no larger than mss, and writes those segments
+// to the TUN device.
+func (h *handler) resendSplit(ctx context.Context, sequence uint32, mss int) {
+	var pkts []Packet
+	h.sendLock.Lock()
+	if prev, el := h.findUnacked(sequence); el != nil {
+		pkts = h.splitUnacked(prev, el, mss)
+	}
+	h.sendLock.Unlock()
+	h.writeSplit(ctx, pkts)
+}
+
+// findUnacked returns the element of the ackWaitQueue whose segment starts at the given
+// sequence, together with the element that precedes it. The element is nil when no such
+// segment is in flight. The sendLock must be held.
+func (h *handler) findUnacked(sequence uint32) (prev, el *queueElement) {
+	el = h.ackWaitQueue
+	for el != nil && el.packet.Header().Sequence() != sequence {
+		prev = el
+		el = el.next
+	}
+	return prev, el
+}
+
+// splitUnacked replaces the given element, which follows prev in the ackWaitQueue, with
+// segments that are no larger than mss, and returns copies of those segments that remain
+// valid when the lock is released, for writeSplit to write. The sendLock must be held.
+func (h *handler) splitUnacked(prev, el *queueElement, mss int) []Packet {
+	sequence := el.packet.Header().Sequence()
+	origHdr := el.packet.Header()
+	data := origHdr.Payload()
+	if len(data) <= mss {
+		return nil
+	}
+
+	ackNbr := h.peerSequenceToAck()
+	now := h.clock.Now()
+	var pkts []Packet
+	next := el.next
+	for start := 0; start < len(data); start += mss {
+		end := start + mss
+		if end > len(data) {
+			end = len(data)
+		}
+		pkt := h.newResponse(HeaderLen+end-start, true)
+		ipHdr := pkt.IPHeader()
+		tcpHdr := pkt.Header()
+		ipHdr.SetPayloadLen(HeaderLen + end - start)
+		ipHdr.SetChecksum()
+		copy(tcpHdr.Payload(), data[start:end])
+		tcpHdr.SetPSH(origHdr.PSH() && end == len(data))
+		tcpHdr.SetACK(true)
+		tcpHdr.SetSequence(sequence + uint32(start))
+		tcpHdr.SetAckNumber(ackNbr)
+		tcpHdr.SetChecksum(ipHdr)
+		pkts = append(pkts, pkt)
+
+		// The ackWaitQueue is sorted descending on sequence, so each new element is
+		// inserted in front of the previous one.
+		next = &queueElement{
+			sequence: sequence + uint32(end),
+			retries:  el.retries,
+			cTime:    now,
+			packet:   pkt,
+			next:     next,
+		}
+	}
+	if prev == nil {
+		h.ackWaitQueue = next
+	} else {
+		prev.next = next
+	}
+	h.ackWaitQueueSize += uint32(len(pkts) - 1)
+	h.releaseQueued(el.packet)
+	for i, pkt := range pkts {
+		pkts[i] = h.copySegment(pkt)
+	}
+	return pkts
+}
+
+// writeSplit writes the segments returned by splitUnacked to the TUN device and releases them.
+func (h *handler) writeSplit(ctx context.Context, pkts []Packet) {
+	for i, pkt := range pkts {
+		dlog.Debugf(ctx, "   CON %s resent after path MTU change", pkt)
+		atomic.AddUint64(&h.retransmittedBytes, uint64(len(pkt.Header().Payload())))
+		err := h.tunWrite(ctx, pkt)
+		pkt.Release()
+		if err != nil {
+			dlog.Errorf(ctx, "!! TUN %s: %v", h.name, err)
+			if errors.Is(err, errTunWriteTimeout) {
+				for _, unwritten := range pkts[i+1:] {
+					unwritten.Release()
+				}
+				return
+			}
+		}
+	}
+}
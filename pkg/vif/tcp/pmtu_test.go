@@ -0,0 +1,98 @@
+package tcp
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/ipproto"
+	"github.com/telepresenceio/telepresence/v2/pkg/tunnel"
+)
+
+func TestHandler_PacketTooBig(t *testing.T) {
+	tests := []struct {
+		name     string
+		src, dst net.IP
+		mtu      int
+		ipHdrLen int
+	}{
+		{"IPv4 fragmentation needed", net.IP{10, 0, 0, 1}, net.IP{10, 0, 0, 2}, 1000, 20},
+		{"IPv6 packet too big", net.ParseIP("fd00::1"), net.ParseIP("fd00::2"), 1300, 40},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+			defer cancel()
+			tun := make(testTun, 100)
+			id := tunnel.NewConnID(ipproto.TCP, tt.src, tt.dst, 4711, 80)
+			p := newTestPeerWithID(ctx, t, HandlerConfig{}, id, tun, tun)
+			p.connect(ctx)
+
+			mss := p.h.maxSegmentSize()
+			data := make([]byte, mss)
+			for i := range data {
+				data[i] = byte(i)
+			}
+			p.stream.fromMgr <- tunnel.NewMessage(tunnel.Normal, data)
+			seg := p.recv().Header()
+			require.Len(t, seg.Payload(), mss)
+			seq := seg.Sequence()
+
+			// The segment is resent in segments that fit the reported MTU.
+			lowered := tt.mtu - (tt.ipHdrLen + HeaderLen)
+			p.h.HandlePacketTooBig(ctx, tt.mtu, seq)
+			require.Equal(t, lowered, p.h.maxSegmentSize())
+			resent := p.recvBurst()
+			require.Len(t, resent, (mss+lowered-1)/lowered)
+			var got []byte
+			next := seq
+			for _, seg := range resent {
+				assert.Equal(t, next, seg.Sequence())
+				assert.LessOrEqual(t, len(seg.Payload()), lowered)
+				next += uint32(len(seg.Payload()))
+				got = append(got, seg.Payload()...)
+			}
+			assert.True(t, bytes.Equal(data, got))
+
+			// The split segments replace the original in the ackWaitQueue.
+			p.h.sendLock.Lock()
+			assert.Equal(t, uint32(len(resent)), p.h.ackWaitQueueSize)
+			p.h.sendLock.Unlock()
+		})
+	}
+}
+
+func TestHandler_PacketTooBigNotInFlight(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+	p := newTestPeer(ctx, t, HandlerConfig{})
+	p.connect(ctx)
+
+	mss := p.h.maxSegmentSize()
+	p.stream.fromMgr <- tunnel.NewMessage(tunnel.Normal, make([]byte, mss))
+	seg := p.recv().Header()
+	require.Len(t, seg.Payload(), mss)
+
+	// A message that quotes a sequence that doesn't start an unacknowledged segment is ignored.
+	p.h.HandlePacketTooBig(ctx, 1000, seg.Sequence()+1)
+	assert.Equal(t, mss, p.h.maxSegmentSize())
+	assert.Empty(t, p.recvBurst())
+
+	// So is a message that quotes a segment that has been acknowledged.
+	p.ack = seg.Sequence() + uint32(mss)
+	p.send(ctx, false, true, false, nil)
+	require.Eventually(t, func() bool {
+		p.h.sendLock.Lock()
+		defer p.h.sendLock.Unlock()
+		return p.h.ackWaitQueue == nil
+	}, 5*time.Second, time.Millisecond)
+	p.h.HandlePacketTooBig(ctx, 1000, seg.Sequence())
+	assert.Equal(t, mss, p.h.maxSegmentSize())
+	assert.Empty(t, p.recvBurst())
+}
@@ -0,0 +1,96 @@
+package tcp
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/tunnel"
+)
+
+// The delay before the first retry of a failed stream creation when HandlerConfig.StreamResumeTimeout
+// is set. It's doubled for each retry, up to streamResumeMaxDelay.
+const (
+	streamResumeInitialDelay = 100 * time.Millisecond
+	streamResumeMaxDelay     = 2 * time.Second
+)
+
+func (h *handler) getStream() tunnel.Stream {
+	h.streamLock.Lock()
+	s := h.stream
+	h.streamLock.Unlock()
+	return s
+}
+
+// startStreamWriter starts a loop that writes the toMgrMsgCh to the current stream. The loop is
+// stopped using the stopStreamWriter. The streamLock must be held.
+func (h *handler) startStreamWriter(ctx context.Context) {
+	ctx, h.stopStreamWriter = context.WithCancel(ctx)
+	tunnel.WriteLoop(ctx, h.stream, h.toMgrMsgCh)
+}
+
+// resumable returns true if the connection should survive the loss of its stream.
+func (h *handler) resumable() bool {
+	return h.cfg.ResumeOnStreamLoss && h.state() == stateEstablished
+}
+
+// resumeStream replaces a lost stream with a new one. The peer is told to stop sending using a
+// zero window while the new stream is created, and the window is reopened once it's in place. The
+// new stream is returned, or nil if it couldn't be created.
+func (h *handler) resumeStream(ctx context.Context) tunnel.Stream {
+	dlog.Debugf(ctx, "   CON %s, stream to traffic-manager lost, resuming", h.name)
+	atomic.StoreInt32(&h.streamPaused, 1)
+	h.setReceiveWindow(0)
+	h.forceSendAck(ctx)
+
+	s := h.createResumedStream(ctx)
+	if s == nil {
+		return nil
+	}
+	h.streamLock.Lock()
+	h.stream = s
+	if h.stopStreamWriter != nil {
+		// Stopping the old writer makes it close the sending side of the lost stream.
+		h.stopStreamWriter()
+		h.startStreamWriter(ctx)
+	}
+	h.streamLock.Unlock()
+	atomic.AddUint64(&h.streamResumes, 1)
+
+	atomic.StoreInt32(&h.streamPaused, 0)
+	h.adjustReceiveWindow()
+	h.forceSendAck(ctx)
+	dlog.Debugf(ctx, "   CON %s, stream to traffic-manager resumed", h.name)
+	return s
+}
+
+// createResumedStream creates the stream that replaces a lost one. A failed creation is retried
+// with an increasing delay until the HandlerConfig.StreamResumeTimeout has passed, so that the
+// connection survives a blip that makes the traffic-manager briefly unreachable. Nil is returned
+// when no stream could be created.
+func (h *handler) createResumedStream(ctx context.Context) tunnel.Stream {
+	deadline := h.clock.Now().Add(h.cfg.StreamResumeTimeout)
+	delay := streamResumeInitialDelay
+	for {
+		s, err := h.streamCreator(ctx)
+		if err == nil {
+			return s
+		}
+		if h.clock.Now().Add(delay).After(deadline) {
+			dlog.Errorf(ctx, "!! CON %s, unable to resume stream to traffic-manager: %v", h.name, err)
+			return nil
+		}
+		dlog.Debugf(ctx, "   CON %s, unable to resume stream to traffic-manager, retrying in %s: %v", h.name, delay, err)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-h.tunDone:
+			return nil
+		case <-h.clock.After(delay):
+		}
+		if delay *= 2; delay > streamResumeMaxDelay {
+			delay = streamResumeMaxDelay
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package tcp
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/tunnel"
+)
+
+func TestHandler_ResumeOnStreamLoss(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+	p := newTestPeer(ctx, t, HandlerConfig{ResumeOnStreamLoss: true})
+	p.connect(ctx)
+
+	resumed := newTestStream(p.id)
+	p.h.streamCreator = func(context.Context) (tunnel.Stream, error) { return resumed, nil }
+	p.stream.breakStream()
+
+	// The peer is paused using a zero window and then resumed using a window update
+	pause := p.recv().Header()
+	require.True(t, pause.ACK())
+	assert.Zero(t, pause.WindowSize())
+	resume := p.recv().Header()
+	require.True(t, resume.ACK())
+	assert.NotZero(t, resume.WindowSize())
+	assert.Equal(t, pause.Sequence(), resume.Sequence())
+	assert.Equal(t, stateEstablished, p.h.state())
+
+	// Data flows in both directions using the new stream
+	p.sendWithPSH(ctx, false, true, false, true, []byte("hello"))
+	select {
+	case m := <-resumed.toMgr:
+		assert.Equal(t, []byte("hello"), m.Payload())
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for data to manager")
+	}
+	resumed.fromMgr <- tunnel.NewMessage(tunnel.Normal, []byte("world"))
+	for {
+		pkt := p.recv().Header()
+		if len(pkt.Payload()) > 0 {
+			assert.Equal(t, []byte("world"), pkt.Payload())
+			assert.Equal(t, p.ack, pkt.Sequence())
+			break
+		}
+	}
+	assert.Equal(t, uint64(1), p.h.Stats().StreamResumes)
+}
+
+func TestHandler_ResumeOnStreamLossFailure(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+	p := newTestPeer(ctx, t, HandlerConfig{ResumeOnStreamLoss: true})
+	p.connect(ctx)
+
+	p.h.streamCreator = func(context.Context) (tunnel.Stream, error) { return nil, errors.New("no manager") }
+	p.stream.breakStream()
+
+	// The peer is paused, and then the connection is reset
+	pause := p.recv().Header()
+	assert.Zero(t, pause.WindowSize())
+	assert.True(t, p.recv().Header().RST())
+	assert.Zero(t, p.h.Stats().StreamResumes)
+}
+
+func TestHandler_ResumeOnStreamLossRetry(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+	p := newTestPeer(ctx, t, HandlerConfig{ResumeOnStreamLoss: true, StreamResumeTimeout: 5 * time.Second})
+	p.connect(ctx)
+
+	// The first two attempts to create a new stream fail.
+	var attempts int32
+	resumed := newTestStream(p.id)
+	p.h.streamCreator = func(context.Context) (tunnel.Stream, error) {
+		if atomic.AddInt32(&attempts, 1) <= 2 {
+			return nil, errors.New("manager unavailable")
+		}
+		return resumed, nil
+	}
+	p.stream.breakStream()
+
+	pause := p.recv().Header()
+	assert.Zero(t, pause.WindowSize())
+	resume := p.recv().Header()
+	assert.False(t, resume.RST())
+	assert.NotZero(t, resume.WindowSize())
+	assert.Equal(t, int32(3), atomic.LoadInt32(&attempts))
+	assert.Equal(t, uint64(1), p.h.Stats().StreamResumes)
+
+	p.sendWithPSH(ctx, false, true, false, true, []byte("hello"))
+	select {
+	case m := <-resumed.toMgr:
+		assert.Equal(t, []byte("hello"), m.Payload())
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for data to manager")
+	}
+}
@@ -0,0 +1,124 @@
+package tcp
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/tunnel"
+)
+
+// StallWatchdog detects handlers whose packet processing has stopped making progress while
+// packets are waiting for it, e.g. because it's deadlocked, and tears them down so that they
+// don't linger in the pool forever.
+type StallWatchdog struct {
+	sync.Mutex
+	timeout  time.Duration
+	onStall  func(ctx context.Context, id tunnel.ConnID)
+	handlers map[*handler]*progress
+}
+
+// progress is the last observed heartbeat of a handler and the time when it was first observed.
+type progress struct {
+	heartbeat uint64
+	since     time.Time
+}
+
+type stallWatchdogKey struct{}
+
+// NewStallWatchdog creates a watchdog that tears down handlers that have had packets waiting
+// without processing any of them for more than the given timeout, and then calls onStall.
+func NewStallWatchdog(timeout time.Duration, onStall func(ctx context.Context, id tunnel.ConnID)) *StallWatchdog {
+	return &StallWatchdog{
+		timeout:  timeout,
+		onStall:  onStall,
+		handlers: make(map[*handler]*progress),
+	}
+}
+
+// WithStallWatchdog returns a context with the given StallWatchdog. Handlers that are started
+// using that context will be watched by it.
+func WithStallWatchdog(ctx context.Context, wd *StallWatchdog) context.Context {
+	return context.WithValue(ctx, stallWatchdogKey{}, wd)
+}
+
+func getStallWatchdog(ctx context.Context) *StallWatchdog {
+	wd, ok := ctx.Value(stallWatchdogKey{}).(*StallWatchdog)
+	if !ok {
+		return nil
+	}
+	return wd
+}
+
+// Run performs a sweep at regular intervals until the given context is done.
+func (w *StallWatchdog) Run(ctx context.Context) error {
+	ticker := time.NewTicker(w.timeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			w.sweep(ctx)
+		}
+	}
+}
+
+// sweep tears down the handlers that have had packets waiting during the whole timeout without
+// making progress. A handler that is torn down is no longer watched.
+func (w *StallWatchdog) sweep(ctx context.Context) {
+	var stalled []*handler
+	now := time.Now()
+	w.Lock()
+	for h, p := range w.handlers {
+		hb := h.heartbeat()
+		if hb != p.heartbeat || len(h.fromTun) == 0 {
+			p.heartbeat = hb
+			p.since = now
+			continue
+		}
+		if now.Sub(p.since) >= w.timeout {
+			delete(w.handlers, h)
+			stalled = append(stalled, h)
+		}
+	}
+	w.Unlock()
+
+	for _, h := range stalled {
+		dlog.Warnf(ctx, "!! CON %s, no packets processed in %s while %d batches are waiting, tearing it down",
+			h.name, w.timeout, len(h.fromTun))
+		h.cancel()
+		h.remove()
+		if w.onStall != nil {
+			w.onStall(ctx, h.id)
+		}
+	}
+}
+
+// watch starts watching the given handler. It's a no-op when w is nil.
+func (w *StallWatchdog) watch(h *handler) {
+	if w == nil {
+		return
+	}
+	w.Lock()
+	w.handlers[h] = &progress{heartbeat: h.heartbeat(), since: time.Now()}
+	w.Unlock()
+}
+
+// unwatch stops watching the given handler. It's a no-op when w is nil.
+func (w *StallWatchdog) unwatch(h *handler) {
+	if w == nil {
+		return
+	}
+	w.Lock()
+	delete(w.handlers, h)
+	w.Unlock()
+}
+
+// heartbeat returns a counter that increases each time the handler takes a packet from its
+// input for processing.
+func (h *handler) heartbeat() uint64 {
+	return atomic.LoadUint64(&h.segmentsReceived)
+}
@@ -0,0 +1,67 @@
+package tcp
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/tunnel"
+)
+
+func TestStallWatchdog(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+	const timeout = 50 * time.Millisecond
+
+	stalls := make(chan tunnel.ConnID, 1)
+	wd := NewStallWatchdog(timeout, func(_ context.Context, id tunnel.ConnID) { stalls <- id })
+	ctx = WithStallWatchdog(ctx, wd)
+	p := newTestPeer(ctx, t, HandlerConfig{})
+	p.connect(ctx)
+
+	// An idle handler is left alone
+	wd.sweep(ctx)
+	time.Sleep(2 * timeout)
+	wd.sweep(ctx)
+	assert.Empty(t, stalls)
+
+	// Wedge the handler on its send lock while it processes a segment, and queue another one
+	p.h.sendLock.Lock()
+	unlocked := false
+	unlock := func() {
+		if !unlocked {
+			unlocked = true
+			p.h.sendLock.Unlock()
+		}
+	}
+	defer unlock()
+	hb := p.h.heartbeat()
+	p.send(ctx, false, true, false, []byte("hello"))
+	require.Eventually(t, func() bool { return p.h.heartbeat() > hb }, 5*time.Second, time.Millisecond)
+	p.send(ctx, false, true, false, []byte("world"))
+	require.Eventually(t, func() bool { return len(p.h.fromTun) > 0 }, 5*time.Second, time.Millisecond)
+
+	wd.sweep(ctx)
+	assert.Empty(t, stalls, "a stall must last for the whole timeout")
+	time.Sleep(2 * timeout)
+	wd.sweep(ctx)
+	select {
+	case id := <-stalls:
+		assert.Equal(t, p.id, id)
+	default:
+		t.Fatal("stalled handler was not detected")
+	}
+
+	// A handler that has been torn down is not reported again
+	time.Sleep(2 * timeout)
+	wd.sweep(ctx)
+	assert.Empty(t, stalls)
+
+	// The stalled handler was cancelled, so it terminates once it's unwedged
+	unlock()
+	require.Eventually(t, func() bool { return p.h.state() == stateIdle }, 5*time.Second, time.Millisecond)
+}
@@ -0,0 +1,247 @@
+package tcp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/vif/buffer"
+	"github.com/telepresenceio/telepresence/v2/pkg/vif/ip"
+)
+
+// handlerStateVersion is the version of the format produced by MarshalState. It must be
+// incremented whenever a change is made to handlerState that older versions can't read.
+const handlerStateVersion = 2
+
+// handlerState is the serialized form of a handler's state.
+type handlerState struct {
+	Version            int            `json:"version"`
+	Sequence           uint32         `json:"sequence"`
+	SequenceAcked      uint32         `json:"sequenceAcked"`
+	LastKnown          uint32         `json:"lastKnown"`
+	PeerSequenceToAck  uint32         `json:"peerSequenceToAck"`
+	PeerSequenceAcked  uint32         `json:"peerSequenceAcked"`
+	ReceiveWindow      int64          `json:"receiveWindow"`
+	PeerWindow         int64          `json:"peerWindow"`
+	PeerWindowScale    uint8          `json:"peerWindowScale"`
+	NoWindowScaling    bool           `json:"noWindowScaling,omitempty"`
+	PeerMaxSegmentSize uint16         `json:"peerMaxSegmentSize"`
+	PeerPermitsSACK    bool           `json:"peerPermitsSACK,omitempty"`
+	PathMaxSegmentSize int32          `json:"pathMaxSegmentSize,omitempty"`
+	AckWaitQueue       []queuedPacket `json:"ackWaitQueue,omitempty"`
+	OutOfOrderQueue    []queuedPacket `json:"outOfOrderQueue,omitempty"`
+	Auth               *authState     `json:"auth,omitempty"`
+}
+
+// authState is the serialized form of the aoConn of a connection that uses the TCP Authentication
+// Option. The traffic keys aren't included. They are derived again from the master key.
+type authState struct {
+	LocalISN  uint32   `json:"localISN"`
+	RemoteISN uint32   `json:"remoteISN"`
+	SendSNE   sneState `json:"sendSNE"`
+	RecvSNE   sneState `json:"recvSNE"`
+}
+
+// sneState is the serialized form of a sneTracker.
+type sneState struct {
+	Valid bool   `json:"valid,omitempty"`
+	High  uint32 `json:"high,omitempty"`
+	Last  uint32 `json:"last,omitempty"`
+}
+
+// queuedPacket is the serialized form of a queueElement.
+type queuedPacket struct {
+	Sequence uint32 `json:"sequence"`
+	Retries  int32  `json:"retries,omitempty"`
+	Packet   []byte `json:"packet"`
+}
+
+// MarshalState returns a versioned snapshot of the state of an ESTABLISHED connection, suitable
+// for handing over the connection to a handler in another process using RestoreState. The
+// out-of-order queue is owned by the goroutine that processes packets from the TUN device, so
+// the snapshot is consistent only when no packets are received while it's taken.
+func (h *handler) MarshalState() ([]byte, error) {
+	if s := h.state(); s != stateEstablished {
+		return nil, fmt.Errorf("unable to marshal state of connection %s in state %s", h.name, s)
+	}
+	h.sendLock.Lock()
+	hs := handlerState{
+		Version:            handlerStateVersion,
+		Sequence:           h.sequence(),
+		SequenceAcked:      h.seqAcked,
+		LastKnown:          h.lastKnown,
+		PeerSequenceToAck:  h.peerSequenceToAck(),
+		PeerSequenceAcked:  h.peerSequenceAcked(),
+		ReceiveWindow:      int64(h.receiveWindow()),
+		PeerWindow:         atomic.LoadInt64(&h.peerWindow),
+		PeerWindowScale:    h.peerWindowScale,
+		NoWindowScaling:    !h.windowScaling,
+		PeerMaxSegmentSize: h.peerMaxSegmentSize,
+		PeerPermitsSACK:    atomic.LoadInt32(&h.peerPermitsSACK) != 0,
+		PathMaxSegmentSize: atomic.LoadInt32(&h.pathMaxSegmentSize),
+		AckWaitQueue:       marshalQueue(h.ackWaitQueue),
+		OutOfOrderQueue:    marshalQueue(h.oooQueue),
+		Auth:               marshalAuth(h.ao),
+	}
+	h.sendLock.Unlock()
+	return json.Marshal(&hs)
+}
+
+// RestoreState restores a state produced by MarshalState. It must be called before the handler
+// is started. A handler with a restored state will open a new stream to the traffic-manager when
+// it's started and then continue the connection in the ESTABLISHED state.
+func (h *handler) RestoreState(data []byte) error {
+	if s := h.state(); s != stateIdle {
+		return fmt.Errorf("unable to restore state of connection %s in state %s", h.name, s)
+	}
+	var hs handlerState
+	if err := json.Unmarshal(data, &hs); err != nil {
+		return fmt.Errorf("unable to unmarshal state of connection %s: %w", h.name, err)
+	}
+	if hs.Version != handlerStateVersion {
+		return fmt.Errorf("unable to restore state of connection %s: unsupported version %d", h.name, hs.Version)
+	}
+	if (hs.Auth == nil) != (h.cfg.AuthOption == nil) {
+		return fmt.Errorf("unable to restore state of connection %s: use of the authentication option doesn't match", h.name)
+	}
+	ackWaitQueue, err := unmarshalQueue(hs.AckWaitQueue, h.clock.Now())
+	if err != nil {
+		return err
+	}
+	oooQueue, err := unmarshalQueue(hs.OutOfOrderQueue, h.clock.Now())
+	if err != nil {
+		releaseQueue(ackWaitQueue)
+		return err
+	}
+
+	h.sendLock.Lock()
+	h.setSequence(hs.Sequence)
+	atomic.StoreUint32(&h.seqAcked, hs.SequenceAcked)
+	h.lastKnown = hs.LastKnown
+	h.setPeerSequenceToAck(hs.PeerSequenceToAck)
+	h.setPeerSequenceAcked(hs.PeerSequenceAcked)
+	h.setReceiveWindow(int(hs.ReceiveWindow))
+	atomic.StoreInt64(&h.peerWindow, hs.PeerWindow)
+	h.peerWindowScale = hs.PeerWindowScale
+	h.windowScaling = !hs.NoWindowScaling
+	h.peerMaxSegmentSize = hs.PeerMaxSegmentSize
+	if hs.PeerPermitsSACK {
+		atomic.StoreInt32(&h.peerPermitsSACK, 1)
+	}
+	atomic.StoreInt32(&h.negotiated, 1)
+	atomic.StoreInt32(&h.pathMaxSegmentSize, hs.PathMaxSegmentSize)
+	if a := hs.Auth; a != nil {
+		h.ao = newAOConn(h.cfg.AuthOption, h.id.Destination(), h.id.Source(), h.id.DestinationPort(), h.id.SourcePort(), a.LocalISN, a.RemoteISN)
+		h.ao.sendSNE = a.SendSNE.tracker()
+		h.ao.recvSNE = a.RecvSNE.tracker()
+	}
+	h.ackWaitQueue = ackWaitQueue
+	h.ackWaitQueueSize = uint32(len(hs.AckWaitQueue))
+	h.oooQueue = oooQueue
+	atomic.StoreInt32(&h.oooQueueLen, int32(len(hs.OutOfOrderQueue)))
+	h.account(queuePayloadLen(ackWaitQueue) + queuePayloadLen(oooQueue))
+	h.sendLock.Unlock()
+	atomic.StoreInt32((*int32)(&h.wfState), int32(stateEstablished))
+	return nil
+}
+
+// adopt opens a stream to the traffic-manager for a connection that was restored using
+// RestoreState and starts the goroutines that an ESTABLISHED connection needs.
+func (h *handler) adopt(ctx context.Context) error {
+	dlog.Debugf(ctx, "   CON %s, adopting restored connection", h.name)
+	h.initCongestionWindow()
+	var err error
+	if h.stream, err = h.streamCreator(ctx); err != nil {
+		return err
+	}
+	h.goTracked(ctx, "readFromMgrLoop", h.readFromMgrLoop)
+	h.goTracked(ctx, "writeToMgrLoop", h.writeToMgrLoop)
+	return nil
+}
+
+// marshalAuth returns the serialized form of the given aoConn, or nil when it's nil. The receive
+// SNE is owned by the goroutine that processes packets from the TUN device, just like the
+// out-of-order queue.
+func marshalAuth(a *aoConn) *authState {
+	if a == nil {
+		return nil
+	}
+	a.sendLock.Lock()
+	sendSNE := marshalSNE(a.sendSNE)
+	a.sendLock.Unlock()
+	return &authState{
+		LocalISN:  a.localISN,
+		RemoteISN: a.remoteISN,
+		SendSNE:   sendSNE,
+		RecvSNE:   marshalSNE(a.recvSNE),
+	}
+}
+
+func marshalSNE(s sneTracker) sneState {
+	return sneState{Valid: s.valid, High: s.high, Last: s.last}
+}
+
+func (s sneState) tracker() sneTracker {
+	return sneTracker{valid: s.Valid, high: s.High, last: s.Last}
+}
+
+func marshalQueue(el *queueElement) []queuedPacket {
+	var qps []queuedPacket
+	for ; el != nil; el = el.next {
+		ipHdr := el.packet.IPHeader()
+		qps = append(qps, queuedPacket{
+			Sequence: el.sequence,
+			Retries:  el.retries,
+			Packet:   ipHdr.Packet()[:ipHdr.HeaderLen()+ipHdr.PayloadLen()],
+		})
+	}
+	return qps
+}
+
+func unmarshalQueue(qps []queuedPacket, now time.Time) (*queueElement, error) {
+	var first, last *queueElement
+	for _, qp := range qps {
+		data := buffer.DataPool.Get(len(qp.Packet))
+		copy(data.Buf(), qp.Packet)
+		ipHdr, err := ip.ParseHeader(data.Buf())
+		if err == nil && len(ipHdr.Payload()) < HeaderLen {
+			err = errors.New("packet is too short")
+		}
+		if err != nil {
+			buffer.DataPool.Put(data)
+			releaseQueue(first)
+			return nil, fmt.Errorf("unable to restore queued packet: %w", err)
+		}
+		el := &queueElement{
+			sequence: qp.Sequence,
+			retries:  qp.Retries,
+			cTime:    now,
+			packet:   PacketFromData(ipHdr, data),
+		}
+		if last == nil {
+			first = el
+		} else {
+			last.next = el
+		}
+		last = el
+	}
+	return first, nil
+}
+
+func queuePayloadLen(el *queueElement) int {
+	n := 0
+	for ; el != nil; el = el.next {
+		n += len(el.packet.Header().Payload())
+	}
+	return n
+}
+
+func releaseQueue(el *queueElement) {
+	for ; el != nil; el = el.next {
+		el.packet.Release()
+	}
+}
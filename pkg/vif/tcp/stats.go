@@ -0,0 +1,163 @@
+package tcp
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Stats contains properties and counters of a TCP handler.
+type Stats struct {
+	// PeerPermitsSACK is true when the peer's SYN contained the "SACK permitted" option. The
+	// handler doesn't use selective acknowledgments, so all retransmits are timer driven
+	// regardless of this setting.
+	PeerPermitsSACK bool
+
+	// TimerRetransmits is the number of segments that were retransmitted because the
+	// resend timer expired before they were acknowledged.
+	TimerRetransmits uint64
+
+	// BufferedBytes is the number of payload bytes that the handler keeps in its queues, waiting
+	// to be acknowledged by the peer or to be processed in order. These are the bytes that the
+	// handler accounts in its MemoryBudget.
+	BufferedBytes int64
+
+	// BytesToManager is the number of payload bytes that were delivered to the traffic-manager.
+	BytesToManager uint64
+
+	// BytesToTun is the number of payload bytes that were sent to the peer. Retransmits are not
+	// included, so this is the number of application bytes sent.
+	BytesToTun uint64
+
+	// RetransmittedBytes is the number of payload bytes that were retransmitted to the peer.
+	RetransmittedBytes uint64
+
+	// SegmentsSent is the number of segments that were written to the TUN device, including
+	// acknowledgments and retransmits.
+	SegmentsSent uint64
+
+	// SegmentsReceived is the number of segments that were read from the TUN device.
+	SegmentsReceived uint64
+
+	// OutOfWindow is the number of received segments that were discarded because their sequence
+	// was far outside the receive window.
+	OutOfWindow uint64
+
+	// AuthFailures is the number of received segments that were discarded because they failed
+	// verification of the TCP Authentication Option.
+	AuthFailures uint64
+
+	// InputDropped is the number of received segments that were dropped because the handler's
+	// input buffer was full and the InputOverflow policy is InputOverflowDrop.
+	InputDropped uint64
+
+	// ManagerDropped is the number of received segments that were dropped because the queue to
+	// the traffic-manager remained full, i.e. the traffic-manager side didn't keep up. The peer
+	// retransmits them once the handler has reopened its receive window.
+	ManagerDropped uint64
+
+	// TunWriteRetries is the number of writes to the TUN device that were retried because they
+	// failed with a retriable error.
+	TunWriteRetries uint64
+
+	// OutOfOrderQueueLength is the number of segments that are waiting for the segments before
+	// them to arrive.
+	OutOfOrderQueueLength int
+
+	// MaxOutOfOrderQueueLength is the configured maximum of the OutOfOrderQueueLength. Zero means
+	// no limit. See HandlerConfig.MaxOutOfOrderQueueLength.
+	MaxOutOfOrderQueueLength int
+
+	// OutOfOrderDropped is the number of out-of-order segments that were dropped because the
+	// out-of-order queue was full.
+	OutOfOrderDropped uint64
+
+	// MaxSegmentSize is the effective maximum segment size that is used when sending to the peer.
+	// It reflects the path MTU that has been discovered using ICMP or probes.
+	MaxSegmentSize int
+
+	// ChaosDropped is the number of segments to the peer that were dropped by the chaos mode. See
+	// HandlerConfig.Chaos.
+	ChaosDropped uint64
+
+	// StreamResumes is the number of times that the stream to the traffic-manager was lost and
+	// successfully replaced. See HandlerConfig.ResumeOnStreamLoss.
+	StreamResumes uint64
+
+	// ZeroWindowStalls is the number of times that sending to the peer had to wait because the
+	// peer's window was full, i.e. the peer's application didn't read fast enough.
+	ZeroWindowStalls uint64
+
+	// ZeroWindowTime is the total time spent waiting for the peer's window to open.
+	ZeroWindowTime time.Duration
+
+	// Age is the time that has passed since the handler was created.
+	Age time.Duration
+
+	// Idle is the time that has passed since a segment was last received from or sent to the peer.
+	Idle time.Duration
+}
+
+// Stats returns a snapshot of the handler's properties and counters.
+func (h *handler) Stats() Stats {
+	now := h.clock.Now()
+	return Stats{
+		PeerPermitsSACK:          atomic.LoadInt32(&h.peerPermitsSACK) != 0,
+		TimerRetransmits:         atomic.LoadUint64(&h.timerRetransmits),
+		BufferedBytes:            atomic.LoadInt64(&h.bufferedBytes),
+		BytesToManager:           atomic.LoadUint64(&h.bytesToMgr),
+		BytesToTun:               atomic.LoadUint64(&h.bytesToTun),
+		RetransmittedBytes:       atomic.LoadUint64(&h.retransmittedBytes),
+		SegmentsSent:             atomic.LoadUint64(&h.segmentsSent),
+		SegmentsReceived:         atomic.LoadUint64(&h.segmentsReceived),
+		OutOfWindow:              atomic.LoadUint64(&h.outOfWindow),
+		AuthFailures:             atomic.LoadUint64(&h.authFailures),
+		InputDropped:             atomic.LoadUint64(&h.inputDropped),
+		ManagerDropped:           atomic.LoadUint64(&h.mgrDropped),
+		TunWriteRetries:          atomic.LoadUint64(&h.tunWriteRetries),
+		StreamResumes:            atomic.LoadUint64(&h.streamResumes),
+		OutOfOrderQueueLength:    int(atomic.LoadInt32(&h.oooQueueLen)),
+		MaxOutOfOrderQueueLength: h.cfg.MaxOutOfOrderQueueLength,
+		OutOfOrderDropped:        atomic.LoadUint64(&h.outOfOrderDropped),
+		MaxSegmentSize:           h.maxSegmentSize(),
+		ChaosDropped:             h.chaos.droppedCount(),
+		ZeroWindowStalls:         atomic.LoadUint64(&h.zeroWindowStalls),
+		ZeroWindowTime:           time.Duration(atomic.LoadInt64(&h.zeroWindowTime)),
+		Age:                      now.Sub(h.createdAt),
+		Idle:                     now.Sub(time.Unix(0, atomic.LoadInt64(&h.lastActivity))),
+	}
+}
+
+// NegotiatedParams are the connection parameters that were negotiated using the options of the
+// peer's SYN and the handler's SYN-ACK.
+type NegotiatedParams struct {
+	// PeerMSS is the maximum segment size that the peer announced, or zero if it didn't.
+	PeerMSS uint16
+
+	// MyWindowScale is the number of bits that the windows advertised by the handler are shifted
+	// by, and PeerWindowScale is the same for the windows advertised by the peer. Both are zero
+	// unless the peer offered window scaling.
+	MyWindowScale   uint8
+	PeerWindowScale uint8
+
+	// SACKPermitted is true when both sides permitted selective acknowledgments. The handler
+	// never includes the "SACK permitted" option in its SYN-ACK, so it's always false. Whether
+	// the peer offered it is reported by Stats.PeerPermitsSACK.
+	SACKPermitted bool
+
+	// TimestampsEnabled is true when the Timestamps option (RFC 7323) is in effect. The handler
+	// doesn't implement that option, so it's always false.
+	TimestampsEnabled bool
+}
+
+// NegotiatedParams returns the parameters that were negotiated during the handshake. They are
+// all zero until the handler has processed the peer's SYN.
+func (h *handler) NegotiatedParams() NegotiatedParams {
+	if atomic.LoadInt32(&h.negotiated) == 0 {
+		return NegotiatedParams{}
+	}
+	return NegotiatedParams{
+		PeerMSS:         h.peerMaxSegmentSize,
+		MyWindowScale:   h.myWindowShift(),
+		PeerWindowScale: h.peerWindowScale,
+	}
+}
@@ -0,0 +1,167 @@
+package tcp
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/tunnel"
+)
+
+// StreamCreatorMiddleware wraps a StreamCreator in order to add a policy, such as retries, to it.
+type StreamCreatorMiddleware func(StreamCreator) StreamCreator
+
+// ChainStreamCreator returns a StreamCreatorMiddleware that applies the given middlewares in order,
+// so that the first one becomes the outermost wrapper of the StreamCreator.
+func ChainStreamCreator(mws ...StreamCreatorMiddleware) StreamCreatorMiddleware {
+	return func(sc StreamCreator) StreamCreator {
+		for i := len(mws) - 1; i >= 0; i-- {
+			sc = mws[i](sc)
+		}
+		return sc
+	}
+}
+
+// RetryStreamCreator returns a StreamCreatorMiddleware that makes at most attempts attempts to create
+// a stream. The delay before the first retry is backoff, and it's doubled for each retry after that.
+func RetryStreamCreator(attempts int, backoff time.Duration) StreamCreatorMiddleware {
+	return func(sc StreamCreator) StreamCreator {
+		return func(ctx context.Context) (tunnel.Stream, error) {
+			delay := backoff
+			for attempt := 1; ; attempt++ {
+				s, err := sc(ctx)
+				if err == nil || attempt >= attempts || errors.Is(err, ErrCircuitOpen) {
+					return s, err
+				}
+				dlog.Debugf(ctx, "stream creation attempt %d failed, retrying in %s: %v", attempt, delay, err)
+				select {
+				case <-ctx.Done():
+					return nil, err
+				case <-time.After(delay):
+				}
+				delay *= 2
+			}
+		}
+	}
+}
+
+// ErrCircuitOpen is returned by a StreamCreator that is wrapped by a CircuitBreaker while the
+// circuit is open.
+var ErrCircuitOpen = errors.New("stream creation circuit is open")
+
+// CircuitState is the state of a CircuitBreaker.
+type CircuitState int
+
+const (
+	// CircuitClosed means that streams are created as usual.
+	CircuitClosed = CircuitState(iota)
+
+	// CircuitOpen means that stream creation fails immediately, so that new connections are reset
+	// without delay.
+	CircuitOpen
+
+	// CircuitHalfOpen means that the cooldown has elapsed and that one trial stream creation is
+	// allowed to determine whether the circuit can be closed again.
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	default:
+		return "half-open"
+	}
+}
+
+// CircuitBreaker short-circuits stream creation once a number of consecutive attempts have failed.
+// It's shared by the StreamCreators of all connections that it wraps, so that an outage detected by
+// some connections makes new connections fail fast.
+type CircuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+
+	sync.Mutex
+	state    CircuitState
+	failures int
+	openedAt time.Time
+	trialing bool
+}
+
+// NewCircuitBreaker returns a CircuitBreaker that opens after threshold consecutive failures and
+// allows a trial stream creation when it has been open for the duration of cooldown.
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{threshold: threshold, cooldown: cooldown, now: time.Now}
+}
+
+// State returns the current state of the circuit.
+func (cb *CircuitBreaker) State() CircuitState {
+	cb.Lock()
+	defer cb.Unlock()
+	if cb.state == CircuitOpen && cb.now().Sub(cb.openedAt) >= cb.cooldown {
+		return CircuitHalfOpen
+	}
+	return cb.state
+}
+
+// Middleware is a StreamCreatorMiddleware that applies the circuit breaker to a StreamCreator.
+func (cb *CircuitBreaker) Middleware(sc StreamCreator) StreamCreator {
+	return func(ctx context.Context) (tunnel.Stream, error) {
+		if !cb.allow() {
+			return nil, ErrCircuitOpen
+		}
+		s, err := sc(ctx)
+		cb.done(ctx, err)
+		return s, err
+	}
+}
+
+// allow returns true if a stream may be created.
+func (cb *CircuitBreaker) allow() bool {
+	cb.Lock()
+	defer cb.Unlock()
+	switch cb.state {
+	case CircuitOpen:
+		if cb.now().Sub(cb.openedAt) < cb.cooldown {
+			return false
+		}
+		cb.state = CircuitHalfOpen
+		fallthrough
+	case CircuitHalfOpen:
+		if cb.trialing {
+			// Only one trial at a time
+			return false
+		}
+		cb.trialing = true
+	}
+	return true
+}
+
+// done records the outcome of a stream creation.
+func (cb *CircuitBreaker) done(ctx context.Context, err error) {
+	cb.Lock()
+	defer cb.Unlock()
+	trial := cb.trialing
+	cb.trialing = false
+	if err == nil {
+		if cb.state != CircuitClosed {
+			dlog.Info(ctx, "stream creation succeeded, closing circuit")
+		}
+		cb.state = CircuitClosed
+		cb.failures = 0
+		return
+	}
+	cb.failures++
+	if trial || cb.failures >= cb.threshold {
+		if cb.state != CircuitOpen {
+			dlog.Errorf(ctx, "stream creation failed %d times, opening circuit for %s: %v", cb.failures, cb.cooldown, err)
+		}
+		cb.state = CircuitOpen
+		cb.openedAt = cb.now()
+	}
+}
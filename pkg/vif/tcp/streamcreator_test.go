@@ -0,0 +1,147 @@
+package tcp
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/tunnel"
+)
+
+// flakyCreator is a StreamCreator that fails while err is set.
+type flakyCreator struct {
+	calls int
+	err   error
+}
+
+func (f *flakyCreator) create(context.Context) (tunnel.Stream, error) {
+	f.calls++
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &testStream{}, nil
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	now := time.Now()
+	cb := NewCircuitBreaker(3, time.Second)
+	cb.now = func() time.Time { return now }
+	fc := &flakyCreator{err: errors.New("manager unavailable")}
+	sc := cb.Middleware(fc.create)
+
+	// Closed: failures are passed through until the threshold is reached.
+	for i := 0; i < 3; i++ {
+		require.Equal(t, CircuitClosed, cb.State())
+		_, err := sc(ctx)
+		require.Equal(t, fc.err, err)
+	}
+
+	// Open: new streams fail fast without calling the creator.
+	require.Equal(t, CircuitOpen, cb.State())
+	_, err := sc(ctx)
+	require.ErrorIs(t, err, ErrCircuitOpen)
+	require.Equal(t, 3, fc.calls)
+
+	// Half-open: after the cooldown, one trial is allowed. It fails, so the circuit opens again.
+	now = now.Add(time.Second)
+	require.Equal(t, CircuitHalfOpen, cb.State())
+	_, err = sc(ctx)
+	require.Equal(t, fc.err, err)
+	require.Equal(t, 4, fc.calls)
+	require.Equal(t, CircuitOpen, cb.State())
+	_, err = sc(ctx)
+	require.ErrorIs(t, err, ErrCircuitOpen)
+
+	// Half-open again, and this time the trial succeeds, so the circuit is closed.
+	now = now.Add(time.Second)
+	fc.err = nil
+	_, err = sc(ctx)
+	require.NoError(t, err)
+	require.Equal(t, CircuitClosed, cb.State())
+
+	// A single failure doesn't open a closed circuit.
+	fc.err = errors.New("hiccup")
+	_, err = sc(ctx)
+	require.Equal(t, fc.err, err)
+	require.Equal(t, CircuitClosed, cb.State())
+}
+
+func TestCircuitBreaker_SingleTrial(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	now := time.Now()
+	cb := NewCircuitBreaker(1, time.Second)
+	cb.now = func() time.Time { return now }
+
+	block := make(chan struct{})
+	started := make(chan struct{})
+	failing := true
+	sc := cb.Middleware(func(context.Context) (tunnel.Stream, error) {
+		if failing {
+			return nil, errors.New("fail")
+		}
+		close(started)
+		<-block
+		return &testStream{}, nil
+	})
+	_, err := sc(ctx)
+	require.Error(t, err)
+	require.Equal(t, CircuitOpen, cb.State())
+
+	// While the trial is in progress, other attempts fail fast.
+	now = now.Add(time.Second)
+	failing = false
+	done := make(chan error)
+	go func() {
+		_, err := sc(ctx)
+		done <- err
+	}()
+	<-started
+	_, err = sc(ctx)
+	require.ErrorIs(t, err, ErrCircuitOpen)
+	close(block)
+	require.NoError(t, <-done)
+	require.Equal(t, CircuitClosed, cb.State())
+}
+
+func TestRetryStreamCreator(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	fc := &flakyCreator{err: errors.New("fail")}
+	sc := RetryStreamCreator(3, time.Millisecond)(fc.create)
+	_, err := sc(ctx)
+	require.Equal(t, fc.err, err)
+	require.Equal(t, 3, fc.calls)
+
+	// Composed with a circuit breaker, the breaker counts one failure per exhausted retry.
+	fc = &flakyCreator{err: errors.New("fail")}
+	cb := NewCircuitBreaker(2, time.Minute)
+	sc = ChainStreamCreator(cb.Middleware, RetryStreamCreator(3, time.Millisecond))(fc.create)
+	_, err = sc(ctx)
+	require.Error(t, err)
+	require.Equal(t, CircuitClosed, cb.State())
+	_, err = sc(ctx)
+	require.Error(t, err)
+	require.Equal(t, CircuitOpen, cb.State())
+	require.Equal(t, 6, fc.calls)
+	_, err = sc(ctx)
+	require.ErrorIs(t, err, ErrCircuitOpen)
+	require.Equal(t, 6, fc.calls)
+}
+
+func TestHandler_CircuitOpenResetsSyn(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+	cb := NewCircuitBreaker(1, time.Minute)
+	cb.done(ctx, errors.New("fail"))
+	require.Equal(t, CircuitOpen, cb.State())
+
+	p := newTestPeer(ctx, t, HandlerConfig{})
+	p.h.streamCreator = cb.Middleware(p.h.streamCreator)
+	p.send(ctx, true, false, false, nil)
+	require.True(t, p.recv().Header().SYN())
+	require.True(t, p.recv().Header().RST())
+}
@@ -0,0 +1,113 @@
+package tcp
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/tunnel"
+)
+
+// Direction is the direction of a captured payload.
+type Direction int
+
+const (
+	// ToManager is the direction from the peer to the traffic-manager.
+	ToManager = Direction(iota)
+
+	// ToPeer is the direction from the traffic-manager to the peer.
+	ToPeer
+)
+
+func (d Direction) String() string {
+	if d == ToManager {
+		return "to-manager"
+	}
+	return "to-peer"
+}
+
+// TrafficSink receives copies of the payloads that flow through TCP connections. Capture is called
+// on the data path, so it must never block. The payload must not be retained after Capture returns.
+type TrafficSink interface {
+	Capture(id tunnel.ConnID, dir Direction, payload []byte)
+}
+
+type trafficSinkKey struct{}
+
+// WithTrafficSink returns a context with the given TrafficSink. Handlers that are started using
+// that context will send a copy of all payloads to the sink.
+func WithTrafficSink(ctx context.Context, sink TrafficSink) context.Context {
+	return context.WithValue(ctx, trafficSinkKey{}, sink)
+}
+
+func getTrafficSink(ctx context.Context) TrafficSink {
+	sink, ok := ctx.Value(trafficSinkKey{}).(TrafficSink)
+	if !ok {
+		return nil
+	}
+	return sink
+}
+
+// capture sends a copy of the given payload to the handler's TrafficSink, if any.
+func (h *handler) capture(dir Direction, payload []byte) {
+	if h.sink != nil && len(payload) > 0 {
+		h.sink.Capture(h.id, dir, payload)
+	}
+}
+
+type capturedPayload struct {
+	time    time.Time
+	id      tunnel.ConnID
+	dir     Direction
+	payload []byte
+}
+
+// TrafficTee is a TrafficSink that writes the captured payloads to an io.Writer. Each payload is
+// written as a line with the time, the direction, the connection, and the payload length, followed
+// by the payload and a newline. Payloads are buffered, and a payload that doesn't fit in the buffer
+// is dropped, so a slow writer never slows down the connections.
+type TrafficTee struct {
+	ch      chan capturedPayload
+	dropped uint64
+}
+
+// NewTrafficTee creates a TrafficTee that buffers at most bufferSize payloads and writes them to w
+// until the given context is cancelled.
+func NewTrafficTee(ctx context.Context, w io.Writer, bufferSize int) *TrafficTee {
+	t := &TrafficTee{ch: make(chan capturedPayload, bufferSize)}
+	go t.run(ctx, w)
+	return t
+}
+
+// Capture implements TrafficSink.
+func (t *TrafficTee) Capture(id tunnel.ConnID, dir Direction, payload []byte) {
+	cp := capturedPayload{time: time.Now(), id: id, dir: dir, payload: make([]byte, len(payload))}
+	copy(cp.payload, payload)
+	select {
+	case t.ch <- cp:
+	default:
+		atomic.AddUint64(&t.dropped, 1)
+	}
+}
+
+// Dropped returns the number of payloads that were dropped because the buffer was full.
+func (t *TrafficTee) Dropped() uint64 {
+	return atomic.LoadUint64(&t.dropped)
+}
+
+func (t *TrafficTee) run(ctx context.Context, w io.Writer) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case cp := <-t.ch:
+			if _, err := fmt.Fprintf(w, "%s %s %s %d\n%s\n", cp.time.Format(time.RFC3339Nano), cp.dir, cp.id, len(cp.payload), cp.payload); err != nil {
+				dlog.Errorf(ctx, "unable to write captured traffic: %v", err)
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package tcp
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/ipproto"
+	"github.com/telepresenceio/telepresence/v2/pkg/tunnel"
+)
+
+type recordingSink struct {
+	sync.Mutex
+	captured []capturedPayload
+}
+
+func (s *recordingSink) Capture(id tunnel.ConnID, dir Direction, payload []byte) {
+	s.Lock()
+	s.captured = append(s.captured, capturedPayload{id: id, dir: dir, payload: append([]byte(nil), payload...)})
+	s.Unlock()
+}
+
+func (s *recordingSink) snapshot() []capturedPayload {
+	s.Lock()
+	defer s.Unlock()
+	return append([]capturedPayload(nil), s.captured...)
+}
+
+func TestHandler_TrafficSink(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+	sink := &recordingSink{}
+	ctx = WithTrafficSink(ctx, sink)
+	p := newTestPeer(ctx, t, HandlerConfig{})
+	p.connect(ctx)
+
+	p.send(ctx, false, true, false, []byte("hello"))
+	<-p.stream.toMgr
+	p.recv()
+	p.stream.fromMgr <- tunnel.NewMessage(tunnel.Normal, []byte("world"))
+	p.recv()
+
+	require.Eventually(t, func() bool { return len(sink.snapshot()) == 2 }, 5*time.Second, time.Millisecond)
+	captured := sink.snapshot()
+	require.Equal(t, p.id, captured[0].id)
+	require.Equal(t, ToManager, captured[0].dir)
+	require.Equal(t, []byte("hello"), captured[0].payload)
+	require.Equal(t, p.id, captured[1].id)
+	require.Equal(t, ToPeer, captured[1].dir)
+	require.Equal(t, []byte("world"), captured[1].payload)
+}
+
+// blockingWriter blocks all writes until it's released.
+type blockingWriter struct {
+	bytes.Buffer
+	release chan struct{}
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	<-w.release
+	return w.Buffer.Write(p)
+}
+
+func TestTrafficTee(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+	id := tunnel.NewConnID(ipproto.TCP, net.IP{10, 0, 0, 1}, net.IP{10, 0, 0, 2}, 4711, 80)
+
+	buf := &lockedBuffer{}
+	tee := NewTrafficTee(ctx, buf, 10)
+	tee.Capture(id, ToPeer, []byte("hello"))
+	require.Eventually(t, func() bool {
+		return strings.HasSuffix(buf.String(), fmt.Sprintf(" to-peer %s 5\nhello\n", id))
+	}, 5*time.Second, time.Millisecond)
+
+	// A writer that doesn't keep up never blocks the capture. Payloads that don't fit in the buffer
+	// are dropped.
+	w := &blockingWriter{release: make(chan struct{})}
+	tee = NewTrafficTee(ctx, w, 2)
+	for i := 0; i < 10; i++ {
+		tee.Capture(id, ToManager, []byte("data"))
+	}
+	require.GreaterOrEqual(t, tee.Dropped(), uint64(7))
+	close(w.release)
+}
+
+type lockedBuffer struct {
+	sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.Lock()
+	defer b.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.Lock()
+	defer b.Unlock()
+	return b.buf.String()
+}
@@ -3,44 +3,208 @@ package tcp
 import (
 	"bytes"
 	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
 	"time"
 
 	"github.com/datawire/dlib/dlog"
+	"google.golang.org/grpc/status"
+
 	"github.com/telepresenceio/telepresence/v2/pkg/tunnel"
 )
 
 func (h *handler) handleStreamControl(ctx context.Context, ctrl tunnel.Message) {
 	switch ctrl.Code() {
 	case tunnel.DialOK:
-	case tunnel.DialReject, tunnel.Disconnect:
-		h.Stop(ctx)
+		atomic.StoreInt32(&h.dialAnswered, 1)
+	case tunnel.Disconnect:
+		h.stopGraceful(ctx)
 	case tunnel.KeepAlive:
+	case tunnel.WindowUpdate:
+		size, err := tunnel.GetWindowSize(ctrl)
+		if err != nil {
+			dlog.Errorf(ctx, "!! CON %s, %v", h.name, err)
+			return
+		}
+		h.applyManagerWindow(ctx, size)
 	}
 }
 
-func (h *handler) sendToMgr(ctx context.Context, pkt Packet) bool {
-	select {
-	case h.toMgrCh <- pkt:
-		h.adjustReceiveWindow()
-		if h.packetLostTimer != nil {
-			h.packetLostTimer.Stop()
-			h.packetLostTimer = nil
+// applyManagerWindow limits the receive window to the given size, which the traffic-manager side
+// asked for because the application that it writes to doesn't keep up. The peer is then throttled
+// using TCP flow control rather than by losing packets. A window update is sent to the peer when
+// the new window is larger than the one that was last advertised.
+func (h *handler) applyManagerWindow(ctx context.Context, size uint32) {
+	dlog.Tracef(ctx, "<- MGR %s, receive window limited to %d", h.name, size)
+	atomic.StoreInt64(&h.mgrWindow, int64(size))
+	h.adjustReceiveWindow()
+	if h.state() == stateEstablished && h.receiveWindow() > int(atomic.LoadInt64(&h.advertisedWindow)) {
+		h.forceSendAck(ctx)
+	}
+}
+
+// rejectedError is returned by readFromStream when the traffic-manager rejected the connection,
+// either explicitly using a DialReject, or implicitly by failing the stream before the dial was
+// answered.
+type rejectedError struct {
+	reason string
+}
+
+func (e *rejectedError) Error() string {
+	return fmt.Sprintf("traffic-manager rejected the connection: %s", e.reason)
+}
+
+// rejectReason returns the reason for a rejection that is given by a DialReject message, or by
+// the gRPC status of an error that failed the stream.
+func rejectReason(m tunnel.Message, err error) string {
+	var reason string
+	if m != nil {
+		reason = tunnel.GetRejectReason(m)
+	} else if err != nil {
+		var se interface{ GRPCStatus() *status.Status }
+		if errors.As(err, &se) {
+			reason = se.GRPCStatus().Message()
+		} else {
+			reason = err.Error()
 		}
+	}
+	if reason == "" {
+		reason = "no reason given"
+	}
+	return reason
+}
+
+// startStream creates the stream to the traffic-manager and starts reading from it.
+func (h *handler) startStream(ctx context.Context) error {
+	s, err := h.streamCreator(ctx)
+	if err != nil {
+		return err
+	}
+	h.streamLock.Lock()
+	h.stream = s
+	h.streamLock.Unlock()
+	h.goTracked(ctx, "readFromMgrLoop", h.readFromMgrLoop)
+	return nil
+}
+
+// ensureStream creates the stream to the traffic-manager if its creation was deferred by the
+// LazyStream setting, and starts writing to it. The connection is reset if the stream can't be
+// created, and false is returned.
+func (h *handler) ensureStream(ctx context.Context) bool {
+	if h.getStream() != nil {
 		return true
+	}
+	if err := h.startStream(ctx); err != nil {
+		dlog.Errorf(ctx, "!! CON %s, unable to create stream to traffic-manager: %v", h.name, err)
+		h.sendReset(ctx)
+		return false
+	}
+	h.goTracked(ctx, "writeToMgrLoop", h.writeToMgrLoop)
+	return true
+}
+
+// mgrSendResult is the result of sendToMgr.
+type mgrSendResult int
+
+const (
+	// mgrDelivered means that the packet was queued for the traffic-manager.
+	mgrDelivered = mgrSendResult(iota)
+
+	// mgrBlocked means that the queue to the traffic-manager was full, but that room was made for
+	// the packet within the mgrSendWait. The packet was queued and the receive window has been
+	// adjusted to the full queue.
+	mgrBlocked
+
+	// mgrDropped means that the packet was released without being queued, because the queue to
+	// the traffic-manager remained full. The receive window has been closed, so that the peer
+	// stops sending until the queue has room again.
+	mgrDropped
+)
+
+// mgrSendWait is the time that sendToMgr waits for room in a full queue to the traffic-manager
+// before the packet is dropped. It doesn't wait while the window is closed because of an earlier
+// drop, because the packets that the peer sent before it learned about that would then delay the
+// handler further.
+const mgrSendWait = 10 * time.Millisecond
+
+func (h *handler) sendToMgr(ctx context.Context, pkt Packet) mgrSendResult {
+	// The packet may be released once it's sent, so the payload length and end are retained here
+	n := len(pkt.Header().Payload())
+	end := pkt.Header().Sequence() + uint32(n)
+	result := mgrDelivered
+	select {
+	case h.toMgrCh <- pkt:
 	default:
+		result = mgrDropped
+		if atomic.LoadInt32(&h.mgrDropClosed) == 0 {
+			select {
+			case h.toMgrCh <- pkt:
+				result = mgrBlocked
+			case <-h.clock.After(mgrSendWait):
+			case <-ctx.Done():
+			case <-h.tunDone:
+			}
+		}
+	}
+	if result == mgrDropped {
 		// Manager doesn't keep up. Packet loss!
 		dlog.Debugf(ctx, "-> MGR %s packet lost!", pkt)
 		pkt.Release()
+		atomic.AddUint64(&h.mgrDropped, 1)
 		if h.packetLostTimer == nil {
-			h.packetLostTimer = time.AfterFunc(5*time.Second, func() {
-				h.Stop(ctx)
+			h.packetLostTimer = h.clock.AfterFunc(5*time.Second, func() {
+				h.stopGraceful(ctx)
 			})
 		}
-		return false
+		// Tell the peer to stop sending. The window is reopened by the writeToMgrLoop once the
+		// queue has room again.
+		h.setReceiveWindow(0)
+		if atomic.CompareAndSwapInt32(&h.mgrDropClosed, 0, 1) && atomic.LoadInt64(&h.advertisedWindow) > 0 {
+			h.forceSendAck(ctx)
+		}
+		return result
+	}
+	atomic.AddUint64(&h.bytesToMgr, uint64(n))
+	h.counters.AddBytesOut(uint64(n))
+	h.autoTuneReceived(ctx, end, n)
+	if atomic.LoadInt32(&h.mgrDropClosed) == 0 {
+		h.adjustReceiveWindow()
 	}
+	if h.packetLostTimer != nil {
+		h.packetLostTimer.Stop()
+		h.packetLostTimer = nil
+	}
+	return result
 }
 
-func (h *handler) adjustReceiveWindow() {
+// adjustReceiveWindow adjusts the receive window based on the current queue sizes. It returns true
+// when a window that was closed because the ManagerQueueHighWatermark was reached, or because
+// sendToMgr dropped a packet, is reopened, or when an auto-tuned window has grown to at least twice
+// the size that was last advertised, in which case the peer must be told about it using a window
+// update.
+func (h *handler) adjustReceiveWindow() bool {
+	if atomic.LoadInt32(&h.streamPaused) == 1 {
+		h.setReceiveWindow(0)
+		return false
+	}
+	reopened := false
+	if hw := h.cfg.ManagerQueueHighWatermark; hw > 0 {
+		queued := len(h.toMgrCh)
+		switch {
+		case queued >= hw:
+			if atomic.CompareAndSwapInt32(&h.mgrQueueThrottled, 0, 1) {
+				h.setReceiveWindow(0)
+			}
+			return false
+		case queued <= h.cfg.ManagerQueueLowWatermark:
+			reopened = atomic.CompareAndSwapInt32(&h.mgrQueueThrottled, 1, 0)
+		case atomic.LoadInt32(&h.mgrQueueThrottled) == 1:
+			// Between the watermarks, so the window remains closed
+			return false
+		}
+	}
+
 	// Adjust window size based on current queue sizes. Both channels
 	// are of ioChannelSize.
 	inBuffer := float64(len(h.toMgrCh) + len(h.fromTun))
@@ -54,79 +218,159 @@ func (h *handler) adjustReceiveWindow() {
 	if ratio > 0.0 {
 		// Make window size dependent on the number o element on the queue
 		ratio *= 2 // 1.0 means empty buffer
-		windowSize = int(float64(maxReceiveWindow) * ratio)
+		windowSize = int(float64(h.windowLimit()) * ratio)
+	}
+	if h.cfg.ReceiveWindowAutoTuning && len(h.toMgrCh) > ioChannelSize/2 {
+		h.autoTuneShrink()
 	}
 
 	// Strip the last 8 bits so that we don't change so often
 	windowSize &^= 0xff
+	windowSize = h.capReceiveWindow(windowSize)
+	if mw := int(atomic.LoadInt64(&h.mgrWindow)); mw >= 0 && windowSize > mw {
+		windowSize = mw
+	}
+	if windowSize > 0 && atomic.CompareAndSwapInt32(&h.mgrDropClosed, 1, 0) {
+		reopened = true
+	}
 	h.setReceiveWindow(windowSize)
+	if h.cfg.ReceiveWindowAutoTuning {
+		if adv := int(atomic.LoadInt64(&h.advertisedWindow)); windowSize >= 2*adv && windowSize-adv >= maxSegmentSize {
+			return true
+		}
+	}
+	return reopened
 }
 
-// readFromMgrLoop sends the packets read from the fromMgr channel to the TUN device
+// readFromMgrLoop sends the packets read from the traffic-manager stream to the TUN device. When
+// the stream is lost, the loop continues with a new stream if the connection can be resumed.
 func (h *handler) readFromMgrLoop(ctx context.Context) {
 	h.wg.Add(1)
-	defer func() {
-		h.Stop(ctx)
-		h.wg.Done()
-	}()
-	fromMgrCh, fromMgrErrs := tunnel.ReadLoop(ctx, h.stream)
+	defer h.wg.Done()
+	stream := h.getStream()
+	for {
+		err := h.readFromStream(ctx, stream)
+		if err == nil {
+			break
+		}
+		var rj *rejectedError
+		if !errors.As(err, &rj) {
+			if h.resumable() {
+				if stream = h.resumeStream(ctx); stream == nil {
+					h.sendReset(ctx)
+					return
+				}
+				continue
+			}
+			if atomic.LoadInt32(&h.dialAnswered) != 0 {
+				break
+			}
+			rj = &rejectedError{reason: rejectReason(nil, err)}
+		}
+		// The peer is told using a RST, so that it fails like it would when the connection was
+		// refused, but the reason can only be found in the log.
+		dlog.Errorf(ctx, "!! CON %s, %v", h.name, rj)
+		h.sendReset(ctx)
+		return
+	}
+	h.stopGraceful(ctx)
+}
+
+// readFromStream sends the packets read from the given stream to the TUN device. It returns an
+// error if it ended because a read from the stream failed, or a *rejectedError if the
+// traffic-manager rejected the connection.
+func (h *handler) readFromStream(ctx context.Context, stream tunnel.Stream) error {
+	var lost error
+	fromMgrCh, fromMgrErrs := tunnel.ReadLoop(ctx, stream)
 	for {
 		select {
 		case <-ctx.Done():
-			return
+			return nil
 		case <-h.tunDone:
-			return
+			return nil
 		case err := <-fromMgrErrs:
 			dlog.Error(ctx, err)
+			lost = err
 		case m := <-fromMgrCh:
 			if m == nil {
-				return
+				// The ReadLoop posts its error before closing the channel.
+				select {
+				case err := <-fromMgrErrs:
+					dlog.Error(ctx, err)
+					lost = err
+				default:
+				}
+				return lost
 			}
 
 			select {
 			case <-ctx.Done():
-				return
+				return nil
 			case <-h.tunDone:
-				return
+				return nil
 			default:
 			}
 
-			if m.Code() != tunnel.Normal {
+			if !m.Code().IsData() {
+				if m.Code() == tunnel.DialReject {
+					return &rejectedError{reason: rejectReason(m, nil)}
+				}
 				h.handleStreamControl(ctx, m)
 				continue
 			}
+			atomic.StoreInt32(&h.dialAnswered, 1)
 			h.processPayload(ctx, m.Payload())
 		}
 	}
 }
 
+// mgrFlushDelay is the time that data from the peer is buffered, waiting for more data, before it's
+// sent to the traffic-manager. Data is sent without delay when it's received with PSH.
+const mgrFlushDelay = 2 * time.Millisecond
+
 // writeToMgrLoop sends the packets read from the toMgrCh channel to the traffic-manager device
 func (h *handler) writeToMgrLoop(ctx context.Context) {
-	// the time to wait until we flush in spite of not getting a PSH
-	const flushDelay = 2 * time.Millisecond
-
 	// Threshold when we flush in spite of not getting a PSH
 	const maxBufSize = 0x10000
 
-	var mgrWrite func(payload []byte) bool
+	// Data that was received with PSH is sent using the Push code so that the manager side flushes
+	// it immediately, provided that the peer supports it.
+	pushCode := tunnel.Normal
+	if tunnel.SupportsPush(h.getStream()) {
+		pushCode = tunnel.Push
+	}
+
+	var mgrWrite func(payload []byte, push bool) bool
 	defer close(h.toMgrMsgCh)
-	tunnel.WriteLoop(ctx, h.stream, h.toMgrMsgCh)
-	mgrWrite = func(payload []byte) bool {
+	h.streamLock.Lock()
+	h.startStreamWriter(ctx)
+	h.streamLock.Unlock()
+	mgrWrite = func(payload []byte, push bool) bool {
+		code := tunnel.Normal
+		if push {
+			code = pushCode
+		}
 		select {
 		case <-ctx.Done():
 			return true
-		case h.toMgrMsgCh <- tunnel.NewMessage(tunnel.Normal, payload):
+		case h.toMgrMsgCh <- tunnel.NewMessage(code, payload):
 			return false
 		}
 	}
 
-	flushTimer := time.NewTimer(flushDelay)
+	flush := make(chan struct{}, 1)
+	flushTimer := h.clock.AfterFunc(mgrFlushDelay, func() {
+		select {
+		case flush <- struct{}{}:
+		default:
+		}
+	})
 	flushTimer.Stop() // Not used until we write to buf
 
 	buf := bytes.Buffer{}
 
-	sendBuf := func() {
-		if mgrWrite(buf.Bytes()) {
+	sendBuf := func(push bool) {
+		if mgrWrite(buf.Bytes(), push) {
 			return
 		}
 		buf.Reset()
@@ -136,32 +380,42 @@ func (h *handler) writeToMgrLoop(ctx context.Context) {
 		select {
 		case <-ctx.Done():
 			return
-		case <-flushTimer.C:
+		case <-flush:
 			if buf.Len() > 0 {
-				sendBuf()
+				sendBuf(false)
 			}
 		case <-h.tunDone:
 			return
 		case pkt := <-h.toMgrCh:
 			if pkt == nil {
+				// The peer has closed its side. Flush what's buffered. The deferred close of the
+				// toMgrMsgCh then makes the WriteLoop close the sending side of the stream.
+				if buf.Len() > 0 {
+					flushTimer.Stop()
+					sendBuf(true)
+				}
 				return
 			}
-			h.adjustReceiveWindow()
+			if h.adjustReceiveWindow() {
+				// Send a window update so that the peer resumes sending.
+				h.forceSendAck(ctx)
+			}
 			tcpHdr := pkt.Header()
 			payload := tcpHdr.Payload()
-			if tcpHdr.PSH() || buf.Len()+len(payload) >= maxBufSize {
+			h.capture(ToManager, payload)
+			if psh := tcpHdr.PSH(); psh || buf.Len()+len(payload) >= maxBufSize {
 				if buf.Len() == 0 {
-					if mgrWrite(payload) { // save extra copying by bypassing buf.
+					if mgrWrite(payload, psh) { // save extra copying by bypassing buf.
 						return
 					}
 				} else {
-					flushTimer.Stop() // It doesn't matter if the flushTime.C isn't empty. It will fire on a zero buffer
+					flushTimer.Stop() // It doesn't matter if the flush channel isn't empty. It will fire on a zero buffer
 					buf.Write(payload)
-					sendBuf()
+					sendBuf(psh)
 				}
 			} else {
 				if buf.Len() == 0 {
-					flushTimer.Reset(flushDelay)
+					flushTimer.Reset(mgrFlushDelay)
 				}
 				buf.Write(payload)
 			}
@@ -170,6 +424,17 @@ func (h *handler) writeToMgrLoop(ctx context.Context) {
 	}
 }
 
+// closeToMgr closes the direction from the peer to the traffic-manager while the direction from
+// the traffic-manager to the peer remains open. It must be called from the goroutine that sends
+// packets to the toMgrCh, after the last packet has been sent.
+func (h *handler) closeToMgr(ctx context.Context) {
+	select {
+	case <-ctx.Done():
+	case <-h.tunDone:
+	case h.toMgrCh <- nil:
+	}
+}
+
 func (h *handler) sendStreamControl(ctx context.Context, code tunnel.MessageCode) {
 	select {
 	case <-ctx.Done():
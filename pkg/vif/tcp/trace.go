@@ -0,0 +1,160 @@
+package tcp
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/tunnel"
+)
+
+// StateEvent describes a transition between two states of a TCP connection.
+type StateEvent struct {
+	// Time is when the transition happened.
+	Time time.Time
+
+	// From is the state that the connection was in before the transition.
+	From string
+
+	// To is the state that the connection was in after the transition.
+	To string
+
+	// Flags is a comma separated list of the flags of the packet that triggered the transition.
+	// It's empty when the transition wasn't triggered by a packet.
+	Flags string
+
+	// Attributes describe what the connection belongs to. They're only set on the first event of
+	// a connection, and only contain the attributes that are known, so Attributes is nil when
+	// nothing is known.
+	Attributes map[string]string
+}
+
+// ConnMetadata describes what a TCP connection belongs to. Fields are empty when unknown.
+type ConnMetadata struct {
+	Namespace string
+	Workload  string
+	Intercept string
+}
+
+// Attributes returns the known fields of the metadata keyed by their attribute names, or nil
+// when no field is known.
+func (md ConnMetadata) Attributes() map[string]string {
+	var attrs map[string]string
+	add := func(k, v string) {
+		if v != "" {
+			if attrs == nil {
+				attrs = make(map[string]string, 3)
+			}
+			attrs[k] = v
+		}
+	}
+	add("namespace", md.Namespace)
+	add("workload", md.Workload)
+	add("intercept", md.Intercept)
+	return attrs
+}
+
+type connMetadataKey struct{}
+
+// WithConnMetadata returns a context with the given ConnMetadata. A handler that is started using
+// that context will report the metadata as attributes of its first StateEvent.
+func WithConnMetadata(ctx context.Context, md ConnMetadata) context.Context {
+	return context.WithValue(ctx, connMetadataKey{}, md)
+}
+
+func getConnMetadata(ctx context.Context) ConnMetadata {
+	md, _ := ctx.Value(connMetadataKey{}).(ConnMetadata)
+	return md
+}
+
+// StateTracer receives the state transitions of TCP connections.
+type StateTracer interface {
+	StateTransition(id tunnel.ConnID, ev StateEvent)
+}
+
+type stateTracerKey struct{}
+
+// WithStateTracer returns a context with the given StateTracer. Handlers that are started using
+// that context will report their state transitions to the tracer.
+func WithStateTracer(ctx context.Context, st StateTracer) context.Context {
+	return context.WithValue(ctx, stateTracerKey{}, st)
+}
+
+func getStateTracer(ctx context.Context) StateTracer {
+	st, ok := ctx.Value(stateTracerKey{}).(StateTracer)
+	if !ok {
+		return nil
+	}
+	return st
+}
+
+// StateRecorder is a StateTracer that keeps the state transitions of the most recent connections
+// in memory, so that the lifecycle of a problematic connection can be reconstructed.
+type StateRecorder struct {
+	sync.Mutex
+	maxConns int
+	conns    []tunnel.ConnID
+	events   map[tunnel.ConnID][]StateEvent
+}
+
+// NewStateRecorder creates a StateRecorder that keeps the state transitions of at most maxConns
+// connections. The connection that was first seen is forgotten when that limit is exceeded.
+func NewStateRecorder(maxConns int) *StateRecorder {
+	return &StateRecorder{
+		maxConns: maxConns,
+		events:   make(map[tunnel.ConnID][]StateEvent),
+	}
+}
+
+// StateTransition records the given event for the connection with the given id.
+func (r *StateRecorder) StateTransition(id tunnel.ConnID, ev StateEvent) {
+	r.Lock()
+	defer r.Unlock()
+	evs, ok := r.events[id]
+	if !ok {
+		if len(r.conns) >= r.maxConns {
+			delete(r.events, r.conns[0])
+			r.conns = r.conns[1:]
+		}
+		r.conns = append(r.conns, id)
+	}
+	r.events[id] = append(evs, ev)
+}
+
+// Connections returns the ids of the connections that have recorded state transitions, in the
+// order that they were first seen.
+func (r *StateRecorder) Connections() []tunnel.ConnID {
+	r.Lock()
+	defer r.Unlock()
+	cp := make([]tunnel.ConnID, len(r.conns))
+	copy(cp, r.conns)
+	return cp
+}
+
+// Events returns the state transitions that have been recorded for the connection with the given id.
+func (r *StateRecorder) Events(id tunnel.ConnID) []StateEvent {
+	r.Lock()
+	defer r.Unlock()
+	evs := r.events[id]
+	cp := make([]StateEvent, len(evs))
+	copy(cp, evs)
+	return cp
+}
+
+// traceStateTransition reports a state transition to the handler's StateTracer, if any.
+func (h *handler) traceStateTransition(from, to state, trigger Header) {
+	if h.tracer == nil {
+		return
+	}
+	ev := StateEvent{Time: h.clock.Now(), From: from.String(), To: to.String()}
+	if trigger != nil {
+		b := bytes.Buffer{}
+		trigger.AppendFlags(&b)
+		ev.Flags = b.String()
+	}
+	if from == stateIdle {
+		ev.Attributes = h.metadata.Attributes()
+	}
+	h.tracer.StateTransition(h.id, ev)
+}
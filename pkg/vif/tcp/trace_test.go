@@ -0,0 +1,67 @@
+package tcp
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/tunnel"
+)
+
+func TestStateRecorder(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+	rec := NewStateRecorder(10)
+	ctx = WithStateTracer(ctx, rec)
+
+	p := newTestPeer(ctx, t, HandlerConfig{})
+	p.connect(ctx)
+	p.h.Stop(ctx)
+	require.True(t, p.recv().Header().FIN())
+
+	evs := rec.Events(p.id)
+	require.Len(t, evs, 3)
+	require.Equal(t, StateEvent{Time: evs[0].Time, From: "IDLE", To: "SYN RECEIVED", Flags: "SYN"}, evs[0])
+	require.Equal(t, StateEvent{Time: evs[1].Time, From: "SYN RECEIVED", To: "ESTABLISHED", Flags: "ACK"}, evs[1])
+	require.Equal(t, StateEvent{Time: evs[2].Time, From: "ESTABLISHED", To: "FIN_WAIT_1"}, evs[2])
+	require.False(t, evs[1].Time.Before(evs[0].Time))
+}
+
+func TestStateRecorder_Attributes(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+	rec := NewStateRecorder(10)
+	ctx = WithStateTracer(ctx, rec)
+
+	p := newTestPeer(WithConnMetadata(ctx, ConnMetadata{Namespace: "default", Workload: "echo"}), t, HandlerConfig{})
+	p.connect(ctx)
+	evs := rec.Events(p.id)
+	require.Len(t, evs, 2)
+	require.Equal(t, map[string]string{"namespace": "default", "workload": "echo"}, evs[0].Attributes)
+	require.Nil(t, evs[1].Attributes)
+
+	// Without metadata, there are no attributes at all.
+	rec = NewStateRecorder(10)
+	p = newTestPeer(WithStateTracer(ctx, rec), t, HandlerConfig{})
+	p.connect(ctx)
+	evs = rec.Events(p.id)
+	require.Len(t, evs, 2)
+	require.Nil(t, evs[0].Attributes)
+	require.Nil(t, ConnMetadata{}.Attributes())
+}
+
+func TestStateRecorder_MaxConns(t *testing.T) {
+	rec := NewStateRecorder(2)
+	ids := make([]tunnel.ConnID, 3)
+	for i := range ids {
+		ids[i] = tunnel.ConnID(string(rune('a' + i)))
+		rec.StateTransition(ids[i], StateEvent{Time: time.Now(), From: "IDLE", To: "SYN RECEIVED"})
+	}
+	require.Empty(t, rec.Events(ids[0]))
+	require.Len(t, rec.Events(ids[1]), 1)
+	require.Len(t, rec.Events(ids[2]), 1)
+	require.Equal(t, ids[1:], rec.Connections())
+}
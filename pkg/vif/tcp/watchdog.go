@@ -0,0 +1,146 @@
+package tcp
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/tunnel"
+)
+
+// LeakWatchdog keeps track of the goroutines that are started by the TCP handlers and reports
+// handlers that still have goroutines running when a grace period has passed since they were closed.
+type LeakWatchdog struct {
+	sync.Mutex
+	gracePeriod time.Duration
+	onLeak      func(ctx context.Context, id tunnel.ConnID, goroutines []string)
+	trackers    map[*goroutineTracker]struct{}
+}
+
+// goroutineTracker keeps track of the goroutines of one handler.
+type goroutineTracker struct {
+	wd       *LeakWatchdog
+	id       tunnel.ConnID
+	running  map[string]int
+	closed   time.Time
+	reported bool
+}
+
+type leakWatchdogKey struct{}
+
+// NewLeakWatchdog creates a watchdog that calls onLeak for each handler that still has goroutines
+// running when more than gracePeriod has passed since the handler was closed. The onLeak function
+// is called at most once for each handler.
+func NewLeakWatchdog(gracePeriod time.Duration, onLeak func(ctx context.Context, id tunnel.ConnID, goroutines []string)) *LeakWatchdog {
+	return &LeakWatchdog{
+		gracePeriod: gracePeriod,
+		onLeak:      onLeak,
+		trackers:    make(map[*goroutineTracker]struct{}),
+	}
+}
+
+// WithLeakWatchdog returns a context with the given LeakWatchdog. Handlers that are started using
+// that context will register their goroutines with the watchdog.
+func WithLeakWatchdog(ctx context.Context, wd *LeakWatchdog) context.Context {
+	return context.WithValue(ctx, leakWatchdogKey{}, wd)
+}
+
+func getLeakWatchdog(ctx context.Context) *LeakWatchdog {
+	wd, ok := ctx.Value(leakWatchdogKey{}).(*LeakWatchdog)
+	if !ok {
+		return nil
+	}
+	return wd
+}
+
+// Run performs a sweep at regular intervals until the given context is done.
+func (w *LeakWatchdog) Run(ctx context.Context) error {
+	ticker := time.NewTicker(w.gracePeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			w.sweep(ctx)
+		}
+	}
+}
+
+// sweep calls onLeak for all handlers that were closed more than a grace period ago and still
+// have running goroutines, and forgets about handlers that have terminated completely.
+func (w *LeakWatchdog) sweep(ctx context.Context) {
+	type leak struct {
+		id         tunnel.ConnID
+		goroutines []string
+	}
+	var leaks []leak
+	now := time.Now()
+	w.Lock()
+	for t := range w.trackers {
+		if t.closed.IsZero() || t.reported || now.Sub(t.closed) < w.gracePeriod {
+			continue
+		}
+		names := make([]string, 0, len(t.running))
+		for name := range t.running {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		t.reported = true
+		leaks = append(leaks, leak{id: t.id, goroutines: names})
+	}
+	w.Unlock()
+
+	for _, l := range leaks {
+		dlog.Errorf(ctx, "   CON %s, goroutines %v still running after close", l.id, l.goroutines)
+		if w.onLeak != nil {
+			w.onLeak(ctx, l.id, l.goroutines)
+		}
+	}
+}
+
+// track returns a goroutineTracker for the handler with the given id. A nil watchdog
+// returns a nil tracker.
+func (w *LeakWatchdog) track(id tunnel.ConnID) *goroutineTracker {
+	if w == nil {
+		return nil
+	}
+	return &goroutineTracker{wd: w, id: id, running: make(map[string]int)}
+}
+
+func (t *goroutineTracker) started(name string) {
+	if t == nil {
+		return
+	}
+	t.wd.Lock()
+	t.running[name]++
+	t.wd.trackers[t] = struct{}{}
+	t.wd.Unlock()
+}
+
+func (t *goroutineTracker) stopped(name string) {
+	if t == nil {
+		return
+	}
+	t.wd.Lock()
+	if t.running[name]--; t.running[name] <= 0 {
+		delete(t.running, name)
+	}
+	if len(t.running) == 0 {
+		delete(t.wd.trackers, t)
+	}
+	t.wd.Unlock()
+}
+
+// close records the time when the handler was closed. The handler's goroutines are expected
+// to terminate within the grace period after that.
+func (t *goroutineTracker) close() {
+	if t == nil {
+		return
+	}
+	t.wd.Lock()
+	t.closed = time.Now()
+	t.wd.Unlock()
+}
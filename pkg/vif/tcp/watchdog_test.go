@@ -0,0 +1,72 @@
+package tcp
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/ipproto"
+	"github.com/telepresenceio/telepresence/v2/pkg/tunnel"
+)
+
+func TestLeakWatchdog(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	const gracePeriod = 50 * time.Millisecond
+
+	var mu sync.Mutex
+	leaks := make(map[tunnel.ConnID][]string)
+	wd := NewLeakWatchdog(gracePeriod, func(_ context.Context, id tunnel.ConnID, goroutines []string) {
+		mu.Lock()
+		leaks[id] = goroutines
+		mu.Unlock()
+	})
+
+	newHandler := func(port uint16) *handler {
+		id := tunnel.NewConnID(ipproto.TCP, net.IP{10, 0, 0, 1}, net.IP{10, 0, 0, 2}, port, 80)
+		return &handler{id: id, goroutines: wd.track(id)}
+	}
+
+	// A well behaved handler that terminates its goroutine when closed
+	good := newHandler(4711)
+	goodDone := make(chan struct{})
+	good.goTracked(ctx, "processResends", func(context.Context) { <-goodDone })
+
+	// A handler with a goroutine that is stuck forever
+	stuck := make(chan struct{})
+	defer close(stuck)
+	bad := newHandler(4712)
+	badDone := make(chan struct{})
+	bad.goTracked(ctx, "processResends", func(context.Context) { <-badDone })
+	bad.goTracked(ctx, "writeToMgrLoop", func(context.Context) { <-stuck })
+
+	close(goodDone)
+	good.goroutines.close()
+	close(badDone)
+	bad.goroutines.close()
+
+	// Nothing is reported within the grace period
+	wd.sweep(ctx)
+	mu.Lock()
+	assert.Empty(t, leaks)
+	mu.Unlock()
+
+	time.Sleep(2 * gracePeriod)
+	wd.sweep(ctx)
+	mu.Lock()
+	require.Len(t, leaks, 1)
+	assert.Equal(t, []string{"writeToMgrLoop"}, leaks[bad.id])
+	delete(leaks, bad.id)
+	mu.Unlock()
+
+	// A leak is only reported once
+	wd.sweep(ctx)
+	mu.Lock()
+	assert.Empty(t, leaks)
+	mu.Unlock()
+}
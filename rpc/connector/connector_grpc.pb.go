@@ -9,6 +9,7 @@ package connector
 import (
 	context "context"
 	common "github.com/telepresenceio/telepresence/rpc/v2/common"
+	daemon "github.com/telepresenceio/telepresence/rpc/v2/daemon"
 	manager "github.com/telepresenceio/telepresence/rpc/v2/manager"
 	userdaemon "github.com/telepresenceio/telepresence/rpc/v2/userdaemon"
 	grpc "google.golang.org/grpc"
@@ -48,6 +49,18 @@ type ConnectorClient interface {
 	// Deactivates and removes an existent workload intercept.
 	// Requires having already called Connect.
 	RemoveIntercept(ctx context.Context, in *manager.RemoveInterceptRequest2, opts ...grpc.CallOption) (*InterceptResult, error)
+	// Pauses an intercept. The traffic is routed to the intercepted
+	// container while the port forwards and mounts are kept intact.
+	PauseIntercept(ctx context.Context, in *manager.RemoveInterceptRequest2, opts ...grpc.CallOption) (*InterceptResult, error)
+	// Resumes an intercept that was paused using PauseIntercept.
+	ResumeIntercept(ctx context.Context, in *manager.RemoveInterceptRequest2, opts ...grpc.CallOption) (*InterceptResult, error)
+	// Returns the intercepts of the current session together with details
+	// about their health. Requires having already called Connect.
+	ListInterceptsDetailed(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*InterceptDetailsList, error)
+	// Removes an intercept, closes its active connections, and reports the
+	// reason for doing so to the StateChanges stream. Requires having
+	// already called Connect.
+	TerminateIntercept(ctx context.Context, in *TerminateInterceptRequest, opts ...grpc.CallOption) (*InterceptResult, error)
 	// Uninstalls traffic-agents and traffic-manager from the cluster.
 	// Requires having already called Connect.
 	Uninstall(ctx context.Context, in *UninstallRequest, opts ...grpc.CallOption) (*UninstallResult, error)
@@ -59,6 +72,10 @@ type ConnectorClient interface {
 	// Returns a stream of messages to display to the user.  Does NOT
 	// require having called anything else first.
 	UserNotifications(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (Connector_UserNotificationsClient, error)
+	// Returns a stream of structured events that describe changes in the
+	// state of the connection and its intercepts.  Does NOT require having
+	// called anything else first.
+	StateChanges(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (Connector_StateChangesClient, error)
 	Login(ctx context.Context, in *LoginRequest, opts ...grpc.CallOption) (*LoginResult, error)
 	// Returns an error with code=NotFound if not currently logged in.
 	Logout(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*emptypb.Empty, error)
@@ -66,6 +83,10 @@ type ConnectorClient interface {
 	GetCloudAPIKey(ctx context.Context, in *KeyRequest, opts ...grpc.CallOption) (*KeyData, error)
 	GetCloudLicense(ctx context.Context, in *LicenseRequest, opts ...grpc.CallOption) (*LicenseData, error)
 	GetIngressInfos(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*IngressInfos, error)
+	// DetectIngressInfos re-runs the detection of ingress behavior, so that
+	// load balancers in namespaces that were added after the connect are
+	// found without reconnecting.
+	DetectIngressInfos(ctx context.Context, in *DetectIngressRequest, opts ...grpc.CallOption) (*IngressInfos, error)
 	// SetLogLevel will temporarily set the log-level for the daemon for a duration that is determined by the request.
 	SetLogLevel(ctx context.Context, in *manager.LogLevelRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
 	// Quits (terminates) the connector process.
@@ -85,6 +106,30 @@ type ConnectorClient interface {
 	AddInterceptor(ctx context.Context, in *Interceptor, opts ...grpc.CallOption) (*emptypb.Empty, error)
 	// RemoveInterceptor removes a previously added interceptor
 	RemoveInterceptor(ctx context.Context, in *Interceptor, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	// TunnelMetrics returns the aggregated throughput of the connections that
+	// the root daemon tunnels to the cluster.
+	TunnelMetrics(ctx context.Context, in *daemon.TunnelMetricsRequest, opts ...grpc.CallOption) (*daemon.TunnelMetricsResponse, error)
+	// ListConnections returns the connections that the root daemon tunnels to
+	// the cluster and that match the given destination.
+	ListConnections(ctx context.Context, in *daemon.ConnectionFilter, opts ...grpc.CallOption) (*daemon.ConnectionList, error)
+	// StartTraceCapture makes the root daemon record the state transitions of
+	// the TCP connections for the given duration, into a capture labeled with
+	// the given correlation ID, so that a trace of a specific user action can
+	// be attached to a bug report.
+	StartTraceCapture(ctx context.Context, in *daemon.TraceCaptureRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	// FetchTraceCapture streams the traces of the capture with the given
+	// correlation ID.
+	FetchTraceCapture(ctx context.Context, in *daemon.TraceCaptureId, opts ...grpc.CallOption) (Connector_FetchTraceCaptureClient, error)
+	// DumpState returns a snapshot of the connector's internal state, intended
+	// to be attached to bug reports.
+	DumpState(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*StateDump, error)
+	// GetEffectiveConfig returns the configuration that the connector uses,
+	// with every setting included, whether it comes from a default, a config
+	// file, or the environment.
+	GetEffectiveConfig(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*EffectiveConfig, error)
+	// Ping measures the round-trip time to the traffic-manager and to the
+	// cluster's API server.
+	Ping(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*PingResult, error)
 }
 
 type connectorClient struct {
@@ -158,6 +203,42 @@ func (c *connectorClient) RemoveIntercept(ctx context.Context, in *manager.Remov
 	return out, nil
 }
 
+func (c *connectorClient) PauseIntercept(ctx context.Context, in *manager.RemoveInterceptRequest2, opts ...grpc.CallOption) (*InterceptResult, error) {
+	out := new(InterceptResult)
+	err := c.cc.Invoke(ctx, "/telepresence.connector.Connector/PauseIntercept", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *connectorClient) ResumeIntercept(ctx context.Context, in *manager.RemoveInterceptRequest2, opts ...grpc.CallOption) (*InterceptResult, error) {
+	out := new(InterceptResult)
+	err := c.cc.Invoke(ctx, "/telepresence.connector.Connector/ResumeIntercept", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *connectorClient) ListInterceptsDetailed(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*InterceptDetailsList, error) {
+	out := new(InterceptDetailsList)
+	err := c.cc.Invoke(ctx, "/telepresence.connector.Connector/ListInterceptsDetailed", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *connectorClient) TerminateIntercept(ctx context.Context, in *TerminateInterceptRequest, opts ...grpc.CallOption) (*InterceptResult, error) {
+	out := new(InterceptResult)
+	err := c.cc.Invoke(ctx, "/telepresence.connector.Connector/TerminateIntercept", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *connectorClient) Uninstall(ctx context.Context, in *UninstallRequest, opts ...grpc.CallOption) (*UninstallResult, error) {
 	out := new(UninstallResult)
 	err := c.cc.Invoke(ctx, "/telepresence.connector.Connector/Uninstall", in, out, opts...)
@@ -240,6 +321,38 @@ func (x *connectorUserNotificationsClient) Recv() (*Notification, error) {
 	return m, nil
 }
 
+func (c *connectorClient) StateChanges(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (Connector_StateChangesClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Connector_ServiceDesc.Streams[2], "/telepresence.connector.Connector/StateChanges", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &connectorStateChangesClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Connector_StateChangesClient interface {
+	Recv() (*StateChange, error)
+	grpc.ClientStream
+}
+
+type connectorStateChangesClient struct {
+	grpc.ClientStream
+}
+
+func (x *connectorStateChangesClient) Recv() (*StateChange, error) {
+	m := new(StateChange)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 func (c *connectorClient) Login(ctx context.Context, in *LoginRequest, opts ...grpc.CallOption) (*LoginResult, error) {
 	out := new(LoginResult)
 	err := c.cc.Invoke(ctx, "/telepresence.connector.Connector/Login", in, out, opts...)
@@ -294,6 +407,15 @@ func (c *connectorClient) GetIngressInfos(ctx context.Context, in *emptypb.Empty
 	return out, nil
 }
 
+func (c *connectorClient) DetectIngressInfos(ctx context.Context, in *DetectIngressRequest, opts ...grpc.CallOption) (*IngressInfos, error) {
+	out := new(IngressInfos)
+	err := c.cc.Invoke(ctx, "/telepresence.connector.Connector/DetectIngressInfos", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *connectorClient) SetLogLevel(ctx context.Context, in *manager.LogLevelRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
 	out := new(emptypb.Empty)
 	err := c.cc.Invoke(ctx, "/telepresence.connector.Connector/SetLogLevel", in, out, opts...)
@@ -366,6 +488,92 @@ func (c *connectorClient) RemoveInterceptor(ctx context.Context, in *Interceptor
 	return out, nil
 }
 
+func (c *connectorClient) TunnelMetrics(ctx context.Context, in *daemon.TunnelMetricsRequest, opts ...grpc.CallOption) (*daemon.TunnelMetricsResponse, error) {
+	out := new(daemon.TunnelMetricsResponse)
+	err := c.cc.Invoke(ctx, "/telepresence.connector.Connector/TunnelMetrics", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *connectorClient) ListConnections(ctx context.Context, in *daemon.ConnectionFilter, opts ...grpc.CallOption) (*daemon.ConnectionList, error) {
+	out := new(daemon.ConnectionList)
+	err := c.cc.Invoke(ctx, "/telepresence.connector.Connector/ListConnections", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *connectorClient) StartTraceCapture(ctx context.Context, in *daemon.TraceCaptureRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, "/telepresence.connector.Connector/StartTraceCapture", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *connectorClient) FetchTraceCapture(ctx context.Context, in *daemon.TraceCaptureId, opts ...grpc.CallOption) (Connector_FetchTraceCaptureClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Connector_ServiceDesc.Streams[3], "/telepresence.connector.Connector/FetchTraceCapture", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &connectorFetchTraceCaptureClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Connector_FetchTraceCaptureClient interface {
+	Recv() (*daemon.TraceChunk, error)
+	grpc.ClientStream
+}
+
+type connectorFetchTraceCaptureClient struct {
+	grpc.ClientStream
+}
+
+func (x *connectorFetchTraceCaptureClient) Recv() (*daemon.TraceChunk, error) {
+	m := new(daemon.TraceChunk)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *connectorClient) DumpState(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*StateDump, error) {
+	out := new(StateDump)
+	err := c.cc.Invoke(ctx, "/telepresence.connector.Connector/DumpState", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *connectorClient) GetEffectiveConfig(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*EffectiveConfig, error) {
+	out := new(EffectiveConfig)
+	err := c.cc.Invoke(ctx, "/telepresence.connector.Connector/GetEffectiveConfig", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *connectorClient) Ping(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*PingResult, error) {
+	out := new(PingResult)
+	err := c.cc.Invoke(ctx, "/telepresence.connector.Connector/Ping", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // ConnectorServer is the server API for Connector service.
 // All implementations must embed UnimplementedConnectorServer
 // for forward compatibility
@@ -392,6 +600,18 @@ type ConnectorServer interface {
 	// Deactivates and removes an existent workload intercept.
 	// Requires having already called Connect.
 	RemoveIntercept(context.Context, *manager.RemoveInterceptRequest2) (*InterceptResult, error)
+	// Pauses an intercept. The traffic is routed to the intercepted
+	// container while the port forwards and mounts are kept intact.
+	PauseIntercept(context.Context, *manager.RemoveInterceptRequest2) (*InterceptResult, error)
+	// Resumes an intercept that was paused using PauseIntercept.
+	ResumeIntercept(context.Context, *manager.RemoveInterceptRequest2) (*InterceptResult, error)
+	// Returns the intercepts of the current session together with details
+	// about their health. Requires having already called Connect.
+	ListInterceptsDetailed(context.Context, *emptypb.Empty) (*InterceptDetailsList, error)
+	// Removes an intercept, closes its active connections, and reports the
+	// reason for doing so to the StateChanges stream. Requires having
+	// already called Connect.
+	TerminateIntercept(context.Context, *TerminateInterceptRequest) (*InterceptResult, error)
 	// Uninstalls traffic-agents and traffic-manager from the cluster.
 	// Requires having already called Connect.
 	Uninstall(context.Context, *UninstallRequest) (*UninstallResult, error)
@@ -403,6 +623,10 @@ type ConnectorServer interface {
 	// Returns a stream of messages to display to the user.  Does NOT
 	// require having called anything else first.
 	UserNotifications(*emptypb.Empty, Connector_UserNotificationsServer) error
+	// Returns a stream of structured events that describe changes in the
+	// state of the connection and its intercepts.  Does NOT require having
+	// called anything else first.
+	StateChanges(*emptypb.Empty, Connector_StateChangesServer) error
 	Login(context.Context, *LoginRequest) (*LoginResult, error)
 	// Returns an error with code=NotFound if not currently logged in.
 	Logout(context.Context, *emptypb.Empty) (*emptypb.Empty, error)
@@ -410,6 +634,10 @@ type ConnectorServer interface {
 	GetCloudAPIKey(context.Context, *KeyRequest) (*KeyData, error)
 	GetCloudLicense(context.Context, *LicenseRequest) (*LicenseData, error)
 	GetIngressInfos(context.Context, *emptypb.Empty) (*IngressInfos, error)
+	// DetectIngressInfos re-runs the detection of ingress behavior, so that
+	// load balancers in namespaces that were added after the connect are
+	// found without reconnecting.
+	DetectIngressInfos(context.Context, *DetectIngressRequest) (*IngressInfos, error)
 	// SetLogLevel will temporarily set the log-level for the daemon for a duration that is determined by the request.
 	SetLogLevel(context.Context, *manager.LogLevelRequest) (*emptypb.Empty, error)
 	// Quits (terminates) the connector process.
@@ -429,6 +657,30 @@ type ConnectorServer interface {
 	AddInterceptor(context.Context, *Interceptor) (*emptypb.Empty, error)
 	// RemoveInterceptor removes a previously added interceptor
 	RemoveInterceptor(context.Context, *Interceptor) (*emptypb.Empty, error)
+	// TunnelMetrics returns the aggregated throughput of the connections that
+	// the root daemon tunnels to the cluster.
+	TunnelMetrics(context.Context, *daemon.TunnelMetricsRequest) (*daemon.TunnelMetricsResponse, error)
+	// ListConnections returns the connections that the root daemon tunnels to
+	// the cluster and that match the given destination.
+	ListConnections(context.Context, *daemon.ConnectionFilter) (*daemon.ConnectionList, error)
+	// StartTraceCapture makes the root daemon record the state transitions of
+	// the TCP connections for the given duration, into a capture labeled with
+	// the given correlation ID, so that a trace of a specific user action can
+	// be attached to a bug report.
+	StartTraceCapture(context.Context, *daemon.TraceCaptureRequest) (*emptypb.Empty, error)
+	// FetchTraceCapture streams the traces of the capture with the given
+	// correlation ID.
+	FetchTraceCapture(*daemon.TraceCaptureId, Connector_FetchTraceCaptureServer) error
+	// DumpState returns a snapshot of the connector's internal state, intended
+	// to be attached to bug reports.
+	DumpState(context.Context, *emptypb.Empty) (*StateDump, error)
+	// GetEffectiveConfig returns the configuration that the connector uses,
+	// with every setting included, whether it comes from a default, a config
+	// file, or the environment.
+	GetEffectiveConfig(context.Context, *emptypb.Empty) (*EffectiveConfig, error)
+	// Ping measures the round-trip time to the traffic-manager and to the
+	// cluster's API server.
+	Ping(context.Context, *emptypb.Empty) (*PingResult, error)
 	mustEmbedUnimplementedConnectorServer()
 }
 
@@ -457,6 +709,18 @@ func (UnimplementedConnectorServer) CreateIntercept(context.Context, *CreateInte
 func (UnimplementedConnectorServer) RemoveIntercept(context.Context, *manager.RemoveInterceptRequest2) (*InterceptResult, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method RemoveIntercept not implemented")
 }
+func (UnimplementedConnectorServer) PauseIntercept(context.Context, *manager.RemoveInterceptRequest2) (*InterceptResult, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PauseIntercept not implemented")
+}
+func (UnimplementedConnectorServer) ResumeIntercept(context.Context, *manager.RemoveInterceptRequest2) (*InterceptResult, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ResumeIntercept not implemented")
+}
+func (UnimplementedConnectorServer) ListInterceptsDetailed(context.Context, *emptypb.Empty) (*InterceptDetailsList, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListInterceptsDetailed not implemented")
+}
+func (UnimplementedConnectorServer) TerminateIntercept(context.Context, *TerminateInterceptRequest) (*InterceptResult, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method TerminateIntercept not implemented")
+}
 func (UnimplementedConnectorServer) Uninstall(context.Context, *UninstallRequest) (*UninstallResult, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Uninstall not implemented")
 }
@@ -469,6 +733,9 @@ func (UnimplementedConnectorServer) WatchWorkloads(*WatchWorkloadsRequest, Conne
 func (UnimplementedConnectorServer) UserNotifications(*emptypb.Empty, Connector_UserNotificationsServer) error {
 	return status.Errorf(codes.Unimplemented, "method UserNotifications not implemented")
 }
+func (UnimplementedConnectorServer) StateChanges(*emptypb.Empty, Connector_StateChangesServer) error {
+	return status.Errorf(codes.Unimplemented, "method StateChanges not implemented")
+}
 func (UnimplementedConnectorServer) Login(context.Context, *LoginRequest) (*LoginResult, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Login not implemented")
 }
@@ -487,6 +754,9 @@ func (UnimplementedConnectorServer) GetCloudLicense(context.Context, *LicenseReq
 func (UnimplementedConnectorServer) GetIngressInfos(context.Context, *emptypb.Empty) (*IngressInfos, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method GetIngressInfos not implemented")
 }
+func (UnimplementedConnectorServer) DetectIngressInfos(context.Context, *DetectIngressRequest) (*IngressInfos, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DetectIngressInfos not implemented")
+}
 func (UnimplementedConnectorServer) SetLogLevel(context.Context, *manager.LogLevelRequest) (*emptypb.Empty, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method SetLogLevel not implemented")
 }
@@ -511,6 +781,27 @@ func (UnimplementedConnectorServer) AddInterceptor(context.Context, *Interceptor
 func (UnimplementedConnectorServer) RemoveInterceptor(context.Context, *Interceptor) (*emptypb.Empty, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method RemoveInterceptor not implemented")
 }
+func (UnimplementedConnectorServer) TunnelMetrics(context.Context, *daemon.TunnelMetricsRequest) (*daemon.TunnelMetricsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method TunnelMetrics not implemented")
+}
+func (UnimplementedConnectorServer) ListConnections(context.Context, *daemon.ConnectionFilter) (*daemon.ConnectionList, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListConnections not implemented")
+}
+func (UnimplementedConnectorServer) StartTraceCapture(context.Context, *daemon.TraceCaptureRequest) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method StartTraceCapture not implemented")
+}
+func (UnimplementedConnectorServer) FetchTraceCapture(*daemon.TraceCaptureId, Connector_FetchTraceCaptureServer) error {
+	return status.Errorf(codes.Unimplemented, "method FetchTraceCapture not implemented")
+}
+func (UnimplementedConnectorServer) DumpState(context.Context, *emptypb.Empty) (*StateDump, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DumpState not implemented")
+}
+func (UnimplementedConnectorServer) GetEffectiveConfig(context.Context, *emptypb.Empty) (*EffectiveConfig, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetEffectiveConfig not implemented")
+}
+func (UnimplementedConnectorServer) Ping(context.Context, *emptypb.Empty) (*PingResult, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Ping not implemented")
+}
 func (UnimplementedConnectorServer) mustEmbedUnimplementedConnectorServer() {}
 
 // UnsafeConnectorServer may be embedded to opt out of forward compatibility for this service.
@@ -650,6 +941,78 @@ func _Connector_RemoveIntercept_Handler(srv interface{}, ctx context.Context, de
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Connector_PauseIntercept_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(manager.RemoveInterceptRequest2)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ConnectorServer).PauseIntercept(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/telepresence.connector.Connector/PauseIntercept",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ConnectorServer).PauseIntercept(ctx, req.(*manager.RemoveInterceptRequest2))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Connector_ResumeIntercept_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(manager.RemoveInterceptRequest2)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ConnectorServer).ResumeIntercept(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/telepresence.connector.Connector/ResumeIntercept",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ConnectorServer).ResumeIntercept(ctx, req.(*manager.RemoveInterceptRequest2))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Connector_ListInterceptsDetailed_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(emptypb.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ConnectorServer).ListInterceptsDetailed(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/telepresence.connector.Connector/ListInterceptsDetailed",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ConnectorServer).ListInterceptsDetailed(ctx, req.(*emptypb.Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Connector_TerminateIntercept_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TerminateInterceptRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ConnectorServer).TerminateIntercept(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/telepresence.connector.Connector/TerminateIntercept",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ConnectorServer).TerminateIntercept(ctx, req.(*TerminateInterceptRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _Connector_Uninstall_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(UninstallRequest)
 	if err := dec(in); err != nil {
@@ -728,6 +1091,27 @@ func (x *connectorUserNotificationsServer) Send(m *Notification) error {
 	return x.ServerStream.SendMsg(m)
 }
 
+func _Connector_StateChanges_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(emptypb.Empty)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ConnectorServer).StateChanges(m, &connectorStateChangesServer{stream})
+}
+
+type Connector_StateChangesServer interface {
+	Send(*StateChange) error
+	grpc.ServerStream
+}
+
+type connectorStateChangesServer struct {
+	grpc.ServerStream
+}
+
+func (x *connectorStateChangesServer) Send(m *StateChange) error {
+	return x.ServerStream.SendMsg(m)
+}
+
 func _Connector_Login_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(LoginRequest)
 	if err := dec(in); err != nil {
@@ -836,6 +1220,24 @@ func _Connector_GetIngressInfos_Handler(srv interface{}, ctx context.Context, de
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Connector_DetectIngressInfos_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DetectIngressRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ConnectorServer).DetectIngressInfos(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/telepresence.connector.Connector/DetectIngressInfos",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ConnectorServer).DetectIngressInfos(ctx, req.(*DetectIngressRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _Connector_SetLogLevel_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(manager.LogLevelRequest)
 	if err := dec(in); err != nil {
@@ -980,6 +1382,135 @@ func _Connector_RemoveInterceptor_Handler(srv interface{}, ctx context.Context,
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Connector_TunnelMetrics_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(daemon.TunnelMetricsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ConnectorServer).TunnelMetrics(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/telepresence.connector.Connector/TunnelMetrics",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ConnectorServer).TunnelMetrics(ctx, req.(*daemon.TunnelMetricsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Connector_ListConnections_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(daemon.ConnectionFilter)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ConnectorServer).ListConnections(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/telepresence.connector.Connector/ListConnections",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ConnectorServer).ListConnections(ctx, req.(*daemon.ConnectionFilter))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Connector_StartTraceCapture_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(daemon.TraceCaptureRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ConnectorServer).StartTraceCapture(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/telepresence.connector.Connector/StartTraceCapture",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ConnectorServer).StartTraceCapture(ctx, req.(*daemon.TraceCaptureRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Connector_FetchTraceCapture_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(daemon.TraceCaptureId)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ConnectorServer).FetchTraceCapture(m, &connectorFetchTraceCaptureServer{stream})
+}
+
+type Connector_FetchTraceCaptureServer interface {
+	Send(*daemon.TraceChunk) error
+	grpc.ServerStream
+}
+
+type connectorFetchTraceCaptureServer struct {
+	grpc.ServerStream
+}
+
+func (x *connectorFetchTraceCaptureServer) Send(m *daemon.TraceChunk) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Connector_DumpState_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(emptypb.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ConnectorServer).DumpState(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/telepresence.connector.Connector/DumpState",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ConnectorServer).DumpState(ctx, req.(*emptypb.Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Connector_GetEffectiveConfig_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(emptypb.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ConnectorServer).GetEffectiveConfig(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/telepresence.connector.Connector/GetEffectiveConfig",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ConnectorServer).GetEffectiveConfig(ctx, req.(*emptypb.Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Connector_Ping_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(emptypb.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ConnectorServer).Ping(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/telepresence.connector.Connector/Ping",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ConnectorServer).Ping(ctx, req.(*emptypb.Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // Connector_ServiceDesc is the grpc.ServiceDesc for Connector service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -1015,6 +1546,22 @@ var Connector_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "RemoveIntercept",
 			Handler:    _Connector_RemoveIntercept_Handler,
 		},
+		{
+			MethodName: "PauseIntercept",
+			Handler:    _Connector_PauseIntercept_Handler,
+		},
+		{
+			MethodName: "ResumeIntercept",
+			Handler:    _Connector_ResumeIntercept_Handler,
+		},
+		{
+			MethodName: "ListInterceptsDetailed",
+			Handler:    _Connector_ListInterceptsDetailed_Handler,
+		},
+		{
+			MethodName: "TerminateIntercept",
+			Handler:    _Connector_TerminateIntercept_Handler,
+		},
 		{
 			MethodName: "Uninstall",
 			Handler:    _Connector_Uninstall_Handler,
@@ -1047,6 +1594,10 @@ var Connector_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "GetIngressInfos",
 			Handler:    _Connector_GetIngressInfos_Handler,
 		},
+		{
+			MethodName: "DetectIngressInfos",
+			Handler:    _Connector_DetectIngressInfos_Handler,
+		},
 		{
 			MethodName: "SetLogLevel",
 			Handler:    _Connector_SetLogLevel_Handler,
@@ -1079,6 +1630,30 @@ var Connector_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "RemoveInterceptor",
 			Handler:    _Connector_RemoveInterceptor_Handler,
 		},
+		{
+			MethodName: "TunnelMetrics",
+			Handler:    _Connector_TunnelMetrics_Handler,
+		},
+		{
+			MethodName: "ListConnections",
+			Handler:    _Connector_ListConnections_Handler,
+		},
+		{
+			MethodName: "StartTraceCapture",
+			Handler:    _Connector_StartTraceCapture_Handler,
+		},
+		{
+			MethodName: "DumpState",
+			Handler:    _Connector_DumpState_Handler,
+		},
+		{
+			MethodName: "GetEffectiveConfig",
+			Handler:    _Connector_GetEffectiveConfig_Handler,
+		},
+		{
+			MethodName: "Ping",
+			Handler:    _Connector_Ping_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{
 		{
@@ -1091,6 +1666,16 @@ var Connector_ServiceDesc = grpc.ServiceDesc{
 			Handler:       _Connector_UserNotifications_Handler,
 			ServerStreams: true,
 		},
+		{
+			StreamName:    "StateChanges",
+			Handler:       _Connector_StateChanges_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "FetchTraceCapture",
+			Handler:       _Connector_FetchTraceCapture_Handler,
+			ServerStreams: true,
+		},
 	},
 	Metadata: "rpc/connector/connector.proto",
 }
@@ -39,8 +39,30 @@ type DaemonClient interface {
 	GetClusterSubnets(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*ClusterSubnets, error)
 	// SetDnsSearchPath sets a new search path.
 	SetDnsSearchPath(ctx context.Context, in *Paths, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	// SetDnsSuffixes sets the suffixes that the DNS resolver always (include) or never (exclude)
+	// resolves using the cluster.
+	SetDnsSuffixes(ctx context.Context, in *Suffixes, opts ...grpc.CallOption) (*emptypb.Empty, error)
 	// SetLogLevel will temporarily set the log-level for the daemon for a duration that is determined b the request.
 	SetLogLevel(ctx context.Context, in *manager.LogLevelRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	// StreamTraces streams the recorded state transitions of the most recent
+	// TCP connections of the current session. The traces are sent in chunks, so
+	// that a large set of traces never has to fit in a single message.
+	StreamTraces(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (Daemon_StreamTracesClient, error)
+	// StartTraceCapture starts recording the state transitions of the TCP
+	// connections of the current session into a capture that is labeled with
+	// the given correlation ID. The recording stops when the duration has
+	// elapsed, and the capture can then be fetched for ten minutes.
+	StartTraceCapture(ctx context.Context, in *TraceCaptureRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	// FetchTraceCapture streams the traces of the capture with the given
+	// correlation ID. A capture that is still recording contains what has been
+	// recorded so far.
+	FetchTraceCapture(ctx context.Context, in *TraceCaptureId, opts ...grpc.CallOption) (Daemon_FetchTraceCaptureClient, error)
+	// TunnelMetrics returns the aggregated throughput of the TCP connections of
+	// the current session, sampled over the requested duration.
+	TunnelMetrics(ctx context.Context, in *TunnelMetricsRequest, opts ...grpc.CallOption) (*TunnelMetricsResponse, error)
+	// ListConnections returns the connections of the current session that
+	// match the given destination, together with their stats.
+	ListConnections(ctx context.Context, in *ConnectionFilter, opts ...grpc.CallOption) (*ConnectionList, error)
 }
 
 type daemonClient struct {
@@ -114,6 +136,15 @@ func (c *daemonClient) SetDnsSearchPath(ctx context.Context, in *Paths, opts ...
 	return out, nil
 }
 
+func (c *daemonClient) SetDnsSuffixes(ctx context.Context, in *Suffixes, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, "/telepresence.daemon.Daemon/SetDnsSuffixes", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *daemonClient) SetLogLevel(ctx context.Context, in *manager.LogLevelRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
 	out := new(emptypb.Empty)
 	err := c.cc.Invoke(ctx, "/telepresence.daemon.Daemon/SetLogLevel", in, out, opts...)
@@ -123,6 +154,97 @@ func (c *daemonClient) SetLogLevel(ctx context.Context, in *manager.LogLevelRequ
 	return out, nil
 }
 
+func (c *daemonClient) StreamTraces(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (Daemon_StreamTracesClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Daemon_ServiceDesc.Streams[0], "/telepresence.daemon.Daemon/StreamTraces", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &daemonStreamTracesClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Daemon_StreamTracesClient interface {
+	Recv() (*TraceChunk, error)
+	grpc.ClientStream
+}
+
+type daemonStreamTracesClient struct {
+	grpc.ClientStream
+}
+
+func (x *daemonStreamTracesClient) Recv() (*TraceChunk, error) {
+	m := new(TraceChunk)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *daemonClient) StartTraceCapture(ctx context.Context, in *TraceCaptureRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, "/telepresence.daemon.Daemon/StartTraceCapture", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *daemonClient) FetchTraceCapture(ctx context.Context, in *TraceCaptureId, opts ...grpc.CallOption) (Daemon_FetchTraceCaptureClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Daemon_ServiceDesc.Streams[1], "/telepresence.daemon.Daemon/FetchTraceCapture", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &daemonFetchTraceCaptureClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Daemon_FetchTraceCaptureClient interface {
+	Recv() (*TraceChunk, error)
+	grpc.ClientStream
+}
+
+type daemonFetchTraceCaptureClient struct {
+	grpc.ClientStream
+}
+
+func (x *daemonFetchTraceCaptureClient) Recv() (*TraceChunk, error) {
+	m := new(TraceChunk)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *daemonClient) TunnelMetrics(ctx context.Context, in *TunnelMetricsRequest, opts ...grpc.CallOption) (*TunnelMetricsResponse, error) {
+	out := new(TunnelMetricsResponse)
+	err := c.cc.Invoke(ctx, "/telepresence.daemon.Daemon/TunnelMetrics", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *daemonClient) ListConnections(ctx context.Context, in *ConnectionFilter, opts ...grpc.CallOption) (*ConnectionList, error) {
+	out := new(ConnectionList)
+	err := c.cc.Invoke(ctx, "/telepresence.daemon.Daemon/ListConnections", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // DaemonServer is the server API for Daemon service.
 // All implementations must embed UnimplementedDaemonServer
 // for forward compatibility
@@ -141,8 +263,30 @@ type DaemonServer interface {
 	GetClusterSubnets(context.Context, *emptypb.Empty) (*ClusterSubnets, error)
 	// SetDnsSearchPath sets a new search path.
 	SetDnsSearchPath(context.Context, *Paths) (*emptypb.Empty, error)
+	// SetDnsSuffixes sets the suffixes that the DNS resolver always (include) or never (exclude)
+	// resolves using the cluster.
+	SetDnsSuffixes(context.Context, *Suffixes) (*emptypb.Empty, error)
 	// SetLogLevel will temporarily set the log-level for the daemon for a duration that is determined b the request.
 	SetLogLevel(context.Context, *manager.LogLevelRequest) (*emptypb.Empty, error)
+	// StreamTraces streams the recorded state transitions of the most recent
+	// TCP connections of the current session. The traces are sent in chunks, so
+	// that a large set of traces never has to fit in a single message.
+	StreamTraces(*emptypb.Empty, Daemon_StreamTracesServer) error
+	// StartTraceCapture starts recording the state transitions of the TCP
+	// connections of the current session into a capture that is labeled with
+	// the given correlation ID. The recording stops when the duration has
+	// elapsed, and the capture can then be fetched for ten minutes.
+	StartTraceCapture(context.Context, *TraceCaptureRequest) (*emptypb.Empty, error)
+	// FetchTraceCapture streams the traces of the capture with the given
+	// correlation ID. A capture that is still recording contains what has been
+	// recorded so far.
+	FetchTraceCapture(*TraceCaptureId, Daemon_FetchTraceCaptureServer) error
+	// TunnelMetrics returns the aggregated throughput of the TCP connections of
+	// the current session, sampled over the requested duration.
+	TunnelMetrics(context.Context, *TunnelMetricsRequest) (*TunnelMetricsResponse, error)
+	// ListConnections returns the connections of the current session that
+	// match the given destination, together with their stats.
+	ListConnections(context.Context, *ConnectionFilter) (*ConnectionList, error)
 	mustEmbedUnimplementedDaemonServer()
 }
 
@@ -171,9 +315,27 @@ func (UnimplementedDaemonServer) GetClusterSubnets(context.Context, *emptypb.Emp
 func (UnimplementedDaemonServer) SetDnsSearchPath(context.Context, *Paths) (*emptypb.Empty, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method SetDnsSearchPath not implemented")
 }
+func (UnimplementedDaemonServer) SetDnsSuffixes(context.Context, *Suffixes) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetDnsSuffixes not implemented")
+}
 func (UnimplementedDaemonServer) SetLogLevel(context.Context, *manager.LogLevelRequest) (*emptypb.Empty, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method SetLogLevel not implemented")
 }
+func (UnimplementedDaemonServer) StreamTraces(*emptypb.Empty, Daemon_StreamTracesServer) error {
+	return status.Errorf(codes.Unimplemented, "method StreamTraces not implemented")
+}
+func (UnimplementedDaemonServer) StartTraceCapture(context.Context, *TraceCaptureRequest) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method StartTraceCapture not implemented")
+}
+func (UnimplementedDaemonServer) FetchTraceCapture(*TraceCaptureId, Daemon_FetchTraceCaptureServer) error {
+	return status.Errorf(codes.Unimplemented, "method FetchTraceCapture not implemented")
+}
+func (UnimplementedDaemonServer) TunnelMetrics(context.Context, *TunnelMetricsRequest) (*TunnelMetricsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method TunnelMetrics not implemented")
+}
+func (UnimplementedDaemonServer) ListConnections(context.Context, *ConnectionFilter) (*ConnectionList, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListConnections not implemented")
+}
 func (UnimplementedDaemonServer) mustEmbedUnimplementedDaemonServer() {}
 
 // UnsafeDaemonServer may be embedded to opt out of forward compatibility for this service.
@@ -313,6 +475,24 @@ func _Daemon_SetDnsSearchPath_Handler(srv interface{}, ctx context.Context, dec
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Daemon_SetDnsSuffixes_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Suffixes)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DaemonServer).SetDnsSuffixes(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/telepresence.daemon.Daemon/SetDnsSuffixes",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DaemonServer).SetDnsSuffixes(ctx, req.(*Suffixes))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _Daemon_SetLogLevel_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(manager.LogLevelRequest)
 	if err := dec(in); err != nil {
@@ -331,6 +511,102 @@ func _Daemon_SetLogLevel_Handler(srv interface{}, ctx context.Context, dec func(
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Daemon_StreamTraces_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(emptypb.Empty)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(DaemonServer).StreamTraces(m, &daemonStreamTracesServer{stream})
+}
+
+type Daemon_StreamTracesServer interface {
+	Send(*TraceChunk) error
+	grpc.ServerStream
+}
+
+type daemonStreamTracesServer struct {
+	grpc.ServerStream
+}
+
+func (x *daemonStreamTracesServer) Send(m *TraceChunk) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Daemon_StartTraceCapture_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TraceCaptureRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DaemonServer).StartTraceCapture(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/telepresence.daemon.Daemon/StartTraceCapture",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DaemonServer).StartTraceCapture(ctx, req.(*TraceCaptureRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Daemon_FetchTraceCapture_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(TraceCaptureId)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(DaemonServer).FetchTraceCapture(m, &daemonFetchTraceCaptureServer{stream})
+}
+
+type Daemon_FetchTraceCaptureServer interface {
+	Send(*TraceChunk) error
+	grpc.ServerStream
+}
+
+type daemonFetchTraceCaptureServer struct {
+	grpc.ServerStream
+}
+
+func (x *daemonFetchTraceCaptureServer) Send(m *TraceChunk) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Daemon_TunnelMetrics_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TunnelMetricsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DaemonServer).TunnelMetrics(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/telepresence.daemon.Daemon/TunnelMetrics",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DaemonServer).TunnelMetrics(ctx, req.(*TunnelMetricsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Daemon_ListConnections_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ConnectionFilter)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DaemonServer).ListConnections(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/telepresence.daemon.Daemon/ListConnections",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DaemonServer).ListConnections(ctx, req.(*ConnectionFilter))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // Daemon_ServiceDesc is the grpc.ServiceDesc for Daemon service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -366,11 +642,38 @@ var Daemon_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "SetDnsSearchPath",
 			Handler:    _Daemon_SetDnsSearchPath_Handler,
 		},
+		{
+			MethodName: "SetDnsSuffixes",
+			Handler:    _Daemon_SetDnsSuffixes_Handler,
+		},
 		{
 			MethodName: "SetLogLevel",
 			Handler:    _Daemon_SetLogLevel_Handler,
 		},
+		{
+			MethodName: "StartTraceCapture",
+			Handler:    _Daemon_StartTraceCapture_Handler,
+		},
+		{
+			MethodName: "TunnelMetrics",
+			Handler:    _Daemon_TunnelMetrics_Handler,
+		},
+		{
+			MethodName: "ListConnections",
+			Handler:    _Daemon_ListConnections_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamTraces",
+			Handler:       _Daemon_StreamTraces_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "FetchTraceCapture",
+			Handler:       _Daemon_FetchTraceCapture_Handler,
+			ServerStreams: true,
+		},
 	},
-	Streams:  []grpc.StreamDesc{},
 	Metadata: "rpc/daemon/daemon.proto",
 }